@@ -0,0 +1,230 @@
+package analysis
+
+import (
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"ratemykb/scanner"
+)
+
+// Package analysis provides vault-wide checks that look across all scanned files
+// rather than at the quality of a single note
+
+// AmbiguousTitle represents a note title shared by files in different folders
+type AmbiguousTitle struct {
+	Title string   `json:"title"` // Title as it would be written in an Obsidian link
+	Paths []string `json:"paths"` // Paths of all files sharing the title, sorted
+}
+
+// DuplicateGroup represents notes whose content is so similar that they are likely duplicates of each other
+type DuplicateGroup struct {
+	Paths      []string `json:"paths"`      // Paths of all notes in the group, sorted
+	Similarity float64  `json:"similarity"` // Highest cosine similarity between two notes of the group
+}
+
+// TopicCluster represents low quality notes about the same topic, which are easier to fix together
+type TopicCluster struct {
+	Name  string   `json:"name"`  // Title of the note most similar to the others in the cluster
+	Paths []string `json:"paths"` // Paths of all notes in the cluster, sorted
+}
+
+// LongNote represents a note whose body exceeds the configured word count
+type LongNote struct {
+	Path       string `json:"path"`                 // Path to the file
+	Words      int    `json:"words"`                // Number of words in the body, excluding frontmatter
+	Suggestion string `json:"suggestion,omitempty"` // Optional outline suggesting how to split the note
+}
+
+// SizeOutlier represents a note whose length is unusual for the vault it is in
+type SizeOutlier struct {
+	Path  string `json:"path"`  // Path to the file
+	Words int    `json:"words"` // Number of words in the body, excluding frontmatter
+	Long  bool   `json:"long"`  // In the top percentile of lengths, otherwise in the bottom one
+}
+
+// minOutlierNotes is the number of notes below which percentiles say too little to flag outliers
+const minOutlierNotes = 20
+
+// TaskNote represents a note containing unchecked tasks
+type TaskNote struct {
+	Path      string `json:"path"`       // Path to the file
+	OpenTasks int    `json:"open_tasks"` // Number of unchecked "- [ ]" tasks
+}
+
+// openTaskPattern matches an unchecked markdown task list item
+var openTaskPattern = regexp.MustCompile(`(?m)^\s*[-*+] \[ \]`)
+
+// FindAmbiguousTitles returns the titles that are shared by more than one file
+// Obsidian resolves links case-insensitively, so titles are compared ignoring case. Excluded files are skipped.
+func FindAmbiguousTitles(files []scanner.File) []AmbiguousTitle {
+	byTitle := make(map[string][]string)
+	for _, file := range files {
+		if file.Status == scanner.StatusExcluded {
+			continue
+		}
+		key := strings.ToLower(noteTitle(file.Path))
+		byTitle[key] = append(byTitle[key], file.Path)
+	}
+
+	var result []AmbiguousTitle
+	for _, paths := range byTitle {
+		if len(paths) < 2 {
+			continue
+		}
+
+		sort.Strings(paths)
+		result = append(result, AmbiguousTitle{
+			Title: noteTitle(paths[0]),
+			Paths: paths,
+		})
+	}
+
+	// Sort for consistent output
+	sort.Slice(result, func(i, j int) bool {
+		return strings.ToLower(result[i].Title) < strings.ToLower(result[j].Title)
+	})
+
+	return result
+}
+
+// FindLongNotes returns the notes whose body has more than maxWords words, longest first
+// Excluded, binary and unreadable files are skipped
+func FindLongNotes(files []scanner.File, maxWords int) []LongNote {
+	if maxWords <= 0 {
+		return nil
+	}
+
+	var result []LongNote
+	for _, file := range files {
+		if file.Status != scanner.StatusNeedsReview {
+			continue
+		}
+
+		content, err := scanner.ReadFileContent(file.Path)
+		if err != nil {
+			continue
+		}
+
+		if words := scanner.CountWords(content); words > maxWords {
+			result = append(result, LongNote{Path: file.Path, Words: words})
+		}
+	}
+
+	// Sort for consistent output
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Words != result[j].Words {
+			return result[i].Words > result[j].Words
+		}
+		return result[i].Path < result[j].Path
+	})
+
+	return result
+}
+
+// FindSizeOutliers returns the notes whose length is in the bottom or top percentile of the vault, shortest first
+// The thresholds adapt to each vault instead of being fixed. Notes as long as the first note outside the percentile
+// are not flagged, so a length many notes share is never an outlier. Only notes with content to review are
+// considered, as empty and frontmatter-only files are reported separately, and vaults with too few notes have no
+// outliers.
+func FindSizeOutliers(files []scanner.File, percentile float64) []SizeOutlier {
+	if percentile <= 0 {
+		return nil
+	}
+
+	var notes []SizeOutlier
+	for _, file := range files {
+		if file.Status != scanner.StatusNeedsReview {
+			continue
+		}
+
+		content, err := scanner.ReadFileContent(file.Path)
+		if err != nil {
+			continue
+		}
+		notes = append(notes, SizeOutlier{Path: file.Path, Words: scanner.CountWords(content)})
+	}
+	if len(notes) < minOutlierNotes {
+		return nil
+	}
+
+	sort.Slice(notes, func(i, j int) bool {
+		if notes[i].Words != notes[j].Words {
+			return notes[i].Words < notes[j].Words
+		}
+		return notes[i].Path < notes[j].Path
+	})
+
+	// Nearest-rank percentiles, with strict bounds so ties at the cut-off are left out
+	rank := int(math.Ceil(float64(len(notes)) * min(percentile, 50) / 100))
+	low, high := notes[min(rank, len(notes)-1)].Words, notes[max(len(notes)-1-rank, 0)].Words
+
+	var result []SizeOutlier
+	for _, note := range notes {
+		switch {
+		case note.Words < low:
+			result = append(result, note)
+		case note.Words > high:
+			note.Long = true
+			result = append(result, note)
+		}
+	}
+	return result
+}
+
+// FindOpenTasks returns the notes containing unchecked tasks, most open tasks first
+// Excluded, binary and unreadable files are skipped
+func FindOpenTasks(files []scanner.File) []TaskNote {
+	var result []TaskNote
+	for _, file := range files {
+		if file.Status == scanner.StatusExcluded || file.Status == scanner.StatusNotMarkdown {
+			continue
+		}
+
+		content, err := scanner.ReadFileContent(file.Path)
+		if err != nil {
+			continue
+		}
+
+		if count := len(openTaskPattern.FindAllStringIndex(content, -1)); count > 0 {
+			result = append(result, TaskNote{Path: file.Path, OpenTasks: count})
+		}
+	}
+
+	// Sort for consistent output
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].OpenTasks != result[j].OpenTasks {
+			return result[i].OpenTasks > result[j].OpenTasks
+		}
+		return result[i].Path < result[j].Path
+	})
+
+	return result
+}
+
+// TotalOpenTasks returns the number of unchecked tasks across all notes
+func TotalOpenTasks(notes []TaskNote) int {
+	total := 0
+	for _, note := range notes {
+		total += note.OpenTasks
+	}
+	return total
+}
+
+// Title returns the title a note is known by: the title field of its frontmatter, or else its file name
+func Title(path, content string) string {
+	if fields, err := scanner.ParseFrontmatter(content); err == nil {
+		if title, ok := fields["title"].(string); ok && strings.TrimSpace(title) != "" {
+			return strings.TrimSpace(title)
+		}
+	}
+	return noteTitle(path)
+}
+
+// noteTitle returns the file name without its extension, which is how Obsidian titles a note
+func noteTitle(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
@@ -0,0 +1,490 @@
+package analysis
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"ratemykb/config"
+	"ratemykb/scanner"
+)
+
+func TestFindAmbiguousTitles(t *testing.T) {
+	files := []scanner.File{
+		{Path: "/vault/projects/Ideas.md", Status: scanner.StatusNeedsReview},
+		{Path: "/vault/inbox/ideas.md", Status: scanner.StatusEmpty},
+		{Path: "/vault/unique.md", Status: scanner.StatusNeedsReview},
+		{Path: "/vault/a/readme.md", Status: scanner.StatusNeedsReview},
+		{Path: "/vault/b/readme.md", Status: scanner.StatusExcluded},
+		{Path: "/vault/c/readme.md", Status: scanner.StatusNeedsReview},
+		{Path: "/vault/x/todo.md", Status: scanner.StatusNeedsReview},
+		{Path: "/vault/y/todo.md", Status: scanner.StatusExcluded},
+	}
+
+	got := FindAmbiguousTitles(files)
+
+	want := []AmbiguousTitle{
+		{Title: "ideas", Paths: []string{"/vault/inbox/ideas.md", "/vault/projects/Ideas.md"}},
+		{Title: "readme", Paths: []string{"/vault/a/readme.md", "/vault/c/readme.md"}},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FindAmbiguousTitles() = %+v, want %+v", got, want)
+	}
+}
+
+func TestTitle(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"---\ntitle: Pod networking\n---\n# Pods", "Pod networking"},
+		{"---\ntitle: \"  \"\n---\n# Pods", "pods"},
+		{"# Pods", "pods"},
+	}
+
+	for _, tt := range tests {
+		if got := Title("/vault/k8s/pods.md", tt.content); got != tt.want {
+			t.Errorf("Title(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestSourceCheck(t *testing.T) {
+	defaults, err := config.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Failed to load default config: %v", err)
+	}
+	cfg := defaults.Sources
+	cfg.Match = []string{"research/"}
+	cfg.Tags = []string{"#reference"}
+	cfg.MinWords = 5
+	check, err := NewSourceCheck(cfg)
+	if err != nil {
+		t.Fatalf("NewSourceCheck() error = %v", err)
+	}
+
+	claims := "Coffee cuts the risk of gout by forty percent."
+	applies := []struct {
+		path    string
+		content string
+		want    bool
+	}{
+		{"research/coffee.md", claims, true},
+		{"inbox/coffee.md", "---\ntags: [reference/health]\n---\n" + claims, true},
+		{"inbox/coffee.md", claims + " #Reference", true},
+		{"inbox/coffee.md", claims, false},
+		{"research/coffee.md", "Coffee cuts gout.", false},
+	}
+	for _, tt := range applies {
+		if got := check.Applies(tt.path, tt.content); got != tt.want {
+			t.Errorf("Applies(%q, %q) = %v, want %v", tt.path, tt.content, got, tt.want)
+		}
+	}
+
+	cited := []struct {
+		content string
+		want    bool
+	}{
+		{claims, false},
+		{claims + " See https://example.com/gout.", true},
+		{claims + "[^1]\n\n[^1]: A cohort study", true},
+		{claims + " (Choi et al., 2007)", true},
+		{claims + " doi:10.1002/art.22712", true},
+		{claims + "\n\n## References\n\nThe coffee book", true},
+	}
+	for _, tt := range cited {
+		if got := check.Cited(tt.content); got != tt.want {
+			t.Errorf("Cited(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+
+	// Without folders or tags every note long enough is a reference note
+	check, err = NewSourceCheck(config.SourcesConfig{MinWords: 5})
+	if err != nil {
+		t.Fatalf("NewSourceCheck() error = %v", err)
+	}
+	if !check.Applies("inbox/coffee.md", claims) {
+		t.Errorf("Expected every note to be checked without folders or tags")
+	}
+}
+
+func TestFindAmbiguousTitlesNone(t *testing.T) {
+	files := []scanner.File{
+		{Path: "/vault/one.md"},
+		{Path: "/vault/two.md"},
+	}
+
+	if got := FindAmbiguousTitles(files); len(got) != 0 {
+		t.Errorf("Expected no ambiguous titles, got %+v", got)
+	}
+}
+
+func TestFindLongNotes(t *testing.T) {
+	tempDir := t.TempDir()
+
+	shortPath := filepath.Join(tempDir, "short.md")
+	longPath := filepath.Join(tempDir, "long.md")
+	longerPath := filepath.Join(tempDir, "longer.md")
+	writeFile(t, shortPath, "---\ntitle: one two three four five six\n---\nOnly three words")
+	writeFile(t, longPath, strings.Repeat("word ", 6))
+	writeFile(t, longerPath, strings.Repeat("word ", 8))
+
+	files := []scanner.File{
+		{Path: shortPath, Status: scanner.StatusNeedsReview},
+		{Path: longPath, Status: scanner.StatusNeedsReview},
+		{Path: longerPath, Status: scanner.StatusNeedsReview},
+		{Path: filepath.Join(tempDir, "missing.md"), Status: scanner.StatusNeedsReview},
+	}
+
+	got := FindLongNotes(files, 5)
+	want := []LongNote{
+		{Path: longerPath, Words: 8},
+		{Path: longPath, Words: 6},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FindLongNotes() = %+v, want %+v", got, want)
+	}
+
+	if got := FindLongNotes(files, 0); got != nil {
+		t.Errorf("Expected no long notes when disabled, got %+v", got)
+	}
+}
+
+// writeFile creates a file with the given content
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create file %s: %v", path, err)
+	}
+}
+
+func TestFindOpenTasks(t *testing.T) {
+	tempDir := t.TempDir()
+
+	fewPath := filepath.Join(tempDir, "few.md")
+	manyPath := filepath.Join(tempDir, "many.md")
+	donePath := filepath.Join(tempDir, "done.md")
+	writeFile(t, fewPath, "# Few\n\n- [ ] one\n- [x] done\n")
+	writeFile(t, manyPath, "- [ ] one\n  - [ ] nested\n* [ ] star\nNot a task - [ ]\n")
+	writeFile(t, donePath, "- [x] finished\n")
+
+	files := []scanner.File{
+		{Path: fewPath, Status: scanner.StatusNeedsReview},
+		{Path: manyPath, Status: scanner.StatusNeedsReview},
+		{Path: donePath, Status: scanner.StatusNeedsReview},
+	}
+
+	got := FindOpenTasks(files)
+	want := []TaskNote{
+		{Path: manyPath, OpenTasks: 3},
+		{Path: fewPath, OpenTasks: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FindOpenTasks() = %+v, want %+v", got, want)
+	}
+
+	if total := TotalOpenTasks(got); total != 4 {
+		t.Errorf("Expected 4 open tasks in total, got %d", total)
+	}
+}
+
+func TestFindMetadataIssues(t *testing.T) {
+	tempDir := t.TempDir()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	okPath := filepath.Join(tempDir, "ok.md")
+	swappedPath := filepath.Join(tempDir, "swapped.md")
+	futurePath := filepath.Join(tempDir, "future.md")
+	invalidPath := filepath.Join(tempDir, "invalid.md")
+	writeFile(t, okPath, "---\ncreated: 2024-01-01\nupdated: 2024-04-30T10:00\n---\nBody")
+	writeFile(t, swappedPath, "---\ncreated: 2024-03-01\nupdated: 2024-02-01\n---\nBody")
+	writeFile(t, futurePath, "---\ncreated: \"2030-01-01\"\n---\nBody")
+	writeFile(t, invalidPath, "---\nupdated: last week\n---\nBody")
+
+	for _, path := range []string{okPath, swappedPath, futurePath, invalidPath} {
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set modification time: %v", err)
+		}
+	}
+
+	files := []scanner.File{
+		{Path: okPath, Status: scanner.StatusNeedsReview},
+		{Path: swappedPath, Status: scanner.StatusNeedsReview},
+		{Path: futurePath, Status: scanner.StatusFrontmatterOnly},
+		{Path: invalidPath, Status: scanner.StatusNeedsReview},
+	}
+
+	got := FindMetadataIssues(files, "created", "updated", now)
+	want := []MetadataIssue{
+		{Path: futurePath, Problems: []string{"`created` is in the future", "`created` is after the file was last modified"}},
+		{Path: invalidPath, Problems: []string{"`updated` is not a valid date: last week"}},
+		{Path: swappedPath, Problems: []string{"`created` is after `updated`"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FindMetadataIssues() = %+v, want %+v", got, want)
+	}
+}
+
+func TestBuildInventory(t *testing.T) {
+	tempDir := t.TempDir()
+
+	paths := map[string]string{
+		"index.md":            "---\ncreated: 2024-01-05\n---\nSee [[Projects/Roadmap]] and ![[diagram.png]]",
+		"projects/roadmap.md": "---\ncreated: 2024-03-10\n---\n" + strings.Repeat("word ", 60),
+		"projects/idea.md":    "---\ncreated: 2024-03-20\n---\nBack to [home](../index.md#top)",
+		"alone.md":            "---\ncreated: 2024-03-01\n---\n",
+		"templates/daily.md":  "Template [[index]]",
+		"diagram.png":         "png",
+		"unused.pdf":          "pdf!",
+		".obsidian/app.json":  "{}",
+	}
+	var files []scanner.File
+	for name, content := range paths {
+		path := filepath.Join(tempDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create folder: %v", err)
+		}
+		writeFile(t, path, content)
+		if strings.HasSuffix(name, ".md") && !strings.HasPrefix(name, "templates/") {
+			files = append(files, scanner.File{Path: path, Status: scanner.StatusNeedsReview})
+		}
+	}
+	exclusions := []scanner.Exclusion{{Path: filepath.Join(tempDir, "templates"), Reason: scanner.ReasonExcludedDirectory, IsDir: true}}
+
+	// A note that cannot be read is left out with a warning instead of failing the inventory
+	files = append(files, scanner.File{Path: filepath.Join(tempDir, "deleted.md"), Status: scanner.StatusNeedsReview})
+
+	inventory := BuildInventory(tempDir, files, exclusions, "created", 1)
+	if len(inventory.Warnings) != 1 || !strings.Contains(inventory.Warnings[0], "deleted.md") {
+		t.Errorf("Expected a warning for the deleted note, got %v", inventory.Warnings)
+	}
+
+	if inventory.Notes != 4 || inventory.Words != 67 {
+		t.Errorf("Expected 4 notes with 67 words, got %d notes with %d words", inventory.Notes, inventory.Words)
+	}
+	// Only the index links to the roadmap and the idea links back, so the idea and the lone note have no backlinks
+	if inventory.WithoutBacklinks != 2 || inventory.Orphans != 1 {
+		t.Errorf("Expected 2 notes without backlinks and 1 orphan, got %d and %d", inventory.WithoutBacklinks, inventory.Orphans)
+	}
+	wantFolders := []FolderCount{{Folder: ".", Notes: 2, Words: 4}, {Folder: "projects", Notes: 2, Words: 63}}
+	if !reflect.DeepEqual(inventory.Folders, wantFolders) {
+		t.Errorf("Folders = %+v, want %+v", inventory.Folders, wantFolders)
+	}
+	wantAttachments := []AttachmentCount{{Extension: ".pdf", Files: 1, Bytes: 4, Unlinked: 1}, {Extension: ".png", Files: 1, Bytes: 3}}
+	if !reflect.DeepEqual(inventory.Attachments, wantAttachments) {
+		t.Errorf("Attachments = %+v, want %+v", inventory.Attachments, wantAttachments)
+	}
+	wantGrowth := []MonthCount{{Month: "2024-01", Added: 1, Total: 1}, {Month: "2024-03", Added: 3, Total: 4}}
+	if !reflect.DeepEqual(inventory.Growth, wantGrowth) {
+		t.Errorf("Growth = %+v, want %+v", inventory.Growth, wantGrowth)
+	}
+	if inventory.WordBuckets[0].Notes != 1 || inventory.WordBuckets[1].Notes != 2 || inventory.WordBuckets[2].Notes != 1 {
+		t.Errorf("Unexpected word buckets %+v", inventory.WordBuckets)
+	}
+}
+
+func TestFindSizeOutliers(t *testing.T) {
+	tempDir := t.TempDir()
+
+	var files []scanner.File
+	for i := 0; i < 40; i++ {
+		words := 100 + i
+		switch i {
+		case 0:
+			words = 2
+		case 39:
+			words = 5000
+		}
+		path := filepath.Join(tempDir, fmt.Sprintf("note%02d.md", i))
+		writeFile(t, path, strings.Repeat("word ", words))
+		files = append(files, scanner.File{Path: path, Status: scanner.StatusNeedsReview})
+	}
+	files = append(files, scanner.File{Path: filepath.Join(tempDir, "empty.md"), Status: scanner.StatusEmpty})
+
+	got := FindSizeOutliers(files, 2.5)
+	want := []SizeOutlier{
+		{Path: filepath.Join(tempDir, "note00.md"), Words: 2},
+		{Path: filepath.Join(tempDir, "note39.md"), Words: 5000, Long: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FindSizeOutliers() = %+v, want %+v", got, want)
+	}
+
+	if got := FindSizeOutliers(files, 0); got != nil {
+		t.Errorf("Expected no outliers when disabled, got %+v", got)
+	}
+	if got := FindSizeOutliers(files[:10], 10); got != nil {
+		t.Errorf("Expected no outliers in a small vault, got %+v", got)
+	}
+
+	// Notes sharing the length at the cut-off are not flagged
+	for _, file := range files[1:5] {
+		writeFile(t, file.Path, "word word")
+	}
+	got = FindSizeOutliers(files, 2.5)
+	want = []SizeOutlier{{Path: filepath.Join(tempDir, "note39.md"), Words: 5000, Long: true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FindSizeOutliers() with ties = %+v, want %+v", got, want)
+	}
+}
+
+func TestFindNavigationIssues(t *testing.T) {
+	t.Run("mkdocs", func(t *testing.T) {
+		root := t.TempDir()
+		writeFile(t, filepath.Join(root, "mkdocs.yml"), "site_name: Docs\ndocs_dir: site-docs\n"+
+			"markdown_extensions:\n  - pymdownx.emoji:\n      emoji_index: !!python/name:material.extensions.emoji.twemoji\n"+
+			"nav:\n  - index.md\n  - Guide:\n      - Setup: guide/setup.md\n      - guide/gone.md\n  - GitHub: https://github.com\n")
+		var files []scanner.File
+		for _, rel := range []string{"index.md", "guide/setup.md", "guide/orphan.md"} {
+			path := filepath.Join(root, "site-docs", filepath.FromSlash(rel))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			writeFile(t, path, "# Page\n")
+			files = append(files, scanner.File{Path: path, Status: scanner.StatusNeedsReview})
+		}
+
+		navFile, issues, err := FindNavigationIssues(root, files)
+		if err != nil {
+			t.Fatalf("FindNavigationIssues() error = %v", err)
+		}
+		want := []NavigationIssue{
+			{Path: filepath.Join(root, "site-docs", "guide", "orphan.md")},
+			{Path: filepath.Join(root, "mkdocs.yml"), Entry: "guide/gone.md"},
+		}
+		if navFile != filepath.Join(root, "mkdocs.yml") || !reflect.DeepEqual(issues, want) {
+			t.Errorf("FindNavigationIssues() = %s, %v, want %v", navFile, issues, want)
+		}
+	})
+
+	t.Run("docusaurus", func(t *testing.T) {
+		root := t.TempDir()
+		writeFile(t, filepath.Join(root, "sidebars.js"), `module.exports = {
+  docs: [
+    'intro',
+    {type: 'category', label: 'Guides', items: ['guides/setup', 'guides/missing']},
+    {type: 'doc', id: 'reference/api', label: 'API'},
+    {type: 'autogenerated', dirName: 'blog'},
+    {'type': 'link', 'label': 'GitHub', 'href': 'https://github.com'}, // Not a doc
+    {type: 'html', value: require('./banner'), className: 'banner'},
+  ],
+};
+`)
+		pages := map[string]string{
+			"01-intro.md":         "# Intro\n",
+			"guides/setup.md":     "# Setup\n",
+			"guides/unlisted.mdx": "# Unlisted\n",
+			"reference/rest.md":   "---\nid: api\n---\n# API\n",
+			"blog/post.md":        "# Post\n",
+		}
+		var files []scanner.File
+		for rel, content := range pages {
+			path := filepath.Join(root, "docs", filepath.FromSlash(rel))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			writeFile(t, path, content)
+			files = append(files, scanner.File{Path: path, Status: scanner.StatusNeedsReview})
+		}
+
+		_, issues, err := FindNavigationIssues(root, files)
+		if err != nil {
+			t.Fatalf("FindNavigationIssues() error = %v", err)
+		}
+		want := []NavigationIssue{
+			{Path: filepath.Join(root, "docs", "guides", "unlisted.mdx")},
+			{Path: filepath.Join(root, "sidebars.js"), Entry: "guides/missing"},
+		}
+		if !reflect.DeepEqual(issues, want) {
+			t.Errorf("FindNavigationIssues() = %v, want %v", issues, want)
+		}
+	})
+
+	t.Run("docusaurus json", func(t *testing.T) {
+		root := t.TempDir()
+		writeFile(t, filepath.Join(root, "docusaurus.config.js"), `module.exports = {
+  presets: [['classic', {docs: {sidebarPath: './sidebars.json', path: 'content'}}]],
+};
+`)
+		writeFile(t, filepath.Join(root, "sidebars.json"),
+			`{"docs":["intro",{"type":"category","label":"Guides","items":["setup"]}],"api":["ref"]}`)
+		var files []scanner.File
+		for _, name := range []string{"intro.md", "setup.md", "unlisted.md"} {
+			path := filepath.Join(root, "content", name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			writeFile(t, path, "# Page\n")
+			files = append(files, scanner.File{Path: path, Status: scanner.StatusNeedsReview})
+		}
+
+		_, issues, err := FindNavigationIssues(root, files)
+		if err != nil {
+			t.Fatalf("FindNavigationIssues() error = %v", err)
+		}
+		want := []NavigationIssue{
+			{Path: filepath.Join(root, "content", "unlisted.md")},
+			{Path: filepath.Join(root, "sidebars.json"), Entry: "ref"},
+		}
+		if !reflect.DeepEqual(issues, want) {
+			t.Errorf("FindNavigationIssues() = %v, want %v", issues, want)
+		}
+	})
+
+	t.Run("no site", func(t *testing.T) {
+		navFile, issues, err := FindNavigationIssues(t.TempDir(), nil)
+		if navFile != "" || issues != nil || err != nil {
+			t.Errorf("FindNavigationIssues() = %q, %v, %v, want nothing", navFile, issues, err)
+		}
+	})
+}
+
+func TestNoteTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"none", "# Heading\n\nIssue #12 is fixed, see https://example.com/#anchor.", nil},
+		{"frontmatter list", "---\ntags: [k8s, \"#networking\"]\n---\nBody", []string{"k8s", "networking"}},
+		{"frontmatter string", "---\ntag: k8s, ops\n---\nBody", []string{"k8s", "ops"}},
+		{"inline", "Notes on #k8s/pods and #K8s/pods.\n\n```\n#not-a-tag\n```\nAnd `#code` too #2024-review", []string{"2024-review", "k8s/pods"}},
+		{"both", "---\ntags:\n  - ops\n---\nAbout #ops and #oncall", []string{"oncall", "ops"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := NoteTags(test.content); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("NoteTags() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCountTags(t *testing.T) {
+	tempDir := t.TempDir()
+	var files []scanner.File
+	for name, content := range map[string]string{
+		"a.md": "---\ntags: [ops, k8s]\n---\nBody",
+		"b.md": "About #ops",
+		"c.md": "About #OPS and #k8s",
+		"d.md": "No tags",
+	} {
+		path := filepath.Join(tempDir, name)
+		writeFile(t, path, content)
+		files = append(files, scanner.File{Path: path, Status: scanner.StatusNeedsReview})
+	}
+	excluded := filepath.Join(tempDir, "excluded.md")
+	writeFile(t, excluded, "#ops")
+	files = append(files, scanner.File{Path: excluded, Status: scanner.StatusExcluded})
+
+	counts := CountTags(files)
+	if len(counts) != 2 || counts[0].Notes != 3 || !strings.EqualFold(counts[0].Tag, "ops") || counts[1] != (TagCount{Tag: "k8s", Notes: 2}) {
+		t.Errorf("CountTags() = %v, want ops in 3 notes and k8s in 2", counts)
+	}
+}
@@ -0,0 +1,310 @@
+package analysis
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"ratemykb/config"
+	"ratemykb/links"
+	"ratemykb/scanner"
+)
+
+// Inventory holds facts about a vault that need no AI engine: where the notes are, how long they are, how they
+// are linked, which attachments they use and how the vault grew
+type Inventory struct {
+	Notes            int               `json:"notes"`              // Notes found by the scan, excluding excluded and binary files
+	Words            int               `json:"words"`              // Words in the bodies of all notes
+	MedianWords      int               `json:"median_words"`       // Median number of words per note
+	Folders          []FolderCount     `json:"folders"`            // Notes by folder, most notes first
+	WordBuckets      []WordBucket      `json:"word_buckets"`       // Notes by number of words, shortest first
+	WithoutBacklinks int               `json:"without_backlinks"`  // Notes no other note links to
+	Orphans          int               `json:"orphans"`            // Notes that neither link to nor are linked from another note
+	Attachments      []AttachmentCount `json:"attachments"`        // Other files of the vault by extension, most files first
+	Growth           []MonthCount      `json:"growth"`             // Notes added by month, oldest first
+	Warnings         []string          `json:"warnings,omitempty"` // Files and folders that could not be read, which are left out
+}
+
+// FolderCount represents the notes of a folder, including its subfolders beyond the grouping depth
+type FolderCount struct {
+	Folder string `json:"folder"` // Slash-separated folder relative to the target folder, "." for the root
+	Notes  int    `json:"notes"`
+	Words  int    `json:"words"`
+}
+
+// WordBucket represents the notes whose number of words falls in a range
+type WordBucket struct {
+	Label string `json:"label"`
+	Notes int    `json:"notes"`
+}
+
+// AttachmentCount represents the files of the vault with one extension that are not notes
+type AttachmentCount struct {
+	Extension string `json:"extension"`
+	Files     int    `json:"files"`
+	Bytes     int64  `json:"bytes"`
+	Unlinked  int    `json:"unlinked"` // Files no note links to or embeds
+}
+
+// MonthCount represents the notes added in a month
+type MonthCount struct {
+	Month string `json:"month"` // Year and month, such as 2024-03
+	Added int    `json:"added"`
+	Total int    `json:"total"` // Notes in the vault at the end of the month
+}
+
+// wordBuckets lists the upper bounds of the word count ranges, the last range having no upper bound
+var wordBuckets = []struct {
+	label string
+	max   int
+}{
+	{"0", 0},
+	{"1-49", 49},
+	{"50-199", 199},
+	{"200-499", 499},
+	{"500-999", 999},
+	{"1000-1999", 1999},
+	{"2000+", -1},
+}
+
+// BuildInventory gathers the facts about the notes of a vault and the other files next to them
+// Notes are grouped by their first depth folder levels (0 for the full path). Growth is based on the creation
+// date in createdField, falling back to the modification time of notes without one. Files inside excluded
+// directories and hidden folders such as .obsidian are left out, as are files and folders that cannot be read, which
+// are listed in the warnings of the inventory.
+func BuildInventory(targetFolder string, files []scanner.File, exclusions []scanner.Exclusion, createdField string, depth int) Inventory {
+	var inventory Inventory
+
+	// List the other files of the vault with their size, which links may refer to
+	others := make(map[string]int64)
+	scanned := make(map[string]bool)
+	isNote := make(map[string]bool)
+	for _, file := range files {
+		scanned[file.Path] = true
+		if file.Status != scanner.StatusExcluded && file.Status != scanner.StatusNotMarkdown && file.Status != scanner.StatusUnreadable {
+			isNote[file.Path] = true
+		}
+	}
+	excluded := make(map[string]bool)
+	for _, exclusion := range exclusions {
+		if exclusion.IsDir {
+			excluded[exclusion.Path] = true
+		}
+	}
+
+	filepath.Walk(targetFolder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			inventory.Warnings = append(inventory.Warnings, fmt.Sprintf("could not read %s: %v", path, err))
+			return nil
+		}
+		if info.IsDir() {
+			if path != targetFolder && (strings.HasPrefix(info.Name(), ".") || info.Name() == config.StateDir || excluded[path]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+
+		// Scanned files, including excluded and binary ones, sync conflicts and generated reports are not attachments
+		if !scanned[path] && !strings.HasPrefix(info.Name(), "vault-quality-report") && info.Name() != config.RollupFile &&
+			!scanner.IsSyncConflict(path) {
+			others[path] = info.Size()
+		}
+		return nil
+	})
+
+	// Read the notes, counting words and following their links
+	resolver := scanner.NewLinkResolver(targetFolder)
+	var words []int
+	folders := make(map[string]*FolderCount)
+	months := make(map[string]int)
+	linksOut := make(map[string]bool)
+	linkedTo := make(map[string]bool)
+	for _, file := range files {
+		if !isNote[file.Path] {
+			continue
+		}
+
+		content, err := scanner.ReadFileContent(file.Path)
+		if err != nil {
+			inventory.Warnings = append(inventory.Warnings, fmt.Sprintf("could not read %s: %v", file.Path, err))
+			delete(isNote, file.Path)
+			continue
+		}
+
+		count := scanner.CountWords(content)
+		words = append(words, count)
+		inventory.Notes++
+		inventory.Words += count
+
+		folder := groupFolder(targetFolder, file.Path, depth)
+		if folders[folder] == nil {
+			folders[folder] = &FolderCount{Folder: folder}
+		}
+		folders[folder].Notes++
+		folders[folder].Words += count
+
+		for _, ref := range links.Refs(content) {
+			target, ok := resolver.Resolve(file.Path, ref)
+			if !ok || target == file.Path {
+				continue
+			}
+			linkedTo[target] = true
+			if isNote[target] {
+				linksOut[file.Path] = true
+			}
+		}
+
+		months[createdMonth(file.Path, content, createdField)]++
+	}
+
+	for path := range isNote {
+		if !linkedTo[path] {
+			inventory.WithoutBacklinks++
+			if !linksOut[path] {
+				inventory.Orphans++
+			}
+		}
+	}
+
+	inventory.MedianWords = median(words)
+	inventory.WordBuckets = bucketWords(words)
+	inventory.Folders = sortedFolders(folders)
+	inventory.Attachments = countAttachments(others, linkedTo)
+	inventory.Growth = growth(months)
+
+	return inventory
+}
+
+// relativeTo returns path relative to root, or path itself if it is not inside root
+func relativeTo(root, path string) string {
+	relPath, err := filepath.Rel(root, path)
+	if err != nil {
+		return path
+	}
+	return relPath
+}
+
+// groupFolder returns the first depth levels of the folder of a note, relative to the target folder
+func groupFolder(targetFolder, path string, depth int) string {
+	folder := filepath.ToSlash(relativeTo(targetFolder, filepath.Dir(path)))
+	if depth <= 0 {
+		return folder
+	}
+	parts := strings.Split(folder, "/")
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+	return strings.Join(parts, "/")
+}
+
+// createdMonth returns the month a note was created, from its frontmatter or else its modification time
+func createdMonth(path, content, createdField string) string {
+	if fields, err := scanner.ParseFrontmatter(content); err == nil {
+		if created, ok, err := dateField(fields, createdField); ok && err == nil {
+			return created.Format("2006-01")
+		}
+	}
+	if info, err := os.Stat(path); err == nil {
+		return info.ModTime().Format("2006-01")
+	}
+	return "unknown"
+}
+
+// median returns the median of the values, 0 if there are none
+func median(values []int) int {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// bucketWords counts the notes in each word count range
+func bucketWords(words []int) []WordBucket {
+	buckets := make([]WordBucket, len(wordBuckets))
+	for i, bucket := range wordBuckets {
+		buckets[i].Label = bucket.label
+	}
+	for _, count := range words {
+		for i, bucket := range wordBuckets {
+			if bucket.max < 0 || count <= bucket.max {
+				buckets[i].Notes++
+				break
+			}
+		}
+	}
+	return buckets
+}
+
+// sortedFolders returns the folder counts with the most notes first
+func sortedFolders(folders map[string]*FolderCount) []FolderCount {
+	result := make([]FolderCount, 0, len(folders))
+	for _, folder := range folders {
+		result = append(result, *folder)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Notes != result[j].Notes {
+			return result[i].Notes > result[j].Notes
+		}
+		return result[i].Folder < result[j].Folder
+	})
+	return result
+}
+
+// countAttachments totals the files that are not notes by extension, most files first
+func countAttachments(sizes map[string]int64, linkedTo map[string]bool) []AttachmentCount {
+	counts := make(map[string]*AttachmentCount)
+	for path, size := range sizes {
+		extension := strings.ToLower(filepath.Ext(path))
+		if extension == "" {
+			extension = "(none)"
+		}
+		if counts[extension] == nil {
+			counts[extension] = &AttachmentCount{Extension: extension}
+		}
+		counts[extension].Files++
+		counts[extension].Bytes += size
+		if !linkedTo[path] {
+			counts[extension].Unlinked++
+		}
+	}
+
+	result := make([]AttachmentCount, 0, len(counts))
+	for _, count := range counts {
+		result = append(result, *count)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Files != result[j].Files {
+			return result[i].Files > result[j].Files
+		}
+		return result[i].Extension < result[j].Extension
+	})
+	return result
+}
+
+// growth returns the notes added in each month with the running total, oldest first
+func growth(months map[string]int) []MonthCount {
+	var keys []string
+	for month := range months {
+		keys = append(keys, month)
+	}
+	sort.Strings(keys)
+
+	result := make([]MonthCount, 0, len(keys))
+	total := 0
+	for _, month := range keys {
+		total += months[month]
+		result = append(result, MonthCount{Month: month, Added: months[month], Total: total})
+	}
+	return result
+}
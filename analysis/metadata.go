@@ -0,0 +1,133 @@
+package analysis
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"ratemykb/scanner"
+)
+
+// MetadataIssue represents a note whose frontmatter dates are clearly wrong
+type MetadataIssue struct {
+	Path     string   `json:"path"`     // Path to the file
+	Problems []string `json:"problems"` // Human readable description of each problem found
+}
+
+// dateTolerance absorbs timezone differences and date-only values when comparing dates
+const dateTolerance = 24 * time.Hour
+
+// dateLayouts lists the date formats accepted in frontmatter date fields
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// FindMetadataIssues validates the created and updated frontmatter fields against each other,
+// the file modification time and the current time
+// Excluded, binary and unreadable files are skipped
+func FindMetadataIssues(files []scanner.File, createdField, updatedField string, now time.Time) []MetadataIssue {
+	var result []MetadataIssue
+	for _, file := range files {
+		if file.Status == scanner.StatusExcluded || file.Status == scanner.StatusNotMarkdown {
+			continue
+		}
+
+		info, err := os.Stat(file.Path)
+		if err != nil {
+			continue
+		}
+
+		content, err := scanner.ReadFileContent(file.Path)
+		if err != nil {
+			continue
+		}
+
+		fields, err := scanner.ParseFrontmatter(content)
+		if err != nil {
+			result = append(result, MetadataIssue{Path: file.Path, Problems: []string{err.Error()}})
+			continue
+		}
+
+		if problems := checkDates(fields, createdField, updatedField, info.ModTime(), now); len(problems) > 0 {
+			result = append(result, MetadataIssue{Path: file.Path, Problems: problems})
+		}
+	}
+
+	// Sort for consistent output
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+
+	return result
+}
+
+// checkDates returns the problems found with the date fields of a single note
+func checkDates(fields map[string]any, createdField, updatedField string, modTime, now time.Time) []string {
+	var problems []string
+
+	created, hasCreated, err := dateField(fields, createdField)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	updated, hasUpdated, err := dateField(fields, updatedField)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if hasCreated {
+		if created.After(now.Add(dateTolerance)) {
+			problems = append(problems, fmt.Sprintf("`%s` is in the future", createdField))
+		}
+		if created.After(modTime.Add(dateTolerance)) {
+			problems = append(problems, fmt.Sprintf("`%s` is after the file was last modified", createdField))
+		}
+	}
+
+	if hasUpdated {
+		if updated.After(now.Add(dateTolerance)) {
+			problems = append(problems, fmt.Sprintf("`%s` is in the future", updatedField))
+		}
+		if updated.After(modTime.Add(dateTolerance)) {
+			problems = append(problems, fmt.Sprintf("`%s` is after the file was last modified", updatedField))
+		}
+	}
+
+	if hasCreated && hasUpdated && created.After(updated) {
+		problems = append(problems, fmt.Sprintf("`%s` is after `%s`", createdField, updatedField))
+	}
+
+	return problems
+}
+
+// dateField reads a date from a frontmatter field
+// It reports whether the field was present and returns an error if its value is not a date
+func dateField(fields map[string]any, name string) (time.Time, bool, error) {
+	if name == "" {
+		return time.Time{}, false, nil
+	}
+
+	value, ok := fields[name]
+	if !ok || value == nil {
+		return time.Time{}, false, nil
+	}
+
+	switch v := value.(type) {
+	case time.Time:
+		return v, true, nil
+	case string:
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, strings.TrimSpace(v)); err == nil {
+				return t, true, nil
+			}
+		}
+	}
+
+	return time.Time{}, false, fmt.Errorf("`%s` is not a valid date: %v", name, value)
+}
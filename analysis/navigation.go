@@ -0,0 +1,392 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"ratemykb/scanner"
+
+	"gopkg.in/yaml.v3"
+)
+
+// NavigationIssue represents a docs page left out of the navigation of the site, or a navigation entry whose page
+// does not exist
+type NavigationIssue struct {
+	Path  string `json:"path"`            // Page left out of the navigation, or the file defining the navigation
+	Entry string `json:"entry,omitempty"` // Navigation entry without a page as written, empty for a page left out
+}
+
+// Files defining the navigation of a docs site, looked for in the target folder
+var (
+	mkdocsFiles   = []string{"mkdocs.yml", "mkdocs.yaml"}
+	sidebarsFiles = []string{"sidebars.js", "sidebars.ts", "sidebars.json"}
+	// Files configuring a Docusaurus site, which can move the docs folder
+	docusaurusFiles = []string{"docusaurus.config.js", "docusaurus.config.ts", "docusaurus.config.mjs"}
+)
+
+// defaultDocsDir is the folder holding the pages of MkDocs and Docusaurus sites unless configured otherwise
+const defaultDocsDir = "docs"
+
+// docsPathPattern matches the path of the docs folder in the docs options of a Docusaurus configuration
+var docsPathPattern = regexp.MustCompile(`\bdocs\s*:\s*\{[^{}]*?\bpath\s*:\s*['"]([^'"]+)['"]`)
+
+// numberPrefixPattern matches the number prefix Docusaurus strips from file and folder names, such as "01-"
+var numberPrefixPattern = regexp.MustCompile(`^\d+[-_.]`)
+
+// FindNavigationIssues checks the navigation of an MkDocs or Docusaurus site in the target folder against its
+// pages, returning the pages the navigation leaves out and the entries pointing to pages that do not exist
+// It also returns the file defining the navigation, empty if the target folder has none.
+func FindNavigationIssues(targetFolder string, files []scanner.File) (string, []NavigationIssue, error) {
+	for _, name := range mkdocsFiles {
+		path := filepath.Join(targetFolder, name)
+		if _, err := os.Stat(path); err == nil {
+			issues, err := checkMkDocs(targetFolder, path, files)
+			return path, issues, err
+		}
+	}
+	for _, name := range sidebarsFiles {
+		path := filepath.Join(targetFolder, name)
+		if _, err := os.Stat(path); err == nil {
+			issues, err := checkSidebars(targetFolder, path, files)
+			return path, issues, err
+		}
+	}
+	return "", nil, nil
+}
+
+// checkMkDocs checks the nav of mkdocs.yml, whose entries are page paths relative to docs_dir
+// Without a nav, MkDocs lists every page, so nothing is left out.
+func checkMkDocs(targetFolder, configPath string, files []scanner.File) ([]NavigationIssue, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filepath.Base(configPath), err)
+	}
+	// Decode into nodes, since MkDocs configurations use Python tags that do not decode into Go values
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filepath.Base(configPath), err)
+	}
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return nil, nil
+	}
+
+	docsDir := defaultDocsDir
+	var nav *yaml.Node
+	root := document.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		switch root.Content[i].Value {
+		case "docs_dir":
+			docsDir = root.Content[i+1].Value
+		case "nav":
+			nav = root.Content[i+1]
+		}
+	}
+	if nav == nil {
+		return nil, nil
+	}
+	docsDir = filepath.Join(targetFolder, filepath.FromSlash(docsDir))
+
+	var entries []string
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		switch node.Kind {
+		case yaml.ScalarNode:
+			if node.Value != "" && !strings.Contains(node.Value, "://") {
+				entries = append(entries, node.Value)
+			}
+		case yaml.SequenceNode:
+			for _, item := range node.Content {
+				walk(item)
+			}
+		case yaml.MappingNode:
+			// Titles map to a page or to a section of further entries
+			for i := 1; i < len(node.Content); i += 2 {
+				walk(node.Content[i])
+			}
+		}
+	}
+	walk(nav)
+
+	var issues []NavigationIssue
+	listed := make(map[string]bool)
+	for _, entry := range entries {
+		rel := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(filepath.FromSlash(entry))), "/")
+		listed[rel] = true
+		if _, err := os.Stat(filepath.Join(docsDir, filepath.FromSlash(rel))); err != nil {
+			issues = append(issues, NavigationIssue{Path: configPath, Entry: entry})
+		}
+	}
+
+	unlisted := unlistedPages(docsDir, files, func(rel string) bool { return listed[rel] })
+	return append(unlisted, issues...), nil
+}
+
+// checkSidebars checks the sidebars of a Docusaurus site, whose entries are doc IDs: the path of a page in the
+// docs folder without its extension or number prefixes, with the last part replaced by the id of its frontmatter
+// JavaScript sidebars are read by the shape of their entries rather than by running the file.
+func checkSidebars(targetFolder, sidebarsPath string, files []scanner.File) ([]NavigationIssue, error) {
+	data, err := os.ReadFile(sidebarsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filepath.Base(sidebarsPath), err)
+	}
+	var entries sidebarEntries
+	if filepath.Ext(sidebarsPath) == ".json" {
+		var sidebars map[string]any
+		if err := json.Unmarshal(data, &sidebars); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", filepath.Base(sidebarsPath), err)
+		}
+		for _, sidebar := range sidebars {
+			entries.addJSON(sidebar)
+		}
+	} else {
+		entries.addScript(string(data))
+	}
+	docsDir := filepath.Join(targetFolder, filepath.FromSlash(docusaurusDocsDir(targetFolder)))
+
+	// Collect the ID of every doc on disk, including the ones the scan left out
+	docs := make(map[string]bool)
+	err = filepath.WalkDir(docsDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if ext := filepath.Ext(path); ext == ".md" || ext == ".mdx" {
+			docs[docID(docsDir, path)] = true
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to list the docs: %w", err)
+	}
+
+	var issues []NavigationIssue
+	listed := make(map[string]bool)
+	seen := make(map[string]bool)
+	for _, id := range entries.ids {
+		listed[id] = true
+		if !docs[id] && !seen[id] {
+			issues = append(issues, NavigationIssue{Path: sidebarsPath, Entry: id})
+		}
+		seen[id] = true
+	}
+	var autogenerated []string
+	for _, dir := range entries.dirs {
+		autogenerated = append(autogenerated, strings.Trim(dir, "/"))
+	}
+
+	unlisted := unlistedPages(docsDir, files, func(rel string) bool {
+		id := docID(docsDir, filepath.Join(docsDir, filepath.FromSlash(rel)))
+		if listed[id] {
+			return true
+		}
+		for _, dir := range autogenerated {
+			if dir == "." || dir == "" || strings.HasPrefix(id, dir+"/") {
+				return true
+			}
+		}
+		return false
+	})
+	return append(unlisted, issues...), nil
+}
+
+// docusaurusDocsDir returns the docs folder of the Docusaurus site in the target folder, relative to it
+func docusaurusDocsDir(targetFolder string) string {
+	for _, name := range docusaurusFiles {
+		data, err := os.ReadFile(filepath.Join(targetFolder, name))
+		if err != nil {
+			continue
+		}
+		if matches := docsPathPattern.FindSubmatch(data); matches != nil {
+			return string(matches[1])
+		}
+		break
+	}
+	return defaultDocsDir
+}
+
+// sidebarEntries holds the doc IDs a Docusaurus sidebar lists and the folders of its autogenerated sections
+type sidebarEntries struct {
+	ids  []string
+	dirs []string
+}
+
+// addJSON adds the entries of a decoded sidebar item, list of items or category shorthand
+func (e *sidebarEntries) addJSON(item any) {
+	switch item := item.(type) {
+	case string:
+		e.ids = append(e.ids, item)
+	case []any:
+		for _, child := range item {
+			e.addJSON(child)
+		}
+	case map[string]any:
+		if _, typed := item["type"]; !typed {
+			// A category shorthand maps labels to their items
+			for _, items := range item {
+				e.addJSON(items)
+			}
+			return
+		}
+		if id, ok := item["id"].(string); ok {
+			e.ids = append(e.ids, id)
+		}
+		if dir, ok := item["dirName"].(string); ok {
+			e.dirs = append(e.dirs, dir)
+		}
+		if link, ok := item["link"].(map[string]any); ok {
+			e.addJSON(link)
+		}
+		e.addJSON(item["items"])
+	}
+}
+
+// scriptFrame is an array, object or call open at some point of a sidebars script
+type scriptFrame struct {
+	kind  byte   // '[', '{' or '('
+	key   string // Key of the property being read, for objects
+	items bool   // Whether the strings in the frame are sidebar items, for arrays
+	list  bool   // Whether the properties of the frame list items, for objects
+}
+
+// addScript adds the entries of a sidebars script
+// Strings count as doc IDs only when they are listed in the arrays of the sidebars, in items arrays or in category
+// shorthands, or when they are the value of an id property, so labels, links and other options are left out.
+func (e *sidebarEntries) addScript(content string) {
+	var stack []*scriptFrame
+	top := func() *scriptFrame {
+		if len(stack) == 0 {
+			return &scriptFrame{}
+		}
+		return stack[len(stack)-1]
+	}
+	// value handles a string or name read in the script, which is a property key when a colon follows it
+	value := func(token string, quoted bool, next int) int {
+		frame := top()
+		rest := strings.TrimLeft(content[next:], " \t\r\n")
+		if frame.kind == '{' && strings.HasPrefix(rest, ":") {
+			frame.key = token
+			return len(content) - len(rest) + 1
+		}
+		if !quoted {
+			return next
+		}
+		switch {
+		case frame.kind == '[' && frame.items:
+			e.ids = append(e.ids, token)
+		case frame.kind == '{' && frame.key == "id":
+			e.ids = append(e.ids, token)
+		case frame.kind == '{' && frame.key == "dirName":
+			e.dirs = append(e.dirs, token)
+		case frame.kind == '{' && frame.key == "type":
+			// Typed items are not category shorthands
+			frame.list = false
+		}
+		return next
+	}
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case strings.HasPrefix(content[i:], "//"):
+			end := strings.IndexByte(content[i:], '\n')
+			if end < 0 {
+				return
+			}
+			i += end
+		case strings.HasPrefix(content[i:], "/*"):
+			end := strings.Index(content[i+2:], "*/")
+			if end < 0 {
+				return
+			}
+			i += end + 4
+		case c == '\'' || c == '"' || c == '`':
+			j := i + 1
+			for j < len(content) && content[j] != c {
+				if content[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(content) {
+				return
+			}
+			i = value(content[i+1:j], true, j+1)
+		case c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9':
+			j := i + 1
+			for j < len(content) && (content[j] == '_' || content[j] == '$' || content[j] >= 'a' && content[j] <= 'z' ||
+				content[j] >= 'A' && content[j] <= 'Z' || content[j] >= '0' && content[j] <= '9') {
+				j++
+			}
+			i = value(content[i:j], false, j)
+		case c == '[':
+			parent := top()
+			items := parent.kind == '{' && (parent.key == "items" || parent.list)
+			stack = append(stack, &scriptFrame{kind: '[', items: items})
+			i++
+		case c == '{':
+			// The properties of the outermost object are the sidebars, and objects listed as items without a type are
+			// category shorthands
+			parent := top()
+			list := len(stack) == 0 || parent.kind == '[' && parent.items
+			stack = append(stack, &scriptFrame{kind: '{', list: list})
+			i++
+		case c == '(':
+			stack = append(stack, &scriptFrame{kind: '('})
+			i++
+		case c == ']' || c == '}' || c == ')':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			i++
+		case c == ',':
+			top().key = ""
+			i++
+		default:
+			i++
+		}
+	}
+}
+
+// docID returns the Docusaurus doc ID of a page in the docs folder
+func docID(docsDir, path string) string {
+	rel, err := filepath.Rel(docsDir, path)
+	if err != nil {
+		rel = path
+	}
+	parts := strings.Split(filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel))), "/")
+	for i, part := range parts {
+		parts[i] = numberPrefixPattern.ReplaceAllString(part, "")
+	}
+	if content, err := scanner.ReadFileContent(path); err == nil {
+		if fields, err := scanner.ParseFrontmatter(content); err == nil {
+			if id, ok := fields["id"].(string); ok && id != "" {
+				parts[len(parts)-1] = id
+			}
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// unlistedPages returns the scanned pages in the docs folder the navigation does not list, sorted by path
+func unlistedPages(docsDir string, files []scanner.File, isListed func(rel string) bool) []NavigationIssue {
+	var issues []NavigationIssue
+	for _, file := range files {
+		if file.Status == scanner.StatusExcluded {
+			continue
+		}
+		rel, err := filepath.Rel(docsDir, file.Path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		if !isListed(filepath.ToSlash(rel)) {
+			issues = append(issues, NavigationIssue{Path: file.Path})
+		}
+	}
+	sort.Slice(issues, func(i, j int) bool { return issues[i].Path < issues[j].Path })
+	return issues
+}
@@ -0,0 +1,79 @@
+package analysis
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"ratemykb/config"
+	"ratemykb/scanner"
+)
+
+// SourceCheck finds the reference notes that make claims without citing any source
+type SourceCheck struct {
+	folders  []*regexp.Regexp
+	tags     []string // Lowercase without #
+	patterns []*regexp.Regexp
+	minWords int
+}
+
+// NewSourceCheck compiles the configured source check
+func NewSourceCheck(cfg config.SourcesConfig) (*SourceCheck, error) {
+	check := &SourceCheck{minWords: cfg.MinWords}
+	for _, match := range cfg.Match {
+		if strings.TrimSpace(match) != "" {
+			check.folders = append(check.folders, config.FolderPattern(match))
+		}
+	}
+	for _, tag := range cfg.Tags {
+		if tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#")); tag != "" {
+			check.tags = append(check.tags, tag)
+		}
+	}
+	for i, pattern := range cfg.Patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("source pattern %d: %w", i+1, err)
+		}
+		check.patterns = append(check.patterns, compiled)
+	}
+	return check, nil
+}
+
+// Applies reports whether a note is a reference note long enough to make claims, given its slash-separated path
+// relative to the target folder
+// Without folders or tags every note is a reference note; otherwise a note must be in one of the folders or have
+// one of the tags or their nested tags.
+func (s *SourceCheck) Applies(relPath, content string) bool {
+	if scanner.CountWords(content) < s.minWords {
+		return false
+	}
+	if len(s.folders) == 0 && len(s.tags) == 0 {
+		return true
+	}
+	for _, folder := range s.folders {
+		if folder.MatchString(relPath) {
+			return true
+		}
+	}
+	for _, tag := range NoteTags(content) {
+		tag = strings.ToLower(tag)
+		if slices.ContainsFunc(s.tags, func(wanted string) bool {
+			return tag == wanted || strings.HasPrefix(tag, wanted+"/")
+		}) {
+			return true
+		}
+	}
+	return false
+}
+
+// Cited reports whether a note cites a source, matching any of the source patterns
+func (s *SourceCheck) Cited(content string) bool {
+	for _, pattern := range s.patterns {
+		if pattern.MatchString(content) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package analysis
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"ratemykb/scanner"
+)
+
+// TagCount represents a tag used in the vault and the number of notes using it
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Notes int    `json:"notes"`
+}
+
+// tagFields are the frontmatter fields Obsidian reads tags from
+var tagFields = []string{"tags", "tag"}
+
+// inlineTagPattern matches a #tag in the body of a note, which must contain a character other than a digit
+var inlineTagPattern = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_/-]*[\p{L}_/-][\p{L}\p{N}_/-]*)`)
+
+// fencePattern matches the start or end of a fenced code block, whose lines are not searched for tags
+var fencePattern = regexp.MustCompile("^\\s*(```|~~~)")
+
+// inlineCodePattern matches inline code, which is not searched for tags
+var inlineCodePattern = regexp.MustCompile("`[^`]*`")
+
+// NoteTags returns the tags of a note, from its frontmatter and its body, without the leading # and sorted
+// Headings are not tags, since a tag cannot be followed by a space.
+func NoteTags(content string) []string {
+	seen := make(map[string]bool)
+	var tags []string
+	add := func(tag string) {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "#")
+		if tag != "" && !seen[strings.ToLower(tag)] {
+			seen[strings.ToLower(tag)] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	if fields, err := scanner.ParseFrontmatter(content); err == nil {
+		for _, field := range tagFields {
+			switch value := fields[field].(type) {
+			case string:
+				for _, tag := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+					add(tag)
+				}
+			case []any:
+				for _, tag := range value {
+					add(fmt.Sprint(tag))
+				}
+			}
+		}
+	}
+
+	_, body := scanner.SplitFrontmatter(content)
+	inFence := false
+	for _, line := range strings.Split(body, "\n") {
+		if fencePattern.MatchString(line) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+		for _, match := range inlineTagPattern.FindAllStringSubmatch(inlineCodePattern.ReplaceAllString(line, ""), -1) {
+			add(match[1])
+		}
+	}
+
+	sort.Strings(tags)
+	return tags
+}
+
+// CountTags counts the notes using each tag, ignoring case, most used first and then by tag
+// Excluded, binary and unreadable files are skipped.
+func CountTags(files []scanner.File) []TagCount {
+	counts := make(map[string]*TagCount)
+	for _, file := range files {
+		if file.Status == scanner.StatusExcluded || file.Status == scanner.StatusNotMarkdown {
+			continue
+		}
+		content, err := scanner.ReadFileContent(file.Path)
+		if err != nil {
+			continue
+		}
+		for _, tag := range NoteTags(content) {
+			key := strings.ToLower(tag)
+			if counts[key] == nil {
+				counts[key] = &TagCount{Tag: tag}
+			}
+			counts[key].Notes++
+		}
+	}
+
+	result := make([]TagCount, 0, len(counts))
+	for _, count := range counts {
+		result = append(result, *count)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Notes != result[j].Notes {
+			return result[i].Notes > result[j].Notes
+		}
+		return result[i].Tag < result[j].Tag
+	})
+	return result
+}
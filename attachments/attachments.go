@@ -0,0 +1,120 @@
+package attachments
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"ratemykb/classification"
+	"ratemykb/links"
+	"ratemykb/scanner"
+)
+
+// Package attachments finds the PDF and Word documents linked from notes and extracts their text, so the knowledge
+// kept in attachments can be classified like notes
+
+// ErrUnsupported indicates the text of an attachment cannot be extracted because of its file type
+var ErrUnsupported = errors.New("unsupported attachment type")
+
+// Attachment represents a file linked from notes whose text is classified along with the notes
+type Attachment struct {
+	Path           string                        `json:"path"`                     // Path to the attachment
+	LinkedFrom     []string                      `json:"linked_from"`              // Paths to the notes linking to the attachment
+	Modified       time.Time                     `json:"modified"`                 // Modification time, used to reuse the previous classification
+	Settings       string                        `json:"settings,omitempty"`       // Hash of the settings the attachment was classified with
+	Words          int                           `json:"words"`                    // Number of words in the extracted text
+	Classification classification.Classification `json:"classification,omitempty"` // Classification of the extracted text
+	Problem        string                        `json:"problem,omitempty"`        // Why the attachment could not be classified
+}
+
+// Find returns the attachments with one of the given extensions that are linked from the notes, sorted by path
+// Links to files that do not exist are left out.
+func Find(targetFolder string, files []scanner.File, extensions []string) ([]Attachment, error) {
+	wanted := make(map[string]bool)
+	for _, extension := range extensions {
+		wanted[strings.ToLower(extension)] = true
+	}
+
+	resolver := scanner.NewLinkResolver(targetFolder)
+	found := make(map[string]*Attachment)
+	for _, file := range files {
+		if file.Status == scanner.StatusEmpty || file.Status == scanner.StatusNotMarkdown || file.Status == scanner.StatusUnreadable {
+			continue
+		}
+
+		content, err := scanner.ReadFileContent(file.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file.Path, err)
+		}
+
+		for _, ref := range links.Refs(content) {
+			if strings.Contains(ref, "://") || !wanted[strings.ToLower(filepath.Ext(ref))] {
+				continue
+			}
+
+			path, ok := resolver.Resolve(file.Path, ref)
+			if !ok {
+				continue
+			}
+
+			attachment, ok := found[path]
+			if !ok {
+				info, err := os.Stat(path)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read %s: %w", path, err)
+				}
+				attachment = &Attachment{Path: path, Modified: info.ModTime()}
+				found[path] = attachment
+			}
+			if !containsPath(attachment.LinkedFrom, file.Path) {
+				attachment.LinkedFrom = append(attachment.LinkedFrom, file.Path)
+			}
+		}
+	}
+
+	attachments := make([]Attachment, 0, len(found))
+	for _, attachment := range found {
+		sort.Strings(attachment.LinkedFrom)
+		attachments = append(attachments, *attachment)
+	}
+	sort.Slice(attachments, func(i, j int) bool {
+		return attachments[i].Path < attachments[j].Path
+	})
+	return attachments, nil
+}
+
+// ExtractText returns the text of an attachment, reading at most limit bytes of text (0 for no limit)
+func ExtractText(path string, limit int64) (string, error) {
+	var text string
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".pdf":
+		text, err = extractPDF(path)
+	case ".docx":
+		text, err = extractDOCX(path)
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnsupported, filepath.Ext(path))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if limit > 0 && int64(len(text)) > limit {
+		text = strings.ToValidUTF8(text[:limit], "")
+	}
+	return text, nil
+}
+
+// containsPath reports whether paths contains path
+func containsPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package attachments
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"ratemykb/scanner"
+)
+
+// writePDF writes a PDF with one uncompressed and one compressed content stream
+func writePDF(t *testing.T, path string) {
+	t.Helper()
+
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	writer.Write([]byte("BT /F1 12 Tf 72 700 Td [(Second) -250 (page)] TJ 0 -14 Td (with \\(nested\\) text) Tj ET"))
+	writer.Close()
+
+	plain := "BT /F1 12 Tf 72 712 Td (First page) Tj T* <FEFF00E9007400E9> Tj ET"
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	pdf.WriteString(fmt.Sprintf("4 0 obj\n<< /Length %d >>\nstream\n%s\nendstream\nendobj\n", len(plain), plain))
+	pdf.WriteString(fmt.Sprintf("5 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n", compressed.Len()))
+	pdf.Write(compressed.Bytes())
+	pdf.WriteString("\nendstream\nendobj\n")
+	pdf.WriteString("6 0 obj\n<< /Length 4 /Filter /DCTDecode >>\nstream\nBT..\nendstream\nendobj\n%%EOF\n")
+
+	if err := os.WriteFile(path, pdf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write PDF: %v", err)
+	}
+}
+
+// writeDOCX writes a Word document with two paragraphs
+func writeDOCX(t *testing.T, path string) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	document, err := archive.Create("word/document.xml")
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+	document.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+<w:p><w:r><w:t>Meeting</w:t></w:r><w:r><w:t xml:space="preserve"> notes</w:t></w:r></w:p>
+<w:p><w:r><w:t>Decisions &amp; actions</w:t></w:r></w:p>
+</w:body></w:document>`))
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Failed to write document: %v", err)
+	}
+}
+
+func TestExtractText(t *testing.T) {
+	tempDir := t.TempDir()
+
+	pdfPath := filepath.Join(tempDir, "slides.pdf")
+	writePDF(t, pdfPath)
+	text, err := ExtractText(pdfPath, 0)
+	if err != nil {
+		t.Fatalf("ExtractText() error = %v", err)
+	}
+	if got, want := strings.Fields(text), []string{"First", "page", "été", "Second", "page", "with", "(nested)", "text"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractText() words = %q, want %q", got, want)
+	}
+
+	docxPath := filepath.Join(tempDir, "minutes.docx")
+	writeDOCX(t, docxPath)
+	text, err = ExtractText(docxPath, 0)
+	if err != nil {
+		t.Fatalf("ExtractText() error = %v", err)
+	}
+	if want := "Meeting notes\nDecisions & actions\n"; text != want {
+		t.Errorf("ExtractText() = %q, want %q", text, want)
+	}
+
+	text, err = ExtractText(docxPath, 7)
+	if err != nil || text != "Meeting" {
+		t.Errorf("ExtractText() with limit = %q, %v, want %q", text, err, "Meeting")
+	}
+
+	if _, err := ExtractText(filepath.Join(tempDir, "sheet.xlsx"), 0); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("ExtractText() error = %v, want %v", err, ErrUnsupported)
+	}
+}
+
+func TestFind(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(tempDir, "attachments"), 0755); err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+	writePDF(t, filepath.Join(tempDir, "attachments", "slides.pdf"))
+	writeDOCX(t, filepath.Join(tempDir, "minutes.docx"))
+
+	notes := map[string]string{
+		"first.md":  "See ![[slides.pdf]] and [minutes](minutes.docx)",
+		"second.md": "Also [[attachments/slides.pdf|the slides]], [[missing.pdf]] and ![[photo.png]]",
+	}
+	var files []scanner.File
+	for name, content := range notes {
+		path := filepath.Join(tempDir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write note: %v", err)
+		}
+		files = append(files, scanner.File{Path: path, Status: scanner.StatusNeedsReview})
+	}
+
+	found, err := Find(tempDir, files, []string{".pdf", ".DOCX"})
+	if err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+
+	if len(found) != 2 {
+		t.Fatalf("Expected 2 attachments, got %d: %+v", len(found), found)
+	}
+	if found[0].Path != filepath.Join(tempDir, "attachments", "slides.pdf") {
+		t.Errorf("Expected slides first, got %s", found[0].Path)
+	}
+	if want := []string{filepath.Join(tempDir, "first.md"), filepath.Join(tempDir, "second.md")}; !reflect.DeepEqual(found[0].LinkedFrom, want) {
+		t.Errorf("Expected slides to be linked from %v, got %v", want, found[0].LinkedFrom)
+	}
+	if found[1].Path != filepath.Join(tempDir, "minutes.docx") || len(found[1].LinkedFrom) != 1 {
+		t.Errorf("Expected minutes linked from one note, got %+v", found[1])
+	}
+}
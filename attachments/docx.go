@@ -0,0 +1,63 @@
+package attachments
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// extractDOCX returns the text of the paragraphs of a Word document, one paragraph per line
+func extractDOCX(path string) (string, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer archive.Close()
+
+	document, err := archive.Open("word/document.xml")
+	if err != nil {
+		return "", fmt.Errorf("failed to read document of %s: %w", path, err)
+	}
+	defer document.Close()
+
+	var text strings.Builder
+	inText := false
+	decoder := xml.NewDecoder(document)
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to parse document of %s: %w", path, err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				text.WriteString("\t")
+			case "br":
+				text.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				text.Write(t)
+			}
+		}
+	}
+
+	return text.String(), nil
+}
@@ -0,0 +1,348 @@
+package attachments
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// unsupportedFilters lists the stream filters whose content is not text, or not worth decoding for text
+var unsupportedFilters = []string{"/DCTDecode", "/JPXDecode", "/CCITTFaxDecode", "/JBIG2Decode", "/LZWDecode", "/ASCII85Decode", "/ASCIIHexDecode", "/RunLengthDecode"}
+
+// extractPDF returns the text shown by the content streams of a PDF
+// Extraction is best effort: text drawn with fonts that use their own glyph encoding, as well as scanned pages,
+// yield no text.
+func extractPDF(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF")) {
+		return "", fmt.Errorf("failed to read %s: not a PDF file", path)
+	}
+
+	var text strings.Builder
+	for _, stream := range pdfStreams(data) {
+		if bytes.Contains(stream, []byte("BT")) {
+			text.WriteString(pdfContentText(stream))
+		}
+	}
+	return text.String(), nil
+}
+
+// pdfStreams returns the decoded streams of a PDF, leaving out streams with filters that cannot be decoded
+func pdfStreams(data []byte) [][]byte {
+	var streams [][]byte
+	for offset := 0; ; {
+		start := bytes.Index(data[offset:], []byte("stream"))
+		if start < 0 {
+			break
+		}
+		start += offset
+		offset = start + len("stream")
+
+		// Only the keyword following a stream dictionary starts a stream, which rules out "endstream"
+		dictEnd := bytes.TrimRight(data[:start], " \t\r\n")
+		if !bytes.HasSuffix(dictEnd, []byte(">>")) {
+			continue
+		}
+		dict := dictEnd[max(0, len(dictEnd)-1024):]
+		if obj := bytes.LastIndex(dict, []byte("obj")); obj >= 0 {
+			dict = dict[obj:]
+		}
+
+		// The stream data starts after the end of line following the keyword
+		bodyStart := offset
+		if bytes.HasPrefix(data[bodyStart:], []byte("\r\n")) {
+			bodyStart += 2
+		} else if bytes.HasPrefix(data[bodyStart:], []byte("\n")) || bytes.HasPrefix(data[bodyStart:], []byte("\r")) {
+			bodyStart++
+		}
+		end := bytes.Index(data[bodyStart:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		body := data[bodyStart : bodyStart+end]
+		offset = bodyStart + end + len("endstream")
+
+		if decoded, ok := decodeStream(dict, body); ok {
+			streams = append(streams, decoded)
+		}
+	}
+	return streams
+}
+
+// decodeStream decodes the data of a stream according to the filters of its dictionary
+func decodeStream(dict, body []byte) ([]byte, bool) {
+	for _, filter := range unsupportedFilters {
+		if bytes.Contains(dict, []byte(filter)) {
+			return nil, false
+		}
+	}
+	if !bytes.Contains(dict, []byte("/FlateDecode")) {
+		return body, true
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, false
+	}
+	defer reader.Close()
+
+	// Keep what could be decoded from a damaged stream
+	decoded, _ := io.ReadAll(reader)
+	return decoded, len(decoded) > 0
+}
+
+// pdfContentText returns the text shown by the text operators of a content stream
+func pdfContentText(content []byte) string {
+	var text strings.Builder
+	var operands []any // Strings and numbers since the last operator
+	lexer := &pdfLexer{data: content}
+
+	for {
+		token, ok := lexer.next()
+		if !ok {
+			break
+		}
+
+		switch t := token.(type) {
+		case pdfString, float64, pdfArray:
+			operands = append(operands, t)
+			continue
+		case pdfOperator:
+			switch t {
+			case "Tj", "TJ":
+				writeOperandText(&text, operands)
+			case "'", "\"":
+				text.WriteString("\n")
+				writeOperandText(&text, operands)
+			case "T*", "ET":
+				text.WriteString("\n")
+			case "Td", "TD":
+				// Moving down starts a new line, moving right separates words
+				if len(operands) == 2 {
+					if y, ok := operands[1].(float64); ok && y != 0 {
+						text.WriteString("\n")
+						break
+					}
+				}
+				text.WriteString(" ")
+			case "Tm":
+				text.WriteString(" ")
+			}
+		}
+		operands = operands[:0]
+	}
+
+	return text.String()
+}
+
+// writeOperandText writes the text of the last string or array operand of a text operator
+func writeOperandText(text *strings.Builder, operands []any) {
+	if len(operands) == 0 {
+		return
+	}
+	switch operand := operands[len(operands)-1].(type) {
+	case pdfString:
+		text.WriteString(decodePDFString(operand))
+	case pdfArray:
+		for _, element := range operand {
+			switch e := element.(type) {
+			case pdfString:
+				text.WriteString(decodePDFString(e))
+			case float64:
+				// Large negative adjustments move the next glyph far enough right to separate words
+				if e < -200 {
+					text.WriteString(" ")
+				}
+			}
+		}
+	}
+}
+
+// decodePDFString converts a PDF string to text, leaving out characters that cannot be printed
+// Strings with a UTF-16 byte order mark are decoded as such, others as latin-1, which is close to the
+// encoding of standard fonts.
+func decodePDFString(s pdfString) string {
+	var runes []rune
+	if bytes.HasPrefix(s, []byte{0xFE, 0xFF}) {
+		units := make([]uint16, 0, len(s)/2)
+		for i := 2; i+1 < len(s); i += 2 {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		runes = utf16.Decode(units)
+	} else {
+		for _, b := range s {
+			runes = append(runes, rune(b))
+		}
+	}
+
+	var text strings.Builder
+	for _, r := range runes {
+		if unicode.IsPrint(r) || r == '\n' || r == '\t' {
+			text.WriteRune(r)
+		}
+	}
+	return text.String()
+}
+
+// Tokens of a content stream
+type (
+	pdfString   []byte
+	pdfArray    []any
+	pdfOperator string
+)
+
+// pdfLexer splits a content stream into operands and operators
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+// next returns the next string, number, array or operator, skipping names, dictionaries and comments
+func (l *pdfLexer) next() (any, bool) {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case isPDFSpace(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		case c == '(':
+			return l.literalString(), true
+		case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<', c == '>' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '>':
+			l.pos += 2
+		case c == '<':
+			return l.hexString(), true
+		case c == '[':
+			l.pos++
+			var array pdfArray
+			for {
+				token, ok := l.next()
+				if !ok || token == pdfOperator("]") {
+					return array, true
+				}
+				array = append(array, token)
+			}
+		case c == ']':
+			l.pos++
+			return pdfOperator("]"), true
+		case c == '/':
+			l.pos++
+			l.regular()
+		case c == '{', c == '}', c == ')', c == '>':
+			l.pos++
+		default:
+			word := l.regular()
+			if number, err := strconv.ParseFloat(word, 64); err == nil {
+				return number, true
+			}
+			return pdfOperator(word), true
+		}
+	}
+	return nil, false
+}
+
+// regular reads a run of regular characters, such as a number, name or operator
+func (l *pdfLexer) regular() string {
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !strings.ContainsRune("()<>[]{}/%", rune(l.data[l.pos])) {
+		l.pos++
+	}
+	if l.pos == start {
+		l.pos++
+	}
+	return string(l.data[start:l.pos])
+}
+
+// literalString reads a string in parentheses, resolving escapes and balanced nested parentheses
+func (l *pdfLexer) literalString() pdfString {
+	var s pdfString
+	depth := 0
+	for l.pos++; l.pos < len(l.data); l.pos++ {
+		c := l.data[l.pos]
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				l.pos++
+				return s
+			}
+			depth--
+		case '\\':
+			l.pos++
+			if l.pos >= len(l.data) {
+				return s
+			}
+			c = l.data[l.pos]
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// A backslash at the end of a line continues the string on the next line
+				if c == '\r' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '\n' {
+					l.pos++
+				}
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					value := 0
+					for i := 0; i < 3 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						value = value*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					l.pos--
+					c = byte(value)
+				}
+			}
+		}
+		s = append(s, c)
+	}
+	return s
+}
+
+// hexString reads a string of hexadecimal digits in angle brackets
+func (l *pdfLexer) hexString() pdfString {
+	var digits []byte
+	for l.pos++; l.pos < len(l.data) && l.data[l.pos] != '>'; l.pos++ {
+		if c := l.data[l.pos]; !isPDFSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	l.pos++
+
+	// A missing final digit is taken to be 0
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	s := make(pdfString, 0, len(digits)/2)
+	for i := 0; i < len(digits); i += 2 {
+		if value, err := strconv.ParseUint(string(digits[i:i+2]), 16, 8); err == nil {
+			s = append(s, byte(value))
+		}
+	}
+	return s
+}
+
+// isPDFSpace reports whether c is a PDF whitespace character
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
@@ -0,0 +1,291 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"ratemykb/classification"
+	"ratemykb/config"
+	"ratemykb/output"
+	"ratemykb/scanner"
+)
+
+// Package audit samples classified files for a human to check, and records how often they agreed with the
+// classifier under .ratemykb/audits/, so its quality can be followed over time.
+
+// ErrNoChecklist is returned when an audit is recorded before any checklist was written
+var ErrNoChecklist = errors.New("no audit checklist found, write one with --sample first")
+
+// Audit is the outcome of a checklist a human went through
+type Audit struct {
+	ID       string               `json:"id"`
+	Model    string               `json:"model,omitempty"` // Model that classified the files when they were sampled
+	Sampled  time.Time            `json:"sampled"`
+	Recorded time.Time            `json:"recorded"`
+	Labels   map[string]Agreement `json:"labels"` // Agreement per label of the sampled files
+}
+
+// Agreement counts the sampled files of a label and the ones whose label the human agreed with
+type Agreement struct {
+	Agreed int `json:"agreed"`
+	Total  int `json:"total"`
+}
+
+// Rate returns the share of the files whose label the human agreed with, 0 without files
+func (a Agreement) Rate() float64 {
+	if a.Total == 0 {
+		return 0
+	}
+	return float64(a.Agreed) / float64(a.Total)
+}
+
+// Overall returns the agreement over every label of the audit
+func (a Audit) Overall() Agreement {
+	var overall Agreement
+	for _, agreement := range a.Labels {
+		overall.Agreed += agreement.Agreed
+		overall.Total += agreement.Total
+	}
+	return overall
+}
+
+// Dir returns the folder where the audits of a target folder are kept
+func Dir(targetFolder string) string {
+	return filepath.Join(targetFolder, config.StateDir, "audits")
+}
+
+// Sample picks up to size files classified by the GenAI engine, spread over their labels: every label gets a file
+// if the size allows, and the rest is shared in proportion to the number of files of each label
+// Files are picked at random within a label, and returned sorted by label and path.
+func Sample(files []output.ResultFile, size int, rng *rand.Rand) []output.ResultFile {
+	byLabel := make(map[string][]output.ResultFile)
+	for _, file := range files {
+		if file.Status != scanner.StatusNeedsReview || file.Classification == "" || file.Classification == classification.TimedOut {
+			continue
+		}
+		label := string(file.Classification)
+		byLabel[label] = append(byLabel[label], file)
+	}
+	labels := make([]string, 0, len(byLabel))
+	for label := range byLabel {
+		labels = append(labels, label)
+	}
+	// Larger labels come first, so they are the ones sampled when the size is smaller than the number of labels
+	sort.Slice(labels, func(i, j int) bool {
+		if len(byLabel[labels[i]]) != len(byLabel[labels[j]]) {
+			return len(byLabel[labels[i]]) > len(byLabel[labels[j]])
+		}
+		return labels[i] < labels[j]
+	})
+	// Shuffle in a fixed order, so the same seed draws the same sample
+	for _, label := range labels {
+		group := byLabel[label]
+		sort.Slice(group, func(i, j int) bool { return group[i].Path < group[j].Path })
+		rng.Shuffle(len(group), func(i, j int) { group[i], group[j] = group[j], group[i] })
+	}
+
+	// One file per label first, then each next file to the label with the most files per file already picked
+	picked := make(map[string]int)
+	for _, label := range labels {
+		if size <= 0 {
+			break
+		}
+		picked[label] = 1
+		size--
+	}
+	for ; size > 0; size-- {
+		best, bestShare := "", 0.0
+		for _, label := range labels {
+			if picked[label] == 0 || picked[label] >= len(byLabel[label]) {
+				continue
+			}
+			if share := float64(len(byLabel[label])) / float64(picked[label]+1); share > bestShare {
+				best, bestShare = label, share
+			}
+		}
+		if best == "" {
+			break
+		}
+		picked[best]++
+	}
+
+	var sample []output.ResultFile
+	for _, label := range labels {
+		sample = append(sample, byLabel[label][:picked[label]]...)
+	}
+	sort.Slice(sample, func(i, j int) bool {
+		if sample[i].Classification != sample[j].Classification {
+			return sample[i].Classification < sample[j].Classification
+		}
+		return sample[i].Path < sample[j].Path
+	})
+	return sample
+}
+
+// Lines of a checklist read back when it is recorded
+var (
+	idPattern      = regexp.MustCompile("^Audit ID: (.+)$")
+	modelPattern   = regexp.MustCompile("^Model: (.+)$")
+	sampledPattern = regexp.MustCompile("^Sampled on: (.+)$")
+	itemPattern    = regexp.MustCompile("^- \\[([ xX])\\] `")
+)
+
+// WriteChecklist writes the sample as a markdown checklist for a human to tick the files whose label is right,
+// returning its path
+// Checklists are named after their audit ID, a sortable timestamp with a numeric suffix if several audits start
+// within the same second.
+func WriteChecklist(targetFolder, model string, sample []output.ResultFile, now time.Time) (string, error) {
+	if err := os.MkdirAll(Dir(targetFolder), 0755); err != nil {
+		return "", fmt.Errorf("failed to create audits folder: %w", err)
+	}
+
+	base := now.Format("20060102-150405")
+	id, path := base, ""
+	for i := 1; ; i++ {
+		path = filepath.Join(Dir(targetFolder), id+".md")
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			file.Close()
+			break
+		}
+		if !os.IsExist(err) {
+			return "", fmt.Errorf("failed to create audit checklist: %w", err)
+		}
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+
+	var content strings.Builder
+	content.WriteString("# Classification Audit\n\n")
+	content.WriteString(fmt.Sprintf("Audit ID: %s\n", id))
+	if model != "" {
+		content.WriteString(fmt.Sprintf("Model: %s\n", model))
+	}
+	content.WriteString(fmt.Sprintf("Sampled on: %s\n\n", now.Format(time.RFC3339)))
+	content.WriteString("Tick each file whose label is right and leave the others unticked, then run `ratemykb audit --record`.\n")
+
+	label := ""
+	for _, file := range sample {
+		if string(file.Classification) != label {
+			label = string(file.Classification)
+			content.WriteString(fmt.Sprintf("\n## %s\n\n", label))
+		}
+		rel, err := filepath.Rel(targetFolder, file.Path)
+		if err != nil {
+			rel = file.Path
+		}
+		entry := fmt.Sprintf("- [ ] `%s`", filepath.ToSlash(rel))
+		if file.Provider != "" {
+			// With fallback engines, the model above may not be the one that classified the file
+			entry += fmt.Sprintf(" (by %s)", file.Provider)
+		}
+		if file.Reason != "" {
+			entry += " — " + file.Reason
+		}
+		content.WriteString(entry + "\n")
+	}
+
+	if err := os.WriteFile(path, []byte(content.String()), 0644); err != nil {
+		return "", fmt.Errorf("failed to write audit checklist: %w", err)
+	}
+	return path, nil
+}
+
+// Latest returns the path of the most recent checklist of a target folder
+func Latest(targetFolder string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(Dir(targetFolder), "*.md"))
+	if err != nil {
+		return "", fmt.Errorf("failed to list audit checklists: %w", err)
+	}
+	if len(matches) == 0 {
+		return "", ErrNoChecklist
+	}
+	// Compare the IDs, since the extension would sort an ID before the one with a suffix
+	sort.Slice(matches, func(i, j int) bool {
+		return strings.TrimSuffix(matches[i], ".md") < strings.TrimSuffix(matches[j], ".md")
+	})
+	return matches[len(matches)-1], nil
+}
+
+// Record counts the ticked files of a checklist per label and saves the outcome next to it, replacing the outcome
+// of an earlier recording of the same checklist
+// Unticked files count as disagreements.
+func Record(checklistPath string, now time.Time) (Audit, error) {
+	file, err := os.Open(checklistPath)
+	if err != nil {
+		return Audit{}, fmt.Errorf("failed to open audit checklist: %w", err)
+	}
+	defer file.Close()
+
+	audit := Audit{Recorded: now, Labels: make(map[string]Agreement)}
+	label := ""
+	lines := bufio.NewScanner(file)
+	for lines.Scan() {
+		line := lines.Text()
+		if matches := idPattern.FindStringSubmatch(line); matches != nil {
+			audit.ID = matches[1]
+		} else if matches := modelPattern.FindStringSubmatch(line); matches != nil {
+			audit.Model = matches[1]
+		} else if matches := sampledPattern.FindStringSubmatch(line); matches != nil {
+			if sampled, err := time.Parse(time.RFC3339, matches[1]); err == nil {
+				audit.Sampled = sampled
+			}
+		} else if heading, ok := strings.CutPrefix(line, "## "); ok {
+			label = heading
+		} else if matches := itemPattern.FindStringSubmatch(line); matches != nil && label != "" {
+			agreement := audit.Labels[label]
+			agreement.Total++
+			if matches[1] != " " {
+				agreement.Agreed++
+			}
+			audit.Labels[label] = agreement
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return Audit{}, fmt.Errorf("failed to read audit checklist: %w", err)
+	}
+	if audit.ID == "" {
+		audit.ID = strings.TrimSuffix(filepath.Base(checklistPath), filepath.Ext(checklistPath))
+	}
+
+	data, err := json.MarshalIndent(audit, "", "  ")
+	if err != nil {
+		return Audit{}, fmt.Errorf("failed to encode audit: %w", err)
+	}
+	if err := os.WriteFile(strings.TrimSuffix(checklistPath, filepath.Ext(checklistPath))+".json", data, 0644); err != nil {
+		return Audit{}, fmt.Errorf("failed to write audit: %w", err)
+	}
+	return audit, nil
+}
+
+// History returns the recorded audits of a target folder, oldest first
+func History(targetFolder string) ([]Audit, error) {
+	matches, err := filepath.Glob(filepath.Join(Dir(targetFolder), "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audits: %w", err)
+	}
+
+	var audits []Audit
+	for _, match := range matches {
+		data, err := os.ReadFile(match)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit: %w", err)
+		}
+		var audit Audit
+		if err := json.Unmarshal(data, &audit); err != nil {
+			return nil, fmt.Errorf("failed to parse audit %s: %w", filepath.Base(match), err)
+		}
+		audits = append(audits, audit)
+	}
+
+	sort.Slice(audits, func(i, j int) bool { return audits[i].ID < audits[j].ID })
+	return audits, nil
+}
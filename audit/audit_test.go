@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"ratemykb/classification"
+	"ratemykb/output"
+	"ratemykb/scanner"
+)
+
+func TestSample(t *testing.T) {
+	var files []output.ResultFile
+	add := func(count int, label classification.Classification, status scanner.FileStatus) {
+		for i := 0; i < count; i++ {
+			files = append(files, output.ResultFile{Path: fmt.Sprintf("/vault/%s-%d.md", label, i), Status: status, Classification: label})
+		}
+	}
+	add(10, "Good enough", scanner.StatusNeedsReview)
+	add(5, "Low quality", scanner.StatusNeedsReview)
+	add(1, "Borderline", scanner.StatusNeedsReview)
+	add(3, classification.TimedOut, scanner.StatusNeedsReview)
+	add(3, classification.Empty, scanner.StatusEmpty)
+
+	sample := Sample(files, 7, rand.New(rand.NewPCG(1, 1)))
+	counts := make(map[string]int)
+	for _, file := range sample {
+		counts[string(file.Classification)]++
+	}
+	if want := map[string]int{"Good enough": 4, "Low quality": 2, "Borderline": 1}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("Sample() counts = %v, want %v", counts, want)
+	}
+	if again := Sample(files, 7, rand.New(rand.NewPCG(1, 1))); !reflect.DeepEqual(again, sample) {
+		t.Error("Expected the same seed to draw the same sample")
+	}
+
+	// Smaller samples favor the larger labels, larger ones take every classified file
+	if sample := Sample(files, 2, rand.New(rand.NewPCG(1, 1))); len(sample) != 2 || sample[0].Classification != "Good enough" || sample[1].Classification != "Low quality" {
+		t.Errorf("Expected a file of each of the two largest labels, got %v", sample)
+	}
+	if sample := Sample(files, 100, rand.New(rand.NewPCG(1, 1))); len(sample) != 16 {
+		t.Errorf("Expected every classified file, got %d", len(sample))
+	}
+}
+
+func TestChecklist(t *testing.T) {
+	tempDir := t.TempDir()
+	if _, err := Latest(tempDir); err != ErrNoChecklist {
+		t.Errorf("Expected ErrNoChecklist before any audit, got %v", err)
+	}
+
+	sample := []output.ResultFile{
+		{Path: filepath.Join(tempDir, "b.md"), Classification: "Good enough"},
+		{Path: filepath.Join(tempDir, "docs", "a.md"), Classification: "Good enough", Provider: "hosted"},
+		{Path: filepath.Join(tempDir, "c.md"), Classification: "Low quality", Reason: "Only a heading."},
+	}
+	now := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+	first, err := WriteChecklist(tempDir, "llama3", sample, now)
+	if err != nil {
+		t.Fatalf("WriteChecklist() error = %v", err)
+	}
+	second, err := WriteChecklist(tempDir, "qwen3", sample[:1], now)
+	if err != nil {
+		t.Fatalf("WriteChecklist() error = %v", err)
+	}
+	if filepath.Base(first) != "20260301-093000.md" || filepath.Base(second) != "20260301-093000-1.md" {
+		t.Errorf("Expected unique checklists named after the time, got %s and %s", first, second)
+	}
+
+	data, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatalf("Failed to read checklist: %v", err)
+	}
+	content := string(data)
+	for _, expected := range []string{"Audit ID: 20260301-093000\nModel: llama3\n", "## Good enough\n\n- [ ] `b.md`\n- [ ] `docs/a.md` (by hosted)\n", "## Low quality\n\n- [ ] `c.md` — Only a heading.\n"} {
+		if !strings.Contains(content, expected) {
+			t.Errorf("Expected checklist to contain %q, got:\n%s", expected, content)
+		}
+	}
+
+	// Tick the files a human agreed with
+	content = strings.Replace(content, "- [ ] `docs/a.md`", "- [x] `docs/a.md`", 1)
+	content = strings.Replace(content, "- [ ] `c.md`", "- [X] `c.md`", 1)
+	if err := os.WriteFile(first, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to tick checklist: %v", err)
+	}
+	result, err := Record(first, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	want := map[string]Agreement{"Good enough": {Agreed: 1, Total: 2}, "Low quality": {Agreed: 1, Total: 1}}
+	if result.ID != "20260301-093000" || result.Model != "llama3" || !result.Sampled.Equal(now) || !reflect.DeepEqual(result.Labels, want) {
+		t.Errorf("Record() = %+v, want the agreement per label %v", result, want)
+	}
+	if overall := result.Overall(); overall.Rate() < 0.66 || overall.Rate() > 0.67 {
+		t.Errorf("Expected an overall agreement of 2 in 3, got %+v", overall)
+	}
+
+	if latest, err := Latest(tempDir); err != nil || latest != second {
+		t.Errorf("Latest() = %s, %v, want %s", latest, err, second)
+	}
+	if _, err := Record(second, now.Add(time.Hour)); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	audits, err := History(tempDir)
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if len(audits) != 2 || audits[0].ID != "20260301-093000" || audits[1].Model != "qwen3" || audits[1].Overall().Rate() != 0 {
+		t.Errorf("History() = %+v, want both audits oldest first", audits)
+	}
+}
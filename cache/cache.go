@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"ratemykb/classification"
+	"ratemykb/config"
+	"ratemykb/safewrite"
+)
+
+// Package cache remembers the verdicts of the GenAI engine in .ratemykb/cache.json by the content they were given
+// for, so content classified before is not sent to the engine again after files are renamed or the state is rebuilt.
+
+// cacheVersion is incremented whenever the cache file format changes incompatibly, which discards older caches
+const cacheVersion = 1
+
+// Cache holds the verdicts of the GenAI engine by key
+type Cache struct {
+	path    string
+	entries map[string]classification.Verdict
+	dirty   bool       // Entries were added since the cache was loaded or saved
+	mu      sync.Mutex // Guards entries and dirty, since files may be classified concurrently
+}
+
+// cacheFile is the on-disk representation of the cache
+type cacheFile struct {
+	Version int                               `json:"version"`
+	Entries map[string]classification.Verdict `json:"entries"`
+}
+
+// Path returns the cache file of a target folder
+func Path(targetFolder string) string {
+	return filepath.Join(targetFolder, config.StateDir, "cache.json")
+}
+
+// Load reads the cache of a target folder, starting an empty one if there is none yet or it was written by an
+// incompatible version
+func Load(targetFolder string) (*Cache, error) {
+	c := &Cache{path: Path(targetFolder), entries: make(map[string]classification.Verdict)}
+
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache: %w", err)
+	}
+
+	var cf cacheFile
+	if err := json.Unmarshal(data, &cf); err != nil {
+		return nil, fmt.Errorf("failed to parse cache %s: %w", c.path, err)
+	}
+	if cf.Version == cacheVersion && cf.Entries != nil {
+		c.entries = cf.Entries
+	}
+	return c, nil
+}
+
+// Key returns the key of the verdict for content classified with the given settings, the SHA-256 of both
+// The settings are anything that changes the verdict for the same content, such as the prompt and the model.
+func Key(content, settings string) string {
+	hash := sha256.New()
+	hash.Write([]byte(settings))
+	hash.Write([]byte{0})
+	hash.Write([]byte(content))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Get returns the verdict stored under a key
+func (c *Cache) Get(key string) (classification.Verdict, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	verdict, ok := c.entries[key]
+	return verdict, ok
+}
+
+// Put stores a verdict under a key
+func (c *Cache) Put(key string, verdict classification.Verdict) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = verdict
+	c.dirty = true
+}
+
+// Len returns the number of verdicts in the cache
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+// Save writes the cache if verdicts were added since it was loaded or last saved
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(cacheFile{Version: cacheVersion, Entries: c.entries})
+	if err != nil {
+		return fmt.Errorf("failed to encode cache: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("failed to create cache folder: %w", err)
+	}
+
+	if err := safewrite.WriteFileAtomic(c.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write cache: %w", err)
+	}
+	c.dirty = false
+	return nil
+}
@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ratemykb/classification"
+)
+
+func TestCache(t *testing.T) {
+	tempDir := t.TempDir()
+
+	c, err := Load(tempDir)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	key := Key("Some content", "gemma3:1b")
+	if _, ok := c.Get(key); ok {
+		t.Fatal("Expected an empty cache")
+	}
+
+	// Nothing is written until a verdict is added
+	if err := c.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if _, err := os.Stat(Path(tempDir)); !os.IsNotExist(err) {
+		t.Errorf("Expected no cache file before a verdict is added, got %v", err)
+	}
+
+	rating := 40
+	verdict := classification.Verdict{Classification: classification.LowQuality, Confidence: 0.8, Reason: "Only links.", Rating: &rating}
+	c.Put(key, verdict)
+	if err := c.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	reloaded, err := Load(tempDir)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	got, ok := reloaded.Get(key)
+	if !ok || got.Classification != verdict.Classification || got.Reason != verdict.Reason || got.Rating == nil || *got.Rating != 40 {
+		t.Errorf("Get() = %+v, %v, want %+v", got, ok, verdict)
+	}
+
+	// The same content with other settings, or other content with the same settings, has another key
+	for _, other := range []string{Key("Some content", "gemma3:12b"), Key("Other content", "gemma3:1b")} {
+		if other == key {
+			t.Errorf("Expected keys to differ, got %s twice", key)
+		}
+		if _, ok := reloaded.Get(other); ok {
+			t.Errorf("Expected no verdict for key %s", other)
+		}
+	}
+}
+
+func TestLoadIncompatibleCache(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Dir(Path(tempDir)), 0755); err != nil {
+		t.Fatalf("Failed to create state folder: %v", err)
+	}
+	if err := os.WriteFile(Path(tempDir), []byte(`{"version": 99, "entries": {"key": {"classification": "Empty"}}}`), 0644); err != nil {
+		t.Fatalf("Failed to write cache: %v", err)
+	}
+
+	c, err := Load(tempDir)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if c.Len() != 0 {
+		t.Errorf("Expected the cache of another version to be discarded, got %d entries", c.Len())
+	}
+}
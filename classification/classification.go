@@ -5,13 +5,25 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"os"
 	"ratemykb/config"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/tmc/langchaingo/jsonschema"
 	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/anthropic"
 	"github.com/tmc/langchaingo/llms/ollama"
+	"github.com/tmc/langchaingo/llms/openai"
 )
 
 // Package classification will handle the quality classification of scanned files
@@ -19,95 +31,757 @@ import (
 // Classification represents the quality classification of a file
 type Classification string
 
+// Image is an image embedded in a note, passed to the vision model along with the text of the note
+type Image struct {
+	MIMEType string
+	Data     []byte
+}
+
+// Errors returned by the classifier, wrapped with details of the failure
+var (
+	// ErrModelUnavailable indicates the GenAI engine could not be reached or failed to generate a response
+	ErrModelUnavailable = errors.New("GenAI engine unavailable")
+
+	// ErrUnparseableResponse indicates the GenAI engine responded with nothing usable
+	ErrUnparseableResponse = errors.New("unparseable response from GenAI engine")
+
+	// ErrTimedOut indicates the GenAI engine did not answer a request about a file within the configured timeout
+	ErrTimedOut = errors.New("classification timed out")
+
+	// errRequestTimedOut indicates a single request got no response within the request timeout, which is retried
+	errRequestTimedOut = errors.New("no response to the request")
+)
+
 // Classifier handles the quality classification of files using a GenAI engine
 type Classifier struct {
-	config *config.Config
+	config     *config.Config
+	llm        llms.Model
+	vision     llms.Model        // Multimodal model for notes that are mostly images, nil if not configured
+	escalation []escalationStage // Larger models classifying again the files an earlier model was unsure about
+	provider   string            // Name of the primary engine, recorded with the files it classifies
+	fallbacks  []namedEngine     // Engines tried in order when the engines before them fail
+	ensemble   []namedEngine     // Engines classifying every file along with the primary one
+	prompts    []promptOverride  // Classification prompts of the files in a folder or with a tag, in order
+	limiter    *rateLimiter      // Keeps requests within the configured rate limits, nil without limits
+	ctx        context.Context   // Cancels the requests in flight when done, nil for requests that run to the end
+	tokensUsed int               // Total tokens reported by the GenAI engine across all calls
+	mu         sync.Mutex        // Guards tokensUsed, since files may be classified concurrently
+}
+
+// namedEngine is an engine besides the primary one, such as a fallback engine, with the name recorded with the
+// files it classifies
+type namedEngine struct {
+	name string
+	llm  llms.Model
+}
+
+// promptOverride is a classification prompt for the files matching a folder glob and a tag
+type promptOverride struct {
+	folder *regexp.Regexp // nil to match files in any folder
+	tag    string         // Lowercase without #, empty to match files with any tags
+	prompt string
+}
+
+// newPromptOverrides compiles the configured prompt overrides
+func newPromptOverrides(overrides []config.PromptOverride) []promptOverride {
+	var compiled []promptOverride
+	for _, override := range overrides {
+		po := promptOverride{
+			tag:    strings.ToLower(strings.TrimPrefix(strings.TrimSpace(override.Tag), "#")),
+			prompt: override.Prompt,
+		}
+		if strings.TrimSpace(override.Match) != "" {
+			po.folder = config.FolderPattern(override.Match)
+		}
+		compiled = append(compiled, po)
+	}
+	return compiled
+}
+
+// PromptFor returns the classification prompt of a file, given its slash-separated path relative to the target
+// folder and its tags: the prompt of the first override matching both, or the configured classification prompt
+// A tag also matches its nested tags, so journal matches journal/daily.
+func (c *Classifier) PromptFor(relPath string, tags []string) string {
+	for _, override := range c.prompts {
+		if override.folder != nil && !override.folder.MatchString(relPath) {
+			continue
+		}
+		if override.tag != "" && !slices.ContainsFunc(tags, func(tag string) bool {
+			tag = strings.ToLower(tag)
+			return tag == override.tag || strings.HasPrefix(tag, override.tag+"/")
+		}) {
+			continue
+		}
+		return override.prompt
+	}
+	return c.config.PromptConfig.QualityClassificationPrompt
+}
+
+// escalationStage is a model that classifies a file again when the previous model gave it one of the labels
+type escalationStage struct {
 	llm    llms.Model
+	labels map[string]bool // Keyed by labelKey
 }
 
 // New creates a new Classifier with the provided configuration
 func New(cfg *config.Config) (*Classifier, error) {
+	if err := validatePipeline(cfg.PromptConfig.Pipeline); err != nil {
+		return nil, fmt.Errorf("invalid prompt pipeline: %w", err)
+	}
+
 	// Special case for tests: if the model name is "mock-model", use a test classifier
 	if cfg.AIEngine.Model == "mock-model" {
 		// Create a test LLM that uses simple heuristics
 		return &Classifier{
-			config: cfg,
-			llm:    &testLLM{},
+			config:   cfg,
+			llm:      &testLLM{},
+			provider: cfg.AIEngine.Provider,
+			prompts:  newPromptOverrides(cfg.Prompts),
+			limiter:  newRateLimiter(cfg.AIEngine.RequestsPerMinute, cfg.AIEngine.TokensPerMinute),
 		}, nil
 	}
 
-	// Initialize Ollama client
-	ollamaOpts := []ollama.Option{
-		ollama.WithServerURL(cfg.AIEngine.URL),
-		ollama.WithModel(cfg.AIEngine.Model),
+	// Azure OpenAI serves models under the name of their deployment
+	llm, err := newModel(cfg.AIEngine, config.EngineModel(cfg.AIEngine.Provider, cfg.AIEngine.Model, cfg.AIEngine.Deployment))
+	if err != nil {
+		return nil, err
 	}
 
-	llm, err := ollama.New(ollamaOpts...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize Ollama client: %w", err)
+	classifier := &Classifier{
+		config:   cfg,
+		llm:      llm,
+		provider: cfg.AIEngine.Provider,
+		prompts:  newPromptOverrides(cfg.Prompts),
+		limiter:  newRateLimiter(cfg.AIEngine.RequestsPerMinute, cfg.AIEngine.TokensPerMinute),
 	}
 
-	return &Classifier{
-		config: cfg,
-		llm:    llm,
-	}, nil
+	// Initialize a second client for the vision model if one is configured
+	if cfg.AIEngine.VisionModel != "" {
+		vision, err := newModel(cfg.AIEngine, cfg.AIEngine.VisionModel)
+		if err != nil {
+			return nil, fmt.Errorf("vision model: %w", err)
+		}
+		classifier.vision = vision
+	}
+
+	// Initialize a client for each escalation stage, in order
+	for i, stageCfg := range cfg.AIEngine.Escalation {
+		if stageCfg.Model == "" || len(stageCfg.Labels) == 0 {
+			return nil, fmt.Errorf("escalation stage %d needs a model and the labels it classifies again", i+1)
+		}
+		stage := escalationStage{labels: make(map[string]bool)}
+		for _, label := range stageCfg.Labels {
+			stage.labels[labelKey(Classification(label))] = true
+		}
+		if stage.llm, err = newModel(cfg.AIEngine, stageCfg.Model); err != nil {
+			return nil, fmt.Errorf("escalation stage %d: %w", i+1, err)
+		}
+		classifier.escalation = append(classifier.escalation, stage)
+	}
+
+	// Initialize a client for each fallback engine, in order, named after its model like the engines of the ensemble
+	for i, fallbackCfg := range cfg.AIEngine.Fallbacks {
+		fallback, err := newNamedEngine(cfg.AIEngine, fallbackCfg, config.EngineModel(fallbackCfg.Provider, fallbackCfg.Model, fallbackCfg.Deployment))
+		if err != nil {
+			return nil, fmt.Errorf("fallback engine %d: %w", i+1, err)
+		}
+		classifier.fallbacks = append(classifier.fallbacks, fallback)
+	}
+
+	// Initialize a client for each engine of the ensemble, named after its model so models of the same provider
+	// can be told apart
+	for i, engineCfg := range cfg.AIEngine.Ensemble.Engines {
+		engine, err := newNamedEngine(cfg.AIEngine, engineCfg, config.EngineModel(engineCfg.Provider, engineCfg.Model, engineCfg.Deployment))
+		if err != nil {
+			return nil, fmt.Errorf("ensemble engine %d: %w", i+1, err)
+		}
+		classifier.ensemble = append(classifier.ensemble, engine)
+	}
+
+	return classifier, nil
+}
+
+// newNamedEngine creates a client for an engine besides the primary one, sharing the generation settings of the
+// primary engine, named by default if it has no name
+func newNamedEngine(primary config.AIEngineConfig, engineCfg config.FallbackEngine, defaultName string) (namedEngine, error) {
+	engine := config.AIEngineConfig{
+		Provider:         engineCfg.Provider,
+		URL:              engineCfg.URL,
+		APIKey:           engineCfg.APIKey,
+		Deployment:       engineCfg.Deployment,
+		APIVersion:       engineCfg.APIVersion,
+		Model:            engineCfg.Model,
+		Options:          primary.Options,
+		StructuredOutput: primary.StructuredOutput,
+	}
+	if engine.APIVersion == "" {
+		engine.APIVersion = primary.APIVersion
+	}
+
+	named := namedEngine{name: engineCfg.Name}
+	if named.name == "" {
+		named.name = defaultName
+	}
+	var err error
+	named.llm, err = newModel(engine, config.EngineModel(engine.Provider, engine.Model, engine.Deployment))
+	return named, err
+}
+
+// newModel creates a client for a model served by the configured provider
+func newModel(engine config.AIEngineConfig, model string) (llms.Model, error) {
+	switch engine.Provider {
+	case config.ProviderOllama, "":
+		if engine.StructuredOutput {
+			url := engine.URL
+			if url == "" {
+				url = defaultOllamaURL
+			}
+			return &ollamaSchemaModel{url: url, model: model, numCtx: engine.Options.NumCtx, client: http.DefaultClient}, nil
+		}
+		opts := []ollama.Option{ollama.WithServerURL(engine.URL), ollama.WithModel(model)}
+		if engine.Options.NumCtx > 0 {
+			opts = append(opts, ollama.WithRunnerNumCtx(engine.Options.NumCtx))
+		}
+		llm, err := ollama.New(opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Ollama client: %w: %w", ErrModelUnavailable, err)
+		}
+		return llm, nil
+	case config.ProviderOpenAI:
+		opts := []openai.Option{openai.WithModel(model)}
+		if engine.URL != "" {
+			opts = append(opts, openai.WithBaseURL(engine.URL))
+		}
+		// Local servers such as vLLM and LM Studio accept any key, but the client refuses to start without one
+		switch {
+		case engine.APIKey != "":
+			opts = append(opts, openai.WithToken(engine.APIKey))
+		case os.Getenv("OPENAI_API_KEY") == "":
+			opts = append(opts, openai.WithToken("none"))
+		}
+		llm, err := openai.New(opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize OpenAI client: %w: %w", ErrModelUnavailable, err)
+		}
+		return llm, nil
+	case config.ProviderAnthropic:
+		opts := []anthropic.Option{anthropic.WithModel(model), anthropic.WithBaseURL(engine.URL)}
+		if engine.APIKey != "" {
+			opts = append(opts, anthropic.WithToken(engine.APIKey))
+		}
+		llm, err := anthropic.New(opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Anthropic client: %w: %w", ErrModelUnavailable, err)
+		}
+		return anthropicModel{llm}, nil
+	case config.ProviderAzureOpenAI:
+		if engine.URL == "" {
+			return nil, errors.New("ai_engine.url must be set to the endpoint of the Azure OpenAI resource")
+		}
+		if model == "" {
+			return nil, errors.New("ai_engine.deployment must be set to the name of an Azure OpenAI deployment")
+		}
+		apiKey := engine.APIKey
+		if apiKey == "" {
+			apiKey = os.Getenv("AZURE_OPENAI_API_KEY")
+		}
+		llm, err := openai.New(
+			openai.WithAPIType(openai.APITypeAzure),
+			openai.WithBaseURL(engine.URL),
+			openai.WithAPIVersion(engine.APIVersion),
+			openai.WithModel(model),
+			openai.WithToken(apiKey),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Azure OpenAI client: %w: %w", ErrModelUnavailable, err)
+		}
+		return llm, nil
+	default:
+		return nil, fmt.Errorf("unsupported AI engine provider %q (supported: %s, %s, %s, %s)", engine.Provider,
+			config.ProviderOllama, config.ProviderOpenAI, config.ProviderAnthropic, config.ProviderAzureOpenAI)
+	}
+}
+
+// anthropicMaxTokens limits the response of Claude, which requires a limit on every request
+const anthropicMaxTokens = 4096
+
+// anthropicModel adapts calls to the Anthropic client, which needs a token limit and only passes functions to
+// Claude as tools
+type anthropicModel struct {
+	*anthropic.LLM
+}
+
+// GenerateContent calls Claude with a default token limit, offering any functions as tools
+func (m anthropicModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	var opts llms.CallOptions
+	for _, option := range options {
+		option(&opts)
+	}
+
+	calls := append([]llms.CallOption{llms.WithMaxTokens(anthropicMaxTokens)}, options...)
+	if len(opts.Functions) > 0 {
+		tools := make([]llms.Tool, len(opts.Functions))
+		for i := range opts.Functions {
+			tools[i] = llms.Tool{Type: "function", Function: &opts.Functions[i]}
+		}
+		calls = append(calls, llms.WithTools(tools))
+	}
+	return m.LLM.GenerateContent(ctx, messages, calls...)
+}
+
+// Verdict is the classification of a file with what the GenAI engine said about it
+type Verdict struct {
+	Classification Classification `json:"classification"`
+	Confidence     float64        `json:"confidence,omitempty"` // Between 0 and 1, see ClassifyWithConfidence
+	Reason         string         `json:"reason,omitempty"`     // One sentence the model gave to justify the classification, empty if it gave none
+	Provider       string         `json:"provider,omitempty"`   // Name of the engine that classified the file, empty when no engine was needed
+
+	Scores map[string]int `json:"scores,omitempty"` // Score from 1 to 5 per configured dimension the model scored, nil without scoring
+	Score  float64        `json:"score,omitempty"`  // Overall score weighted by dimension, 0 when the model scored no dimension
+	Rating *int           `json:"rating,omitempty"` // From 0 (worst) to 100 (best), nil when rating is off or the model gave none
+
+	Votes []Vote `json:"votes,omitempty"` // Label each engine of the ensemble gave, nil without an ensemble
+}
+
+// Vote is the label one engine of an ensemble gave a file
+type Vote struct {
+	Engine         string         `json:"engine"`
+	Classification Classification `json:"classification"`
+}
+
+// Disagree reports whether the engines of an ensemble gave different labels, regardless of case
+func Disagree(votes []Vote) bool {
+	for _, vote := range votes[min(len(votes), 1):] {
+		if !vote.Classification.Is(votes[0].Classification) {
+			return true
+		}
+	}
+	return false
 }
 
 // ClassifyContent classifies the content of a file using the GenAI engine
 // It returns the classification as provided by the LLM
 func (c *Classifier) ClassifyContent(content string) (Classification, error) {
+	verdict, err := c.Classify(content)
+	return verdict.Classification, err
+}
+
+// ClassifyWithConfidence classifies the content of a file, sampling the GenAI engine several times if configured,
+// and returns the majority classification with its confidence between 0 and 1
+// With a single sample the confidence is the one the model reported. With several samples it is the share of
+// samples that agreed, weighted by the confidence each of them reported. A model that reports no confidence counts
+// as certain.
+func (c *Classifier) ClassifyWithConfidence(content string) (Classification, float64, error) {
+	verdict, err := c.Classify(content)
+	return verdict.Classification, verdict.Confidence, err
+}
+
+// Classify classifies the content of a file like ClassifyWithConfidence and returns the verdict with the reason
+// given for it and the engine that classified it
+// Files given a label of an escalation stage are classified again by the model of that stage, whose verdict
+// replaces the earlier one, with the timeout applying to all samples and stages together. When the primary engine
+// fails or times out, each fallback engine is tried in turn with a timeout of its own.
+func (c *Classifier) Classify(content string) (Verdict, error) {
+	return c.ClassifyWithPrompt(content, "")
+}
+
+// ClassifyWithPrompt classifies the content of a file like Classify, with a classification prompt other than the
+// configured one, such as the prompt PromptFor selects for the file, or the configured one if empty
+func (c *Classifier) ClassifyWithPrompt(content, prompt string) (Verdict, error) {
+	return c.ClassifyWithPromptFunc(content, c.ContentPrompt(prompt))
+}
+
+// PromptFunc returns a classification prompt filled with the content of a note
+type PromptFunc func(content string) (string, error)
+
+// ContentPrompt returns the PromptFunc replacing {{ content }} in a classification prompt, or filling the configured
+// one if empty or the configured one, which may be a template
+func (c *Classifier) ContentPrompt(prompt string) PromptFunc {
+	return func(content string) (string, error) {
+		if prompt == "" || prompt == c.config.PromptConfig.QualityClassificationPrompt {
+			return c.config.PromptConfig.ClassificationPrompt(content, "", "")
+		}
+		return strings.Replace(prompt, "{{ content }}", content, 1), nil
+	}
+}
+
+// TemplatePrompt returns the PromptFunc filling the configured classification prompt with the content, path and
+// frontmatter of a note
+func (c *Classifier) TemplatePrompt(relPath, frontmatter string) PromptFunc {
+	return func(content string) (string, error) {
+		return c.config.PromptConfig.ClassificationPrompt(content, relPath, frontmatter)
+	}
+}
+
+// ClassifyWithPromptFunc classifies the content of a file like Classify, with the classification prompt fill
+// returns for it
+func (c *Classifier) ClassifyWithPromptFunc(content string, fill PromptFunc) (Verdict, error) {
 	// Early checks for empty content
 	if strings.TrimSpace(content) == "" {
-		return Classification("Empty"), nil
+		return Verdict{Classification: Empty, Confidence: 1}, nil
 	}
 
 	// If this is a mock classifier (used in tests), return the mock classification directly
 	if mockLLM, ok := c.llm.(*mockLLM); ok {
-		return mockLLM.classification, nil
+		return Verdict{Classification: mockLLM.classification, Confidence: 1}, nil
 	}
 
-	ctx := context.Background()
+	ctx, cancel := c.timeoutContext()
+	verdict, err := c.sample(ctx, c.llm, fill, content)
+	primaryErr := err // The fallbacks stand in for the primary engine only, not for the engines of the ensemble
+	if err == nil && len(c.ensemble) > 0 {
+		verdict, err = c.consensus(ctx, verdict, fill, content)
+	}
+	for _, stage := range c.escalation {
+		if err != nil || !stage.labels[labelKey(verdict.Classification)] {
+			continue
+		}
+		// The votes of the ensemble are kept, since the escalated verdict does not replace them
+		votes := verdict.Votes
+		verdict, err = c.sample(ctx, stage.llm, fill, content)
+		verdict.Votes = votes
+	}
+	cancel()
+	verdict.Provider = c.provider
 
-	// Create the prompt by replacing the template variable in the configuration prompt
-	prompt := strings.Replace(c.config.PromptConfig.QualityClassificationPrompt, "{{ content }}", content, 1)
+	for _, fallback := range c.fallbacks {
+		if primaryErr == nil {
+			break
+		}
+		if !errors.Is(err, ErrModelUnavailable) && !errors.Is(err, ErrTimedOut) || errors.Is(err, context.Canceled) {
+			break
+		}
+		ctx, cancel := c.timeoutContext()
+		verdict, err = c.sample(ctx, fallback.llm, fill, content)
+		cancel()
+		verdict.Provider = fallback.name
+	}
+	return verdict, err
+}
+
+// consensus asks every engine of the ensemble to classify the content as well and combines their labels with the
+// verdict of the primary engine using the configured strategy
+// The verdict of the first engine giving the chosen label is kept, with the label of every engine as its votes.
+// With the majority strategy the confidence is the share of engines that agreed, weighted by the confidence each of
+// them reported, like for samples.
+func (c *Classifier) consensus(ctx context.Context, primary Verdict, fill PromptFunc, content string) (Verdict, error) {
+	verdicts := []Verdict{primary}
+	votes := []Vote{{Engine: config.EngineModel(c.config.AIEngine.Provider, c.config.AIEngine.Model, c.config.AIEngine.Deployment), Classification: primary.Classification}}
+	for _, engine := range c.ensemble {
+		verdict, err := c.sample(ctx, engine.llm, fill, content)
+		if err != nil {
+			return Verdict{Classification: verdict.Classification}, fmt.Errorf("ensemble engine %s: %w", engine.name, err)
+		}
+		verdicts = append(verdicts, verdict)
+		votes = append(votes, Vote{Engine: engine.name, Classification: verdict.Classification})
+	}
+
+	chosen := verdicts[0]
+	if c.config.AIEngine.Ensemble.Strategy == config.EnsembleStrictest {
+		// Ties go to the engine listed first
+		for _, verdict := range verdicts[1:] {
+			if verdict.Classification.Severity() > chosen.Classification.Severity() {
+				chosen = verdict
+			}
+		}
+	} else {
+		counts := make(map[string]int)
+		reported := make(map[string]float64) // Sum of the confidences reported for each label
+		for _, verdict := range verdicts {
+			counts[labelKey(verdict.Classification)]++
+			reported[labelKey(verdict.Classification)] += verdict.Confidence
+		}
+		// Ties go to the label of the engine listed first
+		for _, verdict := range verdicts[1:] {
+			if counts[labelKey(verdict.Classification)] > counts[labelKey(chosen.Classification)] {
+				chosen = verdict
+			}
+		}
+		chosen.Confidence = reported[labelKey(chosen.Classification)] / float64(len(verdicts))
+	}
+	chosen.Votes = votes
+	return chosen, nil
+}
+
+// SetContext makes the classifier give up on the requests in flight, and send no more, once the context is done
+// Requests given up on this way fail with context.Canceled.
+func (c *Classifier) SetContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+// context returns the context set with SetContext, or a context that is never done
+func (c *Classifier) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// timeoutContext returns a context that gives up on a single file after the configured timeout, so one note
+// cannot stall the whole run
+func (c *Classifier) timeoutContext() (context.Context, context.CancelFunc) {
+	if c.config.AIEngine.Timeout > 0 {
+		return context.WithTimeout(c.context(), c.config.AIEngine.Timeout)
+	}
+	return context.WithCancel(c.context())
+}
+
+// sample classifies the content with a model, as many times as configured, and returns the majority
+// classification with its confidence and the reason, scores and rating of the first sample that gave it
+func (c *Classifier) sample(ctx context.Context, llm llms.Model, fill PromptFunc, content string) (Verdict, error) {
+	samples := max(c.config.AIEngine.Samples, 1)
+	if samples == 1 {
+		verdict, err := c.classifyOnce(ctx, llm, fill, content)
+		if errors.Is(err, ErrTimedOut) {
+			return Verdict{Classification: TimedOut}, err
+		}
+		return verdict, err
+	}
+
+	// Sample at a non-zero temperature and count labels regardless of case, keeping the first verdict seen
+	options := []llms.CallOption{llms.WithTemperature(c.config.AIEngine.SampleTemperature)}
+	counts := make(map[string]int)
+	reported := make(map[string]float64) // Sum of the confidences reported for each label
+	var verdicts []Verdict
+	for i := 0; i < samples; i++ {
+		verdict, err := c.classifyOnce(ctx, llm, fill, content, options...)
+		if errors.Is(err, ErrTimedOut) {
+			return Verdict{Classification: TimedOut}, err
+		}
+		if err != nil {
+			return Verdict{Classification: verdict.Classification}, err
+		}
+
+		key := labelKey(verdict.Classification)
+		if counts[key] == 0 {
+			verdicts = append(verdicts, verdict)
+		}
+		counts[key]++
+		reported[key] += verdict.Confidence
+	}
+
+	// Ties go to the label that was seen first
+	majority := verdicts[0]
+	for _, verdict := range verdicts[1:] {
+		if counts[labelKey(verdict.Classification)] > counts[labelKey(majority.Classification)] {
+			majority = verdict
+		}
+	}
+
+	// The share of samples that agreed times the average confidence they reported
+	majority.Confidence = reported[labelKey(majority.Classification)] / float64(samples)
+	return majority, nil
+}
+
+// ClassifyWithImages classifies the content of a note along with the images embedded in it using the vision model,
+// so notes that are mostly screenshots or whiteboard photos are not rated on their sparse text alone
+// Without a vision model or images, the content is classified as usual with the classification prompt fill returns.
+func (c *Classifier) ClassifyWithImages(content string, fill PromptFunc, images []Image) (Verdict, error) {
+	if c.vision == nil || len(images) == 0 {
+		return c.ClassifyWithPromptFunc(content, fill)
+	}
+
+	ctx, cancel := c.timeoutContext()
+	defer cancel()
+
+	// Create the prompt as usual and attach the images after it
+	prompt, err := fill(content)
+	if err != nil {
+		return Verdict{Classification: Unknown}, fmt.Errorf("failed to fill the classification prompt: %w", err)
+	}
+	prompt += fmt.Sprintf("\n\nThe %d images embedded in the note are attached. Take what they show into account as part of the note.", len(images))
+
+	parts := []llms.ContentPart{llms.TextPart(prompt)}
+	for _, image := range images {
+		parts = append(parts, llms.BinaryPart(image.MIMEType, image.Data))
+	}
+
+	resp, err := c.generateContent(ctx, c.vision, c.messages(parts...))
+	if errors.Is(err, ErrTimedOut) {
+		return Verdict{Classification: TimedOut}, err
+	}
+	if err != nil {
+		return Verdict{Classification: Unknown}, fmt.Errorf("error calling vision model: %w: %w", ErrModelUnavailable, err)
+	}
+
+	// Keep track of the tokens consumed so callers can enforce a budget
+	c.addTokens(tokenUsage(resp))
+
+	verdict, err := parseClassification(resp)
+	verdict = c.scored(verdict)
+	verdict.Provider = c.provider
+	return verdict, err
+}
+
+// labelKey returns the key used to count a classification regardless of case
+func labelKey(classification Classification) string {
+	return strings.ToLower(strings.TrimSpace(string(classification)))
+}
+
+// classifyOnce asks a model for a single classification of the content with the confidence and reason it gave
+func (c *Classifier) classifyOnce(ctx context.Context, llm llms.Model, fill PromptFunc, content string, options ...llms.CallOption) (Verdict, error) {
+	// Ask several narrow questions instead of the single prompt if a pipeline is configured, which give no
+	// confidence or reason
+	if len(c.config.PromptConfig.Pipeline.Steps) > 0 {
+		classification, err := c.classifyPipeline(ctx, llm, content, options...)
+		return Verdict{Classification: classification, Confidence: 1}, err
+	}
+
+	// Create the prompt by filling the classification prompt with the content
+	prompt, err := fill(content)
+	if err != nil {
+		return Verdict{Classification: Unknown}, fmt.Errorf("failed to fill the classification prompt: %w", err)
+	}
 
 	// Call the LLM with function calling
-	resp, err := c.llm.GenerateContent(ctx,
-		[]llms.MessageContent{
-			llms.TextParts(llms.ChatMessageTypeHuman, prompt),
-		},
-		llms.WithFunctions(classificationFunctions),
-	)
+	resp, err := c.generateContent(ctx, llm, c.messages(llms.TextPart(prompt)), append(options, llms.WithFunctions(c.functions()))...)
+	if errors.Is(err, ErrTimedOut) {
+		return Verdict{Classification: TimedOut}, err
+	}
 	if err != nil {
-		return Classification("Unknown"), fmt.Errorf("error calling GenAI engine: %w", err)
+		return Verdict{Classification: Unknown}, fmt.Errorf("error calling GenAI engine: %w: %w", ErrModelUnavailable, err)
 	}
 
-	// Check if we have a function call response
-	if len(resp.Choices) > 0 && resp.Choices[0].FuncCall != nil {
-		// print the function call response
-		// fmt.Println("Function call response:", resp.Choices[0].FuncCall.Arguments)
+	// Keep track of the tokens consumed so callers can enforce a budget
+	c.addTokens(tokenUsage(resp))
+
+	verdict, err := parseClassification(resp)
+	return c.scored(verdict), err
+}
+
+// messages returns the messages of a request to the GenAI engine: the configured system prompt as a system
+// message, if any, followed by the prompt
+func (c *Classifier) messages(parts ...llms.ContentPart) []llms.MessageContent {
+	var messages []llms.MessageContent
+	if c.config.PromptConfig.SystemPrompt != "" {
+		messages = append(messages, llms.TextParts(llms.ChatMessageTypeSystem, c.config.PromptConfig.SystemPrompt))
+	}
+	return append(messages, llms.MessageContent{Role: llms.ChatMessageTypeHuman, Parts: parts})
+}
+
+// functionCall returns the function call of a response, which Claude returns as a tool call in a choice of its
+// own after any text
+func functionCall(resp *llms.ContentResponse) *llms.FunctionCall {
+	for _, choice := range resp.Choices {
+		if choice == nil {
+			continue
+		}
+		if choice.FuncCall != nil {
+			return choice.FuncCall
+		}
+		for _, call := range choice.ToolCalls {
+			if call.FunctionCall != nil {
+				return call.FunctionCall
+			}
+		}
+	}
+	return nil
+}
+
+// classificationResponse is the classification returned by a model as function call arguments or JSON
+type classificationResponse struct {
+	Classification string         `json:"classification"`
+	Confidence     any            `json:"confidence"` // Between 0 and 1, though small models also answer with percentages or strings
+	Reason         string         `json:"reason"`
+	Scores         map[string]any `json:"scores"` // Dimension name to score, as numbers or strings
+	Rating         any            `json:"rating"`
+}
+
+// verdict converts the response to a verdict, keeping the reason on a single line
+func (r classificationResponse) verdict() Verdict {
+	return Verdict{
+		Classification: Classification(r.Classification),
+		Confidence:     r.confidence(),
+		Reason:         strings.Join(strings.Fields(r.Reason), " "),
+		Scores:         r.scores(),
+		Rating:         r.rating(),
+	}
+}
+
+// rating returns the rating the model reported rounded to a whole number, nil if it reported none
+func (r classificationResponse) rating() *int {
+	value, ok := numberValue(r.Rating)
+	if !ok {
+		return nil
+	}
+	rating := int(math.Round(value))
+	return &rating
+}
 
-		var classificationResponse struct {
-			Classification string `json:"classification"`
+// scores returns the scores the model reported that are numbers, rounded to whole numbers
+func (r classificationResponse) scores() map[string]int {
+	scores := make(map[string]int)
+	for name, score := range r.Scores {
+		if value, ok := numberValue(score); ok {
+			scores[name] = int(math.Round(value))
 		}
+	}
+	return scores
+}
 
-		err = json.Unmarshal([]byte(resp.Choices[0].FuncCall.Arguments), &classificationResponse)
+// numberValue returns a number a model reported as a JSON number or a string
+func numberValue(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return parsed, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// confidence returns the confidence the model reported between 0 and 1, or 1 if it reported none
+func (r classificationResponse) confidence() float64 {
+	var value float64
+	switch v := r.Confidence.(type) {
+	case float64:
+		value = v
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(v), "%"), 64)
 		if err != nil {
-			return Classification("Unknown"), fmt.Errorf("error parsing function call response: %w", err)
+			return 1
+		}
+		value = parsed
+	default:
+		return 1
+	}
+
+	// Read values such as 85 as percentages
+	if value > 1 {
+		value /= 100
+	}
+	return min(max(value, minConfidence), 1)
+}
+
+// minConfidence is the lowest confidence reported, so a model answering 0 is not mistaken for one reporting none,
+// which results leave out
+const minConfidence = 0.01
+
+// parseClassification extracts the classification with the confidence and reason the model gave from a function
+// call, a JSON object or the plain text of a response
+// Plain text carries no confidence, so it counts as certain.
+func parseClassification(resp *llms.ContentResponse) (Verdict, error) {
+	// Check if we have a function call response
+	if call := functionCall(resp); call != nil {
+		var classificationResponse classificationResponse
+
+		err := json.Unmarshal([]byte(call.Arguments), &classificationResponse)
+		if err != nil {
+			return Verdict{Classification: Unknown}, fmt.Errorf("error parsing function call response: %w: %w", ErrUnparseableResponse, err)
 		}
 
 		// Use the classification directly from the LLM
 		if classificationResponse.Classification != "" {
-			return Classification(classificationResponse.Classification), nil
+			return classificationResponse.verdict(), nil
 		}
 	}
 
 	// If no function call, try to parse from the content directly
 	if len(resp.Choices) > 0 && resp.Choices[0].Content != "" {
 		// Try to parse the content as JSON
-		var classificationResponse struct {
-			Classification string `json:"classification"`
-		}
+		var classificationResponse classificationResponse
 
 		content := resp.Choices[0].Content
 
@@ -141,7 +815,7 @@ func (c *Classifier) ClassifyContent(content string) (Classification, error) {
 		err := json.Unmarshal([]byte(content), &classificationResponse)
 		if err == nil && classificationResponse.Classification != "" {
 			// Successfully parsed JSON, use the classification
-			return Classification(classificationResponse.Classification), nil
+			return classificationResponse.verdict(), nil
 		}
 
 		// If direct parsing fails, try to extract JSON between curly braces using regex
@@ -149,18 +823,548 @@ func (c *Classifier) ClassifyContent(content string) (Classification, error) {
 		if jsonMatch := jsonRegex.FindString(content); jsonMatch != "" {
 			err = json.Unmarshal([]byte(jsonMatch), &classificationResponse)
 			if err == nil && classificationResponse.Classification != "" {
-				return Classification(classificationResponse.Classification), nil
+				return classificationResponse.verdict(), nil
 			}
 		}
-			
+
 		// Log the error for debugging
 		fmt.Println("Error parsing JSON or no valid JSON found in response:", content)
 
 		// If all JSON parsing attempts fail, use the raw content
-		return Classification(strings.TrimSpace(content)), nil
+		return Verdict{Classification: Classification(strings.TrimSpace(content)), Confidence: 1}, nil
+	}
+
+	return Verdict{Classification: Unknown}, fmt.Errorf("%w: no choices returned", ErrUnparseableResponse)
+}
+
+// WarmUp sends a tiny request so the GenAI engine loads the model before the first file is classified
+// The request gives up after the configured timeout and is retried like a classification. The tokens it uses are
+// not counted, since they are not spent on any file.
+func (c *Classifier) WarmUp() error {
+	// The mock classifier never calls an engine
+	if _, ok := c.llm.(*mockLLM); ok {
+		return nil
+	}
+
+	ctx, cancel := c.timeoutContext()
+	defer cancel()
+
+	resp, err := c.generateContent(ctx, c.llm, pingMessages, llms.WithMaxTokens(1))
+	if errors.Is(err, ErrTimedOut) || errors.Is(err, context.Canceled) {
+		return fmt.Errorf("warm-up request failed: %w", err)
+	}
+	if err != nil {
+		return fmt.Errorf("warm-up request failed: %w: %w", ErrModelUnavailable, err)
+	}
+	c.limiter.record(tokenUsage(resp))
+	return nil
+}
+
+// pingMessages is the tiny request of a warm-up or health check
+var pingMessages = []llms.MessageContent{
+	llms.TextParts(llms.ChatMessageTypeHuman, "Reply with OK."),
+}
+
+// healthCheckTimeout is how long a health check waits for the engine without a configured request timeout
+const healthCheckTimeout = 30 * time.Second
+
+// HealthCheck sends a tiny request to check whether the GenAI engine answers, giving up after the request timeout
+// Like the warm-up, its tokens are not counted.
+func (c *Classifier) HealthCheck() error {
+	timeout := healthCheckTimeout
+	if c.config != nil && c.config.AIEngine.RequestTimeout > 0 {
+		timeout = c.config.AIEngine.RequestTimeout
+	}
+	ctx, cancel := context.WithTimeout(c.context(), timeout)
+	defer cancel()
+
+	if err := c.ping(ctx); err != nil {
+		return fmt.Errorf("health check failed: %w", err)
+	}
+	return nil
+}
+
+// ping sends a tiny request within the rate limits
+func (c *Classifier) ping(ctx context.Context) error {
+	// The mock classifier never calls an engine
+	if _, ok := c.llm.(*mockLLM); ok {
+		return nil
+	}
+
+	if err := c.limiter.wait(ctx); err != nil {
+		return fmt.Errorf("%w: %w", ErrModelUnavailable, err)
+	}
+	resp, err := c.llm.GenerateContent(ctx, pingMessages, llms.WithMaxTokens(1))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrModelUnavailable, err)
+	}
+	c.limiter.record(tokenUsage(resp))
+	return nil
+}
+
+// generateContent calls the GenAI engine with the given model, retrying requests that fail with a transient error
+// or get no response within the request timeout with exponential backoff and jitter, and returns ErrTimedOut once
+// the context deadline passes
+// The timeout covers all attempts, the pauses between them and the time spent waiting for the rate limits.
+func (c *Classifier) generateContent(ctx context.Context, llm llms.Model, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	// Options given for the call, such as the sampling temperature, take precedence over the configured ones
+	options = append(c.generationOptions(), options...)
+
+	delay := c.config.AIEngine.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.wait(ctx); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return nil, fmt.Errorf("%w after %s", ErrTimedOut, c.config.AIEngine.Timeout)
+			}
+			return nil, err
+		}
+
+		resp, err := c.generateAttempt(ctx, llm, messages, options...)
+		if err == nil || attempt >= c.config.AIEngine.Retries || !isTransient(err) && !errors.Is(err, errRequestTimedOut) {
+			return resp, err
+		}
+
+		// Wait between half and all of the delay, so concurrent workers do not retry in lockstep
+		pause := delay/2 + rand.N(delay/2+1)
+		select {
+		case <-time.After(pause):
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("%w after %s", ErrTimedOut, c.config.AIEngine.Timeout)
+			}
+			return nil, ctx.Err()
+		}
+		delay = min(delay*2, max(c.config.AIEngine.RetryMaxBackoff, c.config.AIEngine.RetryBackoff))
+	}
+}
+
+// generationOptions returns the configured generation parameters as call options
+func (c *Classifier) generationOptions() []llms.CallOption {
+	var options []llms.CallOption
+	generation := c.config.AIEngine.Options
+	if generation.Temperature != nil {
+		options = append(options, llms.WithTemperature(*generation.Temperature))
+	}
+	if generation.TopP != nil {
+		options = append(options, llms.WithTopP(*generation.TopP))
+	}
+	if generation.Seed != nil {
+		options = append(options, llms.WithSeed(*generation.Seed))
+	}
+	return options
+}
+
+// transientPattern matches the errors of requests worth retrying: server errors, rate limits and dropped connections
+var transientPattern = regexp.MustCompile(`(?i)\b(429|500|502|503|504)\b|too many requests|internal server error|bad gateway|service unavailable|connection reset|connection refused|broken pipe|unexpected EOF`)
+
+// isTransient reports whether an error of the GenAI engine may go away when the request is retried
+// Timeouts are not retried, since the timeout already covers the retries.
+func isTransient(err error) bool {
+	if errors.Is(err, ErrTimedOut) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	return transientPattern.MatchString(err.Error())
+}
+
+// generateAttempt calls the GenAI engine with the given model once, giving up on a request that gets no response
+// within the request timeout while the file still has time left, so a hung engine can be tried again
+func (c *Classifier) generateAttempt(ctx context.Context, llm llms.Model, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	if c.config.AIEngine.RequestTimeout <= 0 {
+		return c.generateOnce(ctx, llm, messages, options...)
+	}
+
+	requestCtx, cancel := context.WithTimeout(ctx, c.config.AIEngine.RequestTimeout)
+	defer cancel()
+	resp, err := c.generateOnce(requestCtx, llm, messages, options...)
+	if err != nil && ctx.Err() == nil && errors.Is(requestCtx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("%w: %w after %s", ErrTimedOut, errRequestTimedOut, c.config.AIEngine.RequestTimeout)
+	}
+	return resp, err
+}
+
+// generateOnce calls the GenAI engine with the given model and returns ErrTimedOut once the context deadline
+// passes, even if the engine keeps working on the request
+func (c *Classifier) generateOnce(ctx context.Context, llm llms.Model, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	type result struct {
+		resp *llms.ContentResponse
+		err  error
+	}
+
+	// Buffered so the call can finish after we gave up on it
+	done := make(chan result, 1)
+	go func() {
+		resp, err := llm.GenerateContent(ctx, messages, options...)
+		done <- result{resp, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w after %s", ErrTimedOut, c.config.AIEngine.Timeout)
+		}
+		return r.resp, r.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w after %s", ErrTimedOut, c.config.AIEngine.Timeout)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// SuggestSplit asks the GenAI engine for an outline of how a long note could be split into smaller notes
+func (c *Classifier) SuggestSplit(content string) (string, error) {
+	// Create the prompt by replacing the template variable in the configuration prompt
+	prompt := strings.Replace(c.config.PromptConfig.SplitSuggestionPrompt, "{{ content }}", content, 1)
+
+	return c.generate(prompt)
+}
+
+// SuggestImprovements asks the GenAI engine for a few concrete actions that would improve a note, one per item
+func (c *Classifier) SuggestImprovements(content string) ([]string, error) {
+	// Create the prompt by replacing the template variable in the configuration prompt
+	prompt := strings.Replace(c.config.PromptConfig.ImprovementPrompt, "{{ content }}", content, 1)
+
+	response, err := c.generate(prompt)
+	if err != nil {
+		return nil, err
+	}
+	return listItems(response), nil
+}
+
+// SuggestTags asks the GenAI engine for tags that would help find a note, offering the known tags of the vault
+func (c *Classifier) SuggestTags(content string, known []string) ([]string, error) {
+	// Create the prompt by replacing the template variables in the configuration prompt
+	knownTags := "none yet"
+	if len(known) > 0 {
+		knownTags = strings.Join(known, ", ")
+	}
+	prompt := strings.Replace(c.config.PromptConfig.TagPrompt, "{{ tags }}", knownTags, 1)
+	prompt = strings.Replace(prompt, "{{ content }}", content, 1)
+
+	response, err := c.generate(prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var tags []string
+	for _, item := range strings.FieldsFunc(response, func(r rune) bool { return r == ',' || r == '\n' }) {
+		// Tags cannot contain spaces, so words of a tag are joined with hyphens
+		item = listItemPattern.ReplaceAllString(item, "")
+		item = tagLabelPattern.ReplaceAllString(item, "")
+		item = strings.Trim(strings.TrimSpace(item), "\"'`*.")
+		tag := strings.Join(strings.Fields(strings.TrimLeft(item, "#")), "-")
+		if !tagPattern.MatchString(tag) {
+			// Obsidian would not read it as a tag, such as a sentence with punctuation or a number
+			continue
+		}
+		if !seen[strings.ToLower(tag)] && len(tags) < maxSuggestedTags {
+			seen[strings.ToLower(tag)] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// Summarize asks the GenAI engine for a one-line summary of a note
+// Only the first line of the response is kept, without a list marker or quotes around it.
+func (c *Classifier) Summarize(content string) (string, error) {
+	// Create the prompt by replacing the template variable in the configuration prompt
+	prompt := strings.Replace(c.config.PromptConfig.SummaryPrompt, "{{ content }}", content, 1)
+
+	response, err := c.generate(prompt)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(response, "\n") {
+		line = strings.Trim(strings.TrimSpace(listItemPattern.ReplaceAllString(line, "")), `"'“”`)
+		if line != "" {
+			return strings.Join(strings.Fields(line), " "), nil
+		}
+	}
+	return "", nil
+}
+
+// CheckTitle asks the GenAI engine whether a note is about what its title says
+// It returns what the note is about instead when it is not, and an empty string when it is.
+func (c *Classifier) CheckTitle(title, content string) (string, error) {
+	// Create the prompt by replacing the template variables in the configuration prompt
+	prompt := strings.Replace(c.config.PromptConfig.TitlePrompt, "{{ title }}", title, 1)
+	prompt = strings.Replace(prompt, "{{ content }}", content, 1)
+
+	response, err := c.generate(prompt)
+	if err != nil {
+		return "", err
+	}
+	answer := strings.Trim(strings.TrimSpace(listItemPattern.ReplaceAllString(strings.TrimSpace(response), "")), `"'*`)
+	match := yesNoPattern.FindStringSubmatch(answer)
+	if match == nil {
+		line, _, _ := strings.Cut(answer, "\n")
+		return "", fmt.Errorf("%w: expected yes or no, got %q", ErrUnparseableResponse, line)
+	}
+	if strings.EqualFold(match[1], "yes") {
+		return "", nil
+	}
+	reason, _, _ := strings.Cut(strings.TrimSpace(match[2]), "\n")
+	if reason = strings.Join(strings.Fields(reason), " "); reason == "" {
+		return "The content does not match the title", nil
+	}
+	return reason, nil
+}
+
+// NeedsSources asks the GenAI engine whether a note makes claims that should be backed by sources
+func (c *Classifier) NeedsSources(content string) (bool, error) {
+	// Create the prompt by replacing the template variable in the configuration prompt
+	prompt := strings.Replace(c.config.PromptConfig.SourcesPrompt, "{{ content }}", content, 1)
+
+	response, err := c.generate(prompt)
+	if err != nil {
+		return false, err
+	}
+	answer := strings.Trim(strings.TrimSpace(listItemPattern.ReplaceAllString(strings.TrimSpace(response), "")), `"'*`)
+	match := yesNoPattern.FindStringSubmatch(answer)
+	if match == nil {
+		line, _, _ := strings.Cut(answer, "\n")
+		return false, fmt.Errorf("%w: expected yes or no, got %q", ErrUnparseableResponse, line)
+	}
+	return strings.EqualFold(match[1], "yes"), nil
+}
+
+// yesNoPattern matches a yes or no answer, followed by any explanation the model adds
+var yesNoPattern = regexp.MustCompile(`(?is)^(yes|no)\b[\s.,:;!*\-–—]*(.*)`)
+
+// maxSuggestedTags caps the tags kept from a response, in case the model lists far more than asked for
+const maxSuggestedTags = 5
+
+// tagLabelPattern matches a label the model puts before its tags, such as "Tags:" or "Suggested tags:"
+var tagLabelPattern = regexp.MustCompile(`^\s*[\p{L}\s]+:\s*`)
+
+// tagPattern matches a tag Obsidian accepts: letters, digits, underscores, hyphens and slashes, with a character
+// other than a digit
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}_/-]*[\p{L}_/-][\p{L}\p{N}_/-]*$`)
+
+// listItemPattern matches the marker of a markdown list item, bulleted or numbered
+var listItemPattern = regexp.MustCompile(`^\s*(?:[-*+]|\d+[.)])\s+`)
+
+// listItems returns the items of a markdown list, or the whole text as a single item when it is not a list
+func listItems(text string) []string {
+	var items []string
+	for _, line := range strings.Split(text, "\n") {
+		if marker := listItemPattern.FindString(line); marker != "" {
+			items = append(items, strings.TrimSpace(line[len(marker):]))
+		}
+	}
+	if len(items) == 0 {
+		if text = strings.Join(strings.Fields(text), " "); text != "" {
+			items = append(items, text)
+		}
+	}
+	return items
+}
+
+// ProposeMerge asks the GenAI engine for a single note combining the content of two duplicate notes
+func (c *Classifier) ProposeMerge(first, second string) (string, error) {
+	// Create the prompt by replacing the template variables in the configuration prompt
+	prompt := strings.Replace(c.config.PromptConfig.MergePrompt, "{{ first }}", first, 1)
+	prompt = strings.Replace(prompt, "{{ second }}", second, 1)
+
+	return c.generate(prompt)
+}
+
+// generate sends a free-form prompt to the GenAI engine and returns the response without any thinking section
+// Like a classification, the request gives up after the configured timeout and is retried on transient errors.
+func (c *Classifier) generate(prompt string) (string, error) {
+	ctx, cancel := c.timeoutContext()
+	defer cancel()
+
+	resp, err := c.generateContent(ctx, c.llm, c.messages(llms.TextPart(prompt)))
+	if errors.Is(err, ErrTimedOut) || errors.Is(err, context.Canceled) {
+		return "", err
+	}
+	if err != nil {
+		return "", fmt.Errorf("error calling GenAI engine: %w: %w", ErrModelUnavailable, err)
+	}
+
+	// Keep track of the tokens consumed so callers can enforce a budget
+	c.addTokens(tokenUsage(resp))
+
+	if len(resp.Choices) == 0 || strings.TrimSpace(resp.Choices[0].Content) == "" {
+		return "", fmt.Errorf("%w: empty response", ErrUnparseableResponse)
+	}
+
+	return stripThinking(resp.Choices[0].Content), nil
+}
+
+// stripThinking removes a <think> section (as produced by reasoning models) from a response
+func stripThinking(content string) string {
+	thinkStart := strings.Index(content, "<think>")
+	thinkEnd := strings.Index(content, "</think>")
+	if thinkStart == -1 || thinkEnd == -1 || thinkEnd < thinkStart {
+		return strings.TrimSpace(content)
 	}
 
-	return Classification("Unknown"), errors.New("no valid response from GenAI engine")
+	return strings.TrimSpace(content[:thinkStart] + content[thinkEnd+len("</think>"):])
+}
+
+// TokensUsed returns the total number of tokens reported by the GenAI engine since the classifier was created
+func (c *Classifier) TokensUsed() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tokensUsed
+}
+
+// addTokens adds the tokens used by a call to the running total
+func (c *Classifier) addTokens(tokens int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokensUsed += tokens
+	c.limiter.record(tokens)
+}
+
+// tokenUsage extracts the number of tokens consumed from the generation info of a response
+// Providers report usage under different keys, so both total and input/output counts are supported. Providers
+// that split a response into several choices, like Claude with text and a tool call, repeat the usage of the
+// whole response on each of them, so only the first choice reporting usage counts.
+func tokenUsage(resp *llms.ContentResponse) int {
+	for _, choice := range resp.Choices {
+		if choice == nil || choice.GenerationInfo == nil {
+			continue
+		}
+
+		if tokens, ok := intValue(choice.GenerationInfo["TotalTokens"]); ok {
+			return tokens
+		}
+
+		input, hasInput := intValue(choice.GenerationInfo["InputTokens"])
+		output, hasOutput := intValue(choice.GenerationInfo["OutputTokens"])
+		if hasInput || hasOutput {
+			return input + output
+		}
+	}
+	return 0
+}
+
+// intValue converts a numeric generation info value to an int
+func intValue(v any) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int32:
+		return int(n), true
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), true
+	default:
+		return 0, false
+	}
+}
+
+// Range of the scores given on each dimension
+const (
+	minScore = 1
+	maxScore = 5
+)
+
+// maxRating is the rating of the best notes, the worst being rated 0
+const maxRating = 100
+
+// scored keeps the scores of the verdict for the configured dimensions, matched regardless of case and clamped to
+// 1-5, and sets the overall score weighted by dimension
+// Dimensions the model did not score are left out of the overall score. The rating is kept between 0 and 100 when
+// rating is on, and dropped otherwise.
+func (c *Classifier) scored(verdict Verdict) Verdict {
+	reported := verdict.Scores
+	verdict.Scores = nil
+	if c.config == nil || !c.config.Scoring.Rating {
+		verdict.Rating = nil
+	} else if verdict.Rating != nil {
+		rating := min(max(*verdict.Rating, 0), maxRating)
+		verdict.Rating = &rating
+	}
+	if c.config == nil || len(c.config.Scoring.Dimensions) == 0 || len(reported) == 0 {
+		return verdict
+	}
+
+	byKey := make(map[string]int)
+	for name, score := range reported {
+		byKey[strings.ToLower(strings.TrimSpace(name))] = score
+	}
+
+	var total, weights float64
+	for _, dimension := range c.config.Scoring.Dimensions {
+		score, ok := byKey[strings.ToLower(strings.TrimSpace(dimension.Name))]
+		if !ok {
+			continue
+		}
+		score = min(max(score, minScore), maxScore)
+		if verdict.Scores == nil {
+			verdict.Scores = make(map[string]int)
+		}
+		verdict.Scores[dimension.Name] = score
+
+		weight := dimension.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		total += weight * float64(score)
+		weights += weight
+	}
+	if weights > 0 {
+		verdict.Score = total / weights
+	}
+	return verdict
+}
+
+// functions returns the classification function, asking for a score on each configured dimension and for a
+// rating when they are on
+func (c *Classifier) functions() []llms.FunctionDefinition {
+	if c.config == nil || (len(c.config.Scoring.Dimensions) == 0 && !c.config.Scoring.Rating) {
+		return classificationFunctions
+	}
+
+	function := classificationFunctions[0]
+	parameters := function.Parameters.(jsonschema.Definition)
+	properties := make(map[string]jsonschema.Definition)
+	for name, property := range parameters.Properties {
+		properties[name] = property
+	}
+	required := []string{"classification"}
+
+	if len(c.config.Scoring.Dimensions) > 0 {
+		dimensions := make(map[string]jsonschema.Definition)
+		var names []string
+		for _, dimension := range c.config.Scoring.Dimensions {
+			dimensions[dimension.Name] = jsonschema.Definition{
+				Type:        jsonschema.Integer,
+				Description: strings.TrimSpace(fmt.Sprintf("Score from %d (poor) to %d (excellent). %s", minScore, maxScore, dimension.Description)),
+			}
+			names = append(names, dimension.Name)
+		}
+		properties["scores"] = jsonschema.Definition{
+			Type:        jsonschema.Object,
+			Description: "Scores of the content on each dimension of quality",
+			Properties:  dimensions,
+			Required:    names,
+		}
+		required = append(required, "scores")
+	}
+
+	if c.config.Scoring.Rating {
+		properties["rating"] = jsonschema.Definition{
+			Type:        jsonschema.Integer,
+			Description: fmt.Sprintf("Overall quality of the content from 0 (useless) to %d (excellent)", maxRating),
+		}
+		required = append(required, "rating")
+	}
+
+	parameters.Properties = properties
+	parameters.Required = required
+	function.Parameters = parameters
+	return []llms.FunctionDefinition{function}
 }
 
 // Define the classification function for the LLM
@@ -175,6 +1379,14 @@ var classificationFunctions = []llms.FunctionDefinition{
 					Type:        jsonschema.String,
 					Description: "The classification of the content describing its quality",
 				},
+				"confidence": {
+					Type:        jsonschema.Number,
+					Description: "How confident you are in the classification, from 0 (guessing) to 1 (certain)",
+				},
+				"reason": {
+					Type:        jsonschema.String,
+					Description: "One sentence explaining the classification, naming what would improve the content",
+				},
 			},
 			Required: []string{"classification"},
 		},
@@ -226,7 +1438,7 @@ func (m *testLLM) Call(ctx context.Context, prompt string, options ...llms.CallO
 	// Extract content from the prompt
 	contentIndex := strings.Index(prompt, "Here is the content to review:")
 	if contentIndex == -1 {
-		return string(Classification("Unknown")), nil
+		return string(Unknown), nil
 	}
 
 	content := prompt[contentIndex+len("Here is the content to review:"):]
@@ -234,14 +1446,14 @@ func (m *testLLM) Call(ctx context.Context, prompt string, options ...llms.CallO
 	// Simple classification logic for tests
 	content = strings.TrimSpace(content)
 	if content == "" {
-		return string(Classification("Empty")), nil
+		return string(Empty), nil
 	}
 
 	if len(content) < 100 || strings.Contains(content, "TODO") {
-		return string(Classification("Low quality")), nil
+		return string(LowQuality), nil
 	}
 
-	return string(Classification("Good enough")), nil
+	return string(GoodEnough), nil
 }
 
 // GenerateContent implements the llms.Model interface for testing
@@ -261,7 +1473,7 @@ func (m *testLLM) GenerateContent(ctx context.Context, messages []llms.MessageCo
 	// Extract content from the prompt
 	contentIndex := strings.Index(prompt, "Here is the content to review:")
 	if contentIndex == -1 {
-		return simpleResponse(Classification("Unknown")), nil
+		return simpleResponse(Unknown), nil
 	}
 
 	content := prompt[contentIndex+len("Here is the content to review:"):]
@@ -269,14 +1481,14 @@ func (m *testLLM) GenerateContent(ctx context.Context, messages []llms.MessageCo
 	// Simple classification logic for tests
 	content = strings.TrimSpace(content)
 	if content == "" {
-		return simpleResponse(Classification("Empty")), nil
+		return simpleResponse(Empty), nil
 	}
 
 	if len(content) < 100 || strings.Contains(content, "TODO") {
-		return simpleResponse(Classification("Low quality")), nil
+		return simpleResponse(LowQuality), nil
 	}
 
-	return simpleResponse(Classification("Good enough")), nil
+	return simpleResponse(GoodEnough), nil
 }
 
 // simpleResponse creates a ContentResponse with both regular content and function call
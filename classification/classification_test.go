@@ -2,10 +2,22 @@ package classification
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"ratemykb/config"
+	"reflect"
+	"slices"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/tmc/langchaingo/jsonschema"
 	"github.com/tmc/langchaingo/llms"
 )
 
@@ -86,8 +98,19 @@ func (m *mixedResponseLLM) Call(ctx context.Context, prompt string, options ...l
 // GenerateContent implements the llms.Model interface
 func (m *mixedResponseLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
 	var content string
-	
+
 	switch m.responseType {
+	case "unavailable":
+		return nil, errors.New("connection refused")
+	case "hang":
+		// Ignore the context, like an engine that never gives up on a request
+		time.Sleep(200 * time.Millisecond)
+	case "no_choices":
+		return &llms.ContentResponse{}, nil
+	case "invalid_function_call":
+		return &llms.ContentResponse{
+			Choices: []*llms.ContentChoice{{FuncCall: &llms.FunctionCall{Name: "classifyContent", Arguments: "{"}}},
+		}, nil
 	case "text_before_json":
 		content = "The content provides specific information about a Machine Learning Guru and suggests watching certain videos, indicating substance without excessive detail. It's clear and informative.\n\n```json\n{\n  \"classification\": \"" + m.classification + "\"\n}\n```"
 	case "text_after_json":
@@ -97,7 +120,7 @@ func (m *mixedResponseLLM) GenerateContent(ctx context.Context, messages []llms.
 	default:
 		content = "{\n  \"classification\": \"" + m.classification + "\"\n}"
 	}
-	
+
 	return &llms.ContentResponse{
 		Choices: []*llms.ContentChoice{
 			{
@@ -136,7 +159,7 @@ func TestJSONExtractionFromMixedContent(t *testing.T) {
 			expected:     Classification("Good enough"),
 		},
 	}
-	
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Create a minimal config for testing
@@ -145,24 +168,1310 @@ func TestJSONExtractionFromMixedContent(t *testing.T) {
 					QualityClassificationPrompt: "Here is the content to review: {{ content }}",
 				},
 			}
-			
+
 			// Create a classifier with our custom mock LLM
 			classifier := &Classifier{
 				config: cfg,
 				llm:    &mixedResponseLLM{classification: "Good enough", responseType: tt.responseType},
 			}
-			
+
 			// Test with some non-empty content
 			got, err := classifier.ClassifyContent("Some test content")
-			
+
 			if err != nil {
 				t.Errorf("ClassifyContent() error = %v, expected no error", err)
 				return
 			}
-			
+
 			if got != tt.expected {
 				t.Errorf("ClassifyContent() = %v, want %v", got, tt.expected)
 			}
 		})
 	}
 }
+
+// TestClassifyErrors tests that failures can be told apart with errors.Is
+func TestClassifyErrors(t *testing.T) {
+	tests := []struct {
+		responseType string
+		expected     error
+	}{
+		{"unavailable", ErrModelUnavailable},
+		{"no_choices", ErrUnparseableResponse},
+		{"invalid_function_call", ErrUnparseableResponse},
+	}
+
+	cfg := &config.Config{
+		PromptConfig: config.PromptConfig{
+			QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.responseType, func(t *testing.T) {
+			classifier := &Classifier{
+				config: cfg,
+				llm:    &mixedResponseLLM{responseType: tt.responseType},
+			}
+
+			if _, err := classifier.ClassifyContent("Some test content"); !errors.Is(err, tt.expected) {
+				t.Errorf("ClassifyContent() error = %v, want %v", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	cfg := &config.Config{AIEngine: config.AIEngineConfig{Timeout: 10 * time.Millisecond}}
+	classifier := &Classifier{config: cfg, llm: &mixedResponseLLM{classification: "Good enough"}}
+	if err := classifier.WarmUp(); err != nil {
+		t.Errorf("WarmUp() error = %v", err)
+	}
+	if tokens := classifier.TokensUsed(); tokens != 0 {
+		t.Errorf("Expected the warm-up not to count tokens, got %d", tokens)
+	}
+
+	classifier = &Classifier{config: cfg, llm: &mixedResponseLLM{responseType: "unavailable"}}
+	if err := classifier.WarmUp(); !errors.Is(err, ErrModelUnavailable) {
+		t.Errorf("WarmUp() error = %v, want %v", err, ErrModelUnavailable)
+	}
+
+	// An engine that never answers fails the warm-up after the timeout
+	classifier = &Classifier{config: cfg, llm: &hangingLLM{hangs: 1}}
+	start := time.Now()
+	if err := classifier.WarmUp(); !errors.Is(err, ErrTimedOut) {
+		t.Errorf("WarmUp() error = %v, want %v", err, ErrTimedOut)
+	}
+	if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+		t.Errorf("Expected WarmUp to give up after the timeout, took %s", elapsed)
+	}
+}
+
+func TestGenerateTimeout(t *testing.T) {
+	cfg := &config.Config{AIEngine: config.AIEngineConfig{Timeout: 10 * time.Millisecond}}
+
+	tests := []struct {
+		name string
+		call func(c *Classifier) error
+	}{
+		{"split", func(c *Classifier) error { _, err := c.SuggestSplit("# Pods"); return err }},
+		{"improvements", func(c *Classifier) error { _, err := c.SuggestImprovements("# Pods"); return err }},
+		{"tags", func(c *Classifier) error { _, err := c.SuggestTags("# Pods", nil); return err }},
+		{"summary", func(c *Classifier) error { _, err := c.Summarize("# Pods"); return err }},
+		{"title", func(c *Classifier) error { _, err := c.CheckTitle("pods", "# Pods"); return err }},
+		{"sources", func(c *Classifier) error { _, err := c.NeedsSources("# Pods"); return err }},
+		{"merge", func(c *Classifier) error { _, err := c.ProposeMerge("# Pods", "# Pod"); return err }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// An engine that never answers
+			classifier := &Classifier{config: cfg, llm: &hangingLLM{hangs: 1}}
+
+			done := make(chan error, 1)
+			go func() { done <- tt.call(classifier) }()
+			select {
+			case err := <-done:
+				if !errors.Is(err, ErrTimedOut) {
+					t.Errorf("Expected %v, got %v", ErrTimedOut, err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Expected the request to give up after the timeout")
+			}
+		})
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	cfg := &config.Config{AIEngine: config.AIEngineConfig{RequestTimeout: 10 * time.Millisecond}}
+	classifier := &Classifier{config: cfg, llm: &mixedResponseLLM{classification: "Good enough"}}
+	if err := classifier.HealthCheck(); err != nil {
+		t.Errorf("HealthCheck() error = %v", err)
+	}
+
+	// An engine that never answers fails the check after the request timeout instead of blocking the run
+	classifier = &Classifier{config: cfg, llm: &hangingLLM{hangs: 1}}
+	done := make(chan error, 1)
+	go func() { done <- classifier.HealthCheck() }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrModelUnavailable) {
+			t.Errorf("HealthCheck() error = %v, want %v", err, ErrModelUnavailable)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the health check to time out")
+	}
+}
+
+func TestClassifyTimeout(t *testing.T) {
+	cfg := &config.Config{
+		AIEngine: config.AIEngineConfig{Timeout: 10 * time.Millisecond},
+		PromptConfig: config.PromptConfig{
+			QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+		},
+	}
+	classifier := &Classifier{
+		config: cfg,
+		llm:    &mixedResponseLLM{classification: "Good enough", responseType: "hang"},
+	}
+
+	start := time.Now()
+	got, err := classifier.ClassifyContent("Some test content")
+	if !errors.Is(err, ErrTimedOut) {
+		t.Fatalf("ClassifyContent() error = %v, want %v", err, ErrTimedOut)
+	}
+	if got != TimedOut {
+		t.Errorf("ClassifyContent() = %v, want %v", got, TimedOut)
+	}
+	if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+		t.Errorf("Expected ClassifyContent to give up after the timeout, took %s", elapsed)
+	}
+}
+
+// sequenceLLM returns the configured labels in turn, one per request, passing labels written as JSON as they are
+type sequenceLLM struct {
+	labels   []string
+	calls    int
+	options  llms.CallOptions      // Options of the last request
+	messages []llms.MessageContent // Messages of the last request
+}
+
+// Call implements the llms.Model interface
+func (m *sequenceLLM) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return "", nil // Not used in this test
+}
+
+// GenerateContent implements the llms.Model interface
+func (m *sequenceLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	label := m.labels[m.calls%len(m.labels)]
+	m.calls++
+	m.messages = messages
+	m.options = llms.CallOptions{}
+	for _, option := range options {
+		option(&m.options)
+	}
+	if !strings.HasPrefix(label, "{") {
+		label = `{"classification": "` + label + `"}`
+	}
+	return &llms.ContentResponse{
+		Choices: []*llms.ContentChoice{
+			{Content: label},
+		},
+	}, nil
+}
+
+func TestClassifyWithConfidence(t *testing.T) {
+	tests := []struct {
+		name               string
+		samples            int
+		labels             []string
+		expected           Classification
+		expectedConfidence float64
+		expectedCalls      int
+	}{
+		{"single sample", 1, []string{"Low quality"}, "Low quality", 1, 1},
+		{"majority", 3, []string{"Low quality", "Good enough", "good enough"}, "Good enough", 2.0 / 3, 3},
+		{"tie goes to the first label", 2, []string{"Low quality", "Good enough"}, "Low quality", 0.5, 2},
+		{"unanimous", 3, []string{"Good enough"}, "Good enough", 1, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				AIEngine: config.AIEngineConfig{Samples: tt.samples, SampleTemperature: 0.7},
+				PromptConfig: config.PromptConfig{
+					QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+				},
+			}
+			llm := &sequenceLLM{labels: tt.labels}
+			classifier := &Classifier{config: cfg, llm: llm}
+
+			got, confidence, err := classifier.ClassifyWithConfidence("Some test content")
+			if err != nil {
+				t.Fatalf("ClassifyWithConfidence() error = %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("ClassifyWithConfidence() = %v, want %v", got, tt.expected)
+			}
+			if confidence != tt.expectedConfidence {
+				t.Errorf("ClassifyWithConfidence() confidence = %v, want %v", confidence, tt.expectedConfidence)
+			}
+			if llm.calls != tt.expectedCalls {
+				t.Errorf("Expected %d requests, got %d", tt.expectedCalls, llm.calls)
+			}
+		})
+	}
+}
+
+func TestSystemPrompt(t *testing.T) {
+	cfg := &config.Config{
+		PromptConfig: config.PromptConfig{
+			QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+		},
+	}
+	llm := &sequenceLLM{labels: []string{"Good enough"}}
+	classifier := &Classifier{config: cfg, llm: llm}
+
+	// Without a system prompt the instructions and the note are a single message
+	if _, err := classifier.Classify("Some test content"); err != nil {
+		t.Fatalf("Classify() error = %v", err)
+	}
+	if len(llm.messages) != 1 || llm.messages[0].Role != llms.ChatMessageTypeHuman {
+		t.Errorf("Expected a single human message, got %+v", llm.messages)
+	}
+
+	cfg.PromptConfig.SystemPrompt = "You review notes of a knowledge base."
+	if _, err := classifier.Classify("Some test content"); err != nil {
+		t.Fatalf("Classify() error = %v", err)
+	}
+	if len(llm.messages) != 2 || llm.messages[0].Role != llms.ChatMessageTypeSystem || llm.messages[1].Role != llms.ChatMessageTypeHuman {
+		t.Fatalf("Expected a system message before the prompt, got %+v", llm.messages)
+	}
+	if system := llm.messages[0].Parts[0].(llms.TextContent).Text; system != cfg.PromptConfig.SystemPrompt {
+		t.Errorf("System message = %q, want %q", system, cfg.PromptConfig.SystemPrompt)
+	}
+	if prompt := llm.messages[1].Parts[0].(llms.TextContent).Text; prompt != "Here is the content to review: Some test content" {
+		t.Errorf("Prompt = %q, want the classification prompt with the content", prompt)
+	}
+
+	// Other requests about a note get the system prompt too
+	cfg.PromptConfig.SummaryPrompt = "Summarize: {{ content }}"
+	text := &textLLM{text: "A note about pods."}
+	classifier = &Classifier{config: cfg, llm: text}
+	if _, err := classifier.Summarize("# Pods"); err != nil {
+		t.Fatalf("Summarize() error = %v", err)
+	}
+	if len(text.messages) != 2 || text.messages[0].Role != llms.ChatMessageTypeSystem || text.prompt != "Summarize: # Pods" {
+		t.Errorf("Expected a system message before the summary prompt, got %+v", text.messages)
+	}
+}
+
+func TestTokenUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		info map[string]any
+		want int
+	}{
+		{name: "total tokens", info: map[string]any{"TotalTokens": 42}, want: 42},
+		{name: "input and output tokens", info: map[string]any{"InputTokens": 30, "OutputTokens": 12}, want: 42},
+		{name: "float total", info: map[string]any{"TotalTokens": float64(7)}, want: 7},
+		{name: "no usage", info: nil, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &llms.ContentResponse{
+				Choices: []*llms.ContentChoice{{GenerationInfo: tt.info}},
+			}
+			if got := tokenUsage(resp); got != tt.want {
+				t.Errorf("tokenUsage() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripThinking(t *testing.T) {
+	got := stripThinking("<think>\nLet me consider this\n</think>\n\n- Part one\n- Part two")
+	want := "- Part one\n- Part two"
+	if got != want {
+		t.Errorf("stripThinking() = %q, want %q", got, want)
+	}
+
+	if got := stripThinking("  plain answer "); got != "plain answer" {
+		t.Errorf("stripThinking() = %q, want %q", got, "plain answer")
+	}
+}
+
+// visionLLM records the number of images sent with each request
+type visionLLM struct {
+	images []int
+}
+
+// Call implements the llms.Model interface
+func (m *visionLLM) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return "", nil // Not used in this test
+}
+
+// GenerateContent implements the llms.Model interface
+func (m *visionLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	images := 0
+	for _, part := range messages[0].Parts {
+		if _, ok := part.(llms.BinaryContent); ok {
+			images++
+		}
+	}
+	m.images = append(m.images, images)
+
+	return &llms.ContentResponse{
+		Choices: []*llms.ContentChoice{
+			{Content: `{"classification": "Good enough"}`},
+		},
+	}, nil
+}
+
+func TestClassifyWithImages(t *testing.T) {
+	cfg := &config.Config{
+		PromptConfig: config.PromptConfig{
+			QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+		},
+	}
+	text := &visionLLM{}
+	vision := &visionLLM{}
+	classifier := &Classifier{config: cfg, llm: text, vision: vision}
+
+	images := []Image{
+		{MIMEType: "image/png", Data: []byte("first")},
+		{MIMEType: "image/png", Data: []byte("second")},
+	}
+	got, err := classifier.ClassifyWithImages("![[board.png]]", classifier.ContentPrompt(""), images)
+	if err != nil {
+		t.Fatalf("ClassifyWithImages() error = %v", err)
+	}
+	if got.Classification != "Good enough" {
+		t.Errorf("ClassifyWithImages() = %v, want Good enough", got.Classification)
+	}
+	if len(vision.images) != 1 || vision.images[0] != 2 || len(text.images) != 0 {
+		t.Errorf("Expected one request with 2 images to the vision model, got %v (text model: %v)", vision.images, text.images)
+	}
+
+	// Without images the text model is used
+	if _, err := classifier.ClassifyWithImages("Some test content", classifier.ContentPrompt(""), nil); err != nil {
+		t.Fatalf("ClassifyWithImages() error = %v", err)
+	}
+	if len(text.images) != 1 || text.images[0] != 0 {
+		t.Errorf("Expected one request without images to the text model, got %v", text.images)
+	}
+}
+
+func TestOpenAIProvider(t *testing.T) {
+	var gotAuth, gotModel string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Model string `json:"model"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		gotAuth, gotModel = r.Header.Get("Authorization"), request.Model
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"1","object":"chat.completion","model":"local","choices":[{"index":0,"finish_reason":"stop",`+
+			`"message":{"role":"assistant","content":"{\"classification\": \"Good enough\"}"}}],`+
+			`"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		AIEngine: config.AIEngineConfig{Provider: config.ProviderOpenAI, URL: server.URL, APIKey: "secret", Model: "local"},
+		PromptConfig: config.PromptConfig{
+			QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+		},
+	}
+	classifier, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	classification, err := classifier.ClassifyContent("Some test content")
+	if err != nil {
+		t.Fatalf("ClassifyContent() error = %v", err)
+	}
+	if classification != "Good enough" {
+		t.Errorf("ClassifyContent() = %q, want %q", classification, "Good enough")
+	}
+	if gotAuth != "Bearer secret" || gotModel != "local" {
+		t.Errorf("Expected the API key and model to be sent, got %q and %q", gotAuth, gotModel)
+	}
+	if tokens := classifier.TokensUsed(); tokens != 15 {
+		t.Errorf("Expected 15 tokens used, got %d", tokens)
+	}
+
+	cfg.AIEngine.Provider = "unknown"
+	if _, err := New(cfg); err == nil {
+		t.Error("Expected an error for an unsupported provider")
+	}
+}
+
+func TestAnthropicProvider(t *testing.T) {
+	var request struct {
+		MaxTokens int `json:"max_tokens"`
+		Tools     []struct {
+			Name string `json:"name"`
+		} `json:"tools"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&request)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude","stop_reason":"tool_use",`+
+			`"content":[{"type":"text","text":"Let me classify this note."},`+
+			`{"type":"tool_use","id":"toolu_1","name":"classifyContent","input":{"classification":"Low quality"}}],`+
+			`"usage":{"input_tokens":30,"output_tokens":12}}`)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		AIEngine: config.AIEngineConfig{Provider: config.ProviderAnthropic, URL: server.URL, APIKey: "secret", Model: "claude"},
+		PromptConfig: config.PromptConfig{
+			QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+		},
+	}
+	classifier, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	classification, err := classifier.ClassifyContent("Some test content")
+	if err != nil {
+		t.Fatalf("ClassifyContent() error = %v", err)
+	}
+	if classification != "Low quality" {
+		t.Errorf("ClassifyContent() = %q, want %q", classification, "Low quality")
+	}
+	if request.MaxTokens != anthropicMaxTokens || len(request.Tools) != 1 || request.Tools[0].Name != "classifyContent" {
+		t.Errorf("Expected a token limit and the classification tool, got %+v", request)
+	}
+	if tokens := classifier.TokensUsed(); tokens != 42 {
+		t.Errorf("Expected the usage to be counted once, got %d tokens", tokens)
+	}
+}
+
+func TestOllamaStructuredOutput(t *testing.T) {
+	var request struct {
+		Path   string
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+		Format struct {
+			Required []string `json:"required"`
+		} `json:"format"`
+		Options map[string]any `json:"options"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request.Path = r.URL.Path
+		_ = json.NewDecoder(r.Body).Decode(&request)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"model":"llama3","message":{"role":"assistant","content":"{\"classification\": \"Empty\"}"},`+
+			`"done":true,"prompt_eval_count":20,"eval_count":8}`)
+	}))
+	defer server.Close()
+
+	temperature := 0.0
+	cfg := &config.Config{
+		AIEngine: config.AIEngineConfig{
+			Provider:         config.ProviderOllama,
+			URL:              server.URL,
+			Model:            "llama3",
+			StructuredOutput: true,
+			Options:          config.GenerationOptions{Temperature: &temperature, NumCtx: 4096},
+		},
+		PromptConfig: config.PromptConfig{
+			QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+		},
+	}
+	classifier, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	classification, err := classifier.ClassifyContent("Some test content")
+	if err != nil {
+		t.Fatalf("ClassifyContent() error = %v", err)
+	}
+	if classification != Empty {
+		t.Errorf("ClassifyContent() = %q, want %q", classification, Empty)
+	}
+	if request.Path != "/api/chat" || request.Model != "llama3" || request.Stream {
+		t.Errorf("Expected a chat request for the model without streaming, got %+v", request)
+	}
+	if !slices.Contains(request.Format.Required, "classification") {
+		t.Errorf("Expected the classification schema as the format, got %+v", request.Format)
+	}
+	if request.Options["temperature"] != 0.0 || request.Options["num_ctx"] != 4096.0 {
+		t.Errorf("Expected the configured options, got %v", request.Options)
+	}
+	if _, ok := request.Options["seed"]; ok {
+		t.Errorf("Expected the options left out not to be sent, got %v", request.Options)
+	}
+	if tokens := classifier.TokensUsed(); tokens != 28 {
+		t.Errorf("Expected 28 tokens used, got %d", tokens)
+	}
+}
+
+func TestAzureOpenAIProvider(t *testing.T) {
+	var gotURL, gotKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURL, gotKey = r.URL.String(), r.Header.Get("api-key")
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"finish_reason":"stop",`+
+			`"message":{"role":"assistant","content":"{\"classification\": \"Good enough\"}"}}],`+
+			`"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		AIEngine: config.AIEngineConfig{
+			Provider:   config.ProviderAzureOpenAI,
+			URL:        server.URL,
+			APIKey:     "secret",
+			Model:      "gpt-4o",
+			Deployment: "kb-classifier",
+			APIVersion: "2024-10-21",
+		},
+		PromptConfig: config.PromptConfig{
+			QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+		},
+	}
+	classifier, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	classification, err := classifier.ClassifyContent("Some test content")
+	if err != nil {
+		t.Fatalf("ClassifyContent() error = %v", err)
+	}
+	if classification != "Good enough" {
+		t.Errorf("ClassifyContent() = %q, want %q", classification, "Good enough")
+	}
+	if want := "/openai/deployments/kb-classifier/chat/completions?api-version=2024-10-21"; gotURL != want || gotKey != "secret" {
+		t.Errorf("Expected a request to %s with the API key, got %s with %q", want, gotURL, gotKey)
+	}
+
+	cfg.AIEngine.Deployment = ""
+	if _, err := New(cfg); err == nil {
+		t.Error("Expected an error without a deployment")
+	}
+}
+
+func TestEscalation(t *testing.T) {
+	cfg := &config.Config{
+		PromptConfig: config.PromptConfig{
+			QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+		},
+	}
+	stage := func(classification string, labels ...string) escalationStage {
+		keys := make(map[string]bool)
+		for _, label := range labels {
+			keys[label] = true
+		}
+		return escalationStage{llm: &mixedResponseLLM{classification: classification}, labels: keys}
+	}
+
+	classifier := &Classifier{
+		config:     cfg,
+		llm:        &mixedResponseLLM{classification: "Borderline"},
+		escalation: []escalationStage{stage("Still unsure", "borderline"), stage("Good enough", "still unsure")},
+	}
+	if got, err := classifier.ClassifyContent("Some test content"); err != nil || got != "Good enough" {
+		t.Errorf("ClassifyContent() = %q, %v, want %q from the last stage", got, err, "Good enough")
+	}
+
+	classifier.llm = &mixedResponseLLM{classification: "Low quality"}
+	if got, err := classifier.ClassifyContent("Some test content"); err != nil || got != "Low quality" {
+		t.Errorf("ClassifyContent() = %q, %v, want %q without escalating", got, err, "Low quality")
+	}
+
+	cfg.AIEngine.Escalation = []config.EscalationStage{{Model: "large"}}
+	if _, err := New(cfg); err == nil {
+		t.Error("Expected an error for an escalation stage without labels")
+	}
+}
+
+func TestFallbackEngines(t *testing.T) {
+	cfg := &config.Config{
+		AIEngine: config.AIEngineConfig{Provider: config.ProviderOllama},
+		PromptConfig: config.PromptConfig{
+			QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+		},
+	}
+	classifier := &Classifier{
+		config:   cfg,
+		llm:      &mixedResponseLLM{responseType: "unavailable"},
+		provider: config.ProviderOllama,
+		fallbacks: []namedEngine{
+			{name: "second", llm: &mixedResponseLLM{responseType: "unavailable"}},
+			{name: "hosted", llm: &mixedResponseLLM{classification: "Good enough"}},
+			{name: "unused", llm: &mixedResponseLLM{classification: "Low quality"}},
+		},
+	}
+
+	got, err := classifier.Classify("Some test content")
+	if err != nil || got.Classification != "Good enough" || got.Provider != "hosted" {
+		t.Errorf("Classify() = %+v, %v, want %q from the hosted engine", got, err, "Good enough")
+	}
+
+	classifier.llm = &mixedResponseLLM{classification: "Low quality"}
+	if got, err := classifier.Classify("Some test content"); err != nil || got.Classification != "Low quality" || got.Provider != config.ProviderOllama {
+		t.Errorf("Classify() = %+v, %v, want %q from the primary engine", got, err, "Low quality")
+	}
+
+	// Unparseable responses are not engine failures, so they are not handed to the fallbacks
+	classifier.llm = &mixedResponseLLM{responseType: "no_choices"}
+	if got, err := classifier.Classify("Some test content"); !errors.Is(err, ErrUnparseableResponse) || got.Provider != config.ProviderOllama {
+		t.Errorf("Classify() = %+v, %v, want %v from the primary engine", got, err, ErrUnparseableResponse)
+	}
+
+	// Fallbacks are named after their model unless they have a name
+	cfg.AIEngine.Fallbacks = []config.FallbackEngine{{Provider: config.ProviderOpenAI, APIKey: "test", Model: "gpt-4o-mini"}}
+	if fallback, err := New(cfg); err != nil || fallback.fallbacks[0].name != "gpt-4o-mini" {
+		t.Errorf("Expected the fallback to be named after its model, got %v", err)
+	}
+
+	cfg.AIEngine.Fallbacks = []config.FallbackEngine{{Provider: "unknown"}}
+	if _, err := New(cfg); err == nil {
+		t.Error("Expected an error for a fallback engine with an unsupported provider")
+	}
+}
+
+func TestEnsemble(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		labels   []Classification // Of the primary engine and the ensemble, in order
+		want     Classification
+		disagree bool
+	}{
+		{"majority", config.EnsembleMajority, []Classification{"Low quality", "Good enough", "good enough"}, "Good enough", true},
+		{"majority tie goes to the primary engine", config.EnsembleMajority, []Classification{"Low quality", "Good enough"}, "Low quality", true},
+		{"strictest", config.EnsembleStrictest, []Classification{"Good enough", "Empty", "Low quality"}, "Empty", true},
+		{"agreement", config.EnsembleStrictest, []Classification{"Good enough", "Good enough"}, "Good enough", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				AIEngine: config.AIEngineConfig{Model: "gemma3:1b", Ensemble: config.EnsembleConfig{Strategy: tt.strategy}},
+				PromptConfig: config.PromptConfig{
+					QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+				},
+			}
+			classifier := &Classifier{config: cfg, llm: &mixedResponseLLM{classification: string(tt.labels[0])}}
+			for i, label := range tt.labels[1:] {
+				classifier.ensemble = append(classifier.ensemble, namedEngine{name: fmt.Sprintf("engine%d", i+1), llm: &mixedResponseLLM{classification: string(label)}})
+			}
+
+			got, err := classifier.Classify("Some test content")
+			if err != nil {
+				t.Fatalf("Classify() error = %v", err)
+			}
+			if got.Classification != tt.want {
+				t.Errorf("Classify() = %q, want %q", got.Classification, tt.want)
+			}
+			if len(got.Votes) != len(tt.labels) || got.Votes[0].Engine != "gemma3:1b" || got.Votes[1].Engine != "engine1" {
+				t.Errorf("Expected a vote per engine, got %+v", got.Votes)
+			}
+			if Disagree(got.Votes) != tt.disagree {
+				t.Errorf("Disagree(%+v) = %v, want %v", got.Votes, !tt.disagree, tt.disagree)
+			}
+		})
+	}
+}
+
+func TestEnsembleWithEscalationAndFallbacks(t *testing.T) {
+	cfg := &config.Config{
+		AIEngine: config.AIEngineConfig{Model: "gemma3:1b"},
+		PromptConfig: config.PromptConfig{
+			QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+		},
+	}
+	classifier := &Classifier{
+		config:     cfg,
+		llm:        &mixedResponseLLM{classification: "Borderline"},
+		ensemble:   []namedEngine{{name: "engine1", llm: &mixedResponseLLM{classification: "Borderline"}}},
+		escalation: []escalationStage{{llm: &mixedResponseLLM{classification: "Good enough"}, labels: map[string]bool{"borderline": true}}},
+		fallbacks:  []namedEngine{{name: "hosted", llm: &mixedResponseLLM{classification: "Low quality"}}},
+	}
+
+	// Escalating keeps the votes of the ensemble
+	got, err := classifier.Classify("Some test content")
+	if err != nil || got.Classification != "Good enough" || len(got.Votes) != 2 {
+		t.Errorf("Classify() = %+v, %v, want %q with the votes of the ensemble", got, err, "Good enough")
+	}
+
+	// A failing ensemble engine is not handed to the fallbacks of the primary engine
+	classifier.ensemble[0].llm = &mixedResponseLLM{responseType: "unavailable"}
+	got, err = classifier.Classify("Some test content")
+	if !errors.Is(err, ErrModelUnavailable) || got.Provider == "hosted" {
+		t.Errorf("Classify() = %+v, %v, want %v without falling back", got, err, ErrModelUnavailable)
+	}
+}
+
+func TestReportedConfidence(t *testing.T) {
+	tests := []struct {
+		name     string
+		samples  int
+		labels   []string
+		expected float64
+	}{
+		{"single sample", 1, []string{`{"classification": "Low quality", "confidence": 0.4}`}, 0.4},
+		{"percentage", 1, []string{`{"classification": "Low quality", "confidence": "85%"}`}, 0.85},
+		{"zero", 1, []string{`{"classification": "Low quality", "confidence": 0}`}, minConfidence},
+		{"none reported", 1, []string{"Low quality"}, 1},
+		{"weighted by agreement", 3, []string{`{"classification": "Good enough", "confidence": 0.9}`, "Low quality", `{"classification": "Good enough", "confidence": 0.6}`}, 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				AIEngine: config.AIEngineConfig{Samples: tt.samples},
+				PromptConfig: config.PromptConfig{
+					QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+				},
+			}
+			classifier := &Classifier{config: cfg, llm: &sequenceLLM{labels: tt.labels}}
+
+			_, confidence, err := classifier.ClassifyWithConfidence("Some test content")
+			if err != nil {
+				t.Fatalf("ClassifyWithConfidence() error = %v", err)
+			}
+			if math.Abs(confidence-tt.expected) > 1e-9 {
+				t.Errorf("ClassifyWithConfidence() confidence = %v, want %v", confidence, tt.expected)
+			}
+		})
+	}
+}
+
+func TestClassificationReason(t *testing.T) {
+	cfg := &config.Config{
+		AIEngine: config.AIEngineConfig{Samples: 3},
+		PromptConfig: config.PromptConfig{
+			QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+		},
+	}
+	llm := &sequenceLLM{labels: []string{
+		`{"classification": "Low quality", "reason": "Only a list of links."}`,
+		`{"classification": "Good enough", "reason": "Explains the setup\nstep by step."}`,
+		`{"classification": "Good enough", "reason": "Complete."}`,
+	}}
+	classifier := &Classifier{config: cfg, llm: llm}
+
+	// The majority verdict keeps the reason of the first sample that gave it, on a single line
+	verdict, err := classifier.Classify("Some test content")
+	if err != nil {
+		t.Fatalf("Classify() error = %v", err)
+	}
+	if verdict.Classification != "Good enough" || verdict.Reason != "Explains the setup step by step." {
+		t.Errorf("Classify() = %+v, want Good enough with the reason of the second sample", verdict)
+	}
+}
+
+func TestQualityScores(t *testing.T) {
+	cfg := &config.Config{
+		PromptConfig: config.PromptConfig{
+			QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+		},
+		Scoring: config.ScoringConfig{Dimensions: []config.ScoringDimension{
+			{Name: "completeness", Weight: 3},
+			{Name: "structure"},
+			{Name: "freshness"},
+		}},
+	}
+	llm := &sequenceLLM{labels: []string{
+		`{"classification": "Good enough", "scores": {"Completeness": 4, "structure": "7", "actionability": 1}}`,
+	}}
+	classifier := &Classifier{config: cfg, llm: llm}
+
+	// Scores are matched to the configured dimensions and clamped, and unscored dimensions carry no weight
+	verdict, err := classifier.Classify("Some test content")
+	if err != nil {
+		t.Fatalf("Classify() error = %v", err)
+	}
+	if !reflect.DeepEqual(verdict.Scores, map[string]int{"completeness": 4, "structure": 5}) {
+		t.Errorf("Scores = %v, want completeness 4 and structure 5", verdict.Scores)
+	}
+	if verdict.Score != 4.25 {
+		t.Errorf("Score = %v, want 4.25", verdict.Score)
+	}
+
+	// The function asks for a score on every dimension
+	parameters := classifier.functions()[0].Parameters.(jsonschema.Definition)
+	if scores := parameters.Properties["scores"]; len(scores.Properties) != 3 || scores.Required[0] != "completeness" {
+		t.Errorf("Expected a score property per dimension, got %+v", scores)
+	}
+
+	// Without dimensions the scores are ignored
+	classifier.config = &config.Config{PromptConfig: cfg.PromptConfig}
+	if verdict, _ := classifier.Classify("Some test content"); verdict.Scores != nil || verdict.Score != 0 {
+		t.Errorf("Expected no scores without dimensions, got %+v", verdict)
+	}
+}
+
+func TestRating(t *testing.T) {
+	cfg := &config.Config{
+		PromptConfig: config.PromptConfig{
+			QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+		},
+		Scoring: config.ScoringConfig{Rating: true},
+	}
+	tests := []struct {
+		response string
+		want     *int
+	}{
+		{`{"classification": "Low quality", "rating": 42}`, intPointer(42)},
+		{`{"classification": "Low quality", "rating": "17.6"}`, intPointer(18)},
+		{`{"classification": "Good enough", "rating": 140}`, intPointer(100)},
+		{`{"classification": "Good enough"}`, nil},
+	}
+
+	for _, tt := range tests {
+		classifier := &Classifier{config: cfg, llm: &sequenceLLM{labels: []string{tt.response}}}
+		verdict, err := classifier.Classify("Some test content")
+		if err != nil {
+			t.Fatalf("Classify() error = %v", err)
+		}
+		if (verdict.Rating == nil) != (tt.want == nil) || (tt.want != nil && *verdict.Rating != *tt.want) {
+			t.Errorf("Classify() of %s rating = %v, want %v", tt.response, verdict.Rating, tt.want)
+		}
+	}
+
+	// The function asks for a rating, and the rating is dropped when rating is off
+	classifier := &Classifier{config: cfg, llm: &sequenceLLM{labels: []string{tests[0].response}}}
+	if parameters := classifier.functions()[0].Parameters.(jsonschema.Definition); parameters.Properties["rating"].Type != jsonschema.Integer {
+		t.Errorf("Expected a rating property, got %+v", parameters.Properties)
+	}
+	classifier.config = &config.Config{PromptConfig: cfg.PromptConfig}
+	if verdict, _ := classifier.Classify("Some test content"); verdict.Rating != nil {
+		t.Errorf("Expected no rating when rating is off, got %d", *verdict.Rating)
+	}
+}
+
+func intPointer(n int) *int {
+	return &n
+}
+
+// flakyLLM fails with an error a number of times before classifying the content
+type flakyLLM struct {
+	failures int
+	err      error
+	calls    int
+}
+
+// Call implements the llms.Model interface
+func (m *flakyLLM) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return "", nil // Not used in this test
+}
+
+// GenerateContent implements the llms.Model interface
+func (m *flakyLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	m.calls++
+	if m.calls <= m.failures {
+		return nil, m.err
+	}
+	return simpleResponse("Good enough"), nil
+}
+
+func TestRetries(t *testing.T) {
+	serverError := errors.New("API returned unexpected status code: 500")
+	tests := []struct {
+		name      string
+		retries   int
+		err       error
+		wantCalls int
+		wantErr   error
+	}{
+		{"recovers", 2, serverError, 3, nil},
+		{"connection reset", 2, fmt.Errorf("post: %w", syscall.ECONNRESET), 3, nil},
+		{"budget exhausted", 1, serverError, 2, ErrModelUnavailable},
+		{"not transient", 2, errors.New("model not found"), 1, ErrModelUnavailable},
+		{"disabled", 0, serverError, 1, ErrModelUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				AIEngine: config.AIEngineConfig{Retries: tt.retries, RetryBackoff: time.Millisecond, RetryMaxBackoff: 2 * time.Millisecond},
+				PromptConfig: config.PromptConfig{
+					QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+				},
+			}
+			llm := &flakyLLM{failures: 2, err: tt.err}
+			classifier := &Classifier{config: cfg, llm: llm}
+
+			got, err := classifier.ClassifyContent("Some test content")
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && got != "Good enough") {
+				t.Errorf("ClassifyContent() = %q, %v, want %v", got, err, tt.wantErr)
+			}
+			if llm.calls != tt.wantCalls {
+				t.Errorf("Expected %d calls, got %d", tt.wantCalls, llm.calls)
+			}
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	if newRateLimiter(0, 0) != nil {
+		t.Error("Expected no limiter without limits")
+	}
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newRateLimiter(2, 1000)
+	limiter.now = func() time.Time { return now }
+
+	// Two requests fit in the first minute, the third waits for the first to leave the window
+	for i := 0; i < 2; i++ {
+		if err := limiter.wait(context.Background()); err != nil {
+			t.Fatalf("wait() error = %v", err)
+		}
+		now = now.Add(10 * time.Second)
+	}
+	if got, want := limiter.delay(now), 40*time.Second; got != want {
+		t.Errorf("Expected a request delay of %s, got %s", want, got)
+	}
+
+	// Once the requests leave the window, the tokens of the last minute hold requests back
+	now = now.Add(time.Minute)
+	limiter.prune(now)
+	limiter.record(600)
+	now = now.Add(15 * time.Second)
+	limiter.record(600)
+	if got, want := limiter.delay(now), 45*time.Second; got != want {
+		t.Errorf("Expected a token delay of %s, got %s", want, got)
+	}
+	now = now.Add(45 * time.Second)
+	limiter.prune(now)
+	if got := limiter.delay(now); got != 0 {
+		t.Errorf("Expected no delay once the tokens are below the limit, got %s", got)
+	}
+
+	// A request that cannot be sent before the context is done gives up
+	limiter.record(1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the wait to be canceled, got %v", err)
+	}
+}
+
+func TestCondense(t *testing.T) {
+	if content, condensed := Condense("Short note", 100); condensed || content != "Short note" {
+		t.Errorf("Expected a short note to be kept, got %q", content)
+	}
+
+	long := strings.Repeat("word ", 200)
+	content := "Intro line\n\n# First\n\n" + long + "\n\n```\n# not a heading\n```\n\n## Second\n\nBrief.\n\n## Third\n\n" + long
+	condensed, ok := Condense(content, 100)
+	if !ok {
+		t.Fatal("Expected a long note to be condensed")
+	}
+	for _, want := range []string{"Intro line", "# First\n", "## Second\n\nBrief.", "## Third\n", condensedMarker} {
+		if !strings.Contains(condensed, want) {
+			t.Errorf("Expected the condensed note to contain %q, got:\n%s", want, condensed)
+		}
+	}
+	if strings.Contains(condensed, "# not a heading") {
+		t.Error("Expected the end of the first section to be left out")
+	}
+	if tokens := EstimateTokens(condensed); tokens > 110 {
+		t.Errorf("Expected about 100 tokens, got %d", tokens)
+	}
+}
+
+// hangingLLM leaves the first requests unanswered until they are given up on
+type hangingLLM struct {
+	hangs int
+	calls atomic.Int32
+}
+
+// Call implements the llms.Model interface
+func (m *hangingLLM) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return "", nil // Not used in this test
+}
+
+// GenerateContent implements the llms.Model interface
+func (m *hangingLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	if int(m.calls.Add(1)) <= m.hangs {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return simpleResponse("Good enough"), nil
+}
+
+func TestRequestTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		hangs   int
+		want    Classification
+		wantErr error
+	}{
+		{"retried", 1, "Good enough", nil},
+		{"retries exhausted", 2, TimedOut, ErrTimedOut},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				AIEngine: config.AIEngineConfig{
+					Timeout:         time.Minute,
+					RequestTimeout:  10 * time.Millisecond,
+					Retries:         1,
+					RetryBackoff:    time.Millisecond,
+					RetryMaxBackoff: time.Millisecond,
+				},
+				PromptConfig: config.PromptConfig{
+					QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+				},
+			}
+			llm := &hangingLLM{hangs: tt.hangs}
+			classifier := &Classifier{config: cfg, llm: llm}
+
+			got, err := classifier.ClassifyContent("Some test content")
+			if got != tt.want || !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("ClassifyContent() = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+			if calls := llm.calls.Load(); calls != 2 {
+				t.Errorf("Expected 2 requests, got %d", calls)
+			}
+		})
+	}
+}
+
+func TestClassifyCanceled(t *testing.T) {
+	cfg := &config.Config{
+		AIEngine: config.AIEngineConfig{Retries: 2, RetryBackoff: time.Millisecond},
+		PromptConfig: config.PromptConfig{
+			QualityClassificationPrompt: "Here is the content to review: {{ content }}",
+		},
+	}
+	fallback := &sequenceLLM{labels: []string{"Good enough"}}
+	classifier := &Classifier{
+		config:    cfg,
+		llm:       &hangingLLM{hangs: 1},
+		fallbacks: []namedEngine{{name: "fallback", llm: fallback}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	classifier.SetContext(ctx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	if _, err := classifier.ClassifyContent("Some test content"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ClassifyContent() error = %v, want %v", err, context.Canceled)
+	}
+	if fallback.calls != 0 {
+		t.Errorf("Expected no fallback once canceled, got %d requests", fallback.calls)
+	}
+}
+
+func TestGenerationOptions(t *testing.T) {
+	temperature, topP, seed := 0.0, 0.9, 42
+	cfg := &config.Config{
+		AIEngine: config.AIEngineConfig{
+			Samples:           1,
+			SampleTemperature: 0.7,
+			Options:           config.GenerationOptions{Temperature: &temperature, TopP: &topP, Seed: &seed},
+		},
+		PromptConfig: config.PromptConfig{QualityClassificationPrompt: "Review: {{ content }}"},
+	}
+	llm := &sequenceLLM{labels: []string{"Good enough"}}
+	classifier := &Classifier{config: cfg, llm: llm}
+
+	if _, err := classifier.Classify("Some test content"); err != nil {
+		t.Fatalf("Classify() error = %v", err)
+	}
+	if llm.options.Temperature != 0 || llm.options.TopP != 0.9 || llm.options.Seed != 42 {
+		t.Errorf("Expected the configured options, got %+v", llm.options)
+	}
+
+	// Sampling several times needs the sample temperature, which takes precedence
+	llm.options.Temperature = 1
+	cfg.AIEngine.Samples = 2
+	if _, err := classifier.Classify("Some test content"); err != nil {
+		t.Fatalf("Classify() error = %v", err)
+	}
+	if llm.options.Temperature != 0.7 || llm.options.Seed != 42 {
+		t.Errorf("Expected the sample temperature with the configured seed, got %+v", llm.options)
+	}
+}
+
+// textLLM answers every request with the same text
+type textLLM struct {
+	text     string
+	prompt   string                // Prompt of the last request
+	messages []llms.MessageContent // Messages of the last request
+}
+
+// Call implements the llms.Model interface
+func (m *textLLM) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return "", nil // Not used in this test
+}
+
+// GenerateContent implements the llms.Model interface
+func (m *textLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	m.messages = messages
+	m.prompt = messages[len(messages)-1].Parts[0].(llms.TextContent).Text
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: m.text}}}, nil
+}
+
+func TestSuggestImprovements(t *testing.T) {
+	cfg := &config.Config{
+		PromptConfig: config.PromptConfig{ImprovementPrompt: "Suggest improvements: {{ content }}"},
+	}
+	llm := &textLLM{text: "<think>Short note.</think>Here are some actions:\n\n1. Explain why the setting matters.\n2) Add an example command.\n- Link the related runbook.\n"}
+	classifier := &Classifier{config: cfg, llm: llm}
+
+	suggestions, err := classifier.SuggestImprovements("# Setting")
+	if err != nil {
+		t.Fatalf("SuggestImprovements() error = %v", err)
+	}
+	want := []string{"Explain why the setting matters.", "Add an example command.", "Link the related runbook."}
+	if !reflect.DeepEqual(suggestions, want) {
+		t.Errorf("SuggestImprovements() = %q, want %q", suggestions, want)
+	}
+	if llm.prompt != "Suggest improvements: # Setting" {
+		t.Errorf("Expected the content in the prompt, got %q", llm.prompt)
+	}
+
+	// An answer that is not a list is kept as a single suggestion
+	llm.text = "Add a summary\nat the top."
+	if suggestions, err := classifier.SuggestImprovements("# Setting"); err != nil || !reflect.DeepEqual(suggestions, []string{"Add a summary at the top."}) {
+		t.Errorf("SuggestImprovements() = %q, %v, want a single suggestion", suggestions, err)
+	}
+}
+
+func TestSuggestTags(t *testing.T) {
+	cfg := &config.Config{
+		PromptConfig: config.PromptConfig{TagPrompt: "Known: {{ tags }}. Note: {{ content }}"},
+	}
+	llm := &textLLM{text: "Tags: #kubernetes, Pod Networking, 2024, what?\n- ops, **kubernetes**, a, b, c, d"}
+	classifier := &Classifier{config: cfg, llm: llm}
+
+	tags, err := classifier.SuggestTags("# Pods", []string{"ops", "k8s"})
+	if err != nil {
+		t.Fatalf("SuggestTags() error = %v", err)
+	}
+	if want := []string{"kubernetes", "Pod-Networking", "ops", "a", "b"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("SuggestTags() = %q, want %q", tags, want)
+	}
+	if llm.prompt != "Known: ops, k8s. Note: # Pods" {
+		t.Errorf("Expected the known tags and content in the prompt, got %q", llm.prompt)
+	}
+
+	if _, err := classifier.SuggestTags("# Pods", nil); err != nil || llm.prompt != "Known: none yet. Note: # Pods" {
+		t.Errorf("Expected a prompt without known tags, got %q, %v", llm.prompt, err)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	cfg := &config.Config{
+		PromptConfig: config.PromptConfig{SummaryPrompt: "Summarize: {{ content }}"},
+	}
+	llm := &textLLM{text: "\n- \"How pods   talk to each other.\"\nA second line"}
+	classifier := &Classifier{config: cfg, llm: llm}
+
+	summary, err := classifier.Summarize("# Pods")
+	if err != nil {
+		t.Fatalf("Summarize() error = %v", err)
+	}
+	if summary != "How pods talk to each other." {
+		t.Errorf("Summarize() = %q, want the first line without the marker and quotes", summary)
+	}
+	if llm.prompt != "Summarize: # Pods" {
+		t.Errorf("Expected the content in the prompt, got %q", llm.prompt)
+	}
+}
+
+func TestCheckTitle(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     string
+		wantErr  bool
+	}{
+		{"match", "Yes.", "", false},
+		{"mismatch with reason", "No - the note is a recipe for   banana bread.\nMore details", "the note is a recipe for banana bread.", false},
+		{"mismatch without reason", "**No**", "The content does not match the title", false},
+		{"unparseable", "Nothing to add", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				PromptConfig: config.PromptConfig{TitlePrompt: "Title: {{ title }}. Note: {{ content }}"},
+			}
+			llm := &textLLM{text: tt.response}
+			classifier := &Classifier{config: cfg, llm: llm}
+
+			got, err := classifier.CheckTitle("Kubernetes pods", "# Banana bread")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckTitle() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CheckTitle() = %q, want %q", got, tt.want)
+			}
+			if llm.prompt != "Title: Kubernetes pods. Note: # Banana bread" {
+				t.Errorf("Expected the title and content in the prompt, got %q", llm.prompt)
+			}
+		})
+	}
+}
+
+func TestNeedsSources(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     bool
+		wantErr  bool
+	}{
+		{"claims", "Yes, it quotes a study.", true, false},
+		{"no claims", "**No**", false, false},
+		{"unparseable", "It depends", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				PromptConfig: config.PromptConfig{SourcesPrompt: "Claims? {{ content }}"},
+			}
+			llm := &textLLM{text: tt.response}
+			classifier := &Classifier{config: cfg, llm: llm}
+
+			got, err := classifier.NeedsSources("Coffee cuts the risk of gout by 40%.")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NeedsSources() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NeedsSources() = %v, want %v", got, tt.want)
+			}
+			if llm.prompt != "Claims? Coffee cuts the risk of gout by 40%." {
+				t.Errorf("Expected the content in the prompt, got %q", llm.prompt)
+			}
+		})
+	}
+}
+
+func TestPromptFor(t *testing.T) {
+	cfg := &config.Config{
+		PromptConfig: config.PromptConfig{QualityClassificationPrompt: "Default: {{ content }}"},
+		Prompts: []config.PromptOverride{
+			{Match: "journal/**", Prompt: "Journal: {{ content }}"},
+			{Tag: "#Reference", Prompt: "Reference: {{ content }}"},
+			{Match: "work", Tag: "meeting", Prompt: "Work meeting: {{ content }}"},
+		},
+	}
+	classifier := &Classifier{config: cfg, prompts: newPromptOverrides(cfg.Prompts)}
+
+	tests := []struct {
+		path string
+		tags []string
+		want string
+	}{
+		{"journal/2024/01-05.md", []string{"reference"}, "Journal: {{ content }}"},
+		{"notes/tcp.md", []string{"reference/networking"}, "Reference: {{ content }}"},
+		{"notes/tcp.md", []string{"references"}, "Default: {{ content }}"},
+		{"work/standup.md", []string{"Meeting"}, "Work meeting: {{ content }}"},
+		{"home/standup.md", []string{"meeting"}, "Default: {{ content }}"},
+	}
+	for _, test := range tests {
+		if got := classifier.PromptFor(test.path, test.tags); got != test.want {
+			t.Errorf("PromptFor(%q, %v) = %q, want %q", test.path, test.tags, got, test.want)
+		}
+	}
+
+	// The selected prompt is the one sent to the model
+	llm := &textLLM{text: `{"classification": "Good enough"}`}
+	classifier.llm = llm
+	if _, err := classifier.ClassifyWithPrompt("Went for a walk", classifier.PromptFor("journal/today.md", nil)); err != nil {
+		t.Fatalf("ClassifyWithPrompt() error = %v", err)
+	}
+	if llm.prompt != "Journal: Went for a walk" {
+		t.Errorf("Expected the journal prompt to be sent, got %q", llm.prompt)
+	}
+}
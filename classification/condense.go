@@ -0,0 +1,108 @@
+package classification
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// charsPerToken approximates the characters in a token for the tokenizers of common models, without loading the
+// vocabulary of the model in use
+const charsPerToken = 4
+
+// condensedMarker replaces the part of a section left out by Condense
+const condensedMarker = "[...]"
+
+// EstimateTokens returns the approximate number of tokens a model needs for the text
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + charsPerToken - 1) / charsPerToken
+}
+
+// condensedSection is a heading with the text up to the next heading
+type condensedSection struct {
+	heading string // Empty for the text before the first heading
+	body    []rune
+}
+
+// Condense shortens content longer than maxTokens to about maxTokens by keeping every heading and the start of the
+// text of each section, and reports whether it did
+// The budget is shared out evenly, and what short sections do not need goes to the longer ones, so the model
+// still sees the structure of the whole note rather than only its beginning.
+func Condense(content string, maxTokens int) (string, bool) {
+	if maxTokens <= 0 || EstimateTokens(content) <= maxTokens {
+		return content, false
+	}
+
+	sections := splitSections(content)
+	budget := maxTokens * charsPerToken
+	for _, section := range sections {
+		budget -= utf8.RuneCountInString(section.heading) + len(condensedMarker) + 2
+	}
+	if budget <= 0 {
+		// Too many headings to keep them all, so keep the beginning only
+		return string([]rune(content)[:maxTokens*charsPerToken]) + "\n" + condensedMarker, true
+	}
+
+	// Give each section an equal share, shortest first, so the share a section does not use goes to the others
+	order := make([]int, len(sections))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return len(sections[order[a]].body) < len(sections[order[b]].body) })
+	shares := make([]int, len(sections))
+	for left, i := range order {
+		shares[i] = min(len(sections[i].body), budget/(len(sections)-left))
+		budget -= shares[i]
+	}
+
+	var result strings.Builder
+	for i, section := range sections {
+		result.WriteString(section.heading)
+		body := section.body
+		if len(body) <= shares[i] {
+			result.WriteString(string(body))
+			continue
+		}
+
+		// Cut at the last line or word break within the share, so the kept text does not end mid-word
+		kept := string(body[:shares[i]])
+		if cut := strings.LastIndexAny(kept, "\n "); cut > 0 {
+			kept = kept[:cut]
+		}
+		result.WriteString(strings.TrimRight(kept, " \n"))
+		result.WriteString("\n" + condensedMarker + "\n\n")
+	}
+	return result.String(), true
+}
+
+// splitSections splits markdown content at its headings, ignoring lines starting with # within code blocks
+func splitSections(content string) []condensedSection {
+	var sections []condensedSection
+	var current condensedSection
+	var body strings.Builder
+	inCode := false
+	for _, line := range strings.SplitAfter(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inCode = !inCode
+		}
+		if !inCode && isHeading(trimmed) {
+			current.body = []rune(body.String())
+			if current.heading != "" || len(current.body) > 0 {
+				sections = append(sections, current)
+			}
+			current = condensedSection{heading: line}
+			body.Reset()
+			continue
+		}
+		body.WriteString(line)
+	}
+	current.body = []rune(body.String())
+	return append(sections, current)
+}
+
+// isHeading reports whether a line is an ATX heading, such as "## Setup"
+func isHeading(line string) bool {
+	level := len(line) - len(strings.TrimLeft(line, "#"))
+	return level >= 1 && level <= 6 && (len(line) == level || line[level] == ' ')
+}
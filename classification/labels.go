@@ -0,0 +1,65 @@
+package classification
+
+import "strings"
+
+// Labels given by ratemykb itself or asked for by the default prompt
+// Prompts may ask for any other labels, which are kept as the model wrote them.
+const (
+	Empty       Classification = "Empty"        // Files without content, found without asking the engine
+	LowQuality  Classification = "Low quality"  // Notes needing work, and files with frontmatter only
+	GoodEnough  Classification = "Good enough"  // Notes that need no work
+	NotMarkdown Classification = "Not markdown" // Files that are not notes, such as exported data
+	Placeholder Classification = "Placeholder"  // Notes with only comments or placeholder text, found without asking the engine
+	Unknown     Classification = "Unknown"      // Files the engine failed to classify
+	TimedOut    Classification = "Timed out"    // Files not classified within the timeout, classified again on the next run
+)
+
+// Labels lists the labels given by ratemykb itself or asked for by the default prompt
+var Labels = []Classification{Empty, LowQuality, GoodEnough, NotMarkdown, Placeholder, Unknown, TimedOut}
+
+// Parse returns the label written in a report or by a model, matching the known labels regardless of case and
+// surrounding space
+// Other labels are returned trimmed, as written.
+func Parse(label string) Classification {
+	parsed := Classification(strings.TrimSpace(label))
+	for _, known := range Labels {
+		if labelKey(parsed) == labelKey(known) {
+			return known
+		}
+	}
+	return parsed
+}
+
+// Is reports whether the classification is the label, regardless of case and surrounding space
+func (c Classification) Is(label Classification) bool {
+	return labelKey(c) == labelKey(label)
+}
+
+// Severity orders classifications from the files needing no work to the files needing the most, for sorting
+// findings
+type Severity int
+
+// Severities of classifications, from least to most severe
+const (
+	SeverityNone    Severity = iota // No verdict on the note: Unknown and Timed out
+	SeverityPassing                 // Good enough
+	SeverityOther                   // Labels of other prompts, whose meaning is not known
+	SeverityLow                     // Low quality and Not markdown
+	SeverityEmpty                   // Empty and Placeholder
+)
+
+// Severity returns how much work a file with the classification needs
+func (c Classification) Severity() Severity {
+	switch Parse(string(c)) {
+	case Unknown, TimedOut:
+		return SeverityNone
+	case GoodEnough:
+		return SeverityPassing
+	case LowQuality, NotMarkdown:
+		return SeverityLow
+	case Empty, Placeholder:
+		return SeverityEmpty
+	default:
+		return SeverityOther
+	}
+}
@@ -0,0 +1,42 @@
+package classification
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		label string
+		want  Classification
+	}{
+		{"Low quality", LowQuality},
+		{" low QUALITY ", LowQuality},
+		{"timed out", TimedOut},
+		{" Borderline ", Classification("Borderline")},
+	}
+
+	for _, tt := range tests {
+		if got := Parse(tt.label); got != tt.want {
+			t.Errorf("Parse(%q) = %q, want %q", tt.label, got, tt.want)
+		}
+	}
+
+	if !Classification("good enough").Is(GoodEnough) || Classification("Good").Is(GoodEnough) {
+		t.Error("Expected Is to compare labels regardless of case only")
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	labels := []Classification{GoodEnough, "empty", Unknown, "Borderline", NotMarkdown, TimedOut}
+	sort.SliceStable(labels, func(i, j int) bool {
+		return labels[i].Severity() > labels[j].Severity()
+	})
+
+	want := []Classification{"empty", NotMarkdown, "Borderline", GoodEnough, Unknown, TimedOut}
+	for i := range want {
+		if labels[i] != want[i] {
+			t.Fatalf("Sorted by severity = %v, want %v", labels, want)
+		}
+	}
+}
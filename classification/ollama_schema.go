@@ -0,0 +1,160 @@
+package classification
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// defaultOllamaURL is the URL of a local Ollama server, used when the engine has none
+const defaultOllamaURL = "http://localhost:11434"
+
+// ollamaSchemaModel calls the chat API of Ollama directly so the classification function can be passed as the JSON
+// schema of a structured output, which Ollama enforces while generating
+// Many local models ignore function calling and answer in prose, while a schema constrains every model to
+// parseable JSON. Requests without functions, such as split suggestions, are sent without a schema.
+type ollamaSchemaModel struct {
+	url    string // Base URL of the Ollama server
+	model  string
+	numCtx int // Context window in tokens, 0 for the model default
+	client *http.Client
+}
+
+// ollamaChatRequest is the body of a request to /api/chat
+type ollamaChatRequest struct {
+	Model    string              `json:"model"`
+	Messages []ollamaChatMessage `json:"messages"`
+	Format   json.RawMessage     `json:"format,omitempty"`
+	Options  map[string]any      `json:"options,omitempty"`
+	Stream   bool                `json:"stream"`
+}
+
+// ollamaChatMessage is a message of a chat request or response
+type ollamaChatMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"` // Base64 encoded
+}
+
+// ollamaChatResponse is the body of a response of /api/chat when not streaming
+type ollamaChatResponse struct {
+	Message         ollamaChatMessage `json:"message"`
+	PromptEvalCount int               `json:"prompt_eval_count"`
+	EvalCount       int               `json:"eval_count"`
+	Error           string            `json:"error"`
+}
+
+// Call implements the llms.Model interface
+func (m *ollamaSchemaModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+// GenerateContent implements the llms.Model interface, constraining the response to the parameters of the first
+// function given
+func (m *ollamaSchemaModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	// Start from values no option sets, so the options left out are left to the model
+	opts := llms.CallOptions{Temperature: -1, TopP: -1, Seed: -1}
+	for _, option := range options {
+		option(&opts)
+	}
+
+	request := ollamaChatRequest{Model: m.model, Options: make(map[string]any)}
+	for _, message := range messages {
+		chatMessage := ollamaChatMessage{Role: ollamaRole(message.Role)}
+		for _, part := range message.Parts {
+			switch part := part.(type) {
+			case llms.TextContent:
+				chatMessage.Content += part.Text
+			case llms.BinaryContent:
+				chatMessage.Images = append(chatMessage.Images, base64.StdEncoding.EncodeToString(part.Data))
+			default:
+				return nil, fmt.Errorf("unsupported content part %T for structured output", part)
+			}
+		}
+		request.Messages = append(request.Messages, chatMessage)
+	}
+
+	if len(opts.Functions) > 0 {
+		schema, err := json.Marshal(opts.Functions[0].Parameters)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode output schema: %w", err)
+		}
+		request.Format = schema
+	}
+
+	if opts.Temperature >= 0 {
+		request.Options["temperature"] = opts.Temperature
+	}
+	if opts.TopP >= 0 {
+		request.Options["top_p"] = opts.TopP
+	}
+	if opts.Seed != -1 {
+		request.Options["seed"] = opts.Seed
+	}
+	if m.numCtx > 0 {
+		request.Options["num_ctx"] = m.numCtx
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(m.url, "/")+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	httpResponse, err := m.client.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+
+	data, err := io.ReadAll(httpResponse.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	var response ollamaChatResponse
+	if err := json.Unmarshal(data, &response); err != nil && httpResponse.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if httpResponse.StatusCode != http.StatusOK {
+		message := response.Error
+		if message == "" {
+			message = strings.TrimSpace(string(data))
+		}
+		return nil, fmt.Errorf("ollama returned %d: %s", httpResponse.StatusCode, message)
+	}
+
+	return &llms.ContentResponse{
+		Choices: []*llms.ContentChoice{{
+			Content: response.Message.Content,
+			GenerationInfo: map[string]any{
+				"InputTokens":  response.PromptEvalCount,
+				"OutputTokens": response.EvalCount,
+			},
+		}},
+	}, nil
+}
+
+// ollamaRole returns the role of a message in the chat API of Ollama
+func ollamaRole(role llms.ChatMessageType) string {
+	switch role {
+	case llms.ChatMessageTypeSystem:
+		return "system"
+	case llms.ChatMessageTypeAI:
+		return "assistant"
+	case llms.ChatMessageTypeTool:
+		return "tool"
+	default:
+		return "user"
+	}
+}
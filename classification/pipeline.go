@@ -0,0 +1,170 @@
+package classification
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ratemykb/config"
+	"sort"
+	"strings"
+
+	"github.com/tmc/langchaingo/jsonschema"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// validatePipeline checks that every step of the prompt pipeline is named once and every rule refers to a step
+func validatePipeline(pipeline config.PipelineConfig) error {
+	steps := make(map[string]bool)
+	for i, step := range pipeline.Steps {
+		name := normalizeAnswer(step.Name)
+		if name == "" {
+			return fmt.Errorf("pipeline step %d has no name", i+1)
+		}
+		if steps[name] {
+			return fmt.Errorf("pipeline step %q is defined twice", step.Name)
+		}
+		if !strings.Contains(step.Prompt, "{{ content }}") {
+			return fmt.Errorf("pipeline step %q prompt does not contain {{ content }}", step.Name)
+		}
+		steps[name] = true
+	}
+
+	for i, rule := range pipeline.Rules {
+		if rule.Label == "" {
+			return fmt.Errorf("pipeline rule %d has no label", i+1)
+		}
+		for step := range rule.When {
+			if !steps[normalizeAnswer(step)] {
+				return fmt.Errorf("pipeline rule %d refers to unknown step %q", i+1, step)
+			}
+		}
+	}
+
+	return nil
+}
+
+// classifyPipeline asks the pipeline questions needed to reach a verdict and returns the label of the first
+// matching rule, or the default label if none matches
+// A question is only asked once a rule depends on it, so an early verdict saves the remaining questions.
+func (c *Classifier) classifyPipeline(ctx context.Context, llm llms.Model, content string, options ...llms.CallOption) (Classification, error) {
+	pipeline := c.config.PromptConfig.Pipeline
+
+	// Step names are compared case-insensitively, since configuration keys lose their case
+	order := make(map[string]int)
+	prompts := make(map[string]string)
+	for i, step := range pipeline.Steps {
+		name := normalizeAnswer(step.Name)
+		order[name] = i
+		prompts[name] = step.Prompt
+	}
+
+	answers := make(map[string]string)
+	for _, rule := range pipeline.Rules {
+		// Ask in pipeline order, so the answers do not depend on how the rule was written
+		steps := make([]string, 0, len(rule.When))
+		expected := make(map[string]string)
+		for step, answer := range rule.When {
+			name := normalizeAnswer(step)
+			steps = append(steps, name)
+			expected[name] = normalizeAnswer(answer)
+		}
+		sort.Slice(steps, func(i, j int) bool {
+			return order[steps[i]] < order[steps[j]]
+		})
+
+		matched := true
+		for _, step := range steps {
+			answer, ok := answers[step]
+			if !ok {
+				var err error
+				answer, err = c.ask(ctx, llm, prompts[step], content, options...)
+				if err != nil {
+					return Unknown, fmt.Errorf("pipeline step %q: %w", step, err)
+				}
+				answers[step] = answer
+			}
+
+			if answer != expected[step] {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return Classification(rule.Label), nil
+		}
+	}
+
+	return Classification(pipeline.DefaultLabel), nil
+}
+
+// ask sends a single pipeline question about the content to a model and returns the normalized answer
+func (c *Classifier) ask(ctx context.Context, llm llms.Model, prompt, content string, options ...llms.CallOption) (string, error) {
+	prompt = strings.Replace(prompt, "{{ content }}", content, 1)
+
+	resp, err := c.generateContent(ctx, llm, c.messages(llms.TextPart(prompt)), append(options, llms.WithFunctions(answerFunctions))...)
+	if errors.Is(err, ErrTimedOut) {
+		return "", err
+	}
+	if err != nil {
+		return "", fmt.Errorf("error calling GenAI engine: %w: %w", ErrModelUnavailable, err)
+	}
+
+	// Keep track of the tokens consumed so callers can enforce a budget
+	c.addTokens(tokenUsage(resp))
+
+	answer := parseAnswer(resp)
+	if answer == "" {
+		return "", fmt.Errorf("%w: no answer", ErrUnparseableResponse)
+	}
+	return answer, nil
+}
+
+// parseAnswer extracts the answer from a function call, a JSON object or the plain text of a response
+// The function call may come in any choice, such as after the preamble of Anthropic models.
+func parseAnswer(resp *llms.ContentResponse) string {
+	var answerResponse struct {
+		Answer string `json:"answer"`
+	}
+
+	if call := functionCall(resp); call != nil {
+		if err := json.Unmarshal([]byte(call.Arguments), &answerResponse); err == nil && answerResponse.Answer != "" {
+			return normalizeAnswer(answerResponse.Answer)
+		}
+	}
+
+	if len(resp.Choices) == 0 || resp.Choices[0] == nil {
+		return ""
+	}
+	content := stripThinking(resp.Choices[0].Content)
+	content = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(content, "```json"), "```"), "```")
+	if err := json.Unmarshal([]byte(strings.TrimSpace(content)), &answerResponse); err == nil && answerResponse.Answer != "" {
+		return normalizeAnswer(answerResponse.Answer)
+	}
+
+	return normalizeAnswer(content)
+}
+
+// normalizeAnswer lowercases an answer or step name and removes surrounding spaces, quotes and punctuation
+func normalizeAnswer(answer string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(answer), " .!?\"'`"))
+}
+
+// Define the answer function for pipeline questions
+var answerFunctions = []llms.FunctionDefinition{
+	{
+		Name:        "answerQuestion",
+		Description: "Answer a question about the content",
+		Parameters: jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"answer": {
+					Type:        jsonschema.String,
+					Description: "The short answer to the question, such as yes or no",
+				},
+			},
+			Required: []string{"answer"},
+		},
+	},
+}
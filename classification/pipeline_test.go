@@ -0,0 +1,145 @@
+package classification
+
+import (
+	"context"
+	"ratemykb/config"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// scriptedLLM answers each pipeline question with the answer configured for the first word of its prompt
+type scriptedLLM struct {
+	answers map[string]string
+	asked   []string
+}
+
+// Call implements the llms.Model interface
+func (m *scriptedLLM) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return "", nil // Not used in this test
+}
+
+// GenerateContent implements the llms.Model interface
+func (m *scriptedLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	prompt := messages[0].Parts[0].(llms.TextContent).Text
+	question := strings.Fields(prompt)[0]
+	m.asked = append(m.asked, question)
+
+	return &llms.ContentResponse{
+		Choices: []*llms.ContentChoice{
+			{Content: m.answers[question]},
+		},
+	}, nil
+}
+
+// pipelineConfig returns a configuration asking whether a note is a stub, then whether it is accurate
+func pipelineConfig() *config.Config {
+	return &config.Config{
+		PromptConfig: config.PromptConfig{
+			Pipeline: config.PipelineConfig{
+				Steps: []config.PipelineStep{
+					{Name: "Stub", Prompt: "stub? {{ content }}"},
+					{Name: "Accurate", Prompt: "accurate? {{ content }}"},
+				},
+				Rules: []config.PipelineRule{
+					{When: map[string]string{"stub": "yes"}, Label: "Low quality"},
+					{When: map[string]string{"accurate": "no", "stub": "no"}, Label: "Inaccurate"},
+				},
+				DefaultLabel: "Good enough",
+			},
+		},
+	}
+}
+
+func TestClassifyPipeline(t *testing.T) {
+	tests := []struct {
+		name          string
+		answers       map[string]string
+		expected      Classification
+		expectedAsked []string
+	}{
+		{
+			name:          "early verdict skips the remaining questions",
+			answers:       map[string]string{"stub?": "Yes."},
+			expected:      "Low quality",
+			expectedAsked: []string{"stub?"},
+		},
+		{
+			name:          "later rule matches",
+			answers:       map[string]string{"stub?": "no", "accurate?": `{"answer": "No"}`},
+			expected:      "Inaccurate",
+			expectedAsked: []string{"stub?", "accurate?"},
+		},
+		{
+			name:          "default label when no rule matches",
+			answers:       map[string]string{"stub?": "no", "accurate?": "yes"},
+			expected:      "Good enough",
+			expectedAsked: []string{"stub?", "accurate?"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := &scriptedLLM{answers: tt.answers}
+			classifier := &Classifier{config: pipelineConfig(), llm: llm}
+
+			got, err := classifier.ClassifyContent("Some test content")
+			if err != nil {
+				t.Fatalf("ClassifyContent() error = %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("ClassifyContent() = %v, want %v", got, tt.expected)
+			}
+			if !reflect.DeepEqual(llm.asked, tt.expectedAsked) {
+				t.Errorf("Asked %v, want %v", llm.asked, tt.expectedAsked)
+			}
+		})
+	}
+}
+
+func TestParseAnswer(t *testing.T) {
+	tests := []struct {
+		name string
+		resp *llms.ContentResponse
+		want string
+	}{
+		{"plain text", &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "Yes."}}}, "yes"},
+		{"json", &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "```json\n{\"answer\": \"No\"}\n```"}}}, "no"},
+		{"function call", &llms.ContentResponse{Choices: []*llms.ContentChoice{
+			{FuncCall: &llms.FunctionCall{Name: "answerQuestion", Arguments: `{"answer": "yes"}`}},
+		}}, "yes"},
+		{"tool call after a preamble", &llms.ContentResponse{Choices: []*llms.ContentChoice{
+			{Content: "Let me look at this note."},
+			{ToolCalls: []llms.ToolCall{{FunctionCall: &llms.FunctionCall{Name: "answerQuestion", Arguments: `{"answer": "No"}`}}}},
+		}}, "no"},
+		{"no choices", &llms.ContentResponse{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseAnswer(tt.resp); got != tt.want {
+				t.Errorf("parseAnswer() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePipeline(t *testing.T) {
+	if err := validatePipeline(pipelineConfig().PromptConfig.Pipeline); err != nil {
+		t.Errorf("validatePipeline() error = %v", err)
+	}
+
+	pipeline := pipelineConfig().PromptConfig.Pipeline
+	pipeline.Rules = append(pipeline.Rules, config.PipelineRule{When: map[string]string{"tone": "rude"}, Label: "Rude"})
+	if err := validatePipeline(pipeline); err == nil {
+		t.Error("Expected an error for a rule referring to an unknown step")
+	}
+
+	pipeline = pipelineConfig().PromptConfig.Pipeline
+	pipeline.Steps[1].Name = "stub"
+	if err := validatePipeline(pipeline); err == nil {
+		t.Error("Expected an error for a step defined twice")
+	}
+}
@@ -0,0 +1,113 @@
+package classification
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateWindow is the period the request and token limits of hosted providers apply to
+const rateWindow = time.Minute
+
+// rateLimiter holds requests back so the requests and tokens sent to the GenAI engine in any minute stay within
+// the configured limits, shared by all workers classifying with the same classifier
+// Tokens are only known once a response arrives, so a request waits until the tokens of the last minute are
+// below the limit rather than until its own tokens fit.
+type rateLimiter struct {
+	requestsPerMinute int // 0 for no limit
+	tokensPerMinute   int // 0 for no limit
+	now               func() time.Time
+
+	mu       sync.Mutex // Guards requests and tokens
+	requests []time.Time
+	tokens   []tokenUse
+}
+
+// tokenUse is the number of tokens a response used and when it arrived
+type tokenUse struct {
+	at     time.Time
+	tokens int
+}
+
+// newRateLimiter returns a limiter for the given limits, or nil if neither is set
+func newRateLimiter(requestsPerMinute, tokensPerMinute int) *rateLimiter {
+	if requestsPerMinute <= 0 && tokensPerMinute <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		requestsPerMinute: max(requestsPerMinute, 0),
+		tokensPerMinute:   max(tokensPerMinute, 0),
+		now:               time.Now,
+	}
+}
+
+// wait blocks until a request may be sent within the limits and records it, or until the context is done
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		l.mu.Lock()
+		now := l.now()
+		l.prune(now)
+		delay := l.delay(now)
+		if delay <= 0 {
+			l.requests = append(l.requests, now)
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// record counts the tokens of a response against the token limit
+func (l *rateLimiter) record(tokens int) {
+	if l == nil || tokens <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = append(l.tokens, tokenUse{at: l.now(), tokens: tokens})
+}
+
+// prune forgets the requests and tokens that are older than the window
+func (l *rateLimiter) prune(now time.Time) {
+	cutoff := now.Add(-rateWindow)
+	for len(l.requests) > 0 && !l.requests[0].After(cutoff) {
+		l.requests = l.requests[1:]
+	}
+	for len(l.tokens) > 0 && !l.tokens[0].at.After(cutoff) {
+		l.tokens = l.tokens[1:]
+	}
+}
+
+// delay returns how long a request has to wait until both limits allow it, 0 if it can be sent now
+func (l *rateLimiter) delay(now time.Time) time.Duration {
+	var delay time.Duration
+	if l.requestsPerMinute > 0 && len(l.requests) >= l.requestsPerMinute {
+		oldest := l.requests[len(l.requests)-l.requestsPerMinute]
+		delay = max(delay, oldest.Add(rateWindow).Sub(now))
+	}
+
+	if l.tokensPerMinute > 0 {
+		used := 0
+		for _, use := range l.tokens {
+			used += use.tokens
+		}
+		// Wait for the oldest responses to leave the window until the rest are below the limit
+		for _, use := range l.tokens {
+			if used < l.tokensPerMinute {
+				break
+			}
+			used -= use.tokens
+			delay = max(delay, use.at.Add(rateWindow).Sub(now))
+		}
+	}
+	return delay
+}
@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"fmt"
+	"ratemykb/config"
+	"ratemykb/state"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// newAggregateCmd creates the command that combines the results of partition runs into the report of the vault
+func newAggregateCmd() *cobra.Command {
+	var partial bool
+
+	cmd := &cobra.Command{
+		Use:   "aggregate [target folder]",
+		Short: "Combine the results of partition runs into the report",
+		Long: `Combine the states kept in .ratemykb/partitions by runs with --partition into the state and
+report of the whole vault, without classifying any files. Partition runs of the same vault can
+run one after the other or in parallel, such as the jobs of a CI matrix whose .ratemykb/partitions
+folders are gathered in one checkout before aggregating. The command refuses to aggregate while
+a partition is missing, unless --partial is set.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Resolve and validate the target folder
+			if err := resolveTargetFolder(args); err != nil {
+				return err
+			}
+
+			// Load configuration
+			cfg, err := config.LoadProfile(configFile, profile)
+			if err != nil {
+				return fmt.Errorf("failed to load configuration: %w", err)
+			}
+
+			partitions, err := state.LoadPartitions(targetFolder)
+			if err != nil {
+				return err
+			}
+			if err := checkPartitions(partitions, partial); err != nil {
+				return err
+			}
+
+			// Aggregate into the stored state, so files relabeled since it was written are flagged as drift
+			stateManager, err := state.New(targetFolder)
+			if err != nil {
+				return fmt.Errorf("failed to load state: %w", err)
+			}
+			if err := configureReport(stateManager, cfg); err != nil {
+				return err
+			}
+			if err := stateManager.Aggregate(partitions); err != nil {
+				return fmt.Errorf("failed to aggregate partitions: %w", err)
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Aggregated %d files from %d partitions\n", len(stateManager.GetProcessedFiles()), len(partitions))
+			fmt.Fprintf(out, "Report available at %s\n", stateManager.ReportPath)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&partial, "partial", false, "Aggregate the partitions found even if some are missing")
+
+	return cmd
+}
+
+// checkPartitions checks that the partitions share the same count and that none is missing, listing the missing
+// ones unless partial results are accepted
+func checkPartitions(partitions []*state.ProcessingState, partial bool) error {
+	count := partitions[0].Partition.Count
+	found := make(map[int]bool)
+	for _, ps := range partitions {
+		if ps.Partition.Count != count {
+			return fmt.Errorf("found partitions of %d and of %d runs, remove the partitions of earlier runs from %s", count, ps.Partition.Count, state.PartitionDir(targetFolder))
+		}
+		found[ps.Partition.Index] = true
+	}
+
+	var missing []string
+	for i := 1; i <= count; i++ {
+		if !found[i] {
+			missing = append(missing, fmt.Sprintf("%d/%d", i, count))
+		}
+	}
+	if len(missing) > 0 && !partial {
+		return fmt.Errorf("missing partitions %s, run them first or aggregate with --partial", strings.Join(missing, ", "))
+	}
+	return nil
+}
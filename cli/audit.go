@@ -0,0 +1,157 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"ratemykb/audit"
+	"ratemykb/config"
+	"ratemykb/output"
+	"ratemykb/state"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// auditOptions holds the flags of the audit command
+type auditOptions struct {
+	sample  int    // Number of files sampled for the checklist
+	seed    uint64 // Seed of the sampling, 0 for a different sample every time
+	record  bool   // Record the latest checklist instead of writing a new one
+	history bool   // Show the recorded agreement rates instead of writing a checklist
+}
+
+// newAuditCmd creates the command that samples classified files for a human to check
+func newAuditCmd() *cobra.Command {
+	opts := auditOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "audit [target folder]",
+		Short: "Sample classified files for a human to check",
+		Long: `Write a checklist of classified files sampled across every label, for a human to tick the
+files whose label is right. Once done, run the command again with --record to save how often
+the human agreed with the classifier, and with --history to follow the agreement over time,
+for example after changing the model or the prompt. Checklists and results are kept in
+.ratemykb/audits in the target folder.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Resolve and validate the target folder
+			if err := resolveTargetFolder(args); err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+
+			if opts.history {
+				audits, err := audit.History(targetFolder)
+				if err != nil {
+					return err
+				}
+				printAuditHistory(out, audits)
+				return nil
+			}
+
+			if opts.record {
+				path, err := audit.Latest(targetFolder)
+				if err != nil {
+					return err
+				}
+				result, err := audit.Record(path, time.Now())
+				if err != nil {
+					return err
+				}
+				printAudit(out, result)
+				return nil
+			}
+
+			if opts.sample <= 0 {
+				return fmt.Errorf("invalid sample size %d, expected a positive number", opts.sample)
+			}
+
+			// Load configuration, for the model the files were classified with
+			cfg, err := config.LoadProfile(configFile, profile)
+			if err != nil {
+				return fmt.Errorf("failed to load configuration: %w", err)
+			}
+
+			// Load the stored state
+			stateManager, err := state.Load(targetFolder)
+			if errors.Is(err, state.ErrNoState) {
+				return err
+			}
+			if err != nil {
+				return fmt.Errorf("failed to load state: %w", err)
+			}
+
+			files := make([]output.ResultFile, 0, len(stateManager.GetProcessedFiles()))
+			for _, file := range stateManager.GetProcessedFiles() {
+				files = append(files, file)
+			}
+			seed := opts.seed
+			if seed == 0 {
+				seed = rand.Uint64()
+			}
+			sample := audit.Sample(files, opts.sample, rand.New(rand.NewPCG(seed, seed)))
+			if len(sample) == 0 {
+				return fmt.Errorf("no classified files to audit in %s", targetFolder)
+			}
+
+			path, err := audit.WriteChecklist(targetFolder, config.EngineModel(cfg.AIEngine.Provider, cfg.AIEngine.Model, cfg.AIEngine.Deployment), sample, time.Now())
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Wrote a checklist of %d files to %s\n", len(sample), path)
+			fmt.Fprintln(out, "Tick the files whose label is right, then run `ratemykb audit --record`")
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVar(&opts.sample, "sample", 20, "Number of classified files sampled across the labels")
+	cmd.Flags().Uint64Var(&opts.seed, "seed", 0, "Seed of the sampling, to draw the same sample again (0 for a new sample)")
+	cmd.Flags().BoolVar(&opts.record, "record", false, "Record the agreement of the latest checklist")
+	cmd.Flags().BoolVar(&opts.history, "history", false, "Show the agreement of every recorded audit, oldest first")
+
+	return cmd
+}
+
+// printAudit prints the agreement of an audit per label and overall
+func printAudit(w io.Writer, result audit.Audit) {
+	labels := make([]string, 0, len(result.Labels))
+	for label := range result.Labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LABEL\tAGREED\tFILES\tAGREEMENT")
+	for _, label := range labels {
+		agreement := result.Labels[label]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.0f%%\n", label, agreement.Agreed, agreement.Total, agreement.Rate()*100)
+	}
+	overall := result.Overall()
+	fmt.Fprintf(tw, "Overall\t%d\t%d\t%.0f%%\n", overall.Agreed, overall.Total, overall.Rate()*100)
+	tw.Flush()
+	fmt.Fprintf(w, "Recorded audit %s\n", result.ID)
+}
+
+// printAuditHistory prints the overall agreement of every recorded audit with the model it audited
+func printAuditHistory(w io.Writer, audits []audit.Audit) {
+	if len(audits) == 0 {
+		fmt.Fprintln(w, "No audits recorded yet")
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "AUDIT ID\tMODEL\tFILES\tAGREEMENT")
+	for _, result := range audits {
+		overall := result.Overall()
+		model := result.Model
+		if model == "" {
+			model = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.0f%%\n", result.ID, model, overall.Total, overall.Rate()*100)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"ratemykb/config"
+	"ratemykb/safewrite"
+	"ratemykb/scanner"
+	"ratemykb/state"
+	"ratemykb/trash"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+// newCleanEmptyCmd creates the command that moves empty and frontmatter-only files to the Obsidian trash
+func newCleanEmptyCmd() *cobra.Command {
+	var dryRun, undo, keepFrontmatterOnly, force bool
+
+	cmd := &cobra.Command{
+		Use:   "clean-empty [target folder]",
+		Short: "Move empty and frontmatter-only files to the Obsidian trash",
+		Long: `Move the empty and frontmatter-only files found by a previous run into the vault's .trash folder,
+where Obsidian can restore them. Every clean-up records a manifest so it can be undone with --undo.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Resolve and validate the target folder
+			if err := resolveTargetFolder(args); err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			if undo {
+				return undoCleanEmpty(out)
+			}
+
+			// Load configuration
+			cfg, err := config.LoadProfile(configFile, profile)
+			if err != nil {
+				return fmt.Errorf("failed to load configuration: %w", err)
+			}
+
+			// Load the stored state
+			stateManager, err := state.Load(targetFolder)
+			if errors.Is(err, state.ErrNoState) {
+				return err
+			}
+			if err != nil {
+				return fmt.Errorf("failed to load state: %w", err)
+			}
+			if err := configureReport(stateManager, cfg); err != nil {
+				return err
+			}
+
+			fileScanner, err := scanner.New(cfg)
+			if err != nil {
+				return fmt.Errorf("failed to initialize scanner: %w", err)
+			}
+
+			// Re-check each candidate so files edited since the last run are never removed
+			var paths []string
+			for _, file := range stateManager.GetProcessedFiles() {
+				if file.Status != scanner.StatusEmpty && (keepFrontmatterOnly || file.Status != scanner.StatusFrontmatterOnly) {
+					continue
+				}
+
+				status, err := fileScanner.FileStatus(file.Path)
+				if err != nil || status != file.Status {
+					fmt.Fprintf(out, "Skipping %s (changed since the last run)\n", file.Path)
+					continue
+				}
+				paths = append(paths, file.Path)
+			}
+			sort.Strings(paths)
+
+			if len(paths) == 0 {
+				fmt.Fprintln(out, "No empty files to clean up")
+				return nil
+			}
+
+			if dryRun {
+				for _, path := range paths {
+					fmt.Fprintf(out, "Would move %s\n", path)
+				}
+				fmt.Fprintf(out, "%d files would be moved to %s\n", len(paths), trash.Dir)
+				return nil
+			}
+
+			if err := guardVault(out, cfg, force); err != nil {
+				return err
+			}
+
+			// Move the files as a run so the clean-up can be undone, even if it stopped part way through
+			run := safewrite.Begin(targetFolder, "clean-empty")
+			moved, moveErr := trash.Move(run, targetFolder, paths)
+			if len(moved) > 0 {
+				fmt.Fprintf(out, "Moved %d files to %s (undo with: ratemykb undo %s)\n", len(moved), trash.Dir, run.ID)
+			}
+
+			// Forget the moved files so the report no longer lists them
+			for _, path := range moved {
+				if err := stateManager.RemoveProcessedFile(path); err != nil {
+					fmt.Fprintf(out, "Warning: Could not update report for %s: %v\n", path, err)
+				}
+			}
+
+			return moveErr
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the files that would be moved without moving them")
+	cmd.Flags().BoolVar(&undo, "undo", false, "Restore the files moved by the most recent clean-up")
+	cmd.Flags().BoolVar(&keepFrontmatterOnly, "keep-frontmatter-only", false, "Only move empty files, keeping frontmatter-only files")
+	cmd.Flags().BoolVar(&force, "force", false, "Change the notes even if safety.guard cannot make the vault recoverable first")
+
+	return cmd
+}
+
+// undoCleanEmpty restores the files moved by the most recent clean-up of the target folder
+func undoCleanEmpty(out io.Writer) error {
+	runID, err := safewrite.Latest(targetFolder, "clean-empty")
+	if err != nil {
+		return err
+	}
+
+	return undoRun(out, runID, false)
+}
@@ -1,58 +1,155 @@
 package cli
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"ratemykb/analysis"
+	"ratemykb/attachments"
+	"ratemykb/cache"
 	"ratemykb/classification"
 	"ratemykb/config"
+	"ratemykb/embeddings"
+	"ratemykb/gates"
 	"ratemykb/output"
+	"ratemykb/runs"
+	"ratemykb/safewrite"
 	"ratemykb/scanner"
 	"ratemykb/state"
+	"ratemykb/throttle"
+	"ratemykb/tuning"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 var (
 	// Used for flags
-	configFile   string
-	targetFolder string
-	rootCmd      = &cobra.Command{
+	configFile      string
+	profile         string
+	targetFolder    string
+	budgetTokens    int
+	budgetFiles     int
+	workers         int
+	precheckWorkers int
+	autoWorkers     bool
+	rebuildState    bool
+	partitionFlag   string
+	suggest         bool
+	failIf          []string
+	logFile         string
+	filesFrom       string
+	rootCmd         = &cobra.Command{
 		Use:   "ratemykb",
 		Short: "Rate My Knowledge Base - Evaluate Markdown files quality",
 		Long: `Rate My Knowledge Base is a CLI tool that evaluates the quality of Markdown files
 in an Obsidian vault or any directory containing Markdown files.
 It classifies files as Empty, Low quality/low effort, or Good enough,
 and generates a report in Markdown format.`,
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// If target folder not provided as a flag, check if it's provided as an argument
-			if targetFolder == "" && len(args) > 0 {
-				targetFolder = args[0]
-			}
-
-			// Validate that target folder is provided
-			if targetFolder == "" {
-				return fmt.Errorf("target folder is required")
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			// Mirror the console to the log file, with debug details the console does not show
+			if logFile != "" {
+				if err := openLog(logFile); err != nil {
+					return err
+				}
+				defer func() { closeLog(err) }()
+				debugf("Command line: %s", strings.Join(os.Args, " "))
 			}
 
-			// Check if target folder exists
-			if _, err := os.Stat(targetFolder); os.IsNotExist(err) {
-				return fmt.Errorf("target folder does not exist: %s", targetFolder)
+			// Resolve and validate the target folder
+			if err := resolveTargetFolder(args); err != nil {
+				return err
 			}
 
 			// Load configuration
-			cfg, err := config.LoadConfig(configFile)
+			cfg, err := config.LoadProfile(configFile, profile)
 			if err != nil {
 				return fmt.Errorf("failed to load configuration: %w", err)
 			}
 
+			applyFlags(cfg)
+			if settings, err := json.Marshal(cfg.Settings()); err == nil {
+				debugf("Configuration: %s", settings)
+			}
+
+			// Share the files of a large vault between separate runs
+			var partition scanner.Partition
+			if partitionFlag != "" {
+				if partition, err = scanner.ParsePartition(partitionFlag); err != nil {
+					return err
+				}
+			}
+
+			// A rebuilt state would only hold the listed files
+			if filesFrom != "" && rebuildState {
+				return fmt.Errorf("--files-from cannot be combined with --rebuild-state, which rescans every file")
+			}
+
+			// Check the quality gates before the run rather than after classifying everything
+			qualityGates, err := gates.New(cfg.QualityGates, failIf)
+			if err != nil {
+				return err
+			}
+
+			// Record the run so its results can be traced back to the configuration and model
+			run, err := runs.Start(targetFolder, "scan", cfg)
+			if err != nil {
+				return fmt.Errorf("failed to start run: %w", err)
+			}
+			printf("Run ID: %s\n", run.ID)
+
 			// Print the LLM model and endpoint
-			fmt.Printf("LLM model: %s\n", cfg.AIEngine.Model)
-			fmt.Printf("LLM endpoint: %s\n", cfg.AIEngine.URL)
+			printf("LLM model: %s\n", config.EngineModel(cfg.AIEngine.Provider, cfg.AIEngine.Model, cfg.AIEngine.Deployment))
+			printf("LLM endpoint: %s\n", cfg.AIEngine.URL)
 
 			// Initialize state manager
-			stateManager, err := state.New(targetFolder)
-			if err != nil {
-				return fmt.Errorf("failed to initialize state manager: %w", err)
+			var stateManager *state.ProcessingState
+			if partition.Count > 0 {
+				printf("Classifying partition %s, aggregate the partitions with `ratemykb aggregate` once they are all done\n", partition)
+				if rebuildState {
+					stateManager = state.RebuildPartition(targetFolder, partition)
+				} else if stateManager, err = state.NewPartition(targetFolder, partition); err != nil {
+					return fmt.Errorf("failed to initialize state manager: %w", err)
+				}
+			} else if rebuildState {
+				printf("Rebuilding state by rescanning %s, the existing state and report are replaced\n", targetFolder)
+				// Keep the replaced results, so a file whose label changes on the same content is still flagged
+				previous, previousErr := state.New(targetFolder)
+				stateManager = state.Rebuild(targetFolder)
+				if previousErr == nil {
+					stateManager.Previous = previous.GetProcessedFiles()
+					for path, drift := range previous.Drifts {
+						stateManager.Drifts[path] = drift
+					}
+				}
+			} else {
+				stateManager, err = state.New(targetFolder)
+				if err != nil {
+					return fmt.Errorf("failed to initialize state manager: %w", err)
+				}
+			}
+			for _, warning := range stateManager.ReportWarnings {
+				printf("Warning: %s\n", warning)
+			}
+			if len(stateManager.ReportWarnings) > 0 {
+				printf("The existing report looks hand-edited, run with --rebuild-state to rescan instead of trusting it\n")
+			}
+			if err := configureReport(stateManager, cfg); err != nil {
+				return err
+			}
+			stateManager.RunID = run.ID
+			if processed := len(stateManager.GetProcessedFiles()); processed > 0 {
+				printf("Found existing state with %d processed files\n", processed)
 			}
 
 			// Initialize scanner
@@ -61,13 +158,34 @@ and generates a report in Markdown format.`,
 				return fmt.Errorf("failed to initialize scanner: %w", err)
 			}
 
-			// Scan the target folder
-			fmt.Printf("Scanning %s for Markdown files...\n", targetFolder)
-			files, err := fileScanner.ScanDirectory(targetFolder)
-			if err != nil {
-				return fmt.Errorf("failed to scan directory: %w", err)
+			// Scan the target folder, or only the files listed by other tools
+			var files []scanner.File
+			if filesFrom != "" {
+				paths, err := readFileList(filesFrom)
+				if err != nil {
+					return err
+				}
+				printf("Checking %d listed files...\n", len(paths))
+				if files, err = fileScanner.ScanFiles(targetFolder, paths); err != nil {
+					return fmt.Errorf("failed to scan listed files: %w", err)
+				}
+			} else {
+				printf("Scanning %s for Markdown files...\n", targetFolder)
+				if files, err = fileScanner.ScanDirectory(targetFolder); err != nil {
+					return fmt.Errorf("failed to scan directory: %w", err)
+				}
+			}
+			printf("Found %d Markdown files\n", len(files))
+			for _, file := range files {
+				debugf("Scanned %s: %s (%s)", file.Path, file.Status, file.Encoding)
+			}
+
+			// Match entries rebuilt from report links to the files on disk, so no note is tracked twice
+			if matched, err := stateManager.MatchFiles(files); err != nil {
+				printf("Warning: Could not update state with matched files: %v\n", err)
+			} else if matched > 0 {
+				printf("Matched %d stored entries to files with a different case or extension on disk\n", matched)
 			}
-			fmt.Printf("Found %d Markdown files\n", len(files))
 
 			// Initialize classifier
 			classifier, err := classification.New(cfg)
@@ -75,93 +193,1203 @@ and generates a report in Markdown format.`,
 				return fmt.Errorf("failed to initialize classifier: %w", err)
 			}
 
+			// Stop classifying on Ctrl-C, giving up on the requests in flight, and still save the report
+			interrupted, stopInterrupts := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stopInterrupts()
+			classifier.SetContext(interrupted)
+
+			// Reuse the verdicts for content classified before with the same settings
+			var verdictCache *cache.Cache
+			if cfg.AIEngine.Cache {
+				if verdictCache, err = cache.Load(targetFolder); err != nil {
+					printf("Warning: Could not load the classification cache, classifying without it: %v\n", err)
+				}
+			}
+			settings := cacheSettings(cfg)
+
+			// Initialize throttle to pause classification while the host is busy
+			throttler := throttle.New(cfg)
+
+			// Pause classification while the AI engine is down, checking its health with a tiny request
+			outage := throttle.NewOutage(cfg, classifier.HealthCheck)
+
+			// The vault-wide analyses need every file, so targeted runs keep the results of the last full run
+			if filesFrom != "" {
+				printf("Skipping the vault-wide analyses in a targeted run, the next full run updates them\n")
+			} else {
+				// List what the scan skipped so the exclusions can be audited
+				var exclusions []scanner.Exclusion
+				if cfg.Report.ListExcluded {
+					exclusions = fileScanner.Exclusions()
+				}
+				if err := stateManager.SetExclusions(exclusions); err != nil {
+					printf("Warning: Could not update report with excluded files: %v\n", err)
+				}
+
+				// Report the copies left behind by sync conflicts instead of classifying them
+				syncConflicts := fileScanner.SyncConflicts()
+				if len(syncConflicts) > 0 {
+					printf("Found %d sync conflict files, they are reported but not classified\n", len(syncConflicts))
+				}
+				if err := stateManager.SetSyncConflicts(syncConflicts); err != nil {
+					printf("Warning: Could not update report with sync conflicts: %v\n", err)
+				}
+
+				// Report notes sharing the same title, since Obsidian links to them are ambiguous
+				ambiguousTitles := analysis.FindAmbiguousTitles(files)
+				if len(ambiguousTitles) > 0 {
+					printf("Found %d ambiguous titles\n", len(ambiguousTitles))
+				}
+				if err := stateManager.SetAmbiguousTitles(ambiguousTitles); err != nil {
+					printf("Warning: Could not update report with ambiguous titles: %v\n", err)
+				}
+
+				// Aggregate unchecked tasks, since abandoned task lists are a strong low-quality signal
+				taskNotes := analysis.FindOpenTasks(files)
+				printf("Found %d open tasks in %d notes\n", analysis.TotalOpenTasks(taskNotes), len(taskNotes))
+				if err := stateManager.SetTaskNotes(taskNotes); err != nil {
+					printf("Warning: Could not update report with open tasks: %v\n", err)
+				}
+
+				// Check frontmatter dates against each other and the file modification times
+				metadataIssues := analysis.FindMetadataIssues(files, cfg.Metadata.CreatedField, cfg.Metadata.UpdatedField, time.Now())
+				if len(metadataIssues) > 0 {
+					printf("Found %d notes with metadata issues\n", len(metadataIssues))
+				}
+				if err := stateManager.SetMetadataIssues(metadataIssues); err != nil {
+					printf("Warning: Could not update report with metadata issues: %v\n", err)
+				}
+
+				// Cross-check the navigation of a docs site with its pages, since pages left out of it are never read
+				navFile, navIssues, err := analysis.FindNavigationIssues(targetFolder, files)
+				if err != nil {
+					printf("Warning: Could not check the site navigation: %v\n", err)
+				} else if navFile != "" {
+					printf("Found %d navigation issues in %s\n", len(navIssues), filepath.Base(navFile))
+				}
+				if err := stateManager.SetNavigationIssues(navIssues); err != nil {
+					printf("Warning: Could not update report with navigation issues: %v\n", err)
+				}
+
+				// Flag overly long notes, optionally asking the GenAI engine how to split them
+				longNotes := analysis.FindLongNotes(files, cfg.LongNotes.MaxWords)
+				if partition.Count > 0 {
+					// Every partition finds the same long notes, each one only keeps and suggests splits for its own
+					var own []analysis.LongNote
+					for _, note := range longNotes {
+						if partition.Contains(targetFolder, note.Path) {
+							own = append(own, note)
+						}
+					}
+					longNotes = own
+				}
+				if len(longNotes) > 0 {
+					printf("Found %d notes longer than %d words\n", len(longNotes), cfg.LongNotes.MaxWords)
+				}
+				if cfg.LongNotes.SuggestSplit {
+					for i := range longNotes {
+						content, err := scanner.ReadFileContent(longNotes[i].Path)
+						if err != nil {
+							printf("Warning: Could not read file %s: %v\n", longNotes[i].Path, err)
+							continue
+						}
+
+						if throttler.Wait(interrupted, func(reason string) {
+							printf("Pausing split suggestions: %s\n", reason)
+						}) != nil {
+							break
+						}
+
+						printf("Suggesting split for %s\n", longNotes[i].Path)
+						longNotes[i].Suggestion, err = classifier.SuggestSplit(content)
+						if err != nil {
+							printf("Warning: Could not suggest split for %s: %v\n", longNotes[i].Path, err)
+						}
+					}
+				}
+				if err := stateManager.SetLongNotes(longNotes); err != nil {
+					printf("Warning: Could not update report with long notes: %v\n", err)
+				}
+
+				// Flag notes of unusual length for this vault
+				sizeOutliers := analysis.FindSizeOutliers(files, cfg.Outliers.Percentile)
+				if len(sizeOutliers) > 0 {
+					printf("Found %d notes of unusual length\n", len(sizeOutliers))
+				}
+				if err := stateManager.SetSizeOutliers(sizeOutliers); err != nil {
+					printf("Warning: Could not update report with size outliers: %v\n", err)
+				}
+
+				// Classify the text of linked attachments, since some vaults keep their knowledge in PDFs and documents
+				var found []attachments.Attachment
+				if cfg.Attachments.Extract {
+					found, err = classifyAttachments(interrupted, cfg, classifier, throttler, files, stateManager.Attachments, partition)
+					if err != nil {
+						printf("Warning: Could not classify attachments: %v\n", err)
+					}
+				}
+				if err := stateManager.SetAttachments(found); err != nil {
+					printf("Warning: Could not update report with attachments: %v\n", err)
+				}
+			}
+
+			// The vault-wide analyses above need every file, only the files of the partition are classified
+			if partition.Count > 0 {
+				files = partition.Filter(targetFolder, files)
+				printf("Partition %s holds %d of the files\n", partition, len(files))
+			}
+
 			// Get total number of files to process
 			totalFiles := len(files)
 			totalAlreadyProcessed := 0
-			fmt.Printf("Processing %d files...\n", totalFiles)
+			printf("Processing %d files...\n", totalFiles)
 
 			// Helper function to show progress
 			showProgress := func(i int, action, details string) {
 				filesProcessed := i + 1
 				percentComplete := float64(filesProcessed) / float64(totalFiles) * 100
-				fmt.Printf("[%d/%d - %.1f%%] %s %s\n", filesProcessed, totalFiles, percentComplete, action, details)
+				printf("[%d/%d - %.1f%%] %s %s\n", filesProcessed, totalFiles, percentComplete, action, details)
+			}
+
+			// The state is read and updated one file at a time, since files are classified concurrently
+			var stateMu sync.Mutex
+
+			// Check whether a file is on the skip list
+			isSkipped := func(path string) bool {
+				stateMu.Lock()
+				defer stateMu.Unlock()
+
+				return stateManager.IsSkipped(path)
+			}
+
+			// Check whether a file has a result from a previous run, files that timed out are tried again
+			isProcessed := func(path string) bool {
+				stateMu.Lock()
+				// Unreadable files are checked again on every run, in case they have been fixed
+				result, ok := stateManager.GetProcessedFiles()[path]
+				stateMu.Unlock()
+				if !ok || result.Classification == classification.TimedOut || result.Status == scanner.StatusUnreadable {
+					return false
+				}
+
+				// Listed files are picked for having changed, so they are classified again once their content differs
+				return filesFrom == "" || !contentChanged(path, result.ContentHash, cfg.ScanSettings.MaxReadBytes)
+			}
+
+			// Load the model before the first file, so the load time does not count towards that file
+			if cfg.AIEngine.WarmUp && needsClassification(files, isProcessed, isSkipped) {
+				printf("Warming up %s...\n", config.EngineModel(cfg.AIEngine.Provider, cfg.AIEngine.Model, cfg.AIEngine.Deployment))
+				start := time.Now()
+				if err := classifier.WarmUp(); err != nil {
+					printf("Warning: %v\n", err)
+				} else {
+					printf("Model ready after %s\n", time.Since(start).Round(time.Millisecond))
+				}
+			}
+
+			// Let the tuner pick the number of concurrent classifications if enabled
+			var tuner *tuning.Tuner
+			if cfg.Concurrency.AutoTune {
+				tuner = tuning.New(cfg.Concurrency.ClassificationWorkers, max(cfg.Concurrency.MaxWorkers, cfg.Concurrency.ClassificationWorkers))
+				tuner.OnChange = func(limit int, reason string) {
+					printf("Adjusting classification workers to %d (%s)\n", limit, reason)
+				}
+			}
+
+			// Record the result of a file in the state and report
+			recordResult := func(result output.ResultFile) {
+				if len(cfg.Report.MetadataFields) > 0 && result.Status != scanner.StatusNotMarkdown && result.Status != scanner.StatusUnreadable {
+					result.Metadata = frontmatterMetadata(result.Path, cfg.Report.MetadataFields)
+				}
+
+				stateMu.Lock()
+				defer stateMu.Unlock()
+
+				// Add processed file to state and update report
+				if err := stateManager.AddProcessedFile(result); err != nil {
+					printf("Warning: Could not update report for %s: %v\n", result.Path, err)
+				}
+			}
+
+			// Count a failed classification towards the skip list
+			recordFailure := func(path string, err error) {
+				stateMu.Lock()
+				defer stateMu.Unlock()
+
+				added, saveErr := stateManager.RecordFailure(path, err.Error(), cfg.ScanSettings.SkipAfterFailures)
+				if saveErr != nil {
+					printf("Warning: Could not update report for %s: %v\n", path, saveErr)
+				}
+				if added {
+					printf("Warning: %s failed %d runs in a row and is skipped from now on\n", path, cfg.ScanSettings.SkipAfterFailures)
+				}
+			}
+
+			// Offer the most used tags of the vault when suggesting tags, so suggestions reuse them
+			var knownTags []string
+			if cfg.Tags.Suggest && cfg.Tags.Known > 0 {
+				for _, count := range analysis.CountTags(files) {
+					if len(knownTags) == cfg.Tags.Known {
+						break
+					}
+					knownTags = append(knownTags, count.Tag)
+				}
+			}
+
+			// Look for reference notes without sources, when enabled
+			sources, err := analysis.NewSourceCheck(cfg.Sources)
+			if err != nil {
+				return fmt.Errorf("failed to set up the source check: %w", err)
+			}
+
+			// Classify a single file that needs review
+			resolver := scanner.NewLinkResolver(targetFolder)
+			classifyFile := func(i int, file scanner.File) {
+				// Read the content of the file, up to the configured limit
+				content, truncated, err := scanner.ReadFileContentLimit(file.Path, cfg.ScanSettings.MaxReadBytes)
+				if err != nil {
+					printf("Warning: Could not read file %s: %v\n", file.Path, err)
+					recordFailure(file.Path, err)
+					return
+				}
+				if truncated {
+					printf("Warning: Only classifying the first %d bytes of %s\n", cfg.ScanSettings.MaxReadBytes, file.Path)
+				}
+				// Hash what was read, so a label changing on the same content can be told apart from an edit
+				contentHash := fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+
+				relPath, err := filepath.Rel(targetFolder, file.Path)
+				if err != nil {
+					relPath = file.Path
+				}
+				relPath = filepath.ToSlash(relPath)
+
+				// Hold the files of a folder or with a tag to their own quality bar, from the tags of the whole note
+				prompt := cfg.PromptConfig.QualityClassificationPrompt
+				if len(cfg.Prompts) > 0 {
+					prompt = classifier.PromptFor(relPath, analysis.NoteTags(content))
+				}
+
+				// Fill the prompt template with the content, path and frontmatter of the note
+				fill := classifier.ContentPrompt(prompt)
+				templated := prompt == cfg.PromptConfig.QualityClassificationPrompt && cfg.PromptConfig.HasTemplate()
+				if templated {
+					frontmatter, _ := scanner.SplitFrontmatter(content)
+					fill = classifier.TemplatePrompt(relPath, frontmatter)
+				}
+
+				// Look for sources in the whole note, since condensing may drop the references at its end
+				uncited := cfg.Sources.Check && sources.Applies(relPath, content) && !sources.Cited(content)
+
+				// Keep long notes within the context window of the model
+				content, condensed := classification.Condense(content, cfg.ScanSettings.MaxTokens)
+				if condensed {
+					printf("Warning: Condensing %s to about %d tokens, keeping the headings and the start of each section\n", file.Path, cfg.ScanSettings.MaxTokens)
+					truncated = true
+				}
+
+				images := loadImages(cfg, resolver, file.Path, content)
+				var verdict classification.Verdict
+
+				// Notes classified along with their images are not cached, since the images may change
+				var key string
+				cached := false
+				if verdictCache != nil && len(images) == 0 {
+					// Files classified with an override or templated prompt are cached apart from the others
+					fileSettings := settings
+					if prompt != cfg.PromptConfig.QualityClassificationPrompt {
+						fileSettings += prompt
+					}
+					if templated {
+						fileSettings += relPath
+					}
+					key = cache.Key(content, fileSettings)
+					verdict, cached = verdictCache.Get(key)
+				}
+				for !cached {
+					// Wait for the host to be idle enough and for the AI engine to recover from an outage
+					if throttler.Wait(interrupted, func(reason string) {
+						printf("Pausing classification: %s\n", reason)
+					}) != nil {
+						return
+					}
+					if outage.Wait(interrupted, func(delay time.Duration, err error) {
+						if err != nil {
+							printf("AI engine still unavailable (%v), checking again in %s\n", err, delay)
+						} else {
+							printf("Pausing classification, checking the AI engine again in %s\n", delay)
+						}
+					}) != nil {
+						return
+					}
+
+					// Classify the content
+					if tuner != nil {
+						tuner.Acquire()
+					}
+					showProgress(i, "Classifying", file.Path)
+					start := time.Now()
+					if len(images) > 0 {
+						// Notes that are mostly images are classified along with their images by the vision model
+						printf("Classifying %s with %d images using %s\n", file.Path, len(images), cfg.AIEngine.VisionModel)
+						verdict, err = classifier.ClassifyWithImages(content, fill, images)
+					} else {
+						verdict, err = classifier.ClassifyWithPromptFunc(content, fill)
+					}
+					if tuner != nil {
+						tuner.Release(time.Since(start), err)
+					}
+					debugf("Classification of %s took %s (error: %v, %d tokens used so far)", file.Path, time.Since(start).Round(time.Millisecond), err, classifier.TokensUsed())
+
+					// Leave a file interrupted mid-request for the next run, without counting it as a failure
+					if errors.Is(err, context.Canceled) {
+						return
+					}
+
+					if !errors.Is(err, classification.ErrModelUnavailable) {
+						outage.Success()
+						break
+					}
+
+					// Once the engine keeps failing, classify the file again after it recovers instead of moving on
+					if !outage.Failure() {
+						break
+					}
+					printf("Warning: AI engine at %s keeps failing, pausing until it is back: %v\n", cfg.AIEngine.URL, err)
+				}
+				if errors.Is(err, classification.ErrTimedOut) {
+					// Report the file and move on, it is classified again on the next run
+					printf("Warning: Skipping file %s, it will be retried on the next run: %v\n", file.Path, err)
+					recordResult(output.ResultFile{
+						Path:           file.Path,
+						Status:         file.Status,
+						Classification: classification.TimedOut,
+						Truncated:      truncated,
+						Encoding:       file.Encoding,
+					})
+					recordFailure(file.Path, err)
+					return
+				}
+				if errors.Is(err, classification.ErrModelUnavailable) {
+					printf("Warning: Could not classify file %s, check that the AI engine is running at %s: %v\n", file.Path, cfg.AIEngine.URL, err)
+					return
+				}
+				if err != nil {
+					printf("Warning: Could not classify file %s: %v\n", file.Path, err)
+					recordFailure(file.Path, err)
+					return
+				}
+
+				result := output.ResultFile{
+					Path:           file.Path,
+					Status:         file.Status,
+					Classification: verdict.Classification,
+					Truncated:      truncated,
+					Encoding:       file.Encoding,
+					Reason:         verdict.Reason,
+					Scores:         verdict.Scores,
+					Score:          verdict.Score,
+					Rating:         verdict.Rating,
+					Votes:          verdict.Votes,
+					ContentHash:    contentHash,
+				}
+
+				// Verdicts of fallback engines are left out, since the cache key holds the settings of the primary engine
+				if key != "" && !cached && (verdict.Provider == "" || verdict.Provider == cfg.AIEngine.Provider) {
+					verdictCache.Put(key, verdict)
+				}
+
+				// Record which engine classified the file once there is more than one, noting when a fallback did
+				var by string
+				if len(cfg.AIEngine.Fallbacks) > 0 {
+					result.Provider = verdict.Provider
+					if verdict.Provider != "" && verdict.Provider != cfg.AIEngine.Provider {
+						by = fmt.Sprintf(" (by fallback engine %s)", verdict.Provider)
+					}
+				}
+				if cached {
+					by += " (cached)"
+				}
+
+				// Print the classification result, with its confidence when sampling or when the model was not certain
+				switch {
+				case cfg.AIEngine.Samples > 1:
+					result.Confidence = verdict.Confidence
+					printf("Classification result for %s: %s (confidence %.0f%% over %d samples)%s\n", file.Path, verdict.Classification, verdict.Confidence*100, cfg.AIEngine.Samples, by)
+				case verdict.Confidence < 1:
+					result.Confidence = verdict.Confidence
+					printf("Classification result for %s: %s (confidence %.0f%%)%s\n", file.Path, verdict.Classification, verdict.Confidence*100, by)
+				default:
+					printf("Classification result for %s: %s%s\n", file.Path, verdict.Classification, by)
+				}
+				if classification.Disagree(verdict.Votes) {
+					var votes []string
+					for _, vote := range verdict.Votes {
+						votes = append(votes, fmt.Sprintf("%s by %s", vote.Classification, vote.Engine))
+					}
+					printf("  Engines disagreed: %s\n", strings.Join(votes, ", "))
+				}
+				if verdict.Reason != "" {
+					printf("  Reason: %s\n", verdict.Reason)
+				}
+				if len(verdict.Scores) > 0 {
+					var scores []string
+					for _, dimension := range cfg.Scoring.Dimensions {
+						if score, ok := verdict.Scores[dimension.Name]; ok {
+							scores = append(scores, fmt.Sprintf("%s %d", dimension.Name, score))
+						}
+					}
+					printf("  Scores: %s (overall %.1f)\n", strings.Join(scores, ", "), verdict.Score)
+				}
+				if verdict.Rating != nil {
+					printf("  Rating: %d/100\n", *verdict.Rating)
+				}
+
+				// Ask how to improve the files that fall short, in suggest mode
+				if cfg.Suggestions.Enabled && fallsShort(cfg, result) {
+					result.Suggestions, err = classifier.SuggestImprovements(content)
+					if errors.Is(err, context.Canceled) {
+						return
+					}
+					if err != nil {
+						printf("Warning: Could not suggest improvements for %s: %v\n", file.Path, err)
+					}
+					for _, suggestion := range result.Suggestions {
+						printf("  Suggestion: %s\n", suggestion)
+					}
+				}
+
+				// Suggest tags for the notes without any, when enabled
+				if cfg.Tags.Suggest && len(analysis.NoteTags(content)) == 0 {
+					result.SuggestedTags, err = classifier.SuggestTags(content, knownTags)
+					if errors.Is(err, context.Canceled) {
+						return
+					}
+					if err != nil {
+						printf("Warning: Could not suggest tags for %s: %v\n", file.Path, err)
+					}
+					if len(result.SuggestedTags) > 0 {
+						printf("  Suggested tags: %s\n", strings.Join(result.SuggestedTags, ", "))
+					}
+				}
+
+				// Check that the note is about what its title says, when enabled
+				if cfg.TitleCheck.Enabled && result.Classification != classification.TimedOut {
+					result.TitleMismatch, err = classifier.CheckTitle(analysis.Title(file.Path, content), content)
+					if errors.Is(err, context.Canceled) {
+						return
+					}
+					if err != nil {
+						printf("Warning: Could not check the title of %s: %v\n", file.Path, err)
+					}
+					if result.TitleMismatch != "" {
+						printf("  Title mismatch: %s\n", result.TitleMismatch)
+					}
+				}
+
+				// Flag the reference notes without sources, asking the model whether they make claims when enabled
+				if uncited && result.Classification != classification.TimedOut {
+					result.MissingSources = true
+					if cfg.Sources.AskModel {
+						needsSources, err := classifier.NeedsSources(content)
+						if errors.Is(err, context.Canceled) {
+							return
+						}
+						if err != nil {
+							// Without an answer, the note stays flagged for having no sources
+							printf("Warning: Could not check the claims of %s: %v\n", file.Path, err)
+						} else {
+							result.MissingSources = needsSources
+						}
+					}
+					if result.MissingSources {
+						printf("  Missing sources\n")
+					}
+				}
+
+				// Summarize the note for the report, when enabled
+				if cfg.Summaries.Enabled && result.Classification != classification.TimedOut {
+					result.Summary, err = classifier.Summarize(content)
+					if errors.Is(err, context.Canceled) {
+						return
+					}
+					if err != nil {
+						printf("Warning: Could not summarize %s: %v\n", file.Path, err)
+					}
+					if result.Summary != "" {
+						printf("  Summary: %s\n", result.Summary)
+					}
+				}
+
+				recordResult(result)
 			}
 
+			// Start the classification workers, leaving the number of active ones to the tuner when auto-tuning
+			poolSize := cfg.Concurrency.ClassificationWorkers
+			if tuner != nil {
+				poolSize = max(cfg.Concurrency.MaxWorkers, poolSize)
+			}
+			jobs := make(chan int)
+			var wg sync.WaitGroup
+			for w := 0; w < poolSize; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := range jobs {
+						classifyFile(i, files[i])
+					}
+				}()
+			}
+
+			// Track the number of files sent to the classifier for the file budget
+			filesClassified := 0
+
 			// Process each file
 			for i, file := range files {
 				// Check if file has already been processed
-				if stateManager.IsFileProcessed(file.Path) {
+				if isProcessed(file.Path) {
 					totalAlreadyProcessed++
 					showProgress(i, "Skipping (already processed)", file.Path)
 					continue
 				}
 
+				// Leave out files that failed too many runs in a row
+				if isSkipped(file.Path) {
+					showProgress(i, "Skipping (on the skip list)", file.Path)
+					continue
+				}
+
 				// Create a result file with default classification
 				result := output.ResultFile{
 					Path:           file.Path,
 					Status:         file.Status,
-					Classification: classification.Classification("Unknown"),
+					Classification: classification.Unknown,
+					Encoding:       file.Encoding,
 				}
 
 				// Classify files that need review
 				if file.Status == scanner.StatusNeedsReview {
-					// Read the content of the file
-					content, err := scanner.ReadFileContent(file.Path)
-					if err != nil {
-						fmt.Printf("Warning: Could not read file %s: %v\n", file.Path, err)
-						continue
+					// Stop cleanly once interrupted or a budget is exhausted, the next run continues from the report
+					if interrupted.Err() != nil {
+						printf("Stopping: interrupted. Run again to continue with the remaining files\n")
+						break
 					}
-
-					// Classify the content
-					showProgress(i, "Classifying", file.Path)
-					result.Classification, err = classifier.ClassifyContent(content)
-
-					if err != nil {
-						fmt.Printf("Warning: Could not classify file %s: %v\n", file.Path, err)
-						continue
+					if reason := budgetExhausted(filesClassified, classifier.TokensUsed()); reason != "" {
+						printf("Stopping: %s. Run again to continue with the remaining files\n", reason)
+						break
 					}
 
-					// Print the classification result
-					fmt.Printf("Classification result: %s\n", result.Classification)
+					// Count the file when it is handed to the next free worker, so the budget never overshoots
+					filesClassified++
+					jobs <- i
+					continue
 
 				} else if file.Status == scanner.StatusEmpty {
 					// Map scanner status to classification
-					result.Classification = classification.Classification("Empty")
+					result.Classification = classification.Empty
 					showProgress(i, "Skipping classification for", file.Path+" (Empty)")
 				} else if file.Status == scanner.StatusFrontmatterOnly {
 					// Frontmatter-only files are considered low quality
-					result.Classification = classification.Classification("Low quality")
+					result.Classification = classification.LowQuality
 					showProgress(i, "Skipping classification for", file.Path+" (Frontmatter-only)")
+				} else if file.Status == scanner.StatusNotMarkdown {
+					// Binary files are reported without asking the AI about them
+					result.Classification = classification.NotMarkdown
+					showProgress(i, "Skipping classification for", file.Path+" (Not markdown)")
+				} else if file.Status == scanner.StatusPlaceholder {
+					// Stubs with only comments or placeholder text are reported without asking the AI about them
+					result.Classification = classification.Placeholder
+					showProgress(i, "Skipping classification for", file.Path+" (Placeholder)")
+				} else if file.Status == scanner.StatusUnreadable {
+					// Files that cannot be read are listed under their own label with the error as the reason
+					result.Classification = classification.Classification(cfg.ScanSettings.UnreadableLabel)
+					result.Reason = file.Error
+					showProgress(i, "Skipping classification for", file.Path+" (Unreadable)")
 				} else if file.Status == scanner.StatusExcluded {
 					// Show progress for excluded files
 					showProgress(i, "Skipping", file.Path+" (Excluded)")
 					continue // Don't add excluded files to the report
 				}
 
-				// Add processed file to state and update report
-				if err := stateManager.AddProcessedFile(result); err != nil {
-					fmt.Printf("Warning: Could not update report for %s: %v\n", file.Path, err)
+				recordResult(result)
+			}
+
+			// Wait for the files still being classified, after which a second Ctrl-C exits at once
+			close(jobs)
+			wg.Wait()
+			stopInterrupts()
+			if err := stateManager.Flush(); err != nil {
+				printf("Warning: Could not save the state: %v\n", err)
+			}
+			if verdictCache != nil {
+				if err := verdictCache.Save(); err != nil {
+					printf("Warning: Could not save the classification cache: %v\n", err)
+				}
+			}
+
+			newlyProcessed := len(stateManager.GetProcessedFiles()) - totalAlreadyProcessed
+			var summary strings.Builder
+			printSummary(&summary, false, stateManager.GetProcessedFiles(), newlyProcessed, totalAlreadyProcessed, cfg.Report.PassingLabels)
+			logLine("INFO", summary.String())
+			fmt.Println()
+			printSummary(os.Stdout, useColor(os.Stdout), stateManager.GetProcessedFiles(), newlyProcessed, totalAlreadyProcessed, cfg.Report.PassingLabels)
+			fmt.Println()
+
+			counts := map[string]int{
+				"files_found":       len(files),
+				"files_classified":  filesClassified,
+				"newly_processed":   newlyProcessed,
+				"already_processed": totalAlreadyProcessed,
+				"total_processed":   len(stateManager.GetProcessedFiles()),
+			}
+			labels := make(map[string]int)
+			for _, file := range stateManager.GetProcessedFiles() {
+				labels[string(file.Classification)]++
+			}
+			if err := run.Finish(counts, labels, classifier.TokensUsed()); err != nil {
+				printf("Warning: Could not record run %s: %v\n", run.ID, err)
+			}
+
+			// Summarize each top-level folder inside the folder itself
+			if cfg.Report.FolderRollups && partition.Count == 0 {
+				written, err := stateManager.WriteFolderRollups(safewrite.BeginWithID(targetFolder, "scan", run.ID))
+				if err != nil {
+					printf("Warning: Could not write folder rollups: %v\n", err)
 				}
+				printf("Wrote %d folder rollups (undo with: ratemykb undo %s)\n", len(written), run.ID)
 			}
 
-			fmt.Printf("Processing complete: %d new files processed, %d already processed, %d total\n",
-				len(stateManager.GetProcessedFiles())-totalAlreadyProcessed,
-				totalAlreadyProcessed,
-				len(stateManager.GetProcessedFiles()))
+			// Export note embeddings for reuse in external tooling, report the notes they show to be near duplicates
+			// and group the low quality notes by topic, which needs every note so partition runs leave it out
+			if cfg.Embeddings.ExportPath != "" || cfg.Embeddings.DuplicateThreshold > 0 || cfg.Embeddings.TopicThreshold > 0 {
+				if partition.Count > 0 {
+					printf("Skipping embeddings in a partition run, the next regular run computes them for the whole vault\n")
+				} else if filesFrom != "" {
+					printf("Skipping embeddings in a targeted run, the next full run computes them for the whole vault\n")
+				} else if err := analyzeEmbeddings(interrupted, cfg, files, stateManager); err != nil {
+					printf("Warning: Could not analyze embeddings: %v\n", err)
+				}
+			} else {
+				if err := stateManager.SetDuplicates(nil); err != nil {
+					printf("Warning: Could not update report with possible duplicates: %v\n", err)
+				}
+				if err := stateManager.SetTopics(nil); err != nil {
+					printf("Warning: Could not update report with topics: %v\n", err)
+				}
+			}
 
 			// No need to generate a final report as it's been updated incrementally
-			fmt.Printf("Report available at %s/vault-quality-report.md\n", targetFolder)
+			printf("Report available at %s (run %s)\n", stateManager.ReportPath, run.ID)
+
+			// Fail the run for CI when the results do not pass the quality gates
+			var gateResults []gates.Result
+			var failed int
+			if !qualityGates.Empty() {
+				gateResults = qualityGates.Check(targetFolder, stateManager.GetProcessedFiles())
+				for _, result := range gateResults {
+					if result.Failed {
+						printf("Quality gate failed: %s\n", result.Failure())
+						failed++
+					}
+				}
+			}
+
+			// Leave a small artifact for CI jobs to parse instead of the console output
+			summaryPath := filepath.Join(filepath.Dir(stateManager.ReportPath), SummaryFile)
+			if err := writeRunSummary(summaryPath, run, stateManager, gateResults); err != nil {
+				printf("Warning: Could not write run summary: %v\n", err)
+			}
+
+			if failed > 0 {
+				return fmt.Errorf("%w: %d failed", gates.ErrFailed, failed)
+			}
+			if len(gateResults) > 0 {
+				printf("All quality gates passed\n")
+			}
 			return nil
 		},
 	}
 )
 
+// applyFlags overrides the configured worker counts with the ones given on the command line
+func applyFlags(cfg *config.Config) {
+	if workers > 0 {
+		cfg.Concurrency.ClassificationWorkers = workers
+	}
+	if precheckWorkers > 0 {
+		cfg.Concurrency.PrecheckWorkers = precheckWorkers
+	}
+	if autoWorkers {
+		cfg.Concurrency.AutoTune = true
+	}
+	if suggest {
+		cfg.Suggestions.Enabled = true
+	}
+	cfg.Concurrency.ClassificationWorkers = max(cfg.Concurrency.ClassificationWorkers, 1)
+}
+
+// fallsShort reports whether a classified file needs improving: its label is not a passing one, or it is rated below
+// the configured rating
+func fallsShort(cfg *config.Config, result output.ResultFile) bool {
+	if result.Classification == classification.TimedOut {
+		return false
+	}
+	if result.Rating != nil && *result.Rating < cfg.Suggestions.BelowRating {
+		return true
+	}
+	for _, label := range cfg.Report.PassingLabels {
+		if strings.EqualFold(label, string(result.Classification)) {
+			return false
+		}
+	}
+	return true
+}
+
+// needsClassification reports whether any file still has to be sent to the GenAI engine, with the checks the run
+// applies before classifying a file
+func needsClassification(files []scanner.File, isProcessed, isSkipped func(path string) bool) bool {
+	for _, file := range files {
+		if file.Status == scanner.StatusNeedsReview && !isProcessed(file.Path) && !isSkipped(file.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadImages returns the images embedded in a note that is mostly images, up to the configured maximum, so it can be
+// classified by the vision model
+// It returns nothing if no vision model is configured or the note has enough text to be classified on its own.
+func loadImages(cfg *config.Config, resolver *scanner.LinkResolver, notePath, content string) []classification.Image {
+	if cfg.AIEngine.VisionModel == "" || !scanner.IsImageHeavy(content) {
+		return nil
+	}
+
+	var images []classification.Image
+	for _, ref := range scanner.EmbeddedImages(content) {
+		if len(images) >= cfg.AIEngine.MaxImages {
+			break
+		}
+
+		path, ok := resolver.Resolve(notePath, ref)
+		if !ok {
+			printf("Warning: Could not find image %s embedded in %s\n", ref, notePath)
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			printf("Warning: Could not read image %s: %v\n", path, err)
+			continue
+		}
+		images = append(images, classification.Image{MIMEType: http.DetectContentType(data), Data: data})
+	}
+	return images
+}
+
+// classifyAttachments finds the attachments linked from the notes and classifies their extracted text
+// Attachments that have not changed since the previous run, and were classified with the same settings, keep their
+// classification. Once ctx is done, the
+// attachments left are listed without a classification.
+func classifyAttachments(ctx context.Context, cfg *config.Config, classifier *classification.Classifier, throttler *throttle.Throttle, files []scanner.File, previous []attachments.Attachment, partition scanner.Partition) ([]attachments.Attachment, error) {
+	found, err := attachments.Find(targetFolder, files, cfg.Attachments.Extensions)
+	if err != nil {
+		return nil, err
+	}
+	if partition.Count > 0 {
+		// Attachments are linked from notes of any partition, each partition only classifies its own
+		var own []attachments.Attachment
+		for _, attachment := range found {
+			if partition.Contains(targetFolder, attachment.Path) {
+				own = append(own, attachment)
+			}
+		}
+		found = own
+	}
+	printf("Found %d linked attachments\n", len(found))
+
+	known := make(map[string]attachments.Attachment)
+	for _, attachment := range previous {
+		known[attachment.Path] = attachment
+	}
+
+	// Classifications are only reused if they were made with the same settings, like cached verdicts
+	settings := fmt.Sprintf("%x", sha256.Sum256([]byte(cacheSettings(cfg))))
+	for i := range found {
+		attachment := &found[i]
+		attachment.Settings = settings
+		if before, ok := known[attachment.Path]; ok && before.Modified.Equal(attachment.Modified) && before.Settings == settings && before.Problem == "" && before.Classification != "" {
+			attachment.Words = before.Words
+			attachment.Classification = before.Classification
+			continue
+		}
+
+		text, err := attachments.ExtractText(attachment.Path, cfg.ScanSettings.MaxReadBytes)
+		if err != nil {
+			printf("Warning: Could not extract text from %s: %v\n", attachment.Path, err)
+			attachment.Problem = "text could not be extracted"
+			continue
+		}
+		attachment.Words = len(strings.Fields(text))
+		if attachment.Words == 0 {
+			attachment.Problem = "no extractable text"
+			continue
+		}
+
+		if throttler.Wait(ctx, func(reason string) {
+			printf("Pausing attachment classification: %s\n", reason)
+		}) != nil {
+			break
+		}
+
+		printf("Classifying attachment %s\n", attachment.Path)
+		attachment.Classification, err = classifier.ClassifyContent(text)
+		if err != nil {
+			printf("Warning: Could not classify attachment %s: %v\n", attachment.Path, err)
+			attachment.Problem = "could not be classified"
+		}
+	}
+	return found, nil
+}
+
+// resolveTargetFolder takes the target folder from the flag or the first argument and checks that it exists
+func resolveTargetFolder(args []string) error {
+	// If target folder not provided as a flag, check if it's provided as an argument
+	if targetFolder == "" && len(args) > 0 {
+		targetFolder = args[0]
+	}
+
+	// Validate that target folder is provided
+	if targetFolder == "" {
+		return fmt.Errorf("target folder is required")
+	}
+
+	// Check if target folder exists
+	if _, err := os.Stat(targetFolder); os.IsNotExist(err) {
+		return fmt.Errorf("target folder does not exist: %s", targetFolder)
+	}
+
+	return nil
+}
+
+// contentChanged reports whether the content of a file differs from the hash recorded when it was classified
+// Files without a recorded hash, such as files classified by older versions, count as changed.
+func contentChanged(path, hash string, limit int64) bool {
+	content, _, err := scanner.ReadFileContentLimit(path, limit)
+	return err != nil || hash == "" || fmt.Sprintf("%x", sha256.Sum256([]byte(content))) != hash
+}
+
+// readFileList reads the paths of the files to check from a file, or from standard input for -
+func readFileList(path string) ([]string, error) {
+	if path == "-" {
+		return scanner.ReadFileList(os.Stdin)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file list: %w", err)
+	}
+	defer file.Close()
+	return scanner.ReadFileList(file)
+}
+
+// frontmatterMetadata returns the listed frontmatter fields of a file as text, nil if it has none of them
+// Lists are joined with commas and dates without a time are written as dates, so the values read the same in every
+// report format.
+func frontmatterMetadata(path string, names []string) map[string]string {
+	content, err := scanner.ReadFileContent(path)
+	if err != nil {
+		return nil
+	}
+	fields, err := scanner.ParseFrontmatter(content)
+	if err != nil {
+		return nil
+	}
+
+	var metadata map[string]string
+	for _, name := range names {
+		value, ok := fields[name]
+		if !ok || value == nil {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[name] = metadataValue(value)
+	}
+	return metadata
+}
+
+// metadataValue formats a frontmatter value as text
+func metadataValue(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case []any:
+		items := make([]string, len(value))
+		for i, item := range value {
+			items[i] = metadataValue(item)
+		}
+		return strings.Join(items, ", ")
+	case time.Time:
+		if value.Equal(value.Truncate(24 * time.Hour)) {
+			return value.Format(time.DateOnly)
+		}
+		return value.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// configureReport applies the report settings to the state: how dates and times are shown, which labels are
+// findings, and the vault used for obsidian:// URIs if they are enabled
+func configureReport(stateManager *state.ProcessingState, cfg *config.Config) error {
+	stateManager.Timestamp = cfg.Report.Timestamp
+	stateManager.TimeFormat = cfg.Report.TimeFormat
+	stateManager.PassingLabels = cfg.Report.PassingLabels
+	stateManager.ReviewThreshold = cfg.Report.ReviewThreshold
+	stateManager.PageSize = cfg.Report.PageSize
+	stateManager.Ratings = cfg.Scoring.Rating
+	stateManager.ScoreDimensions = nil
+	for _, dimension := range cfg.Scoring.Dimensions {
+		stateManager.ScoreDimensions = append(stateManager.ScoreDimensions, dimension.Name)
+	}
+	switch cfg.Report.LinkStyle {
+	case "auto", "":
+		stateManager.LinkStyle = detectLinkStyle(targetFolder)
+	case state.LinkStyleWiki, state.LinkStyleMarkdown:
+		stateManager.LinkStyle = cfg.Report.LinkStyle
+	default:
+		return fmt.Errorf("invalid report link style %q, expected auto, %s or %s", cfg.Report.LinkStyle, state.LinkStyleWiki, state.LinkStyleMarkdown)
+	}
+	if cfg.Report.Timezone != "" {
+		location, err := time.LoadLocation(cfg.Report.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid report timezone %q: %w", cfg.Report.Timezone, err)
+		}
+		stateManager.Location = location
+	}
+
+	if !cfg.Report.ObsidianURIs {
+		return nil
+	}
+
+	name, folder := detectVault(targetFolder)
+	if cfg.Report.VaultName != "" {
+		name = cfg.Report.VaultName
+	}
+
+	stateManager.VaultName = name
+	stateManager.VaultFolder = folder
+	return nil
+}
+
+// detectVault finds the Obsidian vault containing a folder by looking for the .obsidian folder from the folder upwards
+// It returns the vault name and the slash-separated path of the folder inside the vault. Without a .obsidian folder,
+// the folder itself is assumed to be the vault, as Obsidian names a vault after its folder.
+func detectVault(folder string) (string, string) {
+	absPath, err := filepath.Abs(folder)
+	if err != nil {
+		return filepath.Base(folder), ""
+	}
+
+	if root, ok := vaultRoot(absPath); ok {
+		relPath, err := filepath.Rel(root, absPath)
+		if err != nil || relPath == "." {
+			relPath = ""
+		}
+		return filepath.Base(root), filepath.ToSlash(relPath)
+	}
+	return filepath.Base(absPath), ""
+}
+
+// vaultRoot finds the folder holding the .obsidian folder of the vault containing an absolute path
+func vaultRoot(absPath string) (string, bool) {
+	for dir := absPath; ; dir = filepath.Dir(dir) {
+		if info, err := os.Stat(filepath.Join(dir, ".obsidian")); err == nil && info.IsDir() {
+			return dir, true
+		}
+
+		if filepath.Dir(dir) == dir {
+			return "", false
+		}
+	}
+}
+
+// detectLinkStyle reads the link style of the vault containing a folder from the "Use [[Wikilinks]]" option of
+// Obsidian, stored as useMarkdownLinks in .obsidian/app.json, with wikilinks as the default of Obsidian
+func detectLinkStyle(folder string) string {
+	absPath, err := filepath.Abs(folder)
+	if err != nil {
+		return state.LinkStyleWiki
+	}
+	root, ok := vaultRoot(absPath)
+	if !ok {
+		return state.LinkStyleWiki
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, ".obsidian", "app.json"))
+	if err != nil {
+		return state.LinkStyleWiki
+	}
+	var settings struct {
+		UseMarkdownLinks bool `json:"useMarkdownLinks"`
+	}
+	if err := json.Unmarshal(data, &settings); err != nil || !settings.UseMarkdownLinks {
+		return state.LinkStyleWiki
+	}
+	return state.LinkStyleMarkdown
+}
+
+// analyzeEmbeddings computes an embedding for every file that needs review, writes them to the configured path if
+// any, and records the groups of notes similar enough to be duplicates and the topics of the low quality notes if
+// their thresholds are set
+// A relative export path is resolved against the target folder.
+func analyzeEmbeddings(ctx context.Context, cfg *config.Config, files []scanner.File, stateManager *state.ProcessingState) error {
+	embedder, err := embeddings.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	printf("Computing embeddings with %s...\n", cfg.Embeddings.Model)
+	index, err := embeddings.Build(ctx, embedder, targetFolder, files)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Embeddings.ExportPath != "" {
+		exportPath := cfg.Embeddings.ExportPath
+		if !filepath.IsAbs(exportPath) {
+			exportPath = filepath.Join(targetFolder, exportPath)
+		}
+		if err := index.WriteJSON(exportPath); err != nil {
+			return err
+		}
+		printf("Exported %d embeddings to %s\n", len(index), exportPath)
+	}
+
+	var groups []analysis.DuplicateGroup
+	if cfg.Embeddings.DuplicateThreshold > 0 {
+		groups = index.Duplicates(cfg.Embeddings.DuplicateThreshold)
+		for i := range groups {
+			for j, path := range groups[i].Paths {
+				groups[i].Paths[j] = filepath.Join(targetFolder, filepath.FromSlash(path))
+			}
+		}
+		printf("Found %d groups of possible duplicates\n", len(groups))
+	}
+	if err := stateManager.SetDuplicates(groups); err != nil {
+		return fmt.Errorf("failed to update report with possible duplicates: %w", err)
+	}
+
+	var topics []analysis.TopicCluster
+	if cfg.Embeddings.TopicThreshold > 0 {
+		var lowQuality []string
+		for path, result := range stateManager.GetProcessedFiles() {
+			if result.Classification != classification.LowQuality || result.Status != scanner.StatusNeedsReview {
+				continue
+			}
+			if rel, err := filepath.Rel(targetFolder, path); err == nil {
+				lowQuality = append(lowQuality, filepath.ToSlash(rel))
+			}
+		}
+		topics = index.Topics(lowQuality, cfg.Embeddings.TopicThreshold)
+		for i := range topics {
+			for j, path := range topics[i].Paths {
+				topics[i].Paths[j] = filepath.Join(targetFolder, filepath.FromSlash(path))
+			}
+		}
+		printf("Grouped low quality notes into %d topics\n", len(topics))
+	}
+	if err := stateManager.SetTopics(topics); err != nil {
+		return fmt.Errorf("failed to update report with topics: %w", err)
+	}
+	return nil
+}
+
+// cacheSettings returns the settings that change the verdict for the same content, which are part of its cache key
+func cacheSettings(cfg *config.Config) string {
+	var ensemble *config.EnsembleConfig
+	if len(cfg.AIEngine.Ensemble.Engines) > 0 {
+		ensemble = &cfg.AIEngine.Ensemble
+	}
+	data, _ := json.Marshal(struct {
+		Provider, Model, Deployment string
+		SystemPrompt                string `json:",omitempty"` // Left out when unset, so earlier verdicts stay cached
+		Prompt                      string
+		Pipeline                    config.PipelineConfig
+		Samples                     int
+		Options                     config.GenerationOptions
+		StructuredOutput            bool
+		Escalation                  []config.EscalationStage
+		Ensemble                    *config.EnsembleConfig `json:",omitempty"` // Left out without an ensemble, so earlier verdicts stay cached
+		Scoring                     config.ScoringConfig
+	}{
+		Provider:         cfg.AIEngine.Provider,
+		Model:            cfg.AIEngine.Model,
+		Deployment:       cfg.AIEngine.Deployment,
+		SystemPrompt:     cfg.PromptConfig.SystemPrompt,
+		Prompt:           cfg.PromptConfig.QualityClassificationPrompt,
+		Pipeline:         cfg.PromptConfig.Pipeline,
+		Samples:          cfg.AIEngine.Samples,
+		Options:          cfg.AIEngine.Options,
+		StructuredOutput: cfg.AIEngine.StructuredOutput,
+		Escalation:       cfg.AIEngine.Escalation,
+		Ensemble:         ensemble,
+		Scoring:          cfg.Scoring,
+	})
+	return string(data)
+}
+
+// budgetExhausted returns a description of the exhausted budget, or an empty string if neither budget is reached
+func budgetExhausted(filesClassified, tokensUsed int) string {
+	if budgetFiles > 0 && filesClassified >= budgetFiles {
+		return fmt.Sprintf("file budget of %d exhausted", budgetFiles)
+	}
+	if budgetTokens > 0 && tokensUsed >= budgetTokens {
+		return fmt.Sprintf("token budget of %d exhausted (%d used)", budgetTokens, tokensUsed)
+	}
+	return ""
+}
+
+// addFlags registers the command-line flags on the given command
+func addFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVarP(&targetFolder, "target", "t", "", "Target folder containing Markdown files")
+	cmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "Path to configuration file")
+	cmd.PersistentFlags().StringVar(&profile, "profile", "", "Start from the defaults of a profile, such as "+config.ProfileDocsRepo+" (settings in the configuration file take precedence)")
+	cmd.PersistentFlags().IntVar(&budgetTokens, "budget-tokens", 0, "Stop after the GenAI engine has used this many tokens (0 for no limit)")
+	cmd.PersistentFlags().IntVar(&budgetFiles, "budget-files", 0, "Stop after classifying this many files (0 for no limit)")
+	cmd.PersistentFlags().IntVar(&workers, "workers", 0, "Number of files classified in parallel (overrides the configuration)")
+	cmd.PersistentFlags().BoolVar(&autoWorkers, "auto-workers", false, "Adjust the number of classification workers to the measured throughput")
+	cmd.PersistentFlags().IntVar(&precheckWorkers, "precheck-workers", 0, "Number of files pre-checked in parallel (overrides the configuration)")
+	cmd.Flags().StringArrayVar(&failIf, "fail-if", nil, "Fail the run when too many files have a classification, such as \"Empty>0\" or \"Low quality>=25%\" (repeatable)")
+	cmd.Flags().BoolVar(&suggest, "suggest", false, "Ask the GenAI engine for 2 to 3 improvement actions for each newly classified file that falls short")
+	cmd.Flags().BoolVar(&rebuildState, "rebuild-state", false, "Ignore the existing state and report, and rebuild them by rescanning every file")
+	cmd.Flags().StringVar(&partitionFlag, "partition", "", "Only classify one share of the files, such as 3/8, keeping its state apart for the aggregate command")
+	cmd.Flags().StringVar(&filesFrom, "files-from", "", "Only check the files listed in this file, one path per line, or - to read them from standard input, instead of scanning the target folder")
+	cmd.Flags().StringVar(&logFile, "log-file", "", "Append full debug logs of the run to this file, the console output is unchanged")
+}
+
+// addCommands registers the subcommands on the given command
+func addCommands(cmd *cobra.Command) {
+	cmd.AddCommand(newReportCmd())
+	cmd.AddCommand(newStatsCmd())
+	cmd.AddCommand(newQueryCmd())
+	cmd.AddCommand(newCleanEmptyCmd())
+	cmd.AddCommand(newMergeCmd())
+	cmd.AddCommand(newFixLinksCmd())
+	cmd.AddCommand(newUndoCmd())
+	cmd.AddCommand(newSkipListCmd())
+	cmd.AddCommand(newInventoryCmd())
+	cmd.AddCommand(newConfigCmd())
+	cmd.AddCommand(newRelabelCmd())
+	cmd.AddCommand(newAuditCmd())
+	cmd.AddCommand(newAggregateCmd())
+}
+
 // Execute is the entry point for the CLI application
 // It handles command-line arguments and initiates the scanning process
 func Execute() {
-	// Add flags
-	rootCmd.PersistentFlags().StringVarP(&targetFolder, "target", "t", "", "Target folder containing Markdown files")
-	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "Path to configuration file")
+	// Add flags and subcommands
+	addFlags(rootCmd)
+	addCommands(rootCmd)
 
 	// Execute the command
 	if err := rootCmd.Execute(); err != nil {
@@ -181,10 +1409,11 @@ func ResetForTesting() {
 in an Obsidian vault or any directory containing Markdown files.
 It classifies files as Empty, Low quality/low effort, or Good enough,
 and generates a report in Markdown format.`,
+		Args: rootCmd.Args,
 		RunE: rootCmd.RunE,
 	}
 
-	// Add flags
-	rootCmd.PersistentFlags().StringVarP(&targetFolder, "target", "t", "", "Target folder containing Markdown files")
-	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "Path to configuration file")
+	// Add flags and subcommands
+	addFlags(rootCmd)
+	addCommands(rootCmd)
 }
@@ -1,11 +1,36 @@
 package cli
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"ratemykb/analysis"
+	"ratemykb/attachments"
+	"ratemykb/audit"
+	"ratemykb/classification"
+	"ratemykb/config"
+	"ratemykb/gates"
+	"ratemykb/links"
+	"ratemykb/output"
+	"ratemykb/runs"
+	"ratemykb/safewrite"
+	"ratemykb/scanner"
+	"ratemykb/state"
 
 	"github.com/spf13/cobra"
 )
@@ -19,12 +44,13 @@ func executeCommand(t *testing.T, args ...string) (string, error) {
 		Use:   "ratemykb",
 		Short: rootCmd.Short,
 		Long:  rootCmd.Long,
+		Args:  rootCmd.Args,
 		RunE:  rootCmd.RunE,
 	}
 
-	// Copy the flag definitions from the main root command
-	testRootCmd.PersistentFlags().StringVarP(&targetFolder, "target", "t", "", "Target folder containing Markdown files")
-	testRootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "Path to configuration file")
+	// Copy the flag definitions and subcommands from the main root command
+	addFlags(testRootCmd)
+	addCommands(testRootCmd)
 
 	// Redirect output for testing
 	buff := bytes.NewBufferString("")
@@ -185,3 +211,967 @@ func TestConfigLoadingError(t *testing.T) {
 		t.Error("Expected an error for invalid config path, but got none")
 	}
 }
+
+func TestBudgetExhausted(t *testing.T) {
+	defer func() {
+		budgetFiles = 0
+		budgetTokens = 0
+	}()
+
+	budgetFiles = 0
+	budgetTokens = 0
+	if reason := budgetExhausted(1000, 1000000); reason != "" {
+		t.Errorf("Expected no budget limit by default, got: %s", reason)
+	}
+
+	budgetFiles = 2
+	if reason := budgetExhausted(1, 0); reason != "" {
+		t.Errorf("Expected file budget not to be exhausted, got: %s", reason)
+	}
+	if reason := budgetExhausted(2, 0); reason == "" {
+		t.Error("Expected file budget to be exhausted")
+	}
+
+	budgetFiles = 0
+	budgetTokens = 500
+	if reason := budgetExhausted(0, 499); reason != "" {
+		t.Errorf("Expected token budget not to be exhausted, got: %s", reason)
+	}
+	if reason := budgetExhausted(0, 500); reason == "" {
+		t.Error("Expected token budget to be exhausted")
+	}
+}
+
+func TestFilesFromReclassifiesEditedNotes(t *testing.T) {
+	var mu sync.Mutex
+	var classified []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		for _, note := range []string{"pods", "services"} {
+			if strings.Contains(string(body), "About "+note) {
+				classified = append(classified, note)
+			}
+		}
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"1","object":"chat.completion","model":"local","choices":[{"index":0,"finish_reason":"stop",`+
+			`"message":{"role":"assistant","content":"Good enough"}}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+	}))
+	defer server.Close()
+
+	tempDir := t.TempDir()
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	config := "ai_engine:\n  provider: openai\n  url: " + server.URL + "\n  api_key: test\n  model: local\n  warm_up: false\n"
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	pods := filepath.Join(tempDir, "pods.md")
+	services := filepath.Join(tempDir, "services.md")
+	for path, content := range map[string]string{pods: "# About pods\n\nPods group containers.", services: "# About services\n\nServices expose pods."} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write note: %v", err)
+		}
+	}
+
+	run := func(args ...string) {
+		t.Helper()
+		targetFolder = ""
+		mu.Lock()
+		classified = nil
+		mu.Unlock()
+		if _, err := executeCommand(t, append([]string{tempDir, "--config", configPath}, args...)...); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+	}
+	run()
+	if len(classified) != 2 {
+		t.Fatalf("Expected both notes classified by the first run, got %v", classified)
+	}
+
+	// Both notes are listed, only the edited one has changed since it was classified
+	if err := os.WriteFile(pods, []byte("# About pods\n\nPods group containers that share a network namespace."), 0644); err != nil {
+		t.Fatalf("Failed to edit note: %v", err)
+	}
+	list := filepath.Join(t.TempDir(), "changed.txt")
+	if err := os.WriteFile(list, []byte(pods+"\n"+services+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file list: %v", err)
+	}
+	run("--files-from", list)
+	if !reflect.DeepEqual(classified, []string{"pods"}) {
+		t.Errorf("Expected only the edited note classified again, got %v", classified)
+	}
+}
+
+func TestNeedsClassification(t *testing.T) {
+	files := []scanner.File{
+		{Path: "/vault/empty.md", Status: scanner.StatusEmpty},
+		{Path: "/vault/note.md", Status: scanner.StatusNeedsReview},
+	}
+
+	processed := map[string]bool{}
+	skipped := map[string]bool{}
+	isProcessed := func(path string) bool { return processed[path] }
+	isSkipped := func(path string) bool { return skipped[path] }
+	if !needsClassification(files, isProcessed, isSkipped) {
+		t.Error("Expected an unprocessed note to need classification")
+	}
+
+	skipped["/vault/note.md"] = true
+	if needsClassification(files, isProcessed, isSkipped) {
+		t.Error("Expected no classification while the only note is on the skip list")
+	}
+
+	skipped["/vault/note.md"] = false
+	processed["/vault/note.md"] = true
+	if needsClassification(files, isProcessed, isSkipped) {
+		t.Error("Expected no classification once every note is processed")
+	}
+}
+
+func TestReportCommand(t *testing.T) {
+	// Reset global variables before the test
+	targetFolder = ""
+	configFile = ""
+
+	// Create a temporary directory for the test
+	tempDir, err := os.MkdirTemp("", "ratemykb-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Regenerating without stored state is an error
+	if _, err := executeCommand(t, "report", tempDir); err == nil {
+		t.Error("Expected an error when no state is stored")
+	}
+
+	// Store the state of a previous run
+	stateManager, err := state.New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	err = stateManager.AddProcessedFile(output.ResultFile{
+		Path:           filepath.Join(tempDir, "note.md"),
+		Status:         scanner.StatusNeedsReview,
+		Classification: classification.Classification("Good enough"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to add processed file: %v", err)
+	}
+
+	// Regenerate the report as HTML
+	targetFolder = ""
+	if _, err := executeCommand(t, "report", tempDir, "--format", "html"); err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(tempDir, "vault-quality-report.html"))
+	if err != nil {
+		t.Fatalf("Expected HTML report to be written: %v", err)
+	}
+	if !strings.Contains(string(content), `<a href="note.md">note</a>`) {
+		t.Errorf("Expected HTML report to link the note, got:\n%s", content)
+	}
+
+	// Unknown formats are rejected
+	targetFolder = ""
+	if _, err := executeCommand(t, "report", tempDir, "--format", "pdf"); err == nil {
+		t.Error("Expected an error for an unsupported format")
+	}
+}
+
+func TestSkipListCommand(t *testing.T) {
+	targetFolder = ""
+	configFile = ""
+	tempDir := t.TempDir()
+
+	// Store a file that failed too many runs in a row
+	stateManager, err := state.New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	failing := filepath.Join(tempDir, "failing.md")
+	if err := stateManager.AddProcessedFile(output.ResultFile{
+		Path:           failing,
+		Status:         scanner.StatusNeedsReview,
+		Classification: classification.TimedOut,
+	}); err != nil {
+		t.Fatalf("Failed to add processed file: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := stateManager.RecordFailure(failing, "classification timed out", 2); err != nil {
+			t.Fatalf("Failed to record failure: %v", err)
+		}
+	}
+
+	out, err := executeCommand(t, "skip-list", tempDir)
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if !strings.Contains(out, "failing.md") || !strings.Contains(out, "classification timed out") {
+		t.Errorf("Expected the skipped file with its last error, got:\n%s", out)
+	}
+
+	targetFolder = ""
+	out, err = executeCommand(t, "skip-list", tempDir, "--clear")
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if !strings.Contains(out, "Cleared 1 files") {
+		t.Errorf("Expected 1 file to be cleared, got:\n%s", out)
+	}
+
+	reloaded, err := state.Load(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if reloaded.IsSkipped(failing) {
+		t.Error("Expected the skip list to be cleared")
+	}
+}
+
+func TestComputeStats(t *testing.T) {
+	root := filepath.Join("/", "vault")
+	files := map[string]output.ResultFile{}
+	add := func(path string, status scanner.FileStatus, class string) {
+		full := filepath.Join(root, filepath.FromSlash(path))
+		files[full] = output.ResultFile{Path: full, Status: status, Classification: classification.Classification(class)}
+	}
+	add("projects/a/one.md", scanner.StatusNeedsReview, "Low quality")
+	add("projects/b/two.md", scanner.StatusNeedsReview, "Good enough")
+	add("inbox/three.md", scanner.StatusNeedsReview, "Low quality")
+	add("inbox/four.md", scanner.StatusEmpty, "Empty")
+	add("root.md", scanner.StatusNeedsReview, "Low quality")
+
+	rows, total, err := computeStats(root, files, statsOptions{fileFilter: fileFilter{classification: "low quality"}, by: "folder", depth: 1, sortBy: "name"})
+	if err != nil {
+		t.Fatalf("computeStats() error = %v", err)
+	}
+	want := []statsRow{{group: ".", count: 1}, {group: "inbox", count: 1}, {group: "projects", count: 1}}
+	if total != 3 || !reflect.DeepEqual(rows, want) {
+		t.Errorf("computeStats() = %v (total %d), want %v (total 3)", rows, total, want)
+	}
+
+	rows, total, err = computeStats(root, files, statsOptions{fileFilter: fileFilter{folder: "projects"}, by: "classification", sortBy: "count"})
+	if err != nil {
+		t.Fatalf("computeStats() error = %v", err)
+	}
+	want = []statsRow{{group: "Good enough", count: 1}, {group: "Low quality", count: 1}}
+	if total != 2 || !reflect.DeepEqual(rows, want) {
+		t.Errorf("computeStats() = %v (total %d), want %v (total 2)", rows, total, want)
+	}
+
+	if _, _, err := computeStats(root, files, statsOptions{by: "size", sortBy: "count"}); err == nil {
+		t.Error("Expected an error for an unsupported grouping")
+	}
+}
+
+func TestQueryPaths(t *testing.T) {
+	root := filepath.Join("/", "vault")
+	files := map[string]output.ResultFile{}
+	add := func(path string, status scanner.FileStatus, class string) {
+		full := filepath.Join(root, filepath.FromSlash(path))
+		files[full] = output.ResultFile{Path: full, Status: status, Classification: classification.Classification(class)}
+	}
+	add("inbox/b.md", scanner.StatusEmpty, "Empty")
+	add("inbox/a.md", scanner.StatusEmpty, "Empty")
+	add("projects/c.md", scanner.StatusEmpty, "Empty")
+	add("projects/d.md", scanner.StatusNeedsReview, "Good enough")
+
+	got := queryPaths(root, files, queryOptions{fileFilter: fileFilter{classification: "empty", folder: "inbox"}, relative: true})
+	want := []string{filepath.Join("inbox", "a.md"), filepath.Join("inbox", "b.md")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("queryPaths() = %v, want %v", got, want)
+	}
+
+	var buf bytes.Buffer
+	printQuery(&buf, []string{"a.md", "b.md"}, true)
+	if buf.String() != "a.md\x00b.md\x00" {
+		t.Errorf("Expected NUL separated output, got %q", buf.String())
+	}
+}
+
+func TestCleanEmptyCommand(t *testing.T) {
+	// Reset global variables before the test
+	targetFolder = ""
+	configFile = ""
+
+	tempDir := t.TempDir()
+
+	// Store the state of a previous run with one empty and one regular note
+	emptyPath := filepath.Join(tempDir, "empty.md")
+	notePath := filepath.Join(tempDir, "note.md")
+	if err := os.WriteFile(emptyPath, []byte(""), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.WriteFile(notePath, []byte("Some content worth keeping"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	stateManager, err := state.New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	for _, file := range []output.ResultFile{
+		{Path: emptyPath, Status: scanner.StatusEmpty, Classification: classification.Classification("Empty")},
+		{Path: notePath, Status: scanner.StatusNeedsReview, Classification: classification.Classification("Good enough")},
+	} {
+		if err := stateManager.AddProcessedFile(file); err != nil {
+			t.Fatalf("Failed to add processed file: %v", err)
+		}
+	}
+
+	// A dry run leaves the files in place
+	if _, err := executeCommand(t, "clean-empty", tempDir, "--dry-run"); err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if _, err := os.Stat(emptyPath); err != nil {
+		t.Errorf("Expected dry run to keep %s: %v", emptyPath, err)
+	}
+
+	targetFolder = ""
+	if _, err := executeCommand(t, "clean-empty", tempDir); err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, ".trash", "empty.md")); err != nil {
+		t.Errorf("Expected empty file to be moved to the trash: %v", err)
+	}
+	if _, err := os.Stat(notePath); err != nil {
+		t.Errorf("Expected regular note to be kept: %v", err)
+	}
+
+	// The moved file is no longer part of the stored state
+	reloaded, err := state.New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to reload state: %v", err)
+	}
+	if reloaded.IsFileProcessed(emptyPath) {
+		t.Error("Expected moved file to be removed from the state")
+	}
+
+	// Undo restores the file
+	targetFolder = ""
+	if _, err := executeCommand(t, "clean-empty", tempDir, "--undo"); err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if _, err := os.Stat(emptyPath); err != nil {
+		t.Errorf("Expected empty file to be restored: %v", err)
+	}
+}
+
+func TestClassifyAttachmentsReuse(t *testing.T) {
+	tempDir := t.TempDir()
+	targetFolder = tempDir
+
+	// The attachment cannot be extracted, so it is only classified if its previous classification is reused
+	note := filepath.Join(tempDir, "note.md")
+	report := filepath.Join(tempDir, "report.pdf")
+	if err := os.WriteFile(note, []byte("See [[report.pdf]]"), 0644); err != nil {
+		t.Fatalf("Failed to create note: %v", err)
+	}
+	if err := os.WriteFile(report, []byte("not a pdf"), 0644); err != nil {
+		t.Fatalf("Failed to create attachment: %v", err)
+	}
+	info, err := os.Stat(report)
+	if err != nil {
+		t.Fatalf("Failed to stat attachment: %v", err)
+	}
+	files := []scanner.File{{Path: note, Status: scanner.StatusNeedsReview}}
+
+	cfg := &config.Config{
+		AIEngine:    config.AIEngineConfig{Provider: config.ProviderOllama, Model: "gemma3:1b"},
+		Attachments: config.AttachmentsConfig{Extensions: []string{".pdf"}},
+	}
+	found, err := classifyAttachments(context.Background(), cfg, nil, nil, files, nil, scanner.Partition{})
+	if err != nil {
+		t.Fatalf("classifyAttachments() error = %v", err)
+	}
+	if len(found) != 1 || found[0].Problem == "" {
+		t.Fatalf("Expected the attachment to fail extraction, got %+v", found)
+	}
+	previous := []attachments.Attachment{{Path: report, Modified: info.ModTime(), Settings: found[0].Settings, Classification: "Good enough"}}
+
+	found, err = classifyAttachments(context.Background(), cfg, nil, nil, files, previous, scanner.Partition{})
+	if err != nil || len(found) != 1 || found[0].Classification != "Good enough" {
+		t.Errorf("Expected the previous classification to be reused, got %+v, %v", found, err)
+	}
+
+	// A different model classifies the attachment again
+	cfg.AIEngine.Model = "llama3"
+	found, err = classifyAttachments(context.Background(), cfg, nil, nil, files, previous, scanner.Partition{})
+	if err != nil || len(found) != 1 || found[0].Classification != "" {
+		t.Errorf("Expected the attachment to be classified again, got %+v, %v", found, err)
+	}
+}
+
+func TestMergeSession(t *testing.T) {
+	tempDir := t.TempDir()
+	targetFolder = tempDir
+
+	// Two notes sharing a title and a note linking to the one that will be removed
+	kept := filepath.Join(tempDir, "Topic.md")
+	removed := filepath.Join(tempDir, "old", "Topic.md")
+	linking := filepath.Join(tempDir, "index.md")
+	for path, content := range map[string]string{
+		kept:    "# Topic\nshared\n",
+		removed: "# Topic\nshared\nextra\n",
+		linking: "See [[old/Topic]]",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create folder: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	stateManager, err := state.New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	for _, path := range []string{kept, removed} {
+		if err := stateManager.AddProcessedFile(output.ResultFile{Path: path, Status: scanner.StatusNeedsReview}); err != nil {
+			t.Fatalf("Failed to add processed file: %v", err)
+		}
+	}
+	stateManager.Duplicates = []analysis.DuplicateGroup{{Paths: []string{kept, removed}, Similarity: 0.97}}
+
+	cfg := &config.Config{ScanSettings: config.ScanSettingsConfig{FileExtension: ".md"}}
+	var out bytes.Buffer
+	session := &mergeSession{
+		cfg:          cfg,
+		stateManager: stateManager,
+		changes:      safewrite.Begin(tempDir, "merge"),
+		in:           bufio.NewReader(strings.NewReader("maybe\ny\n")),
+		out:          &out,
+	}
+	if err := session.run(); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "Please answer y, s, n or q") {
+		t.Errorf("Expected an invalid answer to be asked again, got %q", out.String())
+	}
+
+	content, _ := os.ReadFile(kept)
+	if string(content) != "# Topic\nshared\nextra\n" {
+		t.Errorf("Expected merged content in kept note, got %q", content)
+	}
+	if _, err := os.Stat(removed); !os.IsNotExist(err) {
+		t.Error("Expected removed note to be moved to the trash")
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, ".trash", "old", "Topic.md")); err != nil {
+		t.Errorf("Expected removed note in the trash: %v", err)
+	}
+
+	content, _ = os.ReadFile(linking)
+	if string(content) != "See [[Topic]]" {
+		t.Errorf("Expected link to be redirected, got %q", content)
+	}
+	if stateManager.IsFileProcessed(removed) {
+		t.Error("Expected removed note to be removed from the state")
+	}
+
+	// Undoing the run restores both notes and the link
+	if _, err := executeCommand(t, "undo", session.changes.ID, tempDir); err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	content, _ = os.ReadFile(kept)
+	if string(content) != "# Topic\nshared\n" {
+		t.Errorf("Expected kept note to be restored, got %q", content)
+	}
+	if _, err := os.Stat(removed); err != nil {
+		t.Errorf("Expected removed note to be restored: %v", err)
+	}
+	content, _ = os.ReadFile(linking)
+	if string(content) != "See [[old/Topic]]" {
+		t.Errorf("Expected link to be restored, got %q", content)
+	}
+}
+
+func TestFixLinks(t *testing.T) {
+	tempDir := t.TempDir()
+
+	notePath := filepath.Join(tempDir, "note.md")
+	if err := os.WriteFile(notePath, []byte("See [[Kubernets]] and [[Kubernets]]"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	broken := []links.BrokenLink{
+		{Path: notePath, Line: 1, Target: "Kubernets", Suggestion: "Kubernetes"},
+		{Path: notePath, Line: 1, Target: "Kubernets", Suggestion: "Kubernetes"},
+	}
+
+	var out bytes.Buffer
+	if err := fixLinks(&out, bufio.NewReader(strings.NewReader("y\n")), safewrite.Begin(tempDir, "fix-links"), broken, false); err != nil {
+		t.Fatalf("fixLinks() error = %v", err)
+	}
+
+	content, _ := os.ReadFile(notePath)
+	if string(content) != "See [[Kubernetes]] and [[Kubernetes]]" {
+		t.Errorf("Expected links to be fixed, got %q", content)
+	}
+	if strings.Count(out.String(), "replace [[Kubernets]]") != 1 {
+		t.Errorf("Expected a single prompt per file and target, got:\n%s", out.String())
+	}
+}
+
+func TestDetectVault(t *testing.T) {
+	tempDir := t.TempDir()
+
+	vault := filepath.Join(tempDir, "My Vault")
+	subfolder := filepath.Join(vault, "Projects", "Work")
+	if err := os.MkdirAll(filepath.Join(vault, ".obsidian"), 0755); err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+	if err := os.MkdirAll(subfolder, 0755); err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+
+	tests := []struct {
+		folder         string
+		expectedName   string
+		expectedFolder string
+	}{
+		{vault, "My Vault", ""},
+		{subfolder, "My Vault", "Projects/Work"},
+		{tempDir, filepath.Base(tempDir), ""},
+	}
+
+	for _, tt := range tests {
+		name, folder := detectVault(tt.folder)
+		if name != tt.expectedName || folder != tt.expectedFolder {
+			t.Errorf("detectVault(%s) = (%q, %q), want (%q, %q)", tt.folder, name, folder, tt.expectedName, tt.expectedFolder)
+		}
+	}
+}
+
+func TestPrintSummary(t *testing.T) {
+	processed := map[string]output.ResultFile{
+		"a.md": {Classification: "Good enough"},
+		"b.md": {Classification: "Good enough"},
+		"c.md": {Classification: "Empty"},
+		"d.md": {Classification: "Low quality"},
+	}
+
+	var buf bytes.Buffer
+	printSummary(&buf, false, processed, 1, 3, []string{"Good enough"})
+	got := buf.String()
+	if strings.Contains(got, "\033[") {
+		t.Errorf("Expected no color codes, got %q", got)
+	}
+	for _, want := range []string{
+		"  New files:               1\n",
+		"CLASSIFICATION    FILES  PERCENT\n",
+		"Good enough           2    50.0%\n",
+		"Empty                 1    25.0%\n",
+		"TOTAL                 4\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected summary to contain %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Index(got, "Empty") > strings.Index(got, "Low quality") {
+		t.Errorf("Expected labels with the same count sorted by name, got:\n%s", got)
+	}
+
+	buf.Reset()
+	printSummary(&buf, true, processed, 1, 3, []string{"Good enough"})
+	if !strings.Contains(buf.String(), colorGreen+"Good enough") || !strings.Contains(buf.String(), colorRed+"Empty") {
+		t.Errorf("Expected colored labels, got %q", buf.String())
+	}
+
+	t.Setenv("NO_COLOR", "1")
+	if useColor(os.Stdout) {
+		t.Error("Expected NO_COLOR to disable color")
+	}
+}
+
+func TestLogFile(t *testing.T) {
+	// Reset global variables before the test
+	targetFolder = ""
+	configFile = ""
+
+	logPath := filepath.Join(t.TempDir(), "run.log")
+	nonExistentFolder := "/path/that/does/not/exist"
+	if _, err := executeCommand(t, "--log-file", logPath, "--target", nonExistentFolder); err == nil {
+		t.Fatal("Expected error when target folder does not exist")
+	}
+
+	content, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	for _, want := range []string{"DEBUG Command line: ", "ERROR target folder does not exist: " + nonExistentFolder} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Expected log file to contain %q, got:\n%s", want, content)
+		}
+	}
+
+	// Messages only reach the log file while it is open
+	debugf("not logged")
+	if after, _ := os.ReadFile(logPath); !bytes.Equal(after, content) {
+		t.Errorf("Expected nothing to be logged after the run, got:\n%s", after)
+	}
+
+	// The configuration is logged without API keys
+	targetFolder = ""
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	engines := "ai_engine:\n  api_key: primary-secret\n  fallbacks:\n    - provider: openai\n      api_key: fallback-secret\n"
+	if err := os.WriteFile(configPath, []byte(engines), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	secretLog := filepath.Join(t.TempDir(), "run.log")
+	if _, err := executeCommand(t, t.TempDir(), "--config", configPath, "--log-file", secretLog); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	content, err = os.ReadFile(secretLog)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	if !strings.Contains(string(content), "DEBUG Configuration: ") || strings.Contains(string(content), "secret") {
+		t.Errorf("Expected the configuration logged without API keys, got:\n%s", content)
+	}
+}
+
+func TestConfigShowCommand(t *testing.T) {
+	configFile = ""
+	t.Setenv("RATEMYKB_AI_ENGINE_MODEL", "llama3")
+
+	out, err := executeCommand(t, "config", "show", "--format", "json", "--workers", "3")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var settings struct {
+		AIEngine struct {
+			Model   string `json:"model"`
+			Timeout string `json:"timeout"`
+		} `json:"ai_engine"`
+		Concurrency struct {
+			ClassificationWorkers int `json:"classification_workers"`
+		} `json:"concurrency"`
+	}
+	if err := json.Unmarshal([]byte(out), &settings); err != nil {
+		t.Fatalf("Expected JSON output, got %v:\n%s", err, out)
+	}
+	if settings.AIEngine.Model != "llama3" || settings.AIEngine.Timeout != "5m0s" || settings.Concurrency.ClassificationWorkers != 3 {
+		t.Errorf("Expected environment and flags to override the defaults, got %+v", settings)
+	}
+
+	out, err = executeCommand(t, "config", "show")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.HasPrefix(out, "ai_engine:\n") || !strings.Contains(out, "\n  max_images: 4\n") {
+		t.Errorf("Expected YAML output, got:\n%s", out)
+	}
+
+	if _, err := executeCommand(t, "config", "show", "--format", "toml"); err == nil {
+		t.Error("Expected an error for an unsupported format")
+	}
+}
+
+func TestRelabelCommand(t *testing.T) {
+	targetFolder = ""
+	configFile = ""
+	tempDir := t.TempDir()
+
+	stateManager, err := state.New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	labels := map[string]string{"a.md": "Low quality/low effort", "b.md": "low quality/low effort", "c.md": "Borderline", "d.md": "Good enough"}
+	for name, label := range labels {
+		path := filepath.Join(tempDir, name)
+		if err := stateManager.AddProcessedFile(output.ResultFile{Path: path, Classification: classification.Classification(label)}); err != nil {
+			t.Fatalf("Failed to add processed file: %v", err)
+		}
+	}
+	if err := stateManager.Flush(); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	out, err := executeCommand(t, "relabel", tempDir, "--map", "Low quality/low effort=Low quality", "--map", "Borderline=", "--dry-run")
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if !strings.Contains(out, "Low quality/low effort -> Low quality: 2 files") || !strings.Contains(out, "Borderline: 1 files to classify again") {
+		t.Errorf("Expected the files to change, got:\n%s", out)
+	}
+	if reloaded, _ := state.Load(tempDir); len(reloaded.GetProcessedFiles()) != 4 {
+		t.Error("Expected a dry run to leave the state unchanged")
+	}
+
+	targetFolder = ""
+	if _, err := executeCommand(t, "relabel", tempDir, "--map", "Low quality/low effort=Low quality", "--map", "Borderline="); err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	reloaded, err := state.Load(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	processed := reloaded.GetProcessedFiles()
+	if processed[filepath.Join(tempDir, "a.md")].Classification != "Low quality" || processed[filepath.Join(tempDir, "b.md")].Classification != "Low quality" {
+		t.Errorf("Expected the old label to be replaced, got %+v", processed)
+	}
+	if _, ok := processed[filepath.Join(tempDir, "c.md")]; ok {
+		t.Error("Expected the file mapped to nothing to be forgotten")
+	}
+	if processed[filepath.Join(tempDir, "d.md")].Classification != "Good enough" {
+		t.Error("Expected other labels to be left alone")
+	}
+
+	targetFolder = ""
+	if _, err := executeCommand(t, "relabel", tempDir, "--map", "=Low quality"); err == nil {
+		t.Error("Expected an error for a mapping without an old label")
+	}
+}
+
+func TestStatsHistory(t *testing.T) {
+	targetFolder = ""
+	configFile = ""
+	tempDir := t.TempDir()
+
+	if err := os.MkdirAll(runs.Dir(tempDir), 0755); err != nil {
+		t.Fatalf("Failed to create runs folder: %v", err)
+	}
+	started := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	writeRun := func(id, model string, hours int, classified int, labels map[string]int) {
+		finished := started.Add(time.Duration(hours) * time.Hour)
+		data, err := json.Marshal(runs.Manifest{
+			ID: id, Command: "scan", Started: started, Finished: &finished, Model: model,
+			Counts: map[string]int{"files_classified": classified}, Labels: labels,
+		})
+		if err != nil {
+			t.Fatalf("Failed to encode manifest: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(runs.Dir(tempDir), id+".json"), data, 0644); err != nil {
+			t.Fatalf("Failed to write manifest: %v", err)
+		}
+		started = finished.Add(24 * time.Hour)
+	}
+	writeRun("20240301-100000", "gemma3:1b", 2, 100, map[string]int{"Good enough": 50, "Low quality": 50})
+	writeRun("20240303-120000", "gemma3:12b", 4, 100, map[string]int{"Good enough": 80, "Low quality": 20})
+
+	out, err := executeCommand(t, "stats", "--history", tempDir)
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	for _, want := range []string{
+		"50.0",
+		"25.0",
+		"20240303-120000: gemma3:1b -> gemma3:12b",
+		"+30.0 pts",
+		"-30.0 pts",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected history to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestRunSummary(t *testing.T) {
+	tempDir := t.TempDir()
+	stateManager, err := state.New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	for name, label := range map[string]classification.Classification{"a.md": classification.Empty, "b.md": classification.GoodEnough} {
+		path := filepath.Join(tempDir, name)
+		if err := stateManager.AddProcessedFile(output.ResultFile{Path: path, Classification: label}); err != nil {
+			t.Fatalf("Failed to add file: %v", err)
+		}
+	}
+
+	qualityGates, err := gates.New(config.QualityGatesConfig{}, []string{"Empty>0", "Low quality>0"})
+	if err != nil {
+		t.Fatalf("Failed to create quality gates: %v", err)
+	}
+	run := &runs.Run{Manifest: runs.Manifest{ID: "20261016-120000", Counts: map[string]int{"files_found": 2}, TokensUsed: 42}}
+
+	path := filepath.Join(tempDir, SummaryFile)
+	if err := writeRunSummary(path, run, stateManager, qualityGates.Check(tempDir, stateManager.GetProcessedFiles())); err != nil {
+		t.Fatalf("writeRunSummary() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read summary: %v", err)
+	}
+	var summary runSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		t.Fatalf("Failed to parse summary: %v", err)
+	}
+	if summary.RunID != run.ID || summary.TokensUsed != 42 || summary.Counts["files_found"] != 2 {
+		t.Errorf("Expected the run details in the summary, got %+v", summary)
+	}
+	if summary.Status != "failed" || summary.ExitCode != 1 {
+		t.Errorf("Expected a failed run with exit code 1, got %q and %d", summary.Status, summary.ExitCode)
+	}
+	want := []gateSummary{
+		{Scope: "vault", Gate: "Empty>0", Count: 1, Total: 2, Passed: false},
+		{Scope: "vault", Gate: "Low quality>0", Count: 0, Total: 2, Passed: true},
+	}
+	if !reflect.DeepEqual(summary.Gates, want) {
+		t.Errorf("Expected gates %+v, got %+v", want, summary.Gates)
+	}
+}
+
+func TestDetectLinkStyle(t *testing.T) {
+	tempDir := t.TempDir()
+	if got := detectLinkStyle(tempDir); got != state.LinkStyleWiki {
+		t.Errorf("Expected wikilinks outside a vault, got %q", got)
+	}
+
+	settings := filepath.Join(tempDir, ".obsidian", "app.json")
+	if err := os.MkdirAll(filepath.Dir(settings), 0755); err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+	if err := os.WriteFile(settings, []byte(`{"useMarkdownLinks": true, "newLinkFormat": "relative"}`), 0644); err != nil {
+		t.Fatalf("Failed to write settings: %v", err)
+	}
+	if got := detectLinkStyle(filepath.Join(tempDir, "Projects")); got != state.LinkStyleMarkdown {
+		t.Errorf("Expected markdown links, got %q", got)
+	}
+}
+
+func TestFrontmatterMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "note.md")
+	content := "---\nowner: alice\ntags: [runbook, oncall]\nreviewed: 2026-03-01\npriority: 2\n---\n# Note\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write note: %v", err)
+	}
+
+	got := frontmatterMetadata(path, []string{"owner", "tags", "reviewed", "priority", "status"})
+	want := map[string]string{"owner": "alice", "tags": "runbook, oncall", "reviewed": "2026-03-01", "priority": "2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got := frontmatterMetadata(path, []string{"status"}); got != nil {
+		t.Errorf("Expected no metadata without the fields, got %v", got)
+	}
+}
+
+func TestFallsShort(t *testing.T) {
+	cfg := &config.Config{
+		Report:      config.ReportConfig{PassingLabels: []string{"Good enough"}},
+		Suggestions: config.SuggestionsConfig{BelowRating: 60},
+	}
+	rating := func(r int) *int { return &r }
+	tests := []struct {
+		result output.ResultFile
+		want   bool
+	}{
+		{output.ResultFile{Classification: "Low quality"}, true},
+		{output.ResultFile{Classification: "good enough"}, false},
+		{output.ResultFile{Classification: "Good enough", Rating: rating(40)}, true},
+		{output.ResultFile{Classification: "Good enough", Rating: rating(60)}, false},
+		{output.ResultFile{Classification: classification.TimedOut}, false},
+	}
+	for _, test := range tests {
+		if got := fallsShort(cfg, test.result); got != test.want {
+			t.Errorf("fallsShort(%+v) = %v, want %v", test.result, got, test.want)
+		}
+	}
+}
+
+func TestAuditCommand(t *testing.T) {
+	targetFolder = ""
+	configFile = ""
+	tempDir := t.TempDir()
+
+	stateManager, err := state.New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	for name, label := range map[string]string{"a.md": "Good enough", "b.md": "Good enough", "c.md": "Low quality"} {
+		path := filepath.Join(tempDir, name)
+		if err := stateManager.AddProcessedFile(output.ResultFile{Path: path, Status: scanner.StatusNeedsReview, Classification: classification.Classification(label)}); err != nil {
+			t.Fatalf("Failed to add processed file: %v", err)
+		}
+	}
+	if err := stateManager.Flush(); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	if _, err := executeCommand(t, "audit", tempDir, "--record"); !errors.Is(err, audit.ErrNoChecklist) {
+		t.Errorf("Expected ErrNoChecklist before sampling, got %v", err)
+	}
+
+	targetFolder = ""
+	out, err := executeCommand(t, "audit", tempDir, "--sample", "2", "--seed", "7")
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if !strings.Contains(out, "Wrote a checklist of 2 files") {
+		t.Errorf("Expected a checklist of 2 files, got:\n%s", out)
+	}
+
+	// Agree with every file of the checklist
+	checklist, err := audit.Latest(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to find checklist: %v", err)
+	}
+	data, err := os.ReadFile(checklist)
+	if err != nil {
+		t.Fatalf("Failed to read checklist: %v", err)
+	}
+	if err := os.WriteFile(checklist, []byte(strings.ReplaceAll(string(data), "- [ ]", "- [x]")), 0644); err != nil {
+		t.Fatalf("Failed to tick checklist: %v", err)
+	}
+
+	targetFolder = ""
+	out, err = executeCommand(t, "audit", tempDir, "--record")
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if !regexp.MustCompile(`Overall\s+2\s+2\s+100%`).MatchString(out) {
+		t.Errorf("Expected full agreement, got:\n%s", out)
+	}
+
+	targetFolder = ""
+	out, err = executeCommand(t, "audit", tempDir, "--history")
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if !strings.Contains(out, filepath.Base(strings.TrimSuffix(checklist, ".md"))) || !strings.Contains(out, "100%") {
+		t.Errorf("Expected the recorded audit in the history, got:\n%s", out)
+	}
+}
+
+func TestAggregateCommand(t *testing.T) {
+	targetFolder = ""
+	configFile = ""
+	tempDir := t.TempDir()
+
+	// Only the first of three partitions has run so far
+	partition, err := state.NewPartition(tempDir, scanner.Partition{Index: 1, Count: 3})
+	if err != nil {
+		t.Fatalf("Failed to create partition: %v", err)
+	}
+	if err := partition.AddProcessedFile(output.ResultFile{Path: filepath.Join(tempDir, "a.md"), Status: scanner.StatusNeedsReview, Classification: "Good enough"}); err != nil {
+		t.Fatalf("Failed to add processed file: %v", err)
+	}
+
+	if _, err := executeCommand(t, "aggregate", tempDir); err == nil || !strings.Contains(err.Error(), "missing partitions 2/3, 3/3") {
+		t.Errorf("Expected the missing partitions to be listed, got %v", err)
+	}
+
+	targetFolder = ""
+	out, err := executeCommand(t, "aggregate", tempDir, "--partial")
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if !strings.Contains(out, "Aggregated 1 files from 1 partitions") {
+		t.Errorf("Expected the aggregated files, got:\n%s", out)
+	}
+	report, err := os.ReadFile(filepath.Join(tempDir, "vault-quality-report.md"))
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	if !strings.Contains(string(report), "[[a]]") {
+		t.Errorf("Expected the file of the partition in the report, got:\n%s", report)
+	}
+}
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"ratemykb/config"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// newConfigCmd creates the command grouping the configuration subcommands
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the configuration",
+	}
+	cmd.AddCommand(newConfigShowCmd())
+	return cmd
+}
+
+// newConfigShowCmd creates the command that prints the configuration a run would use
+func newConfigShowCmd() *cobra.Command {
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Print the effective configuration",
+		Long: `Print the configuration a run would use: the defaults, overridden by the configuration file,
+then by RATEMYKB_* environment variables such as RATEMYKB_AI_ENGINE_MODEL, then by command line flags.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.LoadProfile(configFile, profile)
+			if err != nil {
+				return fmt.Errorf("failed to load configuration: %w", err)
+			}
+			applyFlags(cfg)
+
+			out := cmd.OutOrStdout()
+			switch format {
+			case "yaml":
+				encoder := yaml.NewEncoder(out)
+				encoder.SetIndent(2)
+				if err := encoder.Encode(cfg.Settings()); err != nil {
+					return fmt.Errorf("failed to write configuration: %w", err)
+				}
+				return encoder.Close()
+			case "json":
+				encoder := json.NewEncoder(out)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(cfg.Settings()); err != nil {
+					return fmt.Errorf("failed to write configuration: %w", err)
+				}
+				return nil
+			default:
+				return fmt.Errorf("unsupported configuration format %q (supported: yaml, json)", format)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&format, "format", "f", "yaml", "Configuration format (yaml, json)")
+
+	return cmd
+}
@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"path/filepath"
+	"ratemykb/output"
+	"strings"
+)
+
+// fileFilter selects processed files by their classification, status and folder
+type fileFilter struct {
+	classification string // Only match files with this classification (case-insensitive)
+	status         string // Only match files with this scanner status (case-insensitive)
+	folder         string // Only match files inside this folder, relative to the target folder
+}
+
+// matches reports whether the file satisfies every filter that is set
+func (f fileFilter) matches(root string, file output.ResultFile) bool {
+	if f.classification != "" && !strings.EqualFold(string(file.Classification), f.classification) {
+		return false
+	}
+
+	if f.status != "" && !strings.EqualFold(string(file.Status), f.status) {
+		return false
+	}
+
+	if f.folder != "" {
+		folderFilter := filepath.ToSlash(filepath.Clean(f.folder))
+		folder := relativeFolder(root, file.Path)
+		if folderFilter != "." && folder != folderFilter && !strings.HasPrefix(folder, folderFilter+"/") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// relativeFolder returns the folder of a file relative to the root, using forward slashes
+func relativeFolder(root, path string) string {
+	relPath, err := filepath.Rel(root, filepath.Dir(path))
+	if err != nil {
+		return filepath.ToSlash(filepath.Dir(path))
+	}
+	return filepath.ToSlash(relPath)
+}
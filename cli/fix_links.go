@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"ratemykb/config"
+	"ratemykb/links"
+	"ratemykb/safewrite"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// newFixLinksCmd creates the command that suggests and applies fixes for broken wiki links
+func newFixLinksCmd() *cobra.Command {
+	var dryRun, force bool
+
+	cmd := &cobra.Command{
+		Use:   "fix-links [target folder]",
+		Short: "Interactively fix broken wiki links",
+		Long: `Find the wiki links that do not resolve to any file in the vault, suggest the closest existing note
+for each one and rewrite the link in place after confirmation.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Resolve and validate the target folder
+			if err := resolveTargetFolder(args); err != nil {
+				return err
+			}
+
+			// Load configuration
+			cfg, err := config.LoadProfile(configFile, profile)
+			if err != nil {
+				return fmt.Errorf("failed to load configuration: %w", err)
+			}
+
+			broken, err := links.FindBroken(targetFolder, cfg.ScanSettings.FileExtension)
+			if err != nil {
+				return err
+			}
+
+			if !dryRun && len(broken) > 0 {
+				if err := guardVault(cmd.OutOrStdout(), cfg, force); err != nil {
+					return err
+				}
+			}
+
+			run := safewrite.Begin(targetFolder, "fix-links")
+			return fixLinks(cmd.OutOrStdout(), bufio.NewReader(cmd.InOrStdin()), run, broken, dryRun)
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "List broken links and suggestions without changing any file")
+	cmd.Flags().BoolVar(&force, "force", false, "Change the notes even if safety.guard cannot make the vault recoverable first")
+
+	return cmd
+}
+
+// fixLinks offers the suggested fix for each broken link and rewrites the confirmed ones
+func fixLinks(out io.Writer, in *bufio.Reader, run *safewrite.Run, broken []links.BrokenLink, dryRun bool) error {
+	if len(broken) == 0 {
+		fmt.Fprintln(out, "No broken links found")
+		return nil
+	}
+
+	// A file may link to the same missing note several times, so only ask once per file and target
+	handled := make(map[string]bool)
+	fixed := 0
+	for _, link := range broken {
+		key := link.Path + "\x00" + link.Target
+		if handled[key] {
+			continue
+		}
+		handled[key] = true
+
+		location := fmt.Sprintf("%s:%d", link.Path, link.Line)
+		if link.Suggestion == "" {
+			fmt.Fprintf(out, "%s: [[%s]] has no close match\n", location, link.Target)
+			continue
+		}
+		if dryRun {
+			fmt.Fprintf(out, "%s: [[%s]] -> [[%s]]\n", location, link.Target, link.Suggestion)
+			continue
+		}
+
+		fmt.Fprintf(out, "%s: replace [[%s]] with [[%s]]? [y]es, [n]o, [q]uit: ", location, link.Target, link.Suggestion)
+		answer, err := in.ReadString('\n')
+		if err != nil && answer == "" {
+			// No more input, stop without changing anything else
+			break
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			changed, err := links.Rewrite(run, link.Path, link.Target, link.Suggestion)
+			if err != nil {
+				return err
+			}
+			if changed {
+				fixed++
+			}
+		case "q", "quit":
+			printFixed(out, run, fixed)
+			return nil
+		}
+	}
+
+	if !dryRun {
+		printFixed(out, run, fixed)
+	}
+	return nil
+}
+
+// printFixed reports the number of fixed links and how to undo the fixes
+func printFixed(out io.Writer, run *safewrite.Run, fixed int) {
+	fmt.Fprintf(out, "Fixed links in %d places\n", fixed)
+	if fixed > 0 {
+		fmt.Fprintf(out, "Undo with: ratemykb undo %s\n", run.ID)
+	}
+}
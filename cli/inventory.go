@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"ratemykb/analysis"
+	"ratemykb/config"
+	"ratemykb/scanner"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// newInventoryCmd creates the command that reports facts about the vault without classifying anything
+func newInventoryCmd() *cobra.Command {
+	var format, outputPath string
+	var depth int
+
+	cmd := &cobra.Command{
+		Use:   "inventory [target folder]",
+		Short: "Report facts about the vault without the AI engine",
+		Long: `Scan the vault and report pure facts about it: notes and words by folder, the distribution of
+note lengths, notes without backlinks and orphans, attachment totals by extension and growth by month.
+No file is classified, so the AI engine is not needed and the stored state is left untouched.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Resolve and validate the target folder
+			if err := resolveTargetFolder(args); err != nil {
+				return err
+			}
+
+			// Load configuration
+			cfg, err := config.LoadProfile(configFile, profile)
+			if err != nil {
+				return fmt.Errorf("failed to load configuration: %w", err)
+			}
+
+			fileScanner, err := scanner.New(cfg)
+			if err != nil {
+				return fmt.Errorf("failed to initialize scanner: %w", err)
+			}
+			files, err := fileScanner.ScanDirectory(targetFolder)
+			if err != nil {
+				return fmt.Errorf("failed to scan directory: %w", err)
+			}
+
+			inventory := analysis.BuildInventory(targetFolder, files, fileScanner.Exclusions(), cfg.Metadata.CreatedField, depth)
+			for _, warning := range inventory.Warnings {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %s\n", warning)
+			}
+
+			out := cmd.OutOrStdout()
+			if outputPath != "" {
+				file, err := os.Create(outputPath)
+				if err != nil {
+					return fmt.Errorf("failed to create %s: %w", outputPath, err)
+				}
+				defer file.Close()
+				out = file
+			}
+
+			switch format {
+			case "markdown":
+				printInventory(out, inventory)
+			case "json":
+				encoder := json.NewEncoder(out)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(inventory); err != nil {
+					return fmt.Errorf("failed to write inventory: %w", err)
+				}
+			default:
+				return fmt.Errorf("unsupported inventory format %q (supported: markdown, json)", format)
+			}
+
+			if outputPath != "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "Inventory available at %s\n", outputPath)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&format, "format", "f", "markdown", "Inventory format (markdown, json)")
+	cmd.Flags().StringVarP(&outputPath, "output", "o", "", "Path of the generated inventory (defaults to standard output)")
+	cmd.Flags().IntVar(&depth, "depth", 1, "Number of folder levels used to group notes (0 for the full path)")
+
+	return cmd
+}
+
+// printInventory writes the inventory as a markdown report
+func printInventory(w io.Writer, inventory analysis.Inventory) {
+	fmt.Fprintf(w, "# Vault Inventory\n\n")
+	fmt.Fprintf(w, "Target folder: `%s`\n\n", targetFolder)
+
+	fmt.Fprintf(w, "## Totals\n\n")
+	fmt.Fprintf(w, "- Notes: %d\n", inventory.Notes)
+	fmt.Fprintf(w, "- Words: %d\n", inventory.Words)
+	fmt.Fprintf(w, "- Median words per note: %d\n", inventory.MedianWords)
+	fmt.Fprintf(w, "- Notes without backlinks: %d\n", inventory.WithoutBacklinks)
+	fmt.Fprintf(w, "- Orphan notes (no links in or out): %d\n\n", inventory.Orphans)
+
+	fmt.Fprintf(w, "## Notes by Folder\n\n")
+	fmt.Fprintf(w, "| Folder | Notes | Words |\n|---|---:|---:|\n")
+	for _, folder := range inventory.Folders {
+		fmt.Fprintf(w, "| %s | %d | %d |\n", folder.Folder, folder.Notes, folder.Words)
+	}
+
+	fmt.Fprintf(w, "\n## Note Length\n\n")
+	fmt.Fprintf(w, "| Words | Notes |\n|---|---:|\n")
+	for _, bucket := range inventory.WordBuckets {
+		fmt.Fprintf(w, "| %s | %d |\n", bucket.Label, bucket.Notes)
+	}
+
+	fmt.Fprintf(w, "\n## Attachments\n\n")
+	if len(inventory.Attachments) == 0 {
+		fmt.Fprintf(w, "No attachments found.\n")
+	} else {
+		fmt.Fprintf(w, "| Extension | Files | Size | Unlinked |\n|---|---:|---:|---:|\n")
+		for _, attachment := range inventory.Attachments {
+			fmt.Fprintf(w, "| %s | %d | %s | %d |\n", attachment.Extension, attachment.Files, formatBytes(attachment.Bytes), attachment.Unlinked)
+		}
+	}
+
+	fmt.Fprintf(w, "\n## Growth\n\n")
+	fmt.Fprintf(w, "| Month | Added | Total |\n|---|---:|---:|\n")
+	for _, month := range inventory.Growth {
+		fmt.Fprintf(w, "| %s | %d | %d |\n", month.Month, month.Added, month.Total)
+	}
+}
+
+// formatBytes returns a size in bytes in the largest unit that keeps it above 1
+func formatBytes(size int64) string {
+	units := []string{"B", "KB", "MB", "GB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", value), ".0") + " " + units[unit]
+}
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The log file given with --log-file, which receives everything printed to the console along with debug details
+// that are only useful when looking into a run afterwards
+var (
+	logMu  sync.Mutex
+	logOut *os.File
+)
+
+// openLog opens the log file, appending to it so the log of earlier runs is kept
+func openLog(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	logMu.Lock()
+	logOut = file
+	logMu.Unlock()
+	return nil
+}
+
+// closeLog records the error a run ended with, if any, and closes the log file
+func closeLog(runErr error) {
+	if runErr != nil {
+		logLine("ERROR", runErr.Error())
+	}
+
+	logMu.Lock()
+	defer logMu.Unlock()
+	if logOut != nil {
+		logOut.Close()
+		logOut = nil
+	}
+}
+
+// printf prints a message to the console and the log file
+func printf(format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	fmt.Print(message)
+
+	level := "INFO"
+	if strings.HasPrefix(message, "Warning:") {
+		level = "WARN"
+	}
+	logLine(level, message)
+}
+
+// debugf writes a message to the log file only
+func debugf(format string, args ...any) {
+	logLine("DEBUG", fmt.Sprintf(format, args...))
+}
+
+// logLine writes each non-empty line of a message to the log file with a timestamp and level
+func logLine(level, message string) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if logOut == nil {
+		return
+	}
+
+	now := time.Now().Format("2006-01-02T15:04:05.000Z07:00")
+	for _, line := range strings.Split(strings.TrimRight(message, "\n"), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fmt.Fprintf(logOut, "%s %-5s %s\n", now, level, line)
+	}
+}
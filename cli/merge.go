@@ -0,0 +1,224 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"ratemykb/classification"
+	"ratemykb/config"
+	"ratemykb/merge"
+	"ratemykb/safewrite"
+	"ratemykb/state"
+	"ratemykb/trash"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// mergeSession holds what is needed to merge duplicate notes interactively
+type mergeSession struct {
+	cfg          *config.Config
+	stateManager *state.ProcessingState
+	classifier   *classification.Classifier // Proposes merged notes, nil to combine the notes line by line
+	changes      *safewrite.Run             // Records every change so the merges can be undone
+	in           *bufio.Reader
+	out          io.Writer
+}
+
+// newMergeCmd creates the command that interactively merges the possible duplicates found by a previous run
+func newMergeCmd() *cobra.Command {
+	var useLLM, force bool
+
+	cmd := &cobra.Command{
+		Use:   "merge [target folder]",
+		Short: "Interactively merge duplicate notes",
+		Long: `Walk through the possible duplicates found by a previous run with embeddings.duplicate_threshold. For
+each pair, show a diff and a proposed merged note, then write the merge, move the removed note to the trash and
+redirect its links.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Resolve and validate the target folder
+			if err := resolveTargetFolder(args); err != nil {
+				return err
+			}
+
+			// Load configuration
+			cfg, err := config.LoadProfile(configFile, profile)
+			if err != nil {
+				return fmt.Errorf("failed to load configuration: %w", err)
+			}
+
+			// Load the stored state
+			stateManager, err := state.Load(targetFolder)
+			if errors.Is(err, state.ErrNoState) {
+				return err
+			}
+			if err != nil {
+				return fmt.Errorf("failed to load state: %w", err)
+			}
+			if err := configureReport(stateManager, cfg); err != nil {
+				return err
+			}
+
+			if len(stateManager.Duplicates) > 0 {
+				if err := guardVault(cmd.OutOrStdout(), cfg, force); err != nil {
+					return err
+				}
+			}
+
+			session := &mergeSession{
+				cfg:          cfg,
+				stateManager: stateManager,
+				changes:      safewrite.Begin(targetFolder, "merge"),
+				in:           bufio.NewReader(cmd.InOrStdin()),
+				out:          cmd.OutOrStdout(),
+			}
+
+			if useLLM {
+				session.classifier, err = classification.New(cfg)
+				if err != nil {
+					return fmt.Errorf("failed to initialize classifier: %w", err)
+				}
+			}
+
+			return session.run()
+		},
+	}
+
+	cmd.Flags().BoolVar(&useLLM, "llm", false, "Ask the AI engine to propose the merged note")
+	cmd.Flags().BoolVar(&force, "force", false, "Change the notes even if safety.guard cannot make the vault recoverable first")
+
+	return cmd
+}
+
+// run offers to merge every other note of each duplicate group into the first one
+func (s *mergeSession) run() error {
+	groups := s.stateManager.Duplicates
+	if len(groups) == 0 {
+		fmt.Fprintln(s.out, "No duplicate notes found")
+		return nil
+	}
+
+	merged := 0
+	for _, group := range groups {
+		kept := group.Paths[0]
+		for _, other := range group.Paths[1:] {
+			done, quit, err := s.offer(kept, other)
+			if err != nil {
+				return err
+			}
+			if quit {
+				s.summary(merged)
+				return nil
+			}
+			if done != "" {
+				merged++
+				kept = done
+			}
+		}
+	}
+
+	s.summary(merged)
+	return nil
+}
+
+// summary reports the number of merged notes and how to undo them
+func (s *mergeSession) summary(merged int) {
+	fmt.Fprintf(s.out, "Merged %d notes\n", merged)
+	if merged > 0 {
+		fmt.Fprintf(s.out, "Undo with: ratemykb undo %s\n", s.changes.ID)
+	}
+}
+
+// offer shows the diff and proposed merge of two notes and asks what to do
+// It returns the path of the kept note if the notes were merged, and whether the user wants to stop
+func (s *mergeSession) offer(kept, removed string) (string, bool, error) {
+	for {
+		keptContent, err := os.ReadFile(kept)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to read %s: %w", kept, err)
+		}
+		removedContent, err := os.ReadFile(removed)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to read %s: %w", removed, err)
+		}
+
+		fmt.Fprintf(s.out, "\n--- %s\n+++ %s\n", kept, removed)
+		fmt.Fprint(s.out, merge.FormatDiff(merge.Diff(string(keptContent), string(removedContent))))
+
+		proposal := s.propose(string(keptContent), string(removedContent))
+		fmt.Fprintf(s.out, "\nProposed merge into %s:\n%s\n", kept, proposal)
+
+		answer := s.ask(fmt.Sprintf("Merge %s into %s? [y]es, [s]wap, [n]o, [q]uit: ", removed, kept))
+		switch answer {
+		case "y", "yes":
+			if err := s.apply(kept, removed, proposal); err != nil {
+				return "", false, err
+			}
+			return kept, false, nil
+		case "s", "swap":
+			kept, removed = removed, kept
+		case "n", "no":
+			return "", false, nil
+		default:
+			// Quit, or no more input, stop without merging
+			return "", true, nil
+		}
+	}
+}
+
+// ask shows the question until the answer is one of the choices, and returns it in lower case
+// It returns an empty answer if the input ends first.
+func (s *mergeSession) ask(question string) string {
+	for {
+		fmt.Fprint(s.out, question)
+		answer, err := s.in.ReadString('\n')
+		if err != nil && answer == "" {
+			return ""
+		}
+		switch answer = strings.ToLower(strings.TrimSpace(answer)); answer {
+		case "y", "yes", "s", "swap", "n", "no", "q", "quit":
+			return answer
+		}
+		fmt.Fprintf(s.out, "Please answer y, s, n or q\n")
+	}
+}
+
+// propose returns the merged note, asking the AI engine if enabled
+func (s *mergeSession) propose(keptContent, removedContent string) string {
+	if s.classifier != nil {
+		proposal, err := s.classifier.ProposeMerge(keptContent, removedContent)
+		if err == nil {
+			return strings.TrimSpace(proposal) + "\n"
+		}
+		fmt.Fprintf(s.out, "Warning: Could not get a merge proposal, combining the notes instead: %v\n", err)
+	}
+
+	return merge.Combine(keptContent, removedContent)
+}
+
+// apply writes the merged note, moves the removed note to the trash and redirects its links
+func (s *mergeSession) apply(kept, removed, merged string) error {
+	if err := s.changes.WriteFile(kept, []byte(merged)); err != nil {
+		return err
+	}
+
+	// Move the removed note to the trash so Obsidian can still restore it
+	if _, err := trash.Move(s.changes, targetFolder, []string{removed}); err != nil {
+		return err
+	}
+
+	changed, err := merge.RedirectLinks(s.changes, targetFolder, s.cfg.ScanSettings.FileExtension, removed, kept)
+	if err != nil {
+		return fmt.Errorf("failed to redirect links: %w", err)
+	}
+	fmt.Fprintf(s.out, "Merged %s into %s and updated links in %d files\n", removed, kept, changed)
+
+	if err := s.stateManager.RemoveProcessedFile(removed); err != nil {
+		fmt.Fprintf(s.out, "Warning: Could not update report for %s: %v\n", removed, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"ratemykb/output"
+	"ratemykb/state"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+// queryOptions holds the flags of the query command
+type queryOptions struct {
+	fileFilter
+	relative bool // Print paths relative to the target folder
+	null     bool // Separate paths with NUL characters instead of newlines
+}
+
+// newQueryCmd creates the command that prints the paths of files matching the filters
+func newQueryCmd() *cobra.Command {
+	opts := queryOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "query [target folder]",
+		Short: "Print the paths of processed files matching filters",
+		Long: `Print the paths of the files processed by a previous run that match all of the given filters,
+one per line, for use in shell pipelines. For example, to review every empty file:
+
+  ratemykb query ./vault --classification Empty | xargs -o rm -i`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Resolve and validate the target folder
+			if err := resolveTargetFolder(args); err != nil {
+				return err
+			}
+
+			// Load the stored state
+			stateManager, err := state.Load(targetFolder)
+			if errors.Is(err, state.ErrNoState) {
+				return err
+			}
+			if err != nil {
+				return fmt.Errorf("failed to load state: %w", err)
+			}
+
+			printQuery(cmd.OutOrStdout(), queryPaths(targetFolder, stateManager.GetProcessedFiles(), opts), opts.null)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.classification, "classification", "", "Only print files with this classification")
+	cmd.Flags().StringVar(&opts.status, "status", "", "Only print files with this status")
+	cmd.Flags().StringVar(&opts.folder, "folder", "", "Only print files inside this folder (relative to the target folder)")
+	cmd.Flags().BoolVar(&opts.relative, "relative", false, "Print paths relative to the target folder")
+	cmd.Flags().BoolVarP(&opts.null, "null", "0", false, "Separate paths with NUL characters (for xargs -0)")
+
+	return cmd
+}
+
+// queryPaths returns the sorted paths of the processed files matching the filters
+func queryPaths(root string, files map[string]output.ResultFile, opts queryOptions) []string {
+	var paths []string
+	for _, file := range files {
+		if !opts.matches(root, file) {
+			continue
+		}
+
+		path := file.Path
+		if opts.relative {
+			if relPath, err := filepath.Rel(root, file.Path); err == nil {
+				path = relPath
+			}
+		}
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+// printQuery writes one path per line, or NUL separated paths
+func printQuery(w io.Writer, paths []string, null bool) {
+	separator := "\n"
+	if null {
+		separator = "\x00"
+	}
+
+	for _, path := range paths {
+		fmt.Fprint(w, path+separator)
+	}
+}
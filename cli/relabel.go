@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"ratemykb/config"
+	"ratemykb/state"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// newRelabelCmd creates the command that maps old labels to new ones after the label set changed
+func newRelabelCmd() *cobra.Command {
+	var mappings []string
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "relabel [target folder]",
+		Short: "Map old labels to new ones in the stored state",
+		Long: `When the labels of the prompt change, map the old labels to the new ones in the stored state
+and regenerate the report, instead of classifying every file again. Old labels are matched
+case-insensitively. Map a label to nothing, as in --map "Borderline=", to have only those files
+classified again on the next run.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Resolve and validate the target folder
+			if err := resolveTargetFolder(args); err != nil {
+				return err
+			}
+
+			mapping, err := parseMappings(mappings)
+			if err != nil {
+				return err
+			}
+
+			// Load configuration
+			cfg, err := config.LoadProfile(configFile, profile)
+			if err != nil {
+				return fmt.Errorf("failed to load configuration: %w", err)
+			}
+
+			// Load the stored state
+			stateManager, err := state.Load(targetFolder)
+			if errors.Is(err, state.ErrNoState) {
+				return err
+			}
+			if err != nil {
+				return fmt.Errorf("failed to load state: %w", err)
+			}
+			if err := configureReport(stateManager, cfg); err != nil {
+				return err
+			}
+
+			var counts map[string]int
+			if dryRun {
+				counts = stateManager.RelabelCounts(mapping)
+			} else if counts, err = stateManager.Relabel(mapping); err != nil {
+				return fmt.Errorf("failed to update state: %w", err)
+			}
+
+			out := cmd.OutOrStdout()
+			olds := make([]string, 0, len(mapping))
+			for old := range mapping {
+				olds = append(olds, old)
+			}
+			sort.Strings(olds)
+			for _, old := range olds {
+				if mapping[old] == "" {
+					fmt.Fprintf(out, "%s: %d files to classify again\n", old, counts[old])
+				} else {
+					fmt.Fprintf(out, "%s -> %s: %d files\n", old, mapping[old], counts[old])
+				}
+			}
+			if dryRun {
+				fmt.Fprintln(out, "Dry run, nothing was changed")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringArrayVar(&mappings, "map", nil, "Old label and the new one, such as \"Low quality/low effort=Low quality\" (repeatable)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show how many files would change without changing them")
+
+	return cmd
+}
+
+// parseMappings parses old=new label mappings
+func parseMappings(mappings []string) (map[string]string, error) {
+	if len(mappings) == 0 {
+		return nil, fmt.Errorf("at least one --map old=new is required")
+	}
+
+	mapping := make(map[string]string)
+	for _, m := range mappings {
+		old, label, ok := strings.Cut(m, "=")
+		old, label = strings.TrimSpace(old), strings.TrimSpace(label)
+		if !ok || old == "" {
+			return nil, fmt.Errorf("invalid label mapping %q, expected old=new", m)
+		}
+		mapping[old] = label
+	}
+	return mapping, nil
+}
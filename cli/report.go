@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"ratemykb/config"
+	"ratemykb/state"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// newReportCmd creates the command that regenerates a report from the stored state
+func newReportCmd() *cobra.Command {
+	var format, outputPath string
+
+	cmd := &cobra.Command{
+		Use:   "report [target folder]",
+		Short: "Regenerate the report from stored state",
+		Long: `Regenerate the report in any supported format purely from the state stored by a previous run,
+without rescanning or reclassifying any files.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Resolve and validate the target folder
+			if err := resolveTargetFolder(args); err != nil {
+				return err
+			}
+
+			// Load configuration
+			cfg, err := config.LoadProfile(configFile, profile)
+			if err != nil {
+				return fmt.Errorf("failed to load configuration: %w", err)
+			}
+
+			// Load the stored state
+			stateManager, err := state.Load(targetFolder)
+			if errors.Is(err, state.ErrNoState) {
+				return err
+			}
+			if err != nil {
+				return fmt.Errorf("failed to load state: %w", err)
+			}
+			if err := configureReport(stateManager, cfg); err != nil {
+				return err
+			}
+
+			// Default to the standard report name with the extension of the format
+			if outputPath == "" {
+				outputPath = filepath.Join(targetFolder, "vault-quality-report"+state.FormatExtension(format))
+			}
+
+			if err := stateManager.WriteReport(format, outputPath); err != nil {
+				return fmt.Errorf("failed to write report: %w", err)
+			}
+
+			fmt.Printf("Report available at %s\n", outputPath)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&format, "format", "f", state.FormatMarkdown, "Report format ("+strings.Join(state.ReportFormats, ", ")+")")
+	cmd.Flags().StringVarP(&outputPath, "output", "o", "", "Path of the generated report (defaults to the target folder)")
+
+	return cmd
+}
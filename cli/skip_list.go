@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"ratemykb/state"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// newSkipListCmd creates the command that shows or clears the files skipped after failing too many runs in a row
+func newSkipListCmd() *cobra.Command {
+	var clear bool
+
+	cmd := &cobra.Command{
+		Use:   "skip-list [target folder]",
+		Short: "Show or clear the files skipped after failing repeatedly",
+		Long: `Files that fail to be classified scan_settings.skip_after_failures runs in a row are added
+to a skip list so they stop slowing down every run. Show the list with the last error of each file,
+or clear it with --clear so the files are classified again on the next run.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Resolve and validate the target folder
+			if err := resolveTargetFolder(args); err != nil {
+				return err
+			}
+
+			// Load the stored state
+			stateManager, err := state.Load(targetFolder)
+			if errors.Is(err, state.ErrNoState) {
+				return err
+			}
+			if err != nil {
+				return fmt.Errorf("failed to load state: %w", err)
+			}
+
+			out := cmd.OutOrStdout()
+			if clear {
+				cleared, err := stateManager.ClearSkipList()
+				if err != nil {
+					return fmt.Errorf("failed to clear skip list: %w", err)
+				}
+				fmt.Fprintf(out, "Cleared %d files from the skip list, they are classified again on the next run\n", cleared)
+				return nil
+			}
+
+			return printSkipList(out, stateManager)
+		},
+	}
+
+	cmd.Flags().BoolVar(&clear, "clear", false, "Clear the skip list so the files are classified again on the next run")
+
+	return cmd
+}
+
+// printSkipList writes the skipped files with their number of failed runs and last error as a table
+func printSkipList(out io.Writer, stateManager *state.ProcessingState) error {
+	skipped := stateManager.SkippedFiles()
+	if len(skipped) == 0 {
+		fmt.Fprintln(out, "No skipped files")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tFAILED RUNS\tLAST ERROR")
+	for _, path := range skipped {
+		relPath, err := filepath.Rel(targetFolder, path)
+		if err != nil {
+			relPath = path
+		}
+		failure := stateManager.Failures[path]
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", relPath, failure.Runs, failure.LastError)
+	}
+	return tw.Flush()
+}
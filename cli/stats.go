@@ -0,0 +1,255 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"ratemykb/output"
+	"ratemykb/runs"
+	"ratemykb/state"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// statsOptions holds the flags of the stats command
+type statsOptions struct {
+	fileFilter
+	by     string // Grouping: classification, folder or status
+	depth  int    // Number of folder levels used when grouping by folder
+	sortBy string // Sort order: count or name
+
+	history bool // Summarize the recorded runs instead of the stored state
+	last    int  // Number of most recent runs summarized (0 for all)
+}
+
+// statsRow is a single row of the statistics table
+type statsRow struct {
+	group string
+	count int
+}
+
+// newStatsCmd creates the command that prints statistics from the stored state
+func newStatsCmd() *cobra.Command {
+	opts := statsOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "stats [target folder]",
+		Short: "Print statistics from stored state",
+		Long: `Print tabular statistics about the files processed by a previous run,
+grouped by classification, folder or status, without opening the report.
+With --history, summarize the recorded runs instead: throughput, model changes and how the
+share of each label drifted from run to run. Nothing leaves the machine.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Resolve and validate the target folder
+			if err := resolveTargetFolder(args); err != nil {
+				return err
+			}
+
+			if opts.history {
+				manifests, err := runs.List(targetFolder)
+				if err != nil {
+					return err
+				}
+				printHistory(cmd.OutOrStdout(), manifests, opts.last)
+				return nil
+			}
+
+			// Load the stored state
+			stateManager, err := state.Load(targetFolder)
+			if errors.Is(err, state.ErrNoState) {
+				return err
+			}
+			if err != nil {
+				return fmt.Errorf("failed to load state: %w", err)
+			}
+
+			rows, total, err := computeStats(targetFolder, stateManager.GetProcessedFiles(), opts)
+			if err != nil {
+				return err
+			}
+
+			printStats(cmd.OutOrStdout(), opts.by, rows, total)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.by, "by", "classification", "Group files by classification, folder or status")
+	cmd.Flags().StringVar(&opts.classification, "classification", "", "Only count files with this classification")
+	cmd.Flags().StringVar(&opts.status, "status", "", "Only count files with this status")
+	cmd.Flags().StringVar(&opts.folder, "folder", "", "Only count files inside this folder (relative to the target folder)")
+	cmd.Flags().IntVar(&opts.depth, "depth", 1, "Number of folder levels used when grouping by folder (0 for the full path)")
+	cmd.Flags().StringVar(&opts.sortBy, "sort", "count", "Sort rows by count or name")
+	cmd.Flags().BoolVar(&opts.history, "history", false, "Summarize the recorded runs: throughput, model changes and label drift")
+	cmd.Flags().IntVar(&opts.last, "last", 10, "Number of most recent runs summarized with --history (0 for all)")
+
+	return cmd
+}
+
+// computeStats groups the processed files according to the options and returns the rows and the total count
+func computeStats(root string, files map[string]output.ResultFile, opts statsOptions) ([]statsRow, int, error) {
+	counts := make(map[string]int)
+	total := 0
+	for _, file := range files {
+		// Apply filters
+		if !opts.matches(root, file) {
+			continue
+		}
+
+		var group string
+		switch opts.by {
+		case "classification":
+			group = string(file.Classification)
+		case "status":
+			group = string(file.Status)
+		case "folder":
+			group = truncateFolder(relativeFolder(root, file.Path), opts.depth)
+		default:
+			return nil, 0, fmt.Errorf("unsupported grouping %q (supported: classification, folder, status)", opts.by)
+		}
+
+		counts[group]++
+		total++
+	}
+
+	var rows []statsRow
+	for group, count := range counts {
+		rows = append(rows, statsRow{group: group, count: count})
+	}
+
+	switch opts.sortBy {
+	case "count":
+		sort.Slice(rows, func(i, j int) bool {
+			if rows[i].count != rows[j].count {
+				return rows[i].count > rows[j].count
+			}
+			return rows[i].group < rows[j].group
+		})
+	case "name":
+		sort.Slice(rows, func(i, j int) bool {
+			return rows[i].group < rows[j].group
+		})
+	default:
+		return nil, 0, fmt.Errorf("unsupported sort order %q (supported: count, name)", opts.sortBy)
+	}
+
+	return rows, total, nil
+}
+
+// printStats writes the statistics as an aligned table
+func printStats(w io.Writer, by string, rows []statsRow, total int) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tFILES\tPERCENT\n", strings.ToUpper(by))
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\n", row.group, row.count, float64(row.count)/float64(total)*100)
+	}
+	fmt.Fprintf(tw, "TOTAL\t%d\t\n", total)
+	tw.Flush()
+}
+
+// printHistory writes the throughput, model changes and label drift of the last recorded scans, oldest first
+func printHistory(w io.Writer, manifests []runs.Manifest, last int) {
+	var scans []runs.Manifest
+	for _, manifest := range manifests {
+		if manifest.Command == "scan" {
+			scans = append(scans, manifest)
+		}
+	}
+	if last > 0 && len(scans) > last {
+		scans = scans[len(scans)-last:]
+	}
+	if len(scans) == 0 {
+		fmt.Fprintln(w, "No runs recorded yet")
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN ID\tMODEL\tDURATION\tCLASSIFIED\tFILES/HOUR\tTOKENS")
+	for _, scan := range scans {
+		classified := scan.Counts["files_classified"]
+		duration, rate := "interrupted", "-"
+		if scan.Finished != nil {
+			elapsed := scan.Finished.Sub(scan.Started)
+			duration = elapsed.Round(time.Second).String()
+			if elapsed > 0 && classified > 0 {
+				rate = fmt.Sprintf("%.1f", float64(classified)/elapsed.Hours())
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%d\n", scan.ID, scan.Model, duration, classified, rate, scan.TokensUsed)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w, "\nModel changes:")
+	changes := 0
+	for i := 1; i < len(scans); i++ {
+		if scans[i].Model != scans[i-1].Model {
+			fmt.Fprintf(w, "  %s: %s -> %s\n", scans[i].ID, scans[i-1].Model, scans[i].Model)
+			changes++
+		}
+	}
+	if changes == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+
+	// Share of each label at the end of every run that recorded them, with the change from the first to the last
+	var labeled []runs.Manifest
+	labels := make(map[string]bool)
+	for _, scan := range scans {
+		if len(scan.Labels) == 0 {
+			continue
+		}
+		labeled = append(labeled, scan)
+		for label := range scan.Labels {
+			labels[label] = true
+		}
+	}
+	if len(labeled) == 0 {
+		return
+	}
+	sortedLabels := make([]string, 0, len(labels))
+	for label := range labels {
+		sortedLabels = append(sortedLabels, label)
+	}
+	sort.Strings(sortedLabels)
+
+	share := func(scan runs.Manifest, label string) float64 {
+		total := 0
+		for _, count := range scan.Labels {
+			total += count
+		}
+		return float64(scan.Labels[label]) / float64(total) * 100
+	}
+
+	fmt.Fprintln(w, "\nLabel drift (share of processed files):")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "LABEL")
+	for _, scan := range labeled {
+		fmt.Fprintf(tw, "\t%s", scan.ID)
+	}
+	fmt.Fprintln(tw, "\tCHANGE")
+	for _, label := range sortedLabels {
+		fmt.Fprint(tw, label)
+		for _, scan := range labeled {
+			fmt.Fprintf(tw, "\t%.1f%%", share(scan, label))
+		}
+		fmt.Fprintf(tw, "\t%+.1f pts\n", share(labeled[len(labeled)-1], label)-share(labeled[0], label))
+	}
+	tw.Flush()
+}
+
+// truncateFolder keeps only the first depth levels of a folder path
+func truncateFolder(folder string, depth int) string {
+	if depth <= 0 {
+		return folder
+	}
+
+	parts := strings.Split(folder, "/")
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,173 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"ratemykb/classification"
+	"ratemykb/gates"
+	"ratemykb/output"
+	"ratemykb/runs"
+	"ratemykb/state"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ANSI escape codes used to color the summary
+const (
+	colorReset  = "\033[0m"
+	colorBold   = "\033[1m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorGray   = "\033[90m"
+)
+
+// useColor reports whether output to w should be colored: only terminals are, and never when NO_COLOR is set
+// See https://no-color.org
+func useColor(w io.Writer) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// summaryRow is a single classification of the summary table
+type summaryRow struct {
+	label string
+	count int
+	color string
+}
+
+// printSummary writes the totals of the run and the number of files per classification as aligned columns,
+// most files first
+// With color, passing labels are green, files without content red, files that could not be classified gray
+// and any other label yellow.
+func printSummary(w io.Writer, color bool, processed map[string]output.ResultFile, newlyProcessed, alreadyProcessed int, passingLabels []string) {
+	passing := make(map[string]bool)
+	for _, label := range passingLabels {
+		passing[strings.ToLower(label)] = true
+	}
+
+	counts := make(map[string]int)
+	for _, file := range processed {
+		counts[string(file.Classification)]++
+	}
+
+	var rows []summaryRow
+	width := len("CLASSIFICATION")
+	for label, count := range counts {
+		row := summaryRow{label: label, count: count, color: colorYellow}
+		switch {
+		case passing[strings.ToLower(label)]:
+			row.color = colorGreen
+		case classification.Parse(label).Severity() == classification.SeverityEmpty || classification.Parse(label) == classification.NotMarkdown:
+			row.color = colorRed
+		case classification.Parse(label).Severity() == classification.SeverityNone:
+			row.color = colorGray
+		}
+		rows = append(rows, row)
+		width = max(width, utf8.RuneCountInString(label))
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].count != rows[j].count {
+			return rows[i].count > rows[j].count
+		}
+		return rows[i].label < rows[j].label
+	})
+
+	paint := func(code, text string) string {
+		if !color {
+			return text
+		}
+		return code + text + colorReset
+	}
+
+	fmt.Fprintln(w, paint(colorBold, "Processing complete"))
+	fmt.Fprintf(w, "  %-18s %7d\n", "New files:", newlyProcessed)
+	fmt.Fprintf(w, "  %-18s %7d\n", "Already processed:", alreadyProcessed)
+	fmt.Fprintf(w, "  %-18s %7d\n\n", "Total:", len(processed))
+
+	fmt.Fprintln(w, paint(colorBold, fmt.Sprintf("%-*s  %7s  %7s", width, "CLASSIFICATION", "FILES", "PERCENT")))
+	for _, row := range rows {
+		line := fmt.Sprintf("%-*s  %7d  %6.1f%%", width, row.label, row.count, float64(row.count)/float64(len(processed))*100)
+		fmt.Fprintln(w, paint(row.color, line))
+	}
+	fmt.Fprintln(w, paint(colorBold, fmt.Sprintf("%-*s  %7d", width, "TOTAL", len(processed))))
+}
+
+// SummaryFile is the name of the run summary written next to the report at the end of every scan
+const SummaryFile = "summary.json"
+
+// runSummary is the structure of the run summary, a stable artifact for CI jobs
+type runSummary struct {
+	RunID        string               `json:"run_id"`
+	Finished     string               `json:"finished"` // RFC 3339
+	TargetFolder string               `json:"target_folder"`
+	Counts       map[string]int       `json:"counts"` // Files found, classified and processed by the run
+	Labels       map[string]int       `json:"labels"` // Processed files by classification
+	AverageScore float64              `json:"average_score,omitempty"`
+	Ratings      *state.RatingSummary `json:"ratings,omitempty"`
+	TokensUsed   int                  `json:"tokens_used"`
+	Gates        []gateSummary        `json:"gates"`  // Every quality gate evaluated, in configuration order
+	Status       string               `json:"status"` // passed, or failed when a quality gate failed
+	ExitCode     int                  `json:"exit_code"`
+}
+
+// gateSummary is the outcome of a quality gate in the run summary
+type gateSummary struct {
+	Scope  string `json:"scope"`
+	Gate   string `json:"gate"`
+	Count  int    `json:"count"`
+	Total  int    `json:"total"`
+	Passed bool   `json:"passed"`
+}
+
+// writeRunSummary writes the counts, scores and quality gates of a finished run as JSON
+func writeRunSummary(path string, run *runs.Run, stateManager *state.ProcessingState, gateResults []gates.Result) error {
+	summary := runSummary{
+		RunID:        run.ID,
+		Finished:     time.Now().Format(time.RFC3339),
+		TargetFolder: stateManager.TargetFolder,
+		Counts:       run.Counts,
+		Labels:       run.Labels,
+		AverageScore: stateManager.AverageScore(),
+		Ratings:      stateManager.RatingSummary(),
+		TokensUsed:   run.TokensUsed,
+		Gates:        []gateSummary{},
+		Status:       "passed",
+	}
+	if run.Finished != nil {
+		summary.Finished = run.Finished.Format(time.RFC3339)
+	}
+	for _, result := range gateResults {
+		summary.Gates = append(summary.Gates, gateSummary{
+			Scope:  result.Scope,
+			Gate:   result.Gate.Expression,
+			Count:  result.Count,
+			Total:  result.Total,
+			Passed: !result.Failed,
+		})
+		if result.Failed {
+			summary.Status = "failed"
+			summary.ExitCode = 1
+		}
+	}
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode run summary: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write run summary: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"ratemykb/config"
+	"ratemykb/safewrite"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// newUndoCmd creates the command that reverts the changes made to notes by a previous run
+func newUndoCmd() *cobra.Command {
+	var list, force bool
+
+	cmd := &cobra.Command{
+		Use:   "undo <run-id> [target folder]",
+		Short: "Undo the changes a command made to notes",
+		Long: `Every command that changes notes (clean-empty, merge, fix-links) backs up the files it changes
+under .ratemykb/backups/<run-id>/. Undo restores them and moves trashed notes back.
+Use --list to show the recorded runs.`,
+		Args: cobra.MaximumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			if list {
+				if err := resolveTargetFolder(args); err != nil {
+					return err
+				}
+				return listRuns(out)
+			}
+
+			if len(args) == 0 {
+				return fmt.Errorf("run ID is required (use --list to show recorded runs)")
+			}
+			if err := resolveTargetFolder(args[1:]); err != nil {
+				return err
+			}
+
+			return undoRun(out, args[0], force)
+		},
+	}
+
+	cmd.Flags().BoolVar(&list, "list", false, "List the recorded runs that can be undone")
+	cmd.Flags().BoolVar(&force, "force", false, "Restore files even if they were edited after the run")
+
+	return cmd
+}
+
+// listRuns writes the recorded runs of the target folder as a table
+func listRuns(out io.Writer) error {
+	manifests, err := safewrite.List(targetFolder)
+	if err != nil {
+		return err
+	}
+	if len(manifests) == 0 {
+		fmt.Fprintln(out, "No runs to undo")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN ID\tCOMMAND\tCHANGES")
+	for _, manifest := range manifests {
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", manifest.ID, manifest.Command, len(manifest.Entries))
+	}
+	return tw.Flush()
+}
+
+// undoRun reverts a recorded run of the target folder
+func undoRun(out io.Writer, runID string, force bool) error {
+	reverted, err := safewrite.Undo(targetFolder, runID, force)
+	fmt.Fprintf(out, "Reverted %d changes\n", reverted)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, "Run a scan to bring the report up to date")
+	return nil
+}
+
+// guardVault makes sure the target folder can be recovered before a command changes notes in bulk, as set by
+// safety.guard, and refuses to go on otherwise unless forced
+func guardVault(out io.Writer, cfg *config.Config, force bool) error {
+	note, err := safewrite.Guard(targetFolder, cfg.Safety.Guard)
+	if err != nil {
+		if !force {
+			return fmt.Errorf("%w (use --force to change the notes anyway)", err)
+		}
+		fmt.Fprintf(out, "Warning: %v, going on because of --force\n", err)
+		return nil
+	}
+	if note != "" {
+		fmt.Fprintln(out, note)
+	}
+	return nil
+}
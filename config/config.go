@@ -4,33 +4,237 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
+// StateDir is the directory inside the target folder where ratemykb keeps its own files
+const StateDir = ".ratemykb"
+
+// TrashDir is the folder Obsidian moves deleted notes to, which is never part of the vault
+const TrashDir = ".trash"
+
+// RollupFile is the name of the rollup written into each top-level folder, which is never classified
+const RollupFile = "_quality.md"
+
 // Config represents the application configuration structure
 type Config struct {
 	AIEngine      AIEngineConfig      `mapstructure:"ai_engine"`
 	ScanSettings  ScanSettingsConfig  `mapstructure:"scan_settings"`
 	PromptConfig  PromptConfig        `mapstructure:"prompt_config"`
+	Prompts       []PromptOverride    `mapstructure:"prompts"` // Checked in order, the first override matching a file applies
 	ExclusionFile ExclusionFileConfig `mapstructure:"exclusion_file"`
+	Throttle      ThrottleConfig      `mapstructure:"throttle"`
+	LongNotes     LongNotesConfig     `mapstructure:"long_notes"`
+	Suggestions   SuggestionsConfig   `mapstructure:"suggestions"`
+	Tags          TagsConfig          `mapstructure:"tags"`
+	Summaries     SummariesConfig     `mapstructure:"summaries"`
+	TitleCheck    TitleCheckConfig    `mapstructure:"title_check"`
+	Sources       SourcesConfig       `mapstructure:"sources"`
+	Outliers      OutliersConfig      `mapstructure:"outliers"`
+	Metadata      MetadataConfig      `mapstructure:"metadata"`
+	Embeddings    EmbeddingsConfig    `mapstructure:"embeddings"`
+	Report        ReportConfig        `mapstructure:"report"`
+	Concurrency   ConcurrencyConfig   `mapstructure:"concurrency"`
+	Attachments   AttachmentsConfig   `mapstructure:"attachments"`
+	QualityGates  QualityGatesConfig  `mapstructure:"quality_gates"`
+	Scoring       ScoringConfig       `mapstructure:"scoring"`
+	Safety        SafetyConfig        `mapstructure:"safety"`
+	Profile       string              `mapstructure:"profile"` // Preset whose defaults were applied, empty for none
+}
+
+// ProfileDocsRepo presets the defaults for a repository of technical docs, such as a Docusaurus or MkDocs site
+const ProfileDocsRepo = "docs-repo"
+
+// profiles maps each profile to the defaults it changes, which the configuration file and environment still override
+var profiles = map[string]map[string]any{
+	ProfileDocsRepo: {
+		// Docs sites link pages with relative markdown links and have no Obsidian vault to open notes in
+		"report.link_style":    "markdown",
+		"report.obsidian_uris": false,
+		"scan_settings.exclude_directories": []string{
+			".git", ".github", "node_modules", "build", "site", ".docusaurus", "vendor",
+		},
+		// Docs repositories are git worktrees, so a clean one is the natural safety net
+		"safety.guard": GuardGit,
+		"prompt_config.quality_classification_prompt": "Review this page of technical documentation and determine if it's: " +
+			"'Empty', 'Low quality/low effort', or 'Good enough'. A page is good enough when a reader can act on " +
+			"it without asking its author.\n\n{{ content }}",
+	},
+}
+
+// AI engine providers
+const (
+	ProviderOllama    = "ollama"    // Ollama server
+	ProviderOpenAI    = "openai"    // Any OpenAI-compatible API, such as OpenAI, vLLM, LM Studio or LiteLLM
+	ProviderAnthropic = "anthropic" // Anthropic API serving Claude models
+
+	ProviderAzureOpenAI = "azure_openai" // Azure OpenAI resource, addressed by deployment name
+)
+
+// EngineModel returns the model an engine is asked for: the deployment with Azure OpenAI, the model otherwise
+func EngineModel(provider, model, deployment string) string {
+	if provider == ProviderAzureOpenAI {
+		return deployment
+	}
+	return model
+}
+
+// DefaultURLs maps each provider to the URL used when ai_engine.url is empty
+var DefaultURLs = map[string]string{
+	ProviderOllama:    "http://localhost:11434/",
+	ProviderOpenAI:    "https://api.openai.com/v1",
+	ProviderAnthropic: "https://api.anthropic.com/v1",
 }
 
 // AIEngineConfig represents the AI engine configuration
 type AIEngineConfig struct {
-	URL   string `mapstructure:"url"`
-	Model string `mapstructure:"model"`
+	Provider          string        `mapstructure:"provider"`         // API spoken by the engine: ollama, openai, anthropic or azure_openai
+	URL               string        `mapstructure:"url"`              // Empty for the default URL of the provider
+	APIKey            string        `mapstructure:"api_key" json:"-"` // Never recorded in run manifests
+	Deployment        string        `mapstructure:"deployment"`       // Azure OpenAI deployment classifying files, used instead of the model
+	APIVersion        string        `mapstructure:"api_version"`      // Azure OpenAI API version
+	Model             string        `mapstructure:"model"`
+	Timeout           time.Duration `mapstructure:"timeout"`             // Time allowed to classify a single file (0 for no limit)
+	RequestTimeout    time.Duration `mapstructure:"request_timeout"`     // Time allowed for a single request before it is retried (0 for no limit)
+	WarmUp            bool          `mapstructure:"warm_up"`             // Load the model with a tiny request before classifying the first file
+	Samples           int           `mapstructure:"samples"`             // Number of times each file is classified, taking the majority label
+	SampleTemperature float64       `mapstructure:"sample_temperature"`  // Temperature used when taking more than one sample
+	VisionModel       string        `mapstructure:"vision_model"`        // Multimodal model for notes that are mostly images, empty to disable
+	MaxImages         int           `mapstructure:"max_images"`          // Maximum number of images passed to the vision model per note
+	Retries           int           `mapstructure:"retries"`             // Times a request failing with a transient error is retried (0 disables)
+	RetryBackoff      time.Duration `mapstructure:"retry_backoff"`       // Pause before the first retry, doubled after each one, with jitter
+	RetryMaxBackoff   time.Duration `mapstructure:"retry_max_backoff"`   // Longest pause between retries
+	RequestsPerMinute int           `mapstructure:"requests_per_minute"` // Requests sent to the engine per minute across all workers (0 for no limit)
+	TokensPerMinute   int           `mapstructure:"tokens_per_minute"`   // Tokens used per minute across all workers before requests wait (0 for no limit)
+	Cache             bool          `mapstructure:"cache"`               // Reuse the verdicts for content classified before with the same settings
+	StructuredOutput  bool          `mapstructure:"structured_output"`   // Constrain Ollama models to the JSON schema of the answer instead of function calling
+
+	// Options are the generation parameters passed to the model on every request
+	Options GenerationOptions `mapstructure:"options"`
+
+	// Escalation hands the files a model labels with one of the stage labels to a larger model, in order
+	Escalation []EscalationStage `mapstructure:"escalation"`
+
+	// Fallbacks are tried in order when the engine before them fails or times out on a file
+	Fallbacks []FallbackEngine `mapstructure:"fallbacks"`
+
+	// Ensemble asks other engines to classify every file as well and combines their labels with this one's
+	Ensemble EnsembleConfig `mapstructure:"ensemble"`
+}
+
+// validate checks that failed requests are retried a number of times and with pauses that are not negative
+func (e AIEngineConfig) validate() error {
+	if e.Retries < 0 {
+		return fmt.Errorf("retries must not be negative, got %d", e.Retries)
+	}
+	if e.RetryBackoff < 0 {
+		return fmt.Errorf("retry_backoff must not be negative, got %s", e.RetryBackoff)
+	}
+	if e.RetryMaxBackoff < 0 {
+		return fmt.Errorf("retry_max_backoff must not be negative, got %s", e.RetryMaxBackoff)
+	}
+	return nil
+}
+
+// Strategies combining the labels of an ensemble, see EnsembleConfig
+const (
+	EnsembleMajority  = "majority"  // The label most engines gave, the one of the first engine on a tie
+	EnsembleStrictest = "strictest" // The label of the files needing the most work, such as Empty over Low quality
+)
+
+// EnsembleConfig represents the engines classifying every file along with the primary engine
+type EnsembleConfig struct {
+	Engines  []FallbackEngine `mapstructure:"engines"`  // Configured like fallback engines, named after their model if unnamed
+	Strategy string           `mapstructure:"strategy"` // majority or strictest
+}
+
+// validate checks that the strategy is a known one
+func (e EnsembleConfig) validate() error {
+	switch e.Strategy {
+	case EnsembleMajority, EnsembleStrictest:
+		return nil
+	}
+	return fmt.Errorf("unknown strategy %q, expected majority or strictest", e.Strategy)
+}
+
+// FallbackEngine represents an engine classifying the files the engines before it could not
+type FallbackEngine struct {
+	Name       string `mapstructure:"name"`             // Recorded with the files it classifies, the model if empty
+	Provider   string `mapstructure:"provider"`         // API spoken by the engine: ollama, openai, anthropic or azure_openai
+	URL        string `mapstructure:"url"`              // Empty for the default URL of the provider
+	APIKey     string `mapstructure:"api_key" json:"-"` // Never recorded in run manifests
+	Deployment string `mapstructure:"deployment"`       // Azure OpenAI deployment, used instead of the model
+	APIVersion string `mapstructure:"api_version"`      // Azure OpenAI API version, the one of the primary engine if empty
+	Model      string `mapstructure:"model"`
+}
+
+// EscalationStage represents a model classifying again the files the previous model gave one of the labels
+type EscalationStage struct {
+	Model  string   `mapstructure:"model"`  // Model of the provider, or deployment with azure_openai
+	Labels []string `mapstructure:"labels"` // Labels of the previous model sent to this one, compared case-insensitively
 }
 
 // ScanSettingsConfig represents the scanning settings
 type ScanSettingsConfig struct {
 	FileExtension      string   `mapstructure:"file_extension"`
 	ExcludeDirectories []string `mapstructure:"exclude_directories"`
+	MaxReadBytes       int64    `mapstructure:"max_read_bytes"`      // Bytes read from each file, larger files are truncated (0 for no limit)
+	MaxTokens          int      `mapstructure:"max_tokens"`          // Approximate tokens of a note sent to the engine, longer notes are condensed (0 for no limit)
+	ExtraExtensions    []string `mapstructure:"extra_extensions"`    // Other note formats converted to markdown before the checks (.txt, .html, .htm)
+	SkipAfterFailures  int      `mapstructure:"skip_after_failures"` // Runs in a row a file may fail to be classified before it is skipped (0 never skips)
+	UnreadableLabel    string   `mapstructure:"unreadable_label"`    // Label of the files that cannot be read (empty skips them with a warning)
+	PlaceholderWords   []string `mapstructure:"placeholder_words"`   // Text that alone marks a note as a placeholder, such as TBD
 }
 
 // PromptConfig represents the configuration for the GenAI prompt
 type PromptConfig struct {
-	QualityClassificationPrompt string `mapstructure:"quality_classification_prompt"`
+	SystemPrompt                    string `mapstructure:"system_prompt"` // Sent as a system message before each classification prompt
+	QualityClassificationPrompt     string `mapstructure:"quality_classification_prompt"`
+	QualityClassificationPromptFile string `mapstructure:"quality_classification_prompt_file"` // Go template replacing the classification prompt, relative to the configuration file
+	SplitSuggestionPrompt           string `mapstructure:"split_suggestion_prompt"`
+	ImprovementPrompt               string `mapstructure:"improvement_prompt"`
+	TagPrompt                       string `mapstructure:"tag_prompt"`
+	SummaryPrompt                   string `mapstructure:"summary_prompt"`
+	TitlePrompt                     string `mapstructure:"title_prompt"`
+	SourcesPrompt                   string `mapstructure:"sources_prompt"`
+	MergePrompt                     string `mapstructure:"merge_prompt"`
+
+	// Pipeline replaces the single classification prompt with several narrow questions when it has steps
+	Pipeline PipelineConfig `mapstructure:"pipeline"`
+
+	qualityTemplate *template.Template // Parsed from QualityClassificationPromptFile
+}
+
+// PromptData is what the classification prompt template of a file is filled with
+type PromptData struct {
+	Content     string // Content of the note
+	Path        string // Path of the note relative to the target folder, with forward slashes
+	Frontmatter string // Frontmatter block of the note, empty if it has none
+}
+
+// PipelineConfig represents a sequence of questions whose answers are combined into a classification
+type PipelineConfig struct {
+	Steps        []PipelineStep `mapstructure:"steps"`
+	Rules        []PipelineRule `mapstructure:"rules"`         // Checked in order, the first rule matching the answers gives the label
+	DefaultLabel string         `mapstructure:"default_label"` // Label used when no rule matches
+}
+
+// PipelineStep represents a single question asked about the content of a file
+type PipelineStep struct {
+	Name   string `mapstructure:"name"`
+	Prompt string `mapstructure:"prompt"` // Replaces {{ content }} with the content of the file
+}
+
+// PipelineRule maps the expected answers of some steps to a label
+type PipelineRule struct {
+	When  map[string]string `mapstructure:"when"` // Step name to expected answer, compared case-insensitively
+	Label string            `mapstructure:"label"`
 }
 
 // ExclusionFileConfig represents the configuration for the exclusion file
@@ -38,13 +242,215 @@ type ExclusionFileConfig struct {
 	Path string `mapstructure:"path"`
 }
 
+// ThrottleConfig represents the settings used to pause classification while the host is busy
+type ThrottleConfig struct {
+	MaxLoadAverage    float64       `mapstructure:"max_load_average"`    // 1-minute load average above which classification pauses (0 disables)
+	MaxGPUUtilization int           `mapstructure:"max_gpu_utilization"` // GPU utilization percentage above which classification pauses (0 disables)
+	CheckInterval     time.Duration `mapstructure:"check_interval"`      // How long to wait before checking the load again
+	OutageThreshold   int           `mapstructure:"outage_threshold"`    // Consecutive engine failures after which classification pauses (0 disables)
+	OutageBackoff     time.Duration `mapstructure:"outage_backoff"`      // First pause before checking the health of the engine, doubled after each failed check
+	OutageMaxBackoff  time.Duration `mapstructure:"outage_max_backoff"`  // Longest pause between health checks
+}
+
+// validate checks that the throttle checks the load and the health of the engine again after a pause
+func (t ThrottleConfig) validate() error {
+	if t.CheckInterval <= 0 {
+		return fmt.Errorf("check_interval must be positive, got %s", t.CheckInterval)
+	}
+	if t.OutageBackoff <= 0 {
+		return fmt.Errorf("outage_backoff must be positive, got %s", t.OutageBackoff)
+	}
+	return nil
+}
+
+// LongNotesConfig represents the settings for flagging notes that should be split
+type LongNotesConfig struct {
+	MaxWords     int  `mapstructure:"max_words"`     // Word count above which a note is flagged (0 disables)
+	SuggestSplit bool `mapstructure:"suggest_split"` // Ask the GenAI engine for a split outline for each flagged note
+}
+
+// PromptOverride represents a classification prompt replacing quality_classification_prompt for the files in a
+// folder or with a tag, since daily notes and reference notes are not held to the same quality bar
+type PromptOverride struct {
+	Match  string `mapstructure:"match"`  // Glob relative to the target folder, such as journal/**
+	Tag    string `mapstructure:"tag"`    // Obsidian tag, with or without #, also matching its nested tags
+	Prompt string `mapstructure:"prompt"` // Classification prompt of the matching files, with {{ content }}
+}
+
+// SuggestionsConfig represents the settings for asking the GenAI engine how to improve the files that fall short
+type SuggestionsConfig struct {
+	Enabled     bool `mapstructure:"enabled"`      // Ask for improvement actions for each newly classified file that falls short
+	BelowRating int  `mapstructure:"below_rating"` // With rating on, files rated below this also fall short (0 for labels only)
+}
+
+// TagsConfig represents the settings for suggesting tags for the notes that have none
+type TagsConfig struct {
+	Suggest bool `mapstructure:"suggest"` // Ask the GenAI engine for tags for each newly classified note without tags
+	Known   int  `mapstructure:"known"`   // Most used tags of the vault given to the engine to choose from (0 for none)
+}
+
+// SummariesConfig represents the settings for summarizing each note in the report
+type SummariesConfig struct {
+	Enabled bool `mapstructure:"enabled"` // Ask the GenAI engine for a one-line summary of each newly classified note
+}
+
+// TitleCheckConfig represents the settings for checking that each note is about what its title says
+type TitleCheckConfig struct {
+	Enabled bool `mapstructure:"enabled"` // Ask the GenAI engine whether the body of each newly classified note matches its title
+}
+
+// SourcesConfig represents the settings for flagging reference notes that cite no sources
+type SourcesConfig struct {
+	Check    bool     `mapstructure:"check"`     // Check each newly classified reference note for sources
+	Match    []string `mapstructure:"match"`     // Folder globs of the reference notes, every note if neither these nor tags are set
+	Tags     []string `mapstructure:"tags"`      // Tags of the reference notes, matching their nested tags as well
+	Patterns []string `mapstructure:"patterns"`  // Regular expressions matching a source, such as a URL, DOI or footnote
+	MinWords int      `mapstructure:"min_words"` // Notes with fewer words make too few claims to need sources
+	AskModel bool     `mapstructure:"ask_model"` // Ask the GenAI engine whether a note without sources makes claims needing them
+}
+
+// validate checks that every pattern is a valid regular expression
+func (s SourcesConfig) validate() error {
+	for i, pattern := range s.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("pattern %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// OutliersConfig represents the settings for flagging notes of unusual length for the vault
+type OutliersConfig struct {
+	Percentile float64 `mapstructure:"percentile"` // Notes in the bottom or top percentile of lengths are flagged (0 disables)
+}
+
+// MetadataConfig represents the frontmatter fields used for metadata consistency checks
+type MetadataConfig struct {
+	CreatedField string `mapstructure:"created_field"` // Frontmatter field holding the creation date
+	UpdatedField string `mapstructure:"updated_field"` // Frontmatter field holding the last update date
+}
+
+// EmbeddingsConfig represents the settings for computing note embeddings
+type EmbeddingsConfig struct {
+	Model      string `mapstructure:"model"`       // Embedding model served by the AI engine
+	ExportPath string `mapstructure:"export_path"` // JSON file the embeddings are exported to (empty disables)
+
+	DuplicateThreshold float64 `mapstructure:"duplicate_threshold"` // Cosine similarity from which notes are reported as possible duplicates (0 disables)
+	TopicThreshold     float64 `mapstructure:"topic_threshold"`     // Cosine similarity from which low quality notes are grouped by topic (0 disables)
+}
+
+// ReportConfig represents the settings for the generated reports
+type ReportConfig struct {
+	ObsidianURIs bool   `mapstructure:"obsidian_uris"` // Link notes with obsidian:// URIs in HTML and JSON reports
+	VaultName    string `mapstructure:"vault_name"`    // Vault name used in URIs (empty detects it from the .obsidian folder)
+	ListExcluded bool   `mapstructure:"list_excluded"` // List excluded files and directories in an appendix with the reason
+	Timestamp    string `mapstructure:"timestamp"`     // Where the generation time and run ID go: header, comment or none
+	TimeFormat   string `mapstructure:"time_format"`   // Go layout of the dates and times in reports
+	Timezone     string `mapstructure:"timezone"`      // IANA timezone of the dates and times in reports (empty for local time)
+
+	PassingLabels []string `mapstructure:"passing_labels"` // Classifications that are not reported as findings in rdjson reports
+	FolderRollups bool     `mapstructure:"folder_rollups"` // Write a _quality.md rollup into each top-level folder
+
+	LinkStyle string `mapstructure:"link_style"` // Links to notes in the markdown report: auto, wikilink or markdown
+
+	ReviewThreshold float64 `mapstructure:"review_threshold"` // Files classified with a lower confidence need human review (0 disables)
+	PageSize        int     `mapstructure:"page_size"`        // Entries per collapsible block in longer sections of the report (0 never splits)
+
+	MetadataFields []string `mapstructure:"metadata_fields"` // Frontmatter fields carried into every report format, such as owner or status
+}
+
+// QualityGatesConfig represents the thresholds that fail a run, for CI
+type QualityGatesConfig struct {
+	FailIf   []string             `mapstructure:"fail_if"`  // Gates for the files outside every profile, such as "Empty>0"
+	Profiles []QualityGateProfile `mapstructure:"profiles"` // Checked in order, the first profile matching a file applies
+}
+
+// GenerationOptions represents the generation parameters of the model, the ones not set are left to the model
+type GenerationOptions struct {
+	Temperature *float64 `mapstructure:"temperature" json:"temperature,omitempty"` // 0 for the most deterministic output
+	TopP        *float64 `mapstructure:"top_p" json:"top_p,omitempty"`
+	Seed        *int     `mapstructure:"seed" json:"seed,omitempty"`       // Fixed seed for reproducible output, where the provider supports it
+	NumCtx      int      `mapstructure:"num_ctx" json:"num_ctx,omitempty"` // Context window in tokens, Ollama only (0 for the model default)
+}
+
+// QualityGateProfile represents the gates of the files matching a folder glob
+type QualityGateProfile struct {
+	Folder string   `mapstructure:"folder"`  // Glob relative to the target folder, such as docs/runbooks/**
+	FailIf []string `mapstructure:"fail_if"` // Gates replacing the default ones for the matching files
+	Ignore bool     `mapstructure:"ignore"`  // Leave the matching files out of every gate
+}
+
+// ScoringConfig represents the dimensions each note is scored on from 1 to 5 besides its classification
+type ScoringConfig struct {
+	Dimensions []ScoringDimension `mapstructure:"dimensions"` // Empty disables scoring
+	Rating     bool               `mapstructure:"rating"`     // Ask for an overall rating of each note from 0 to 100
+}
+
+// ScoringDimension represents an aspect of quality the model scores each note on
+type ScoringDimension struct {
+	Name        string  `mapstructure:"name"`        // Column of the report, such as completeness
+	Description string  `mapstructure:"description"` // What the model looks at, included in the request
+	Weight      float64 `mapstructure:"weight"`      // Share of the overall score, 1 if not set
+}
+
+// Checks made before commands change notes, see SafetyConfig
+const (
+	GuardOff  = "off"  // No check
+	GuardGit  = "git"  // The vault must be a git worktree without uncommitted changes
+	GuardZip  = "zip"  // A zip backup of the vault is written first
+	GuardAuto = "auto" // A clean git worktree, or else a zip backup
+)
+
+// SafetyConfig represents the safety net for the commands that change notes in bulk
+type SafetyConfig struct {
+	Guard string `mapstructure:"guard"` // off, git, zip or auto
+}
+
+// validate checks that the guard is a known one
+func (s SafetyConfig) validate() error {
+	switch s.Guard {
+	case GuardOff, GuardGit, GuardZip, GuardAuto:
+		return nil
+	}
+	return fmt.Errorf("unknown guard %q, expected off, git, zip or auto", s.Guard)
+}
+
+// ConcurrencyConfig represents the number of workers used by each processing stage
+type ConcurrencyConfig struct {
+	PrecheckWorkers       int  `mapstructure:"precheck_workers"`       // Files read and pre-checked in parallel (I/O bound)
+	ClassificationWorkers int  `mapstructure:"classification_workers"` // Files classified in parallel (bound by the AI engine)
+	AutoTune              bool `mapstructure:"auto_tune"`              // Adjust the classification workers to the measured throughput
+	MaxWorkers            int  `mapstructure:"max_workers"`            // Upper bound for the classification workers when auto-tuning
+}
+
+// AttachmentsConfig represents the settings for classifying the text of attachments linked from notes
+type AttachmentsConfig struct {
+	Extract    bool     `mapstructure:"extract"`    // Extract and classify the text of linked attachments
+	Extensions []string `mapstructure:"extensions"` // Attachment types to extract, .pdf and .docx are supported
+}
+
+// EnvPrefix is the prefix of the environment variables overriding settings, such as RATEMYKB_AI_ENGINE_MODEL
+const EnvPrefix = "RATEMYKB"
+
 // LoadConfig loads the configuration from the specified path or uses default values
+// Environment variables override both the defaults and the configuration file.
 func LoadConfig(configPath string) (*Config, error) {
+	return LoadProfile(configPath, "")
+}
+
+// LoadProfile loads the configuration like LoadConfig, starting from the defaults of a profile instead
+// An empty profile uses the profile setting of the configuration file or environment, if any.
+func LoadProfile(configPath, profile string) (*Config, error) {
 	v := viper.New()
 
 	// Set default values
 	setDefaults(v)
 
+	// Let environment variables override any setting that has a default
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
 	// If configuration path was provided, use it
 	if configPath != "" {
 		// If the path is a directory, append the default config filename
@@ -65,31 +471,234 @@ func LoadConfig(configPath string) (*Config, error) {
 		}
 	}
 
+	// Apply the defaults of the profile, which the settings read above take precedence over
+	if profile == "" {
+		profile = v.GetString("profile")
+	}
+	if profile != "" {
+		defaults, ok := profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q, expected %s", profile, ProfileDocsRepo)
+		}
+		for key, value := range defaults {
+			v.SetDefault(key, value)
+		}
+	}
+
 	// Unmarshal the configuration into a Config struct
 	var config Config
 	if err := v.Unmarshal(&config); err != nil {
 		return nil, fmt.Errorf("unable to decode config into struct: %w", err)
 	}
+	config.Profile = profile
+	config.applyDefaultURL()
+
+	// Read the classification prompt template next to the configuration file
+	if config.PromptConfig.QualityClassificationPromptFile != "" {
+		dir := "."
+		if configPath != "" {
+			dir = filepath.Dir(configPath)
+		}
+		if err := config.PromptConfig.loadTemplate(dir); err != nil {
+			return nil, fmt.Errorf("invalid prompt configuration: %w", err)
+		}
+	}
+
+	// Catch prompts that would silently leave out the content of the notes
+	if err := config.PromptConfig.validate(); err != nil {
+		return nil, fmt.Errorf("invalid prompt configuration: %w", err)
+	}
+	if err := validatePromptOverrides(config.Prompts, config.PromptConfig.Pipeline); err != nil {
+		return nil, fmt.Errorf("invalid prompts configuration: %w", err)
+	}
+	if err := config.Scoring.validate(); err != nil {
+		return nil, fmt.Errorf("invalid scoring configuration: %w", err)
+	}
+	if err := config.Sources.validate(); err != nil {
+		return nil, fmt.Errorf("invalid sources configuration: %w", err)
+	}
+	if err := config.AIEngine.validate(); err != nil {
+		return nil, fmt.Errorf("invalid ai_engine configuration: %w", err)
+	}
+	if err := config.AIEngine.Ensemble.validate(); err != nil {
+		return nil, fmt.Errorf("invalid ensemble configuration: %w", err)
+	}
+	if err := config.Safety.validate(); err != nil {
+		return nil, fmt.Errorf("invalid safety configuration: %w", err)
+	}
+	if err := config.Throttle.validate(); err != nil {
+		return nil, fmt.Errorf("invalid throttle configuration: %w", err)
+	}
 
 	return &config, nil
 }
 
+// applyDefaultURL points the AI engine, its fallbacks and its ensemble at the default URL of their provider if no URL is configured
+func (c *Config) applyDefaultURL() {
+	if c.AIEngine.URL == "" {
+		c.AIEngine.URL = DefaultURLs[c.AIEngine.Provider]
+	}
+	for i := range c.AIEngine.Fallbacks {
+		if c.AIEngine.Fallbacks[i].URL == "" {
+			c.AIEngine.Fallbacks[i].URL = DefaultURLs[c.AIEngine.Fallbacks[i].Provider]
+		}
+	}
+	for i := range c.AIEngine.Ensemble.Engines {
+		if c.AIEngine.Ensemble.Engines[i].URL == "" {
+			c.AIEngine.Ensemble.Engines[i].URL = DefaultURLs[c.AIEngine.Ensemble.Engines[i].Provider]
+		}
+	}
+}
+
 // setDefaults sets the default values for the configuration
 func setDefaults(v *viper.Viper) {
 	// AI Engine defaults
-	v.SetDefault("ai_engine.url", "http://localhost:11434/")
+	v.SetDefault("ai_engine.provider", ProviderOllama)
+	v.SetDefault("ai_engine.url", "")
+	v.SetDefault("ai_engine.api_key", "")
+	v.SetDefault("ai_engine.deployment", "")
+	v.SetDefault("ai_engine.api_version", "2024-10-21")
 	v.SetDefault("ai_engine.model", "gemma3:1b")
+	v.SetDefault("ai_engine.timeout", "5m")
+	v.SetDefault("ai_engine.request_timeout", 0)
+	v.SetDefault("ai_engine.warm_up", true)
+	v.SetDefault("ai_engine.samples", 1)
+	v.SetDefault("ai_engine.sample_temperature", 0.7)
+	v.SetDefault("ai_engine.vision_model", "")
+	v.SetDefault("ai_engine.max_images", 4)
+	v.SetDefault("ai_engine.retries", 2)
+	v.SetDefault("ai_engine.retry_backoff", "1s")
+	v.SetDefault("ai_engine.retry_max_backoff", "30s")
+	v.SetDefault("ai_engine.requests_per_minute", 0)
+	v.SetDefault("ai_engine.tokens_per_minute", 0)
+	v.SetDefault("ai_engine.cache", true)
+	v.SetDefault("ai_engine.structured_output", false)
+	v.SetDefault("ai_engine.ensemble.strategy", EnsembleMajority)
 
 	// Scan Settings defaults
 	v.SetDefault("scan_settings.file_extension", ".md")
 	v.SetDefault("scan_settings.exclude_directories", []string{})
+	v.SetDefault("scan_settings.max_read_bytes", 1024*1024)
+	v.SetDefault("scan_settings.max_tokens", 0)
+	v.SetDefault("scan_settings.extra_extensions", []string{})
+	v.SetDefault("scan_settings.skip_after_failures", 3)
+	v.SetDefault("scan_settings.unreadable_label", "Unreadable")
+	v.SetDefault("scan_settings.placeholder_words", []string{"TBD", "TBC", "TODO", "WIP", "Coming soon", "Placeholder"})
 
 	// Prompt Config defaults
 	v.SetDefault("prompt_config.quality_classification_prompt",
-		"Review the content and determine if it's: 'Empty', 'Low quality/low effort', or 'Good enough'.")
+		"Review the content and determine if it's: 'Empty', 'Low quality/low effort', or 'Good enough'.\n\n{{ content }}")
+	v.SetDefault("prompt_config.split_suggestion_prompt",
+		"The following note is too long. Suggest how to split it into smaller notes as a short markdown outline "+
+			"with one bullet per proposed note title. Respond only with the outline.\n\n{{ content }}")
+	v.SetDefault("prompt_config.improvement_prompt",
+		"The following note falls short of a useful knowledge base note. Suggest 2 to 3 concrete actions that would "+
+			"improve it, as a markdown list with one short sentence per action. Respond only with the list.\n\n{{ content }}")
+	v.SetDefault("prompt_config.tag_prompt",
+		"Suggest 1 to 3 tags that would help find the following note, preferring these tags already used in the "+
+			"knowledge base when they fit: {{ tags }}. Respond only with the tags, separated by commas.\n\n{{ content }}")
+	v.SetDefault("prompt_config.summary_prompt",
+		"Summarize the following note in one sentence of at most 20 words, so a reader can decide whether to open "+
+			"it. Respond only with the sentence.\n\n{{ content }}")
+	v.SetDefault("prompt_config.title_prompt",
+		"Is the following note about what its title \"{{ title }}\" says? Respond with yes if it is. Otherwise respond "+
+			"with no, followed by one short sentence on what the note is about instead.\n\n{{ content }}")
+	v.SetDefault("prompt_config.sources_prompt",
+		"Does the following note make factual claims that should be backed by sources, such as figures, research "+
+			"findings or quotes? Respond only with yes or no.\n\n{{ content }}")
+	v.SetDefault("prompt_config.merge_prompt",
+		"The following two notes are duplicates. Combine them into a single markdown note that keeps all of "+
+			"their information without repeating it. Keep the frontmatter of the first note. "+
+			"Respond only with the merged note.\n\nFirst note:\n{{ first }}\n\nSecond note:\n{{ second }}")
+	v.SetDefault("prompt_config.pipeline.default_label", "Good enough")
 
 	// Exclusion File defaults
 	v.SetDefault("exclusion_file.path", "quality_exclude_links.md")
+
+	// Throttle defaults
+	v.SetDefault("throttle.max_load_average", 0)
+	v.SetDefault("throttle.max_gpu_utilization", 0)
+	v.SetDefault("throttle.check_interval", "10s")
+	v.SetDefault("throttle.outage_threshold", 3)
+	v.SetDefault("throttle.outage_backoff", "5s")
+	v.SetDefault("throttle.outage_max_backoff", "5m")
+
+	// Long Notes defaults
+	v.SetDefault("long_notes.max_words", 3000)
+	v.SetDefault("long_notes.suggest_split", false)
+
+	// Suggestions defaults
+	v.SetDefault("suggestions.enabled", false)
+	v.SetDefault("suggestions.below_rating", 0)
+
+	// Tags defaults
+	v.SetDefault("tags.suggest", false)
+	v.SetDefault("tags.known", 50)
+
+	// Summaries defaults
+	v.SetDefault("summaries.enabled", false)
+
+	// Title Check defaults
+	v.SetDefault("title_check.enabled", false)
+
+	// Sources defaults
+	v.SetDefault("sources.check", false)
+	v.SetDefault("sources.match", []string{})
+	v.SetDefault("sources.tags", []string{})
+	v.SetDefault("sources.patterns", []string{
+		`https?://`,                         // Links
+		`(?i)\bdoi:|\b10\.\d{4,9}/\S`,       // DOIs
+		`\[\^[^\]]+\]`,                      // Footnotes
+		`\([A-Z][\w.&' -]*,? \d{4}[a-z]?\)`, // Author-year citations, such as (Smith, 2020)
+		`(?im)^#+\s*(sources|references|bibliography|citations)\s*$`, // Headings of a list of sources
+	})
+	v.SetDefault("sources.min_words", 100)
+	v.SetDefault("sources.ask_model", false)
+
+	// Outliers defaults
+	v.SetDefault("outliers.percentile", 0)
+
+	// Metadata defaults
+	v.SetDefault("metadata.created_field", "created")
+	v.SetDefault("metadata.updated_field", "updated")
+
+	// Embeddings defaults
+	v.SetDefault("embeddings.model", "nomic-embed-text")
+	v.SetDefault("embeddings.export_path", "")
+	v.SetDefault("embeddings.duplicate_threshold", 0)
+	v.SetDefault("embeddings.topic_threshold", 0)
+
+	// Report defaults
+	v.SetDefault("report.obsidian_uris", false)
+	v.SetDefault("report.link_style", "auto")
+	v.SetDefault("report.vault_name", "")
+	v.SetDefault("report.list_excluded", false)
+	v.SetDefault("report.timestamp", "header")
+	v.SetDefault("report.time_format", "2006-01-02 15:04:05")
+	v.SetDefault("report.timezone", "")
+	v.SetDefault("report.passing_labels", []string{"Good enough"})
+	v.SetDefault("report.folder_rollups", false)
+	v.SetDefault("report.review_threshold", 0.5)
+	v.SetDefault("report.page_size", 1000)
+	v.SetDefault("report.metadata_fields", []string{})
+
+	// Attachments defaults
+	v.SetDefault("attachments.extract", false)
+	v.SetDefault("attachments.extensions", []string{".pdf", ".docx"})
+
+	// Scoring defaults
+	v.SetDefault("scoring.dimensions", []ScoringDimension{})
+	v.SetDefault("scoring.rating", false)
+	v.SetDefault("safety.guard", GuardOff)
+
+	// No profile by default
+	v.SetDefault("profile", "")
+
+	// Concurrency defaults
+	v.SetDefault("concurrency.precheck_workers", 16)
+	v.SetDefault("concurrency.classification_workers", 1)
+	v.SetDefault("concurrency.auto_tune", false)
+	v.SetDefault("concurrency.max_workers", 8)
 }
 
 // GetDefaultConfig returns a config object with default values
@@ -99,6 +708,237 @@ func GetDefaultConfig() *Config {
 
 	var config Config
 	_ = v.Unmarshal(&config)
+	config.applyDefaultURL()
 
 	return &config
 }
+
+// Settings returns the configuration as nested maps keyed like the configuration file, so it can be written as
+// YAML or JSON
+// Durations are written the way they are configured, such as "5m0s", and missing lists as empty ones. Secrets
+// such as the API key are redacted.
+func (c *Config) Settings() map[string]any {
+	return settingsOf(reflect.ValueOf(*c)).(map[string]any)
+}
+
+// placeholderPattern matches a template variable of a prompt, however it is spaced
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([^{}]*?)\s*\}\}`)
+
+// validate checks that every prompt contains the template variables it is filled with, written the way they
+// are replaced, and no others
+// The classification prompt may leave out {{ content }} when a pipeline replaces it.
+func (p PromptConfig) validate() error {
+	if match := placeholderPattern.FindString(p.SystemPrompt); match != "" {
+		return fmt.Errorf("system_prompt uses %s, but it is sent apart from the note and takes no variables", match)
+	}
+	if len(p.Pipeline.Steps) == 0 && p.qualityTemplate != nil {
+		// Fill the template with a marker in place of the content to see whether it sends the note
+		const marker = "\x00content\x00"
+		var prompt strings.Builder
+		if err := p.qualityTemplate.Execute(&prompt, PromptData{Content: marker}); err != nil {
+			return fmt.Errorf("failed to fill quality_classification_prompt_file: %w", err)
+		}
+		if !strings.Contains(prompt.String(), marker) {
+			return fmt.Errorf("%s does not contain {{ .Content }}", p.QualityClassificationPromptFile)
+		}
+	} else if len(p.Pipeline.Steps) == 0 {
+		if err := checkPlaceholders("quality_classification_prompt", p.QualityClassificationPrompt, "content"); err != nil {
+			return err
+		}
+	}
+	if err := checkPlaceholders("split_suggestion_prompt", p.SplitSuggestionPrompt, "content"); err != nil {
+		return err
+	}
+	if err := checkPlaceholders("improvement_prompt", p.ImprovementPrompt, "content"); err != nil {
+		return err
+	}
+	if err := checkPlaceholders("tag_prompt", p.TagPrompt, "content", "tags"); err != nil {
+		return err
+	}
+	if err := checkPlaceholders("summary_prompt", p.SummaryPrompt, "content"); err != nil {
+		return err
+	}
+	if err := checkPlaceholders("title_prompt", p.TitlePrompt, "title", "content"); err != nil {
+		return err
+	}
+	if err := checkPlaceholders("sources_prompt", p.SourcesPrompt, "content"); err != nil {
+		return err
+	}
+	if err := checkPlaceholders("merge_prompt", p.MergePrompt, "first", "second"); err != nil {
+		return err
+	}
+	for _, step := range p.Pipeline.Steps {
+		if err := checkPlaceholders(fmt.Sprintf("pipeline step %q prompt", step.Name), step.Prompt, "content"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadTemplate parses the classification prompt template, resolved against dir if relative, and replaces the
+// classification prompt with the text of the template, so changing the template classifies cached notes again
+func (p *PromptConfig) loadTemplate(dir string) error {
+	path := p.QualityClassificationPromptFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read quality_classification_prompt_file: %w", err)
+	}
+	p.qualityTemplate, err = template.New(p.QualityClassificationPromptFile).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return fmt.Errorf("failed to parse quality_classification_prompt_file: %w", err)
+	}
+	p.QualityClassificationPrompt = string(data)
+	return nil
+}
+
+// HasTemplate reports whether the classification prompt is a template file filled per note
+func (p PromptConfig) HasTemplate() bool {
+	return p.qualityTemplate != nil
+}
+
+// ClassificationPrompt returns the classification prompt of a note filled with its content, and with its path and
+// frontmatter if a template file is configured
+func (p PromptConfig) ClassificationPrompt(content, path, frontmatter string) (string, error) {
+	if p.qualityTemplate == nil {
+		return strings.Replace(p.QualityClassificationPrompt, "{{ content }}", content, 1), nil
+	}
+	var prompt strings.Builder
+	if err := p.qualityTemplate.Execute(&prompt, PromptData{Content: content, Path: path, Frontmatter: frontmatter}); err != nil {
+		return "", fmt.Errorf("failed to fill quality_classification_prompt_file: %w", err)
+	}
+	return prompt.String(), nil
+}
+
+// validatePromptOverrides checks that every override matches files and classifies them with the content
+// Overrides are rejected along with a prompt pipeline, which classifies every file with its steps instead.
+func validatePromptOverrides(overrides []PromptOverride, pipeline PipelineConfig) error {
+	if len(overrides) > 0 && len(pipeline.Steps) > 0 {
+		return fmt.Errorf("prompt overrides cannot be used with prompt_config.pipeline, which classifies every file with its steps")
+	}
+	for i, override := range overrides {
+		if strings.TrimSpace(override.Match) == "" && strings.TrimSpace(override.Tag) == "" {
+			return fmt.Errorf("override %d has neither a match nor a tag", i+1)
+		}
+		if err := checkPlaceholders(fmt.Sprintf("override %d prompt", i+1), override.Prompt, "content"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks that every dimension has a unique name and a weight that is not negative
+func (s ScoringConfig) validate() error {
+	seen := make(map[string]bool)
+	for i, dimension := range s.Dimensions {
+		name := strings.ToLower(strings.TrimSpace(dimension.Name))
+		if name == "" {
+			return fmt.Errorf("dimension %d has no name", i+1)
+		}
+		if seen[name] {
+			return fmt.Errorf("dimension %q is configured twice", dimension.Name)
+		}
+		if dimension.Weight < 0 {
+			return fmt.Errorf("dimension %q has a negative weight", dimension.Name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// checkPlaceholders checks that a prompt contains each of the variables, as {{ name }}, and no unknown ones
+func checkPlaceholders(name, prompt string, variables ...string) error {
+	known := make(map[string]bool)
+	for _, variable := range variables {
+		known[variable] = true
+	}
+
+	for _, match := range placeholderPattern.FindAllStringSubmatch(prompt, -1) {
+		if !known[match[1]] {
+			return fmt.Errorf("%s uses unknown variable %s (supported: {{ %s }})", name, match[0], strings.Join(variables, " }}, {{ "))
+		}
+		if match[0] != "{{ "+match[1]+" }}" {
+			return fmt.Errorf("%s writes %s, which is only replaced when written as {{ %s }}", name, match[0], match[1])
+		}
+	}
+
+	for _, variable := range variables {
+		if !strings.Contains(prompt, "{{ "+variable+" }}") {
+			return fmt.Errorf("%s does not contain {{ %s }}, so the prompt would be sent without it", name, variable)
+		}
+	}
+	return nil
+}
+
+// secretSettings lists the keys whose values are never shown
+var secretSettings = map[string]bool{"api_key": true}
+
+// settingsOf converts a configuration value to maps, slices and plain values
+func settingsOf(value reflect.Value) any {
+	if duration, ok := value.Interface().(time.Duration); ok {
+		return duration.String()
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		settings := make(map[string]any)
+		for i := 0; i < value.NumField(); i++ {
+			key := value.Type().Field(i).Tag.Get("mapstructure")
+			switch {
+			case key == "":
+			case secretSettings[key] && !value.Field(i).IsZero():
+				settings[key] = "<redacted>"
+			default:
+				settings[key] = settingsOf(value.Field(i))
+			}
+		}
+		return settings
+	case reflect.Slice:
+		items := make([]any, value.Len())
+		for i := range items {
+			items[i] = settingsOf(value.Index(i))
+		}
+		return items
+	case reflect.Map:
+		entries := make(map[string]any)
+		for _, key := range value.MapKeys() {
+			entries[fmt.Sprint(key.Interface())] = settingsOf(value.MapIndex(key))
+		}
+		return entries
+	default:
+		return value.Interface()
+	}
+}
+
+// FolderPattern converts a folder glob to a regular expression matching slash-separated relative paths
+// ** matches any number of folders, * and ? match within a single path element. A glob naming a folder, with
+// or without a trailing slash, matches everything inside it.
+func FolderPattern(glob string) *regexp.Regexp {
+	glob = strings.TrimPrefix(filepath.ToSlash(glob), "/")
+	if !strings.ContainsAny(glob, "*?") || strings.HasSuffix(glob, "/") {
+		glob = strings.TrimSuffix(glob, "/") + "/**"
+	}
+
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			pattern.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			pattern.WriteString(".*")
+			i++
+		case c == '*':
+			pattern.WriteString("[^/]*")
+		case c == '?':
+			pattern.WriteString("[^/]")
+		default:
+			pattern.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	pattern.WriteString("$")
+	return regexp.MustCompile(pattern.String())
+}
@@ -4,6 +4,8 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -59,6 +61,14 @@ scan_settings:
     - ".git"
 prompt_config:
   quality_classification_prompt: "Custom prompt for classification"
+  pipeline:
+    steps:
+      - name: Stub
+        prompt: "Is this a stub? {{ content }}"
+    rules:
+      - when:
+          Stub: "yes"
+        label: "Low quality"
 exclusion_file:
   path: "custom_exclusion_file.md"
 `
@@ -94,6 +104,16 @@ exclusion_file:
 		if config.ExclusionFile.Path != "custom_exclusion_file.md" {
 			t.Errorf("Expected ExclusionFile.Path to be 'custom_exclusion_file.md', got %s", config.ExclusionFile.Path)
 		}
+
+		// Map keys lose their case, so step names in rules are matched case-insensitively
+		expectedPipeline := PipelineConfig{
+			Steps:        []PipelineStep{{Name: "Stub", Prompt: "Is this a stub? {{ content }}"}},
+			Rules:        []PipelineRule{{When: map[string]string{"stub": "yes"}, Label: "Low quality"}},
+			DefaultLabel: "Good enough",
+		}
+		if !reflect.DeepEqual(config.PromptConfig.Pipeline, expectedPipeline) {
+			t.Errorf("Expected PromptConfig.Pipeline to be %+v, got %+v", expectedPipeline, config.PromptConfig.Pipeline)
+		}
 	})
 
 	// Test loading from a directory path
@@ -185,3 +205,361 @@ func TestGetDefaultConfig(t *testing.T) {
 		t.Errorf("Expected default ScanSettings.FileExtension to be '.md', got %s", config.ScanSettings.FileExtension)
 	}
 }
+
+func TestEnvironmentOverrides(t *testing.T) {
+	t.Setenv("RATEMYKB_AI_ENGINE_MODEL", "llama3")
+	t.Setenv("RATEMYKB_REPORT_PASSING_LABELS", "Good enough,Great")
+
+	config, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+	if config.AIEngine.Model != "llama3" {
+		t.Errorf("Expected AIEngine.Model to be 'llama3', got %s", config.AIEngine.Model)
+	}
+	if want := []string{"Good enough", "Great"}; !reflect.DeepEqual(config.Report.PassingLabels, want) {
+		t.Errorf("Expected Report.PassingLabels to be %v, got %v", want, config.Report.PassingLabels)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	config := GetDefaultConfig()
+	config.QualityGates.Profiles = []QualityGateProfile{{Folder: "scratch", Ignore: true}}
+	config.AIEngine.APIKey = "secret"
+
+	settings := config.Settings()
+	aiEngine := settings["ai_engine"].(map[string]any)
+	if aiEngine["timeout"] != "5m0s" {
+		t.Errorf("Expected ai_engine.timeout to be '5m0s', got %v", aiEngine["timeout"])
+	}
+	if aiEngine["model"] != "gemma3:1b" {
+		t.Errorf("Expected ai_engine.model to be 'gemma3:1b', got %v", aiEngine["model"])
+	}
+	if aiEngine["api_key"] != "<redacted>" {
+		t.Errorf("Expected ai_engine.api_key to be redacted, got %v", aiEngine["api_key"])
+	}
+
+	profiles := settings["quality_gates"].(map[string]any)["profiles"].([]any)
+	want := map[string]any{"folder": "scratch", "fail_if": []any{}, "ignore": true}
+	if len(profiles) != 1 || !reflect.DeepEqual(profiles[0], want) {
+		t.Errorf("Expected quality_gates.profiles to be [%v], got %v", want, profiles)
+	}
+}
+
+func TestPromptValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		prompt string
+		want   string
+	}{
+		{"missing content", `quality_classification_prompt: "Classify this note."`, "does not contain {{ content }}"},
+		{"unknown variable", `quality_classification_prompt: "Classify {{ title }}: {{ content }}"`, "unknown variable {{ title }}"},
+		{"unspaced variable", `quality_classification_prompt: "Classify {{content}}"`, "only replaced when written as {{ content }}"},
+		{"missing merge variable", `merge_prompt: "Merge {{ first }}"`, "merge_prompt does not contain {{ second }}"},
+		{"pipeline step", "pipeline:\n    steps:\n      - name: stub\n        prompt: \"Is this a stub?\"", `pipeline step "stub" prompt does not contain {{ content }}`},
+		{"system prompt variable", `system_prompt: "You review notes. {{ content }}"`, "system_prompt uses {{ content }}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte("prompt_config:\n  "+tt.prompt+"\n"), 0644); err != nil {
+				t.Fatalf("Failed to write test config file: %v", err)
+			}
+
+			_, err := LoadConfig(configPath)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadConfig() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+
+	// The defaults are valid
+	if _, err := LoadConfig(""); err != nil {
+		t.Errorf("LoadConfig() error = %v", err)
+	}
+}
+
+func TestPromptOverrides(t *testing.T) {
+	tests := []struct {
+		name    string
+		prompts string
+		want    string
+	}{
+		{"valid", "\n  - match: \"journal/**\"\n    prompt: \"Is this a useful journal entry? {{ content }}\"\n  - tag: reference\n    prompt: \"Reference: {{ content }}\"", ""},
+		{"no match or tag", "\n  - prompt: \"Classify {{ content }}\"", "override 1 has neither a match nor a tag"},
+		{"missing content", "\n  - tag: daily\n    prompt: \"Classify this note.\"", "override 1 prompt does not contain {{ content }}"},
+		{"with pipeline", "\n  - tag: daily\n    prompt: \"Classify {{ content }}\"\nprompt_config:\n  pipeline:\n    steps:\n      - name: prose\n        prompt: \"Is this prose? {{ content }}\"", "cannot be used with prompt_config.pipeline"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte("prompts:"+tt.prompts+"\n"), 0644); err != nil {
+				t.Fatalf("Failed to write test config file: %v", err)
+			}
+
+			config, err := LoadConfig(configPath)
+			if tt.want == "" {
+				if err != nil || len(config.Prompts) != 2 || config.Prompts[0].Match != "journal/**" || config.Prompts[1].Tag != "reference" {
+					t.Errorf("LoadConfig() = %+v, %v, want two overrides", config, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadConfig() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptTemplateFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"valid", "Classify {{ .Path }}.\n{{ if .Frontmatter }}Frontmatter:\n{{ .Frontmatter }}\n{{ end }}\n{{ .Content }}", ""},
+		{"missing content", "Classify {{ .Path }}.", "prompts/quality.tmpl does not contain {{ .Content }}"},
+		{"unknown field", "Classify {{ .Title }}. {{ .Content }}", "can't evaluate field Title"},
+		{"old placeholder", "Classify {{ content }}", "failed to parse quality_classification_prompt_file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.Mkdir(filepath.Join(dir, "prompts"), 0755); err != nil {
+				t.Fatalf("Failed to create prompts folder: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "prompts", "quality.tmpl"), []byte(tt.template), 0644); err != nil {
+				t.Fatalf("Failed to write test template: %v", err)
+			}
+			configPath := filepath.Join(dir, "config.yaml")
+			if err := os.WriteFile(configPath, []byte("prompt_config:\n  quality_classification_prompt_file: prompts/quality.tmpl\n"), 0644); err != nil {
+				t.Fatalf("Failed to write test config file: %v", err)
+			}
+
+			config, err := LoadConfig(configPath)
+			if tt.want != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Errorf("LoadConfig() error = %v, want it to mention %q", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+
+			// The content is filled in as it is, even where it looks like a placeholder
+			prompt, err := config.PromptConfig.ClassificationPrompt("Use {{ content }} here", "journal/day.md", "tags: [daily]")
+			if err != nil {
+				t.Fatalf("ClassificationPrompt() error = %v", err)
+			}
+			if want := "Classify journal/day.md.\nFrontmatter:\ntags: [daily]\n\nUse {{ content }} here"; prompt != want {
+				t.Errorf("ClassificationPrompt() = %q, want %q", prompt, want)
+			}
+		})
+	}
+}
+
+func TestEnsembleValidation(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte("ai_engine:\n  ensemble:\n    strategy: unanimous\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test config file: %v", err)
+	}
+	if _, err := LoadConfig(configPath); err == nil || !strings.Contains(err.Error(), `unknown strategy "unanimous"`) {
+		t.Errorf("LoadConfig() error = %v, want an unknown strategy", err)
+	}
+
+	config, err := LoadConfig("")
+	if err != nil || config.AIEngine.Ensemble.Strategy != EnsembleMajority {
+		t.Errorf("LoadConfig() = %+v, %v, want the majority strategy by default", config, err)
+	}
+}
+
+func TestSourcesValidation(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte("sources:\n  patterns:\n    - 'https?://'\n    - '[unclosed'\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test config file: %v", err)
+	}
+	if _, err := LoadConfig(configPath); err == nil || !strings.Contains(err.Error(), "invalid sources configuration: pattern 2") {
+		t.Errorf("LoadConfig() error = %v, want an invalid second pattern", err)
+	}
+
+	config, err := LoadConfig("")
+	if err != nil || config.Sources.Check || config.Sources.MinWords != 100 || len(config.Sources.Patterns) == 0 {
+		t.Errorf("LoadConfig() = %+v, %v, want the source check off with default patterns", config.Sources, err)
+	}
+}
+
+func TestThrottleValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		throttle string
+		want     string
+	}{
+		{"defaults", "", ""},
+		{"zero check interval", "check_interval: 0s", "check_interval must be positive"},
+		{"negative outage backoff", "outage_backoff: -5s", "outage_backoff must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte("throttle:\n  "+tt.throttle+"\n"), 0644); err != nil {
+				t.Fatalf("Failed to write test config file: %v", err)
+			}
+
+			_, err := LoadConfig(configPath)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("LoadConfig() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadConfig() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		retry string
+		want  string
+	}{
+		{"defaults", "", ""},
+		{"no retries", "retries: 0\n  retry_backoff: 0s", ""},
+		{"negative retries", "retries: -1", "retries must not be negative"},
+		{"negative backoff", "retry_backoff: -1s", "retry_backoff must not be negative"},
+		{"negative max backoff", "retry_max_backoff: -30s", "retry_max_backoff must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte("ai_engine:\n  "+tt.retry+"\n"), 0644); err != nil {
+				t.Fatalf("Failed to write test config file: %v", err)
+			}
+
+			_, err := LoadConfig(configPath)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("LoadConfig() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadConfig() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestScoringValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		dimensions string
+		want       string
+	}{
+		{"valid", "\n    - name: completeness\n      weight: 2\n    - name: structure", ""},
+		{"missing name", "\n    - description: Covers its topic", "dimension 1 has no name"},
+		{"duplicate name", "\n    - name: structure\n    - name: Structure", `dimension "Structure" is configured twice`},
+		{"negative weight", "\n    - name: freshness\n      weight: -1", `dimension "freshness" has a negative weight`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte("scoring:\n  dimensions:"+tt.dimensions+"\n"), 0644); err != nil {
+				t.Fatalf("Failed to write test config file: %v", err)
+			}
+
+			config, err := LoadConfig(configPath)
+			if tt.want == "" {
+				if err != nil || len(config.Scoring.Dimensions) != 2 || config.Scoring.Dimensions[0].Weight != 2 {
+					t.Errorf("LoadConfig() = %+v, %v, want two dimensions", config, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadConfig() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerationOptions(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte("ai_engine:\n  options:\n    temperature: 0\n    seed: 42\n    num_ctx: 8192\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test config file: %v", err)
+	}
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	options := config.AIEngine.Options
+	if options.Temperature == nil || *options.Temperature != 0 || options.Seed == nil || *options.Seed != 42 || options.NumCtx != 8192 {
+		t.Errorf("Expected the configured options, got %+v", options)
+	}
+	if options.TopP != nil {
+		t.Errorf("Expected top_p to be left to the model, got %v", *options.TopP)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	config, err := LoadProfile("", ProfileDocsRepo)
+	if err != nil {
+		t.Fatalf("LoadProfile() error = %v", err)
+	}
+	if config.Profile != ProfileDocsRepo || config.Report.LinkStyle != "markdown" || config.Safety.Guard != GuardGit {
+		t.Errorf("Expected the defaults of the profile, got profile %q, link style %q and guard %q", config.Profile, config.Report.LinkStyle, config.Safety.Guard)
+	}
+	if !slices.Contains(config.ScanSettings.ExcludeDirectories, "node_modules") {
+		t.Errorf("Expected node_modules to be excluded, got %v", config.ScanSettings.ExcludeDirectories)
+	}
+
+	// Settings of the file take precedence over the profile, which the file may also set
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte("profile: docs-repo\nreport:\n  link_style: wikilink\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test config file: %v", err)
+	}
+	config, err = LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if config.Report.LinkStyle != "wikilink" || config.Safety.Guard != GuardGit {
+		t.Errorf("Expected the link style of the file with the guard of the profile, got %q and %q", config.Report.LinkStyle, config.Safety.Guard)
+	}
+
+	if _, err := LoadProfile("", "wiki"); err == nil {
+		t.Error("Expected an error for an unknown profile")
+	}
+}
+
+func TestFolderPattern(t *testing.T) {
+	tests := []struct {
+		glob  string
+		path  string
+		match bool
+	}{
+		{"docs/runbooks/**", "docs/runbooks/restart.md", true},
+		{"docs/runbooks/**", "docs/runbooks/db/failover.md", true},
+		{"docs/runbooks/**", "docs/runbook.md", false},
+		{"docs/runbooks", "docs/runbooks/restart.md", true},
+		{"**/scratch/**", "notes/scratch/idea.md", true},
+		{"**/scratch/**", "scratch/idea.md", true},
+		{"notes/*.md", "notes/idea.md", true},
+		{"notes/*.md", "notes/2024/idea.md", false},
+		{"notes/?.md", "notes/a.md", true},
+	}
+
+	for _, test := range tests {
+		if got := FolderPattern(test.glob).MatchString(test.path); got != test.match {
+			t.Errorf("FolderPattern(%q) matching %q = %v, want %v", test.glob, test.path, got, test.match)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package embeddings
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"ratemykb/analysis"
+	"ratemykb/config"
+	"ratemykb/scanner"
+
+	"github.com/tmc/langchaingo/llms/ollama"
+)
+
+// Package embeddings computes vector embeddings of notes using the AI engine
+
+// Embedder creates embeddings for a batch of texts
+type Embedder interface {
+	CreateEmbedding(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// Index maps a note path, relative to the target folder, to its embedding
+type Index map[string][]float32
+
+// batchSize is the number of notes sent to the AI engine in a single embedding request
+const batchSize = 16
+
+// New creates an Embedder for the embedding model configured for the AI engine
+func New(cfg *config.Config) (Embedder, error) {
+	llm, err := ollama.New(
+		ollama.WithServerURL(cfg.AIEngine.URL),
+		ollama.WithModel(cfg.Embeddings.Model),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize Ollama embedding client: %w", err)
+	}
+
+	return llm, nil
+}
+
+// Build computes an embedding for every file that needs review
+// Files that cannot be read are skipped
+func Build(ctx context.Context, embedder Embedder, targetFolder string, files []scanner.File) (Index, error) {
+	index := make(Index)
+
+	var paths, texts []string
+	flush := func() error {
+		if len(texts) == 0 {
+			return nil
+		}
+
+		vectors, err := embedder.CreateEmbedding(ctx, texts)
+		if err != nil {
+			return fmt.Errorf("failed to create embeddings: %w", err)
+		}
+		if len(vectors) != len(texts) {
+			return fmt.Errorf("expected %d embeddings, got %d", len(texts), len(vectors))
+		}
+
+		for i, vector := range vectors {
+			index[paths[i]] = vector
+		}
+		paths, texts = nil, nil
+		return nil
+	}
+
+	for _, file := range files {
+		if file.Status != scanner.StatusNeedsReview {
+			continue
+		}
+
+		content, err := scanner.ReadFileContent(file.Path)
+		if err != nil {
+			continue
+		}
+
+		paths = append(paths, relativePath(targetFolder, file.Path))
+		texts = append(texts, content)
+
+		if len(texts) == batchSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// WriteJSON exports the index as a JSON object of path to vector
+func (idx Index) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode embeddings: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write embeddings: %w", err)
+	}
+
+	return nil
+}
+
+// Duplicates groups the notes whose embeddings have a cosine similarity of at least the threshold with every
+// other note of their group
+// Paths are relative to the target folder like those of the index, and groups are sorted by their first path.
+func (idx Index) Duplicates(threshold float64) []analysis.DuplicateGroup {
+	paths := make([]string, 0, len(idx))
+	for path := range idx {
+		paths = append(paths, path)
+	}
+
+	var groups []analysis.DuplicateGroup
+	for _, cluster := range idx.clusters(paths, threshold) {
+		if len(cluster.paths) > 1 {
+			groups = append(groups, analysis.DuplicateGroup{Paths: cluster.paths, Similarity: cluster.similarity})
+		}
+	}
+	return groups
+}
+
+// Topics groups the given notes by topic, keeping together the notes whose embeddings have a cosine similarity of
+// at least the threshold with one another, and names each group after its most central note
+// Notes without an embedding or unlike every other note are left out. Paths are relative to the target folder like
+// those of the index, and the largest topics come first.
+func (idx Index) Topics(paths []string, threshold float64) []analysis.TopicCluster {
+	var embedded []string
+	for _, path := range paths {
+		if _, ok := idx[path]; ok {
+			embedded = append(embedded, path)
+		}
+	}
+
+	var topics []analysis.TopicCluster
+	for _, cluster := range idx.clusters(embedded, threshold) {
+		if len(cluster.paths) > 1 {
+			topics = append(topics, analysis.TopicCluster{Name: idx.centralNote(cluster.paths), Paths: cluster.paths})
+		}
+	}
+	sort.SliceStable(topics, func(i, j int) bool { return len(topics[i].Paths) > len(topics[j].Paths) })
+	return topics
+}
+
+// cluster is a group of notes that are all similar to one another
+type cluster struct {
+	paths      []string // Sorted
+	similarity float64  // Highest similarity between two notes of the cluster
+}
+
+// clusters groups the notes whose embeddings have a cosine similarity of at least the threshold, including notes
+// unlike every other note in a cluster of their own, sorted by their first path
+// Clusters use complete linkage: the most similar pairs are joined first, and two clusters are only merged if every
+// note of one is similar enough to every note of the other, so a chain of similar notes never joins notes that are
+// unlike each other.
+func (idx Index) clusters(paths []string, threshold float64) []cluster {
+	sort.Strings(paths)
+
+	type pair struct {
+		i, j       int
+		similarity float64
+	}
+	similar := make([][]bool, len(paths))
+	var pairs []pair
+	for i := range paths {
+		similar[i] = make([]bool, len(paths))
+	}
+	for i := range paths {
+		for j := i + 1; j < len(paths); j++ {
+			s := cosine(idx[paths[i]], idx[paths[j]])
+			if s >= threshold {
+				similar[i][j], similar[j][i] = true, true
+				pairs = append(pairs, pair{i, j, s})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(a, b int) bool { return pairs[a].similarity > pairs[b].similarity })
+
+	// Each note starts in a cluster of its own, identified by its lowest note
+	owner := make([]int, len(paths))
+	members := make(map[int][]int, len(paths))
+	for i := range paths {
+		owner[i] = i
+		members[i] = []int{i}
+	}
+	similarity := make(map[int]float64)
+	for _, p := range pairs {
+		a, b := owner[p.i], owner[p.j]
+		if a == b || !allSimilar(similar, members[a], members[b]) {
+			continue
+		}
+		if b < a {
+			a, b = b, a
+		}
+		for _, member := range members[b] {
+			owner[member] = a
+		}
+		members[a] = append(members[a], members[b]...)
+		delete(members, b)
+		similarity[a] = max(similarity[a], similarity[b], p.similarity)
+	}
+
+	var clusters []cluster
+	for i := range paths {
+		if owner[i] != i {
+			continue
+		}
+		group := make([]string, 0, len(members[i]))
+		for _, member := range members[i] {
+			group = append(group, paths[member])
+		}
+		sort.Strings(group)
+		clusters = append(clusters, cluster{paths: group, similarity: similarity[i]})
+	}
+	return clusters
+}
+
+// allSimilar reports whether every note of a is similar to every note of b
+func allSimilar(similar [][]bool, a, b []int) bool {
+	for _, i := range a {
+		for _, j := range b {
+			if !similar[i][j] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// centralNote returns the title of the note most similar to the others, the first in path order on a tie
+func (idx Index) centralNote(paths []string) string {
+	best, bestTotal := paths[0], -1.0
+	for _, path := range paths {
+		total := 0.0
+		for _, other := range paths {
+			if other != path {
+				total += cosine(idx[path], idx[other])
+			}
+		}
+		if total > bestTotal {
+			best, bestTotal = path, total
+		}
+	}
+	return strings.TrimSuffix(filepath.Base(best), filepath.Ext(best))
+}
+
+// cosine returns the cosine similarity of two vectors, 0 if either is empty or they differ in length
+func cosine(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+// relativePath returns the path relative to the target folder using forward slashes
+func relativePath(targetFolder, path string) string {
+	relPath, err := filepath.Rel(targetFolder, path)
+	if err != nil {
+		relPath = path
+	}
+	return filepath.ToSlash(relPath)
+}
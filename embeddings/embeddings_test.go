@@ -0,0 +1,131 @@
+package embeddings
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"ratemykb/analysis"
+	"ratemykb/scanner"
+)
+
+// lengthEmbedder is a test Embedder returning the text length as a one-dimensional vector
+type lengthEmbedder struct {
+	calls int
+}
+
+func (e *lengthEmbedder) CreateEmbedding(ctx context.Context, texts []string) ([][]float32, error) {
+	e.calls++
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = []float32{float32(len(text))}
+	}
+	return vectors, nil
+}
+
+func TestBuildAndExport(t *testing.T) {
+	tempDir := t.TempDir()
+
+	notePath := filepath.Join(tempDir, "notes", "note.md")
+	if err := os.MkdirAll(filepath.Dir(notePath), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(notePath, []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to create note: %v", err)
+	}
+
+	files := []scanner.File{
+		{Path: notePath, Status: scanner.StatusNeedsReview},
+		{Path: filepath.Join(tempDir, "empty.md"), Status: scanner.StatusEmpty},
+	}
+
+	embedder := &lengthEmbedder{}
+	index, err := Build(context.Background(), embedder, tempDir, files)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	want := Index{"notes/note.md": {5}}
+	if !reflect.DeepEqual(index, want) {
+		t.Errorf("Build() = %v, want %v", index, want)
+	}
+	if embedder.calls != 1 {
+		t.Errorf("Expected 1 embedding request, got %d", embedder.calls)
+	}
+
+	// Export and read back the index
+	exportPath := filepath.Join(tempDir, "embeddings.json")
+	if err := index.WriteJSON(exportPath); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+
+	data, err := os.ReadFile(exportPath)
+	if err != nil {
+		t.Fatalf("Failed to read export: %v", err)
+	}
+	var exported Index
+	if err := json.Unmarshal(data, &exported); err != nil {
+		t.Fatalf("Failed to decode export: %v", err)
+	}
+	if !reflect.DeepEqual(exported, want) {
+		t.Errorf("Exported index = %v, want %v", exported, want)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	index := Index{
+		"b.md":       {1, 0},
+		"a.md":       {1, 0.01},
+		"c.md":       {0.99, 0.1},
+		"other.md":   {0, 1},
+		"unique.md":  {-1, 0},
+		"zero.md":    {0, 0},
+		"vector.md":  {1},
+		"nearby.md":  {0.01, 1},
+		"farther.md": {0.5, 0.5},
+	}
+
+	groups := index.Duplicates(0.99)
+	if len(groups) != 2 {
+		t.Fatalf("Duplicates() = %v, want 2 groups", groups)
+	}
+	if want := []string{"a.md", "b.md", "c.md"}; !reflect.DeepEqual(groups[0].Paths, want) {
+		t.Errorf("Expected the first group to be %v, got %v", want, groups[0].Paths)
+	}
+	if groups[0].Similarity < 0.9999 || groups[0].Similarity > 1 {
+		t.Errorf("Expected the closest pair to be almost identical, got %f", groups[0].Similarity)
+	}
+	if want := []string{"nearby.md", "other.md"}; !reflect.DeepEqual(groups[1].Paths, want) {
+		t.Errorf("Expected the second group to be %v, got %v", want, groups[1].Paths)
+	}
+
+	if groups := index.Duplicates(1.01); len(groups) != 0 {
+		t.Errorf("Expected no groups above a similarity of 1, got %v", groups)
+	}
+}
+
+func TestTopics(t *testing.T) {
+	index := Index{
+		"k8s/pods.md":      {1, 0},
+		"k8s/ingress.md":   {0.98, 0.2},
+		"k8s/services.md":  {0.95, 0.3},
+		"k8s/helm.md":      {0.766, 0.643}, // Like services, but not like pods
+		"cooking/bread.md": {0, 1},
+		"cooking/cake.md":  {0.1, 1},
+		"lonely.md":        {-1, 0},
+		"good.md":          {1, 0},
+	}
+	paths := []string{"cooking/cake.md", "k8s/services.md", "lonely.md", "k8s/pods.md", "cooking/bread.md", "k8s/ingress.md", "k8s/helm.md", "missing.md"}
+
+	topics := index.Topics(paths, 0.9)
+	want := []analysis.TopicCluster{
+		{Name: "ingress", Paths: []string{"k8s/ingress.md", "k8s/pods.md", "k8s/services.md"}},
+		{Name: "bread", Paths: []string{"cooking/bread.md", "cooking/cake.md"}},
+	}
+	if !reflect.DeepEqual(topics, want) {
+		t.Errorf("Topics() = %v, want %v", topics, want)
+	}
+}
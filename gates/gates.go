@@ -0,0 +1,224 @@
+package gates
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"ratemykb/config"
+	"ratemykb/output"
+)
+
+// Package gates evaluates quality gates at the end of a run, so CI can fail when a vault, or a folder held to a
+// stricter standard, has too many files with a classification
+
+// ErrFailed indicates that at least one quality gate failed
+var ErrFailed = errors.New("quality gates failed")
+
+// Gate fails when more files in its scope have a classification than its threshold allows
+type Gate struct {
+	Expression     string  // Gate as written, such as "Empty>0" or "Low quality>=25%"
+	Classification string  // Compared case-insensitively
+	Inclusive      bool    // Fails when the count reaches the threshold rather than exceeds it
+	Threshold      float64 // Number of files, or share of the files in scope in percent
+	Percent        bool
+}
+
+// Profile applies its own gates to the files matching a folder glob
+type Profile struct {
+	Folder  string // Glob relative to the target folder, such as docs/runbooks/**
+	Gates   []Gate
+	Ignore  bool // Leave the matching files out of every gate
+	pattern *regexp.Regexp
+}
+
+// Set holds the gates of a run: the profiles, checked in order, and the default gates for files matching none
+type Set struct {
+	Default  []Gate
+	Profiles []Profile
+}
+
+// Failure describes a gate that failed
+type Failure struct {
+	Scope string // Folder glob of the profile, or "vault" for the default gates
+	Gate  Gate
+	Count int // Files with the classification in scope
+	Total int // Files in scope
+}
+
+// Result is the outcome of a gate for the files in its scope
+type Result struct {
+	Scope  string // Folder glob of the profile, or "vault" for the default gates
+	Gate   Gate
+	Count  int // Files with the classification in scope
+	Total  int // Files in scope
+	Failed bool
+}
+
+// Failure describes the result as a failure
+func (r Result) Failure() Failure {
+	return Failure{Scope: r.Scope, Gate: r.Gate, Count: r.Count, Total: r.Total}
+}
+
+// String describes the failure for the console
+func (f Failure) String() string {
+	return fmt.Sprintf("%s: %s (%d of %d files)", f.Scope, f.Gate.Expression, f.Count, f.Total)
+}
+
+// gatePattern matches a gate such as "Low quality>=25%"
+var gatePattern = regexp.MustCompile(`^\s*(.+?)\s*(>=|>)\s*(\d+(?:\.\d+)?)\s*(%?)\s*$`)
+
+// Parse parses a gate written as a classification, > or >=, and a number of files or a percentage
+func Parse(expression string) (Gate, error) {
+	matches := gatePattern.FindStringSubmatch(expression)
+	if matches == nil {
+		return Gate{}, fmt.Errorf("invalid quality gate %q, expected for example \"Empty>0\" or \"Low quality>=25%%\"", expression)
+	}
+
+	threshold, err := strconv.ParseFloat(matches[3], 64)
+	if err != nil {
+		return Gate{}, fmt.Errorf("invalid quality gate %q: %w", expression, err)
+	}
+
+	return Gate{
+		Expression:     strings.TrimSpace(expression),
+		Classification: matches[1],
+		Inclusive:      matches[2] == ">=",
+		Threshold:      threshold,
+		Percent:        matches[4] == "%",
+	}, nil
+}
+
+// New creates the gates of a run from the configuration, adding the gates given on the command line to the
+// default gates
+func New(cfg config.QualityGatesConfig, failIf []string) (*Set, error) {
+	set := &Set{}
+
+	var err error
+	if set.Default, err = parseAll(append(append([]string{}, cfg.FailIf...), failIf...)); err != nil {
+		return nil, err
+	}
+
+	for _, profileCfg := range cfg.Profiles {
+		gates, err := parseAll(profileCfg.FailIf)
+		if err != nil {
+			return nil, fmt.Errorf("quality gate profile %s: %w", profileCfg.Folder, err)
+		}
+		set.Profiles = append(set.Profiles, Profile{
+			Folder:  profileCfg.Folder,
+			Gates:   gates,
+			Ignore:  profileCfg.Ignore,
+			pattern: config.FolderPattern(profileCfg.Folder),
+		})
+	}
+
+	return set, nil
+}
+
+// parseAll parses a list of gates
+func parseAll(expressions []string) ([]Gate, error) {
+	var gates []Gate
+	for _, expression := range expressions {
+		gate, err := Parse(expression)
+		if err != nil {
+			return nil, err
+		}
+		gates = append(gates, gate)
+	}
+	return gates, nil
+}
+
+// Empty reports whether the set has no gate to evaluate
+func (s *Set) Empty() bool {
+	if len(s.Default) > 0 {
+		return false
+	}
+	for _, profile := range s.Profiles {
+		if len(profile.Gates) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Evaluate checks the gates against the processed files and returns the gates that failed, in configuration order
+func (s *Set) Evaluate(targetFolder string, files map[string]output.ResultFile) []Failure {
+	var failures []Failure
+	for _, result := range s.Check(targetFolder, files) {
+		if result.Failed {
+			failures = append(failures, result.Failure())
+		}
+	}
+	return failures
+}
+
+// Check checks the gates against the processed files and returns the outcome of every gate, in configuration order
+// Each file counts towards the first profile whose glob matches its path, or the default gates if none matches.
+func (s *Set) Check(targetFolder string, files map[string]output.ResultFile) []Result {
+	// Group the classifications by scope, -1 being the default gates
+	scopes := make(map[int][]string)
+	for path, file := range files {
+		relPath, err := filepath.Rel(targetFolder, path)
+		if err != nil {
+			relPath = path
+		}
+		scope := s.profileFor(filepath.ToSlash(relPath))
+		if scope >= 0 && s.Profiles[scope].Ignore {
+			continue
+		}
+		scopes[scope] = append(scopes[scope], string(file.Classification))
+	}
+
+	var results []Result
+	check := func(scope string, gates []Gate, classifications []string) {
+		for _, gate := range gates {
+			count := 0
+			for _, c := range classifications {
+				if strings.EqualFold(c, gate.Classification) {
+					count++
+				}
+			}
+			results = append(results, Result{
+				Scope:  scope,
+				Gate:   gate,
+				Count:  count,
+				Total:  len(classifications),
+				Failed: gate.fails(count, len(classifications)),
+			})
+		}
+	}
+
+	check("vault", s.Default, scopes[-1])
+	for i, profile := range s.Profiles {
+		check(profile.Folder, profile.Gates, scopes[i])
+	}
+	return results
+}
+
+// profileFor returns the index of the first profile matching a slash-separated relative path, or -1
+func (s *Set) profileFor(relPath string) int {
+	for i, profile := range s.Profiles {
+		if profile.pattern.MatchString(relPath) {
+			return i
+		}
+	}
+	return -1
+}
+
+// fails reports whether count files out of total exceed the threshold of the gate
+func (g Gate) fails(count, total int) bool {
+	value := float64(count)
+	if g.Percent {
+		if total == 0 {
+			return false
+		}
+		value = value / float64(total) * 100
+	}
+	if g.Inclusive {
+		return value >= g.Threshold
+	}
+	return value > g.Threshold
+}
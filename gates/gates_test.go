@@ -0,0 +1,72 @@
+package gates
+
+import (
+	"path/filepath"
+	"testing"
+
+	"ratemykb/classification"
+	"ratemykb/config"
+	"ratemykb/output"
+)
+
+func TestParse(t *testing.T) {
+	gate, err := Parse(" Low quality >= 25% ")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := Gate{Expression: "Low quality >= 25%", Classification: "Low quality", Inclusive: true, Threshold: 25, Percent: true}
+	if gate != want {
+		t.Errorf("Parse() = %+v, want %+v", gate, want)
+	}
+
+	for _, expression := range []string{"Empty", "Empty<3", ">0", "Empty>many"} {
+		if _, err := Parse(expression); err == nil {
+			t.Errorf("Expected an error for %q", expression)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	root := filepath.FromSlash("/vault")
+	files := make(map[string]output.ResultFile)
+	add := func(path, label string) {
+		path = filepath.Join(root, filepath.FromSlash(path))
+		files[path] = output.ResultFile{Path: path, Classification: classification.Classification(label)}
+	}
+	add("docs/runbooks/restart.md", "Empty")
+	add("docs/runbooks/failover.md", "Good enough")
+	add("notes/scratch/a.md", "Empty")
+	add("notes/scratch/b.md", "Empty")
+	add("notes/idea.md", "Low quality")
+	add("notes/plan.md", "Good enough")
+
+	cfg := config.QualityGatesConfig{
+		FailIf: []string{"empty>0"},
+		Profiles: []config.QualityGateProfile{
+			{Folder: "docs/runbooks/**", FailIf: []string{"Empty>0"}},
+			{Folder: "notes/scratch/**", Ignore: true},
+		},
+	}
+	set, err := New(cfg, []string{"Low quality>=50%"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	failures := set.Evaluate(root, files)
+	if len(failures) != 2 {
+		t.Fatalf("Expected 2 failures, got %v", failures)
+	}
+	if got, want := failures[0].String(), "vault: Low quality>=50% (1 of 2 files)"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got, want := failures[1].String(), "docs/runbooks/**: Empty>0 (1 of 2 files)"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	if _, err := New(config.QualityGatesConfig{}, []string{"nonsense"}); err == nil {
+		t.Error("Expected an error for an invalid gate")
+	}
+	if set, _ := New(config.QualityGatesConfig{Profiles: []config.QualityGateProfile{{Folder: "x", Ignore: true}}}, nil); !set.Empty() {
+		t.Error("Expected a set without gates to be empty")
+	}
+}
@@ -409,7 +409,7 @@ scan_settings:
   exclude_directories: []
 
 prompt_config:
-  quality_classification_prompt: "Review the content and determine if it's: 'Empty', 'Low quality/low effort', or 'Good enough'."
+  quality_classification_prompt: "Review the content and determine if it's: 'Empty', 'Low quality/low effort', or 'Good enough'. {{ content }}"
 
 exclusion_file:
   path: "quality_exclude_links.md"
@@ -0,0 +1,247 @@
+package links
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"ratemykb/config"
+	"ratemykb/safewrite"
+)
+
+// Package links finds Obsidian wiki links that do not resolve to a file and suggests the closest existing note
+
+// Pattern matches an Obsidian link, capturing the linked note and the optional heading or alias
+var Pattern = regexp.MustCompile(`\[\[([^\]|#]+)([^\]]*)\]\]`)
+
+// MarkdownPattern matches a markdown link or embed [text](path/file.pdf "title"), capturing the linked file
+var MarkdownPattern = regexp.MustCompile(`\]\(<?([^)\s>]+)>?[^)]*\)`)
+
+// fencePattern matches the start or end of a fenced code block, whose links are not checked
+var fencePattern = regexp.MustCompile("^\\s*(```|~~~)")
+
+// inlineCodePattern matches inline code, whose links are not checked
+var inlineCodePattern = regexp.MustCompile("`[^`]*`")
+
+// BrokenLink represents a wiki link whose target does not exist in the vault
+type BrokenLink struct {
+	Path       string // Path to the file containing the link
+	Line       int    // Line number of the link, starting at 1
+	Target     string // Linked note as written in the link
+	Suggestion string // Closest existing note as it would be written in a link, empty if none is close enough
+}
+
+// LinkPath returns the path of a note as written in an Obsidian link: relative, with forward slashes and
+// without the note extension
+func LinkPath(targetFolder, path string) string {
+	if relPath, err := filepath.Rel(targetFolder, path); err == nil {
+		path = relPath
+	}
+	return filepath.ToSlash(strings.TrimSuffix(path, filepath.Ext(path)))
+}
+
+// Target returns the note a link match points to, without surrounding spaces or the .md extension
+func Target(match []string) string {
+	return strings.TrimSuffix(strings.TrimSpace(match[1]), ".md")
+}
+
+// Refs returns the files referenced by the wiki and markdown links of a note, as written in the links
+func Refs(content string) []string {
+	var refs []string
+	for _, match := range Pattern.FindAllStringSubmatch(content, -1) {
+		refs = append(refs, strings.TrimSpace(match[1]))
+	}
+	for _, match := range MarkdownPattern.FindAllStringSubmatch(content, -1) {
+		refs = append(refs, match[1])
+	}
+	return refs
+}
+
+// FindBroken returns the wiki links in notes of the target folder that do not resolve to any file
+func FindBroken(targetFolder, fileExtension string) ([]BrokenLink, error) {
+	var notes []string
+	known := make(map[string]bool)
+
+	err := filepath.Walk(targetFolder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if info.Name() == config.StateDir || info.Name() == config.TrashDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Links may point to attachments by path or name, and to notes without their extension
+		relPath, _ := filepath.Rel(targetFolder, path)
+		known[strings.ToLower(filepath.ToSlash(relPath))] = true
+		known[strings.ToLower(info.Name())] = true
+		if strings.HasSuffix(info.Name(), fileExtension) {
+			notes = append(notes, path)
+			linkPath := LinkPath(targetFolder, path)
+			known[strings.ToLower(linkPath)] = true
+			known[strings.ToLower(filepath.Base(linkPath))] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %w", targetFolder, err)
+	}
+
+	titles := NewTitles(targetFolder, notes)
+	suggestions := make(map[string]string)
+
+	var broken []BrokenLink
+	for _, note := range notes {
+		content, err := os.ReadFile(note)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", note, err)
+		}
+
+		inFence := false
+		for i, line := range strings.Split(string(content), "\n") {
+			// Links in code are examples rather than links
+			if fencePattern.MatchString(line) {
+				inFence = !inFence
+				continue
+			}
+			if inFence {
+				continue
+			}
+
+			for _, match := range Pattern.FindAllStringSubmatch(inlineCodePattern.ReplaceAllString(line, ""), -1) {
+				target := Target(match)
+				if known[strings.ToLower(target)] {
+					continue
+				}
+
+				suggestion, ok := suggestions[target]
+				if !ok {
+					suggestion = titles.Suggest(target)
+					suggestions[target] = suggestion
+				}
+				broken = append(broken, BrokenLink{
+					Path:       note,
+					Line:       i + 1,
+					Target:     target,
+					Suggestion: suggestion,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(broken, func(i, j int) bool {
+		return broken[i].Path < broken[j].Path
+	})
+
+	return broken, nil
+}
+
+// Titles indexes the notes of a vault by title, to suggest the closest note to broken links
+type Titles struct {
+	titles []string            // Distinct titles in lowercase, sorted
+	notes  map[string][]string // Link paths of the notes of each title, in the order of the notes
+}
+
+// NewTitles indexes the titles of the given notes
+func NewTitles(targetFolder string, notes []string) *Titles {
+	t := &Titles{notes: make(map[string][]string)}
+	for _, note := range notes {
+		linkPath := LinkPath(targetFolder, note)
+		title := strings.ToLower(filepath.Base(linkPath))
+		if t.notes[title] == nil {
+			t.titles = append(t.titles, title)
+		}
+		t.notes[title] = append(t.notes[title], linkPath)
+	}
+	sort.Strings(t.titles)
+	return t
+}
+
+// Suggest returns the note closest to a broken link target as it would be written in a link,
+// or an empty string if no note is close enough to be a likely typo or rename
+func (t *Titles) Suggest(target string) string {
+	wanted := strings.ToLower(filepath.Base(target))
+
+	// Only accept small edits relative to the length of the title
+	limit := max(2, len(wanted)/3)
+	length := utf8.RuneCountInString(wanted)
+
+	best := ""
+	bestDistance := limit + 1
+	for _, title := range t.titles {
+		// The length difference alone rules out most titles
+		if diff := utf8.RuneCountInString(title) - length; diff >= bestDistance || -diff >= bestDistance {
+			continue
+		}
+		if distance := levenshtein(wanted, title); distance < bestDistance {
+			best, bestDistance = title, distance
+		}
+	}
+	if best == "" {
+		return ""
+	}
+
+	// Link by title unless it is shared by several notes
+	linkPaths := t.notes[best]
+	if len(linkPaths) > 1 {
+		return linkPaths[0]
+	}
+	return filepath.Base(linkPaths[0])
+}
+
+// Rewrite replaces the links to a target in a file with links to a replacement as part of a run, keeping
+// headings and aliases, and returns whether the file changed
+func Rewrite(run *safewrite.Run, path, target, replacement string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	updated := Pattern.ReplaceAllStringFunc(string(content), func(link string) string {
+		match := Pattern.FindStringSubmatch(link)
+		if Target(match) != target {
+			return link
+		}
+		return "[[" + replacement + match[2] + "]]"
+	})
+
+	if updated == string(content) {
+		return false, nil
+	}
+	if err := run.WriteFile(path, []byte(updated)); err != nil {
+		return false, fmt.Errorf("failed to update links in %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// levenshtein returns the number of single character edits needed to turn one string into the other
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
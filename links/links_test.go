@@ -0,0 +1,97 @@
+package links
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ratemykb/safewrite"
+)
+
+func TestFindBrokenAndRewrite(t *testing.T) {
+	tempDir := t.TempDir()
+
+	index := filepath.Join(tempDir, "index.md")
+	files := map[string]string{
+		filepath.Join(tempDir, "notes", "Kubernetes.md"): "content",
+		filepath.Join(tempDir, "image.png"):              "",
+		index: "[[notes/Kubernetes]] [[Kubernetes]] ![[image.png]]\n" +
+			"[[Kubernets#Pods|pods]] and [[Something else entirely]]\n" +
+			"Write `[[Example]]` for a link\n```\n[[Template]]\n```",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create folder: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	broken, err := FindBroken(tempDir, ".md")
+	if err != nil {
+		t.Fatalf("FindBroken() error = %v", err)
+	}
+	if len(broken) != 2 {
+		t.Fatalf("Expected 2 broken links, got %d: %+v", len(broken), broken)
+	}
+	if broken[0].Target != "Kubernets" || broken[0].Line != 2 || broken[0].Suggestion != "Kubernetes" {
+		t.Errorf("Unexpected broken link: %+v", broken[0])
+	}
+	if broken[1].Suggestion != "" {
+		t.Errorf("Expected no suggestion for an unrelated target, got %q", broken[1].Suggestion)
+	}
+
+	changed, err := Rewrite(safewrite.Begin(tempDir, "fix-links"), index, broken[0].Target, broken[0].Suggestion)
+	if err != nil {
+		t.Fatalf("Rewrite() error = %v", err)
+	}
+	if !changed {
+		t.Error("Expected the file to change")
+	}
+
+	content, _ := os.ReadFile(index)
+	expected := "[[notes/Kubernetes]] [[Kubernetes]] ![[image.png]]\n" +
+		"[[Kubernetes#Pods|pods]] and [[Something else entirely]]\n" +
+		"Write `[[Example]]` for a link\n```\n[[Template]]\n```"
+	if string(content) != expected {
+		t.Errorf("Expected %q, got %q", expected, content)
+	}
+}
+
+func TestTitlesSuggest(t *testing.T) {
+	titles := NewTitles("/vault", []string{"/vault/a/Setup.md", "/vault/b/setup.md", "/vault/Kubernetes.md", "/vault/Pods.md"})
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"Kubernets", "Kubernetes"},
+		{"k8s/Pod", "Pods"},
+		{"Setpu", "a/Setup"}, // Shared titles are linked by path
+		{"Networking", ""},
+	}
+
+	for _, tt := range tests {
+		if got := titles.Suggest(tt.target); got != tt.want {
+			t.Errorf("Suggest(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"same", "same", 0},
+	}
+
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.expected {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.expected)
+		}
+	}
+}
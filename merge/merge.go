@@ -0,0 +1,180 @@
+package merge
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ratemykb/config"
+	"ratemykb/links"
+	"ratemykb/safewrite"
+)
+
+// Package merge helps combine duplicate notes: it diffs two notes, proposes a merged version
+// and redirects the links pointing to the note that is removed
+
+// Op describes how a line of a diff relates to the two notes
+type Op int
+
+const (
+	Equal  Op = iota // Line is present in both notes
+	Delete           // Line is only present in the first note
+	Insert           // Line is only present in the second note
+)
+
+// Line is a single line of a diff
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// Diff returns the line-based difference between two notes
+func Diff(first, second string) []Line {
+	a := splitLines(first)
+	b := splitLines(second)
+
+	// Longest common subsequence table, filled from the end so the diff can be read front to back
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []Line
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, Line{Op: Equal, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, Line{Op: Delete, Text: a[i]})
+			i++
+		default:
+			lines = append(lines, Line{Op: Insert, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, Line{Op: Delete, Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, Line{Op: Insert, Text: b[j]})
+	}
+
+	return lines
+}
+
+// FormatDiff renders a diff with the usual "-" and "+" line prefixes
+func FormatDiff(lines []Line) string {
+	var sb strings.Builder
+	for _, line := range lines {
+		switch line.Op {
+		case Equal:
+			sb.WriteString("  ")
+		case Delete:
+			sb.WriteString("- ")
+		case Insert:
+			sb.WriteString("+ ")
+		}
+		sb.WriteString(line.Text)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// Combine proposes a merged note that keeps every line of both notes in diff order
+// Lines shared by both notes appear once
+func Combine(first, second string) string {
+	var lines []string
+	for _, line := range Diff(first, second) {
+		lines = append(lines, line.Text)
+	}
+
+	merged := strings.Join(lines, "\n")
+	if merged != "" {
+		merged += "\n"
+	}
+	return merged
+}
+
+// splitLines splits content into lines, ignoring a trailing newline
+func splitLines(content string) []string {
+	content = strings.TrimSuffix(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	if content == "" {
+		return nil
+	}
+	return strings.Split(content, "\n")
+}
+
+// RedirectLinks rewrites the links to the removed note in all files of the target folder so they point to
+// the kept note as part of a run, and returns the number of files changed
+func RedirectLinks(run *safewrite.Run, targetFolder, fileExtension, removed, kept string) (int, error) {
+	removedPath := links.LinkPath(targetFolder, removed)
+	removedTitle := filepath.Base(removedPath)
+	keptPath := links.LinkPath(targetFolder, kept)
+	keptTitle := filepath.Base(keptPath)
+
+	// Links using the title only are left alone when both notes share it
+	redirectTitle := !strings.EqualFold(removedTitle, keptTitle)
+
+	changed := 0
+	err := filepath.Walk(targetFolder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if info.Name() == config.StateDir || info.Name() == config.TrashDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(info.Name(), fileExtension) {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		updated := links.Pattern.ReplaceAllStringFunc(string(content), func(link string) string {
+			match := links.Pattern.FindStringSubmatch(link)
+			target := links.Target(match)
+
+			switch {
+			case strings.EqualFold(target, removedPath):
+				return "[[" + keptPath + match[2] + "]]"
+			case redirectTitle && strings.EqualFold(target, removedTitle):
+				return "[[" + keptTitle + match[2] + "]]"
+			default:
+				return link
+			}
+		})
+
+		if updated == string(content) {
+			return nil
+		}
+		if err := run.WriteFile(path, []byte(updated)); err != nil {
+			return fmt.Errorf("failed to update links in %s: %w", path, err)
+		}
+		changed++
+		return nil
+	})
+	if err != nil {
+		return changed, err
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,65 @@
+package merge
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ratemykb/safewrite"
+)
+
+func TestDiff(t *testing.T) {
+	lines := Diff("# Title\nshared\nfirst only\n", "# Title\nsecond only\nshared\n")
+
+	expected := "  # Title\n+ second only\n  shared\n- first only\n"
+	if got := FormatDiff(lines); got != expected {
+		t.Errorf("Expected diff:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestCombine(t *testing.T) {
+	merged := Combine("# Title\nshared\nfirst only\n", "# Title\nsecond only\nshared\n")
+
+	expected := "# Title\nsecond only\nshared\nfirst only\n"
+	if merged != expected {
+		t.Errorf("Expected merged note:\n%s\ngot:\n%s", expected, merged)
+	}
+}
+
+func TestRedirectLinks(t *testing.T) {
+	tempDir := t.TempDir()
+
+	removed := filepath.Join(tempDir, "old", "Topic.md")
+	kept := filepath.Join(tempDir, "Topic.md")
+	linking := filepath.Join(tempDir, "index.md")
+	untouched := filepath.Join(tempDir, "other.md")
+
+	files := map[string]string{
+		removed:   "old",
+		kept:      "kept",
+		linking:   "See [[old/Topic#Heading|the topic]], [[Topic]] and ![[old/topic.md]]",
+		untouched: "See [[Other]]",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create folder: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	changed, err := RedirectLinks(safewrite.Begin(tempDir, "merge"), tempDir, ".md", removed, kept)
+	if err != nil {
+		t.Fatalf("RedirectLinks() error = %v", err)
+	}
+	if changed != 1 {
+		t.Errorf("Expected 1 changed file, got %d", changed)
+	}
+
+	content, _ := os.ReadFile(linking)
+	expected := "See [[Topic#Heading|the topic]], [[Topic]] and ![[Topic]]"
+	if string(content) != expected {
+		t.Errorf("Expected %q, got %q", expected, content)
+	}
+}
@@ -13,9 +13,25 @@ import (
 
 // ResultFile represents a file entry for the final report
 type ResultFile struct {
-	Path           string                        // Full path to the file
-	Status         scanner.FileStatus            // Status from scanner pre-checks
-	Classification classification.Classification // Classification from the AI
+	Path           string                        `json:"path"`                      // Full path to the file
+	Status         scanner.FileStatus            `json:"status"`                    // Status from scanner pre-checks
+	Classification classification.Classification `json:"classification"`            // Classification from the AI
+	Truncated      bool                          `json:"truncated,omitempty"`       // Only part of the file was classified
+	Encoding       string                        `json:"encoding,omitempty"`        // Encoding the file was transcoded from, empty for UTF-8
+	Confidence     float64                       `json:"confidence,omitempty"`      // Between 0 and 1, only set when sampling or when the model was not certain
+	Provider       string                        `json:"provider,omitempty"`        // Engine that classified the file, only set when fallback engines are configured
+	Reason         string                        `json:"reason,omitempty"`          // Sentence the model gave to justify the classification
+	Scores         map[string]int                `json:"scores,omitempty"`          // Score from 1 to 5 per dimension, only set when scoring is configured
+	Score          float64                       `json:"score,omitempty"`           // Overall score weighted by dimension
+	Rating         *int                          `json:"rating,omitempty"`          // From 0 to 100, only set when rating is on
+	Metadata       map[string]string             `json:"metadata,omitempty"`        // Frontmatter fields listed in report.metadata_fields that the file has
+	Suggestions    []string                      `json:"suggestions,omitempty"`     // Actions the model suggested to improve the file, only set in suggest mode
+	SuggestedTags  []string                      `json:"suggested_tags,omitempty"`  // Tags the model suggested for a note without any, only set when tag suggestions are on
+	Summary        string                        `json:"summary,omitempty"`         // One-line summary of the note by the model, only set when summaries are on
+	TitleMismatch  string                        `json:"title_mismatch,omitempty"`  // What the note is about instead of its title according to the model, only set when the title check is on
+	MissingSources bool                          `json:"missing_sources,omitempty"` // Reference note without any source, only set when the source check is on
+	Votes          []classification.Vote         `json:"votes,omitempty"`           // Label each engine of the ensemble gave, only set with an ensemble
+	ContentHash    string                        `json:"content_hash,omitempty"`    // SHA-256 of the content the model classified, to tell a changed verdict from a changed note
 }
 
 // Generator handles the generation of the final report
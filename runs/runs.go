@@ -0,0 +1,127 @@
+package runs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"ratemykb/config"
+	"ratemykb/safewrite"
+)
+
+// Package runs records a manifest for every scan of a target folder under .ratemykb/runs/, so the results of
+// a run can be traced back to the configuration and model that produced them.
+
+// Manifest describes a single run
+type Manifest struct {
+	ID         string         `json:"id"`
+	Command    string         `json:"command"`
+	Started    time.Time      `json:"started"`
+	Finished   *time.Time     `json:"finished,omitempty"` // Not set while the run is in progress or if it was interrupted
+	Duration   string         `json:"duration,omitempty"`
+	Model      string         `json:"model"`
+	Endpoint   string         `json:"endpoint"`
+	Config     *config.Config `json:"config"` // Configuration in effect, including command line overrides
+	Counts     map[string]int `json:"counts,omitempty"`
+	Labels     map[string]int `json:"labels,omitempty"` // Processed files by classification at the end of the run
+	TokensUsed int            `json:"tokens_used"`
+}
+
+// Run is a run in progress
+type Run struct {
+	Manifest
+	targetFolder string
+}
+
+// Dir returns the folder where the run manifests of a target folder are recorded
+func Dir(targetFolder string) string {
+	return filepath.Join(targetFolder, config.StateDir, "runs")
+}
+
+// Start assigns a new run its ID and writes its manifest
+// The ID is reserved with safewrite.ReserveID, so changes the run makes to notes are undone under the same ID.
+func Start(targetFolder, command string, cfg *config.Config) (*Run, error) {
+	r := &Run{
+		Manifest: Manifest{
+			Command:  command,
+			Started:  time.Now(),
+			Model:    config.EngineModel(cfg.AIEngine.Provider, cfg.AIEngine.Model, cfg.AIEngine.Deployment),
+			Endpoint: cfg.AIEngine.URL,
+			Config:   cfg,
+		},
+		targetFolder: targetFolder,
+	}
+
+	if err := os.MkdirAll(Dir(targetFolder), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create runs folder: %w", err)
+	}
+
+	id, err := safewrite.ReserveID(targetFolder, r.Started)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve run ID: %w", err)
+	}
+	r.ID = id
+
+	return r, r.save()
+}
+
+// Finish records the counts, labels and token usage of the run along with its duration
+func (r *Run) Finish(counts, labels map[string]int, tokensUsed int) error {
+	finished := time.Now()
+	r.Finished = &finished
+	r.Duration = finished.Sub(r.Started).Round(time.Millisecond).String()
+	r.Counts = counts
+	r.Labels = labels
+	r.TokensUsed = tokensUsed
+	return r.save()
+}
+
+// path returns the manifest file of a run
+func (r *Run) path(id string) string {
+	return filepath.Join(Dir(r.targetFolder), id+".json")
+}
+
+// save writes the manifest of the run
+func (r *Run) save() error {
+	if err := safewrite.WriteJSON(r.path(r.ID), r.Manifest); err != nil {
+		return fmt.Errorf("failed to write run manifest: %w", err)
+	}
+	return nil
+}
+
+// List returns the manifests of all recorded runs of a target folder, oldest first
+func List(targetFolder string) ([]Manifest, error) {
+	matches, err := filepath.Glob(filepath.Join(Dir(targetFolder), "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runs: %w", err)
+	}
+
+	var manifests []Manifest
+	for _, match := range matches {
+		data, err := os.ReadFile(match)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read run manifest: %w", err)
+		}
+
+		var manifest Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("failed to parse run manifest %s: %w", filepath.Base(match), err)
+		}
+		manifests = append(manifests, manifest)
+	}
+
+	sort.Slice(manifests, func(i, j int) bool {
+		if !manifests[i].Started.Equal(manifests[j].Started) {
+			return manifests[i].Started.Before(manifests[j].Started)
+		}
+		return manifests[i].ID < manifests[j].ID
+	})
+	return manifests, nil
+}
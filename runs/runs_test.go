@@ -0,0 +1,84 @@
+package runs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ratemykb/config"
+	"ratemykb/safewrite"
+)
+
+func TestStartAndFinish(t *testing.T) {
+	tempDir := t.TempDir()
+	cfg := config.GetDefaultConfig()
+
+	first, err := Start(tempDir, "scan", cfg)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	second, err := Start(tempDir, "scan", cfg)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if first.ID == second.ID {
+		t.Errorf("Expected unique run IDs, got %s twice", first.ID)
+	}
+
+	// Commands changing notes draw from the same IDs, so undo finds the run a scan printed
+	changes := safewrite.Begin(tempDir, "clean-empty")
+	if err := changes.WriteFile(filepath.Join(tempDir, "note.md"), []byte("# Note")); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if changes.ID == first.ID || changes.ID == second.ID {
+		t.Errorf("Expected the changes to get a run ID of their own, got %s", changes.ID)
+	}
+
+	if err := first.Finish(map[string]int{"files_found": 3}, map[string]int{"Good enough": 2, "Empty": 1}, 120); err != nil {
+		t.Fatalf("Finish() error = %v", err)
+	}
+
+	manifests, err := List(tempDir)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(manifests) != 2 {
+		t.Fatalf("Expected 2 runs, got %d", len(manifests))
+	}
+
+	finished := manifests[0]
+	if finished.ID != first.ID || finished.Finished == nil || finished.Duration == "" {
+		t.Errorf("Expected the first run to be finished, got %+v", finished)
+	}
+	if finished.Counts["files_found"] != 3 || finished.Labels["Good enough"] != 2 || finished.TokensUsed != 120 {
+		t.Errorf("Unexpected counts %v, labels %v and tokens %d", finished.Counts, finished.Labels, finished.TokensUsed)
+	}
+	if finished.Model != cfg.AIEngine.Model || finished.Config == nil || finished.Config.AIEngine.URL != cfg.AIEngine.URL {
+		t.Errorf("Expected the configuration to be recorded, got %+v", finished)
+	}
+
+	// A run that never finished, for example because it was interrupted, has no end time
+	if manifests[1].Finished != nil {
+		t.Errorf("Expected the second run to be unfinished, got %v", manifests[1].Finished)
+	}
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(Dir(tempDir))
+	if err != nil {
+		t.Fatalf("Failed to read runs folder: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 manifests, got %d entries", len(entries))
+	}
+
+	// Azure OpenAI is asked for a deployment rather than a model
+	cfg.AIEngine.Provider = config.ProviderAzureOpenAI
+	cfg.AIEngine.Deployment = "quality-gpt4o"
+	azure, err := Start(tempDir, "scan", cfg)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if azure.Model != "quality-gpt4o" {
+		t.Errorf("Expected the deployment to be recorded as the model, got %q", azure.Model)
+	}
+}
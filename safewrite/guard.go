@@ -0,0 +1,141 @@
+package safewrite
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ratemykb/config"
+)
+
+// ErrUnsafe indicates that the vault could not be made recoverable before a command changes notes
+var ErrUnsafe = errors.New("vault cannot be recovered if the changes go wrong")
+
+// generatedFiles are written by ratemykb itself, so they do not make a git worktree unclean
+var generatedFiles = []string{
+	":(exclude)" + config.StateDir,
+	":(exclude,glob)vault-quality-report*",
+	":(exclude)summary.json",
+	":(exclude,glob)**/" + config.RollupFile,
+}
+
+// Guard makes sure the vault can be recovered before a command changes notes in bulk, as set by safety.guard, and
+// describes what it found or did
+// The per-file backups of a run cover the changes ratemykb makes, the guard covers everything else: a clean git
+// worktree or a zip of the whole vault.
+func Guard(targetFolder, mode string) (string, error) {
+	switch mode {
+	case config.GuardGit:
+		if err := checkCleanWorktree(targetFolder); err != nil {
+			return "", fmt.Errorf("%w: %w", ErrUnsafe, err)
+		}
+		return "Vault is a clean git worktree", nil
+	case config.GuardAuto:
+		if err := checkCleanWorktree(targetFolder); err == nil {
+			return "Vault is a clean git worktree", nil
+		}
+		fallthrough
+	case config.GuardZip:
+		path, err := backupVault(targetFolder)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrUnsafe, err)
+		}
+		return "Backed up the vault to " + path, nil
+	}
+	return "", nil
+}
+
+// checkCleanWorktree checks that the target folder is in a git worktree without uncommitted changes, apart from the
+// files ratemykb writes itself
+func checkCleanWorktree(targetFolder string) error {
+	args := append([]string{"-C", targetFolder, "status", "--porcelain", "--", "."}, generatedFiles...)
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return fmt.Errorf("not a git worktree: %w", err)
+	}
+	if changes := strings.Count(string(out), "\n"); changes > 0 {
+		return fmt.Errorf("git worktree has %d uncommitted changes", changes)
+	}
+	return nil
+}
+
+// backupVault writes a zip of the target folder to the backup folder, leaving out the git and ratemykb folders,
+// and returns its path
+func backupVault(targetFolder string) (string, error) {
+	dir := BackupDir(targetFolder)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create backup folder: %w", err)
+	}
+	path := filepath.Join(dir, "vault-"+time.Now().Format("20060102-150405")+".zip")
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create vault backup: %w", err)
+	}
+	archive := zip.NewWriter(file)
+	err = filepath.WalkDir(targetFolder, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if filePath != targetFolder && (entry.Name() == ".git" || entry.Name() == config.StateDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(targetFolder, filePath)
+		if err != nil {
+			return err
+		}
+		return addToZip(archive, filePath, filepath.ToSlash(rel))
+	})
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to write vault backup: %w", err)
+	}
+	return path, nil
+}
+
+// addToZip adds a file to a zip under a name
+func addToZip(archive *zip.Writer, path, name string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+
+	target, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(target, source)
+	return err
+}
@@ -0,0 +1,407 @@
+package safewrite
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"ratemykb/config"
+)
+
+// Package safewrite is the shared layer for every change ratemykb makes to notes. Each command that changes
+// files starts a run; the run backs up every file before it is first changed and records a manifest under
+// .ratemykb/backups/<run-id>/ so the whole run can be undone.
+
+// Operations recorded in a run manifest
+const (
+	OpWrite = "write" // A file was written, the backup holds its previous content
+	OpMove  = "move"  // A file was moved to Destination
+)
+
+// Entry records a single change made during a run
+// Paths are relative to the target folder, with forward slashes
+type Entry struct {
+	Op          string `json:"op"`
+	Path        string `json:"path"`
+	Backup      string `json:"backup,omitempty"`      // Backup of the previous content, relative to the run folder
+	Created     bool   `json:"created,omitempty"`     // The file did not exist before the write
+	Checksum    string `json:"checksum,omitempty"`    // SHA-256 of the written content
+	Destination string `json:"destination,omitempty"` // Where the file was moved to
+}
+
+// Manifest describes a run and every change it made
+type Manifest struct {
+	ID      string    `json:"id"`
+	Command string    `json:"command"`
+	Created time.Time `json:"created"`
+	Entries []Entry   `json:"entries"`
+}
+
+// Run records the changes of a single command so they can be undone
+type Run struct {
+	Manifest
+	targetFolder string
+	backedUp     map[string]bool
+}
+
+// BackupDir returns the folder where the runs of a target folder are recorded
+func BackupDir(targetFolder string) string {
+	return filepath.Join(targetFolder, config.StateDir, "backups")
+}
+
+// Begin starts a run for a command
+// Nothing is written to disk until the first change is made, which is also when the run gets its ID.
+func Begin(targetFolder, command string) *Run {
+	return &Run{
+		Manifest: Manifest{
+			Command: command,
+			Created: time.Now(),
+		},
+		targetFolder: targetFolder,
+		backedUp:     make(map[string]bool),
+	}
+}
+
+// BeginWithID starts a run for a command under an ID already reserved with ReserveID, such as the ID of a scan,
+// so its changes are undone with the ID the command printed
+func BeginWithID(targetFolder, command, id string) *Run {
+	r := Begin(targetFolder, command)
+	r.ID = id
+	return r
+}
+
+// ReserveID assigns a run started at the given time a unique ID by creating its folder under BackupDir
+// Every command draws its ID from here, whether it records a scan under .ratemykb/runs, changes notes or both,
+// so an ID printed by any command is the one undo looks up. Run IDs are sortable timestamps, with a numeric
+// suffix if several runs start within the same second.
+func ReserveID(targetFolder string, started time.Time) (string, error) {
+	if err := os.MkdirAll(BackupDir(targetFolder), 0755); err != nil {
+		return "", fmt.Errorf("failed to create backup folder: %w", err)
+	}
+
+	base := started.Format("20060102-150405")
+	id := base
+	for i := 1; ; i++ {
+		err := os.Mkdir(filepath.Join(BackupDir(targetFolder), id), 0755)
+		if err == nil {
+			return id, nil
+		}
+		if !os.IsExist(err) {
+			return "", fmt.Errorf("failed to create backup folder: %w", err)
+		}
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// reserve assigns the run its ID
+func (r *Run) reserve() error {
+	id, err := ReserveID(r.targetFolder, r.Created)
+	if err != nil {
+		return err
+	}
+	r.ID = id
+	return nil
+}
+
+// dir returns the folder holding the manifest and backups of the run
+func (r *Run) dir() string {
+	return filepath.Join(BackupDir(r.targetFolder), r.ID)
+}
+
+// WriteFile writes data to a file inside the target folder, backing up the previous content first
+// The file is replaced atomically and keeps its permissions.
+func (r *Run) WriteFile(path string, data []byte) error {
+	relPath, err := r.relPath(path)
+	if err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	info, statErr := os.Stat(path)
+	if statErr == nil {
+		mode = info.Mode().Perm()
+	}
+
+	if r.ID == "" {
+		if err := r.reserve(); err != nil {
+			return err
+		}
+	}
+
+	// Back up the content from before the run only once, so undo restores the original
+	if !r.backedUp[relPath] {
+		entry := Entry{Op: OpWrite, Path: relPath, Created: os.IsNotExist(statErr)}
+		if !entry.Created {
+			if err := copyFile(path, filepath.Join(r.dir(), "files", filepath.FromSlash(relPath))); err != nil {
+				return fmt.Errorf("failed to back up %s: %w", path, err)
+			}
+			entry.Backup = "files/" + relPath
+		}
+		r.Entries = append(r.Entries, entry)
+		r.backedUp[relPath] = true
+	}
+
+	// Record the checksum of the new content, so undo can detect later edits
+	for i := len(r.Entries) - 1; i >= 0; i-- {
+		if r.Entries[i].Op == OpWrite && r.Entries[i].Path == relPath {
+			r.Entries[i].Checksum = checksum(data)
+			break
+		}
+	}
+	if err := r.save(); err != nil {
+		return err
+	}
+
+	if err := WriteFileAtomic(path, data, mode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// Move moves a file inside the target folder, creating the destination folder if needed
+func (r *Run) Move(path, destination string) error {
+	relPath, err := r.relPath(path)
+	if err != nil {
+		return err
+	}
+	relDestination, err := r.relPath(destination)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return fmt.Errorf("failed to create folder for %s: %w", destination, err)
+	}
+	if err := os.Rename(path, destination); err != nil {
+		return fmt.Errorf("failed to move %s: %w", path, err)
+	}
+
+	r.Entries = append(r.Entries, Entry{Op: OpMove, Path: relPath, Destination: relDestination})
+	return r.save()
+}
+
+// relPath returns the path relative to the target folder, refusing paths outside of it
+func (r *Run) relPath(path string) (string, error) {
+	relPath, err := filepath.Rel(r.targetFolder, path)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("file %s is outside the target folder", path)
+	}
+	return filepath.ToSlash(relPath), nil
+}
+
+// save writes the manifest of the run
+func (r *Run) save() error {
+	if r.ID == "" {
+		if err := r.reserve(); err != nil {
+			return err
+		}
+	}
+
+	if err := WriteJSON(filepath.Join(r.dir(), "manifest.json"), r.Manifest); err != nil {
+		return fmt.Errorf("failed to write run manifest: %w", err)
+	}
+	return nil
+}
+
+// List returns the manifests of all recorded runs of a target folder, oldest first
+func List(targetFolder string) ([]Manifest, error) {
+	matches, err := filepath.Glob(filepath.Join(BackupDir(targetFolder), "*", "manifest.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runs: %w", err)
+	}
+
+	var manifests []Manifest
+	for _, match := range matches {
+		manifest, err := readManifest(match)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, manifest)
+	}
+
+	sort.Slice(manifests, func(i, j int) bool {
+		if !manifests[i].Created.Equal(manifests[j].Created) {
+			return manifests[i].Created.Before(manifests[j].Created)
+		}
+		return manifests[i].ID < manifests[j].ID
+	})
+	return manifests, nil
+}
+
+// Latest returns the ID of the most recent run of a command
+func Latest(targetFolder, command string) (string, error) {
+	manifests, err := List(targetFolder)
+	if err != nil {
+		return "", err
+	}
+
+	for i := len(manifests) - 1; i >= 0; i-- {
+		if manifests[i].Command == command {
+			return manifests[i].ID, nil
+		}
+	}
+	return "", fmt.Errorf("no %s run to undo", command)
+}
+
+// Undo reverts every change of a run, newest first, and removes the run with its backups
+// Files edited since the run are not touched unless force is set.
+func Undo(targetFolder, runID string, force bool) (int, error) {
+	if runID == "" || strings.ContainsAny(runID, `/\`) || runID == "." || runID == ".." {
+		return 0, fmt.Errorf("invalid run ID %q", runID)
+	}
+
+	dir := filepath.Join(BackupDir(targetFolder), runID)
+	manifest, err := readManifest(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return 0, err
+	}
+
+	// Check every file before changing anything, so a refused undo leaves the vault as it was
+	if !force {
+		for _, entry := range manifest.Entries {
+			if err := checkUnchanged(targetFolder, entry); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	reverted := 0
+	for i := len(manifest.Entries) - 1; i >= 0; i-- {
+		entry := manifest.Entries[i]
+		path := filepath.Join(targetFolder, filepath.FromSlash(entry.Path))
+
+		switch entry.Op {
+		case OpWrite:
+			if entry.Created {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					return reverted, fmt.Errorf("failed to remove %s: %w", entry.Path, err)
+				}
+			} else if err := copyFile(filepath.Join(dir, filepath.FromSlash(entry.Backup)), path); err != nil {
+				return reverted, fmt.Errorf("failed to restore %s: %w", entry.Path, err)
+			}
+		case OpMove:
+			destination := filepath.Join(targetFolder, filepath.FromSlash(entry.Destination))
+			if _, err := os.Stat(destination); os.IsNotExist(err) {
+				// Already moved back by an earlier, interrupted undo
+				if _, err := os.Stat(path); err == nil {
+					continue
+				}
+			}
+			if _, err := os.Stat(path); err == nil {
+				return reverted, fmt.Errorf("cannot restore %s: file already exists", entry.Path)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return reverted, fmt.Errorf("failed to create folder for %s: %w", entry.Path, err)
+			}
+			if err := os.Rename(destination, path); err != nil {
+				return reverted, fmt.Errorf("failed to restore %s: %w", entry.Path, err)
+			}
+		default:
+			return reverted, fmt.Errorf("unknown operation %q in run %s", entry.Op, runID)
+		}
+		reverted++
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return reverted, fmt.Errorf("failed to remove run %s: %w", runID, err)
+	}
+	return reverted, nil
+}
+
+// checkUnchanged returns an error if a file written during a run was edited afterwards
+func checkUnchanged(targetFolder string, entry Entry) error {
+	if entry.Op != OpWrite || entry.Checksum == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(filepath.Join(targetFolder, filepath.FromSlash(entry.Path)))
+	if err != nil {
+		return fmt.Errorf("cannot undo changes to %s: %w", entry.Path, err)
+	}
+	if checksum(content) != entry.Checksum {
+		return fmt.Errorf("cannot undo changes to %s: file was edited after the run (use --force to overwrite)", entry.Path)
+	}
+	return nil
+}
+
+// readManifest reads a run manifest
+func readManifest(path string) (Manifest, error) {
+	var manifest Manifest
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return manifest, fmt.Errorf("run %s not found", filepath.Base(filepath.Dir(path)))
+		}
+		return manifest, fmt.Errorf("failed to read run manifest: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to parse run manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// copyFile copies a file, creating the destination folder if needed
+func copyFile(source, destination string) error {
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(source); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return err
+	}
+	return WriteFileAtomic(destination, data, mode)
+}
+
+// WriteFileAtomic writes data to a temporary file next to path and renames it over path, so readers never see
+// a partly written file
+// It is the only way ratemykb replaces a file, whether in the vault or its own files under .ratemykb. Changes to
+// notes go through a Run instead, which backs them up first.
+func WriteFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// WriteJSON writes a value as indented JSON with WriteFileAtomic
+func WriteJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", filepath.Base(path), err)
+	}
+	return WriteFileAtomic(path, data, 0644)
+}
+
+// checksum returns the hex encoded SHA-256 of data
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
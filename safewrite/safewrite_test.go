@@ -0,0 +1,193 @@
+package safewrite
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"ratemykb/config"
+)
+
+func TestWriteFileAndUndo(t *testing.T) {
+	tempDir := t.TempDir()
+
+	existing := filepath.Join(tempDir, "note.md")
+	created := filepath.Join(tempDir, "new", "note.md")
+	if err := os.WriteFile(existing, []byte("original"), 0600); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	run := Begin(tempDir, "test")
+	for _, content := range []string{"first change", "second change"} {
+		if err := run.WriteFile(existing, []byte(content)); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(created), 0755); err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+	if err := run.WriteFile(created, []byte("created")); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	// Permissions are kept and the original content is backed up once
+	info, err := os.Stat(existing)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected permissions to be kept, got %v", info.Mode().Perm())
+	}
+	if len(run.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(run.Entries))
+	}
+	backup, err := os.ReadFile(filepath.Join(BackupDir(tempDir), run.ID, run.Entries[0].Backup))
+	if err != nil || string(backup) != "original" {
+		t.Errorf("Expected backup of the original content, got %q (%v)", backup, err)
+	}
+
+	manifests, err := List(tempDir)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(manifests) != 1 || manifests[0].ID != run.ID || manifests[0].Command != "test" {
+		t.Errorf("Unexpected runs: %+v", manifests)
+	}
+
+	reverted, err := Undo(tempDir, run.ID, false)
+	if err != nil {
+		t.Fatalf("Undo() error = %v", err)
+	}
+	if reverted != 2 {
+		t.Errorf("Expected 2 reverted changes, got %d", reverted)
+	}
+
+	content, _ := os.ReadFile(existing)
+	if string(content) != "original" {
+		t.Errorf("Expected original content to be restored, got %q", content)
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Error("Expected created file to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(BackupDir(tempDir), run.ID)); !os.IsNotExist(err) {
+		t.Error("Expected the run to be removed after undo")
+	}
+}
+
+func TestUndoRefusesEditedFiles(t *testing.T) {
+	tempDir := t.TempDir()
+
+	path := filepath.Join(tempDir, "note.md")
+	if err := os.WriteFile(path, []byte("original"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	run := Begin(tempDir, "test")
+	if err := run.WriteFile(path, []byte("changed")); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	// Edited after the run
+	if err := os.WriteFile(path, []byte("edited by hand"), 0644); err != nil {
+		t.Fatalf("Failed to edit file: %v", err)
+	}
+
+	if _, err := Undo(tempDir, run.ID, false); err == nil {
+		t.Error("Expected undo to refuse overwriting an edited file")
+	}
+	if _, err := Undo(tempDir, run.ID, true); err != nil {
+		t.Fatalf("Undo() with force error = %v", err)
+	}
+
+	content, _ := os.ReadFile(path)
+	if string(content) != "original" {
+		t.Errorf("Expected original content to be restored, got %q", content)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "state.json")
+
+	for _, content := range []string{"first", "second"} {
+		if err := WriteFileAtomic(path, []byte(content), 0600); err != nil {
+			t.Fatalf("WriteFileAtomic() error = %v", err)
+		}
+		if data, _ := os.ReadFile(path); string(data) != content {
+			t.Errorf("Expected %q, got %q", content, data)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	// No temporary file is left behind
+	entries, err := os.ReadDir(tempDir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("Expected only the written file, got %v, %v", entries, err)
+	}
+}
+
+func TestUndoUnknownRun(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for _, runID := range []string{"missing", "../outside", ""} {
+		if _, err := Undo(tempDir, runID, false); err == nil {
+			t.Errorf("Expected an error for run ID %q", runID)
+		}
+	}
+}
+
+func TestGuard(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "note.md"), []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(tempDir, config.StateDir), 0755); err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, config.StateDir, "state.json"), []byte("{}"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	if note, err := Guard(tempDir, config.GuardOff); note != "" || err != nil {
+		t.Errorf("Expected no check when off, got %q, %v", note, err)
+	}
+
+	// A folder outside git only passes the git guard with a backup
+	if _, err := exec.LookPath("git"); err == nil {
+		if _, err := Guard(tempDir, config.GuardGit); !errors.Is(err, ErrUnsafe) {
+			t.Errorf("Expected ErrUnsafe outside a git worktree, got %v", err)
+		}
+	}
+	note, err := Guard(tempDir, config.GuardAuto)
+	if err != nil {
+		t.Fatalf("Guard() error = %v", err)
+	}
+	path, ok := strings.CutPrefix(note, "Backed up the vault to ")
+	if !ok {
+		t.Fatalf("Expected a backup, got %q", note)
+	}
+
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	defer archive.Close()
+	var names []string
+	for _, file := range archive.File {
+		names = append(names, file.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"note.md"}) {
+		t.Errorf("Expected only the notes in the backup, got %v", names)
+	}
+}
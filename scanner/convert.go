@@ -0,0 +1,202 @@
+package scanner
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// converters turn notes stored in other formats into markdown, by file extension
+// Plain text is already valid markdown and passes through unchanged.
+var converters = map[string]func(string) string{
+	".txt":  func(content string) string { return content },
+	".html": HTMLToMarkdown,
+	".htm":  HTMLToMarkdown,
+}
+
+// ConvertibleExtensions returns the extensions of the note formats that can be converted to markdown
+func ConvertibleExtensions() []string {
+	extensions := make([]string, 0, len(converters))
+	for extension := range converters {
+		extensions = append(extensions, extension)
+	}
+	sort.Strings(extensions)
+	return extensions
+}
+
+// IsConvertible reports whether notes with the extension of path can be converted to markdown
+func IsConvertible(path string) bool {
+	_, ok := converters[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// convertNote converts the content of a note in another format to markdown, based on the extension of its path
+func convertNote(path, content string) string {
+	if convert, ok := converters[strings.ToLower(filepath.Ext(path))]; ok {
+		return convert(content)
+	}
+	return content
+}
+
+var (
+	// tagPattern matches an HTML tag, used to strip tags from documents too broken to parse
+	tagPattern = regexp.MustCompile(`<[^>]*>`)
+	// blankLinesPattern matches runs of blank lines left between converted blocks
+	blankLinesPattern = regexp.MustCompile(`\n[ \t]*(\n[ \t]*)+`)
+	// spacePattern matches runs of whitespace, which HTML renders as a single space
+	spacePattern = regexp.MustCompile(`\s+`)
+)
+
+// skippedElements lists the HTML elements whose content is not part of the text of a page
+var skippedElements = map[string]bool{
+	"head":     true,
+	"script":   true,
+	"style":    true,
+	"noscript": true,
+	"template": true,
+	"svg":      true,
+}
+
+// HTMLToMarkdown converts an HTML page to markdown, keeping headings, paragraphs, lists, links, images, emphasis
+// and code so the page can be classified like a note
+// Pages too broken to parse have their tags stripped instead.
+func HTMLToMarkdown(content string) string {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	var md strings.Builder
+	var links []string // Targets of the links being converted, innermost last
+	skipping, preformatted := 0, 0
+
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return strings.TrimSpace(spacePattern.ReplaceAllString(tagPattern.ReplaceAllString(content, " "), " "))
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			if skippedElements[name] {
+				skipping++
+				continue
+			}
+			if skipping > 0 {
+				continue
+			}
+
+			switch name {
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				md.WriteString("\n\n" + strings.Repeat("#", int(name[1]-'0')) + " ")
+			case "p", "div", "section", "article", "header", "footer", "main", "table", "ul", "ol", "blockquote":
+				md.WriteString("\n\n")
+			case "tr":
+				md.WriteString("\n")
+			case "td", "th":
+				md.WriteString(" ")
+			case "li":
+				md.WriteString("\n- ")
+			case "br":
+				md.WriteString("\n")
+			case "hr":
+				md.WriteString("\n\n---\n\n")
+			case "strong", "b":
+				md.WriteString("**")
+			case "em", "i":
+				md.WriteString("*")
+			case "code":
+				if preformatted == 0 {
+					md.WriteString("`")
+				}
+			case "pre":
+				preformatted++
+				md.WriteString("\n\n```\n")
+			case "a":
+				href := attribute(t, "href")
+				links = append(links, href)
+				if href != "" {
+					md.WriteString("[")
+				}
+			case "img":
+				if src := attribute(t, "src"); src != "" {
+					md.WriteString("![" + attribute(t, "alt") + "](" + src + ")")
+				}
+			}
+
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			if skippedElements[name] {
+				skipping = max(skipping-1, 0)
+				continue
+			}
+			if skipping > 0 {
+				continue
+			}
+
+			switch name {
+			case "h1", "h2", "h3", "h4", "h5", "h6", "p", "div", "section", "article", "header", "footer", "main", "table", "ul", "ol", "blockquote":
+				md.WriteString("\n\n")
+			case "strong", "b":
+				md.WriteString("**")
+			case "em", "i":
+				md.WriteString("*")
+			case "code":
+				if preformatted == 0 {
+					md.WriteString("`")
+				}
+			case "pre":
+				preformatted = max(preformatted-1, 0)
+				md.WriteString("\n```\n\n")
+			case "a":
+				if len(links) > 0 {
+					if href := links[len(links)-1]; href != "" {
+						md.WriteString("](" + href + ")")
+					}
+					links = links[:len(links)-1]
+				}
+			}
+
+		case xml.CharData:
+			if skipping > 0 {
+				continue
+			}
+			if preformatted > 0 {
+				md.Write(t)
+				continue
+			}
+			md.WriteString(spacePattern.ReplaceAllString(string(t), " "))
+		}
+	}
+
+	// Tidy the spacing left around blocks
+	lines := strings.Split(md.String(), "\n")
+	fenced := false
+	for i, line := range lines {
+		if strings.HasPrefix(line, "```") {
+			fenced = !fenced
+		}
+		if !fenced {
+			lines[i] = strings.TrimSpace(line)
+		}
+	}
+	return strings.TrimSpace(blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// attribute returns the value of an attribute of an HTML element, empty if it is not set
+func attribute(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if strings.EqualFold(attr.Name.Local, name) {
+			return attr.Value
+		}
+	}
+	return ""
+}
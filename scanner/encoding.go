@@ -0,0 +1,108 @@
+package scanner
+
+import (
+	"bytes"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// Encodings reported for files that are not stored as UTF-8
+const (
+	EncodingUTF16LE     = "UTF-16LE"
+	EncodingUTF16BE     = "UTF-16BE"
+	EncodingWindows1252 = "Windows-1252"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// DecodeText detects the encoding of raw file content and returns it transcoded to UTF-8
+// The returned encoding is empty for UTF-8 content, with or without a byte order mark.
+// Binary content is returned unchanged so it can still be recognized as binary.
+func DecodeText(raw []byte) ([]byte, string) {
+	switch {
+	case bytes.HasPrefix(raw, bomUTF8):
+		return raw[len(bomUTF8):], ""
+	case bytes.HasPrefix(raw, bomUTF16LE):
+		return decodeUTF16(raw[len(bomUTF16LE):], unicode.LittleEndian), EncodingUTF16LE
+	case bytes.HasPrefix(raw, bomUTF16BE):
+		return decodeUTF16(raw[len(bomUTF16BE):], unicode.BigEndian), EncodingUTF16BE
+	}
+
+	// Windows tools sometimes write UTF-16 without a byte order mark
+	sample := raw[:min(len(raw), sniffLength)]
+	if order, ok := guessUTF16(sample); ok {
+		name := EncodingUTF16LE
+		if order == unicode.BigEndian {
+			name = EncodingUTF16BE
+		}
+		return decodeUTF16(raw, order), name
+	}
+
+	if IsBinary(sample) || validUTF8(raw) {
+		return raw, ""
+	}
+
+	// Text that is not UTF-8 is most likely from a Windows export, whose code page is a superset of latin-1
+	decoded, err := charmap.Windows1252.NewDecoder().Bytes(raw)
+	if err != nil {
+		return raw, ""
+	}
+	return decoded, EncodingWindows1252
+}
+
+// validUTF8 reports whether content is valid UTF-8, allowing a character cut in half at the end
+func validUTF8(content []byte) bool {
+	for i := 0; i < utf8.UTFMax && i <= len(content); i++ {
+		if utf8.Valid(content[:len(content)-i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// guessUTF16 reports whether content without a byte order mark looks like UTF-16 and in which byte order
+// Mostly ASCII text in UTF-16 has a NUL in nearly every other byte, which no other text encoding has.
+func guessUTF16(content []byte) (unicode.Endianness, bool) {
+	if len(content) < 4 {
+		return unicode.LittleEndian, false
+	}
+
+	var evenNUL, oddNUL int
+	for i, b := range content {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			evenNUL++
+		} else {
+			oddNUL++
+		}
+	}
+
+	pairs := len(content) / 2
+	switch {
+	case oddNUL*10 >= pairs*7 && evenNUL*10 < pairs:
+		return unicode.LittleEndian, true
+	case evenNUL*10 >= pairs*7 && oddNUL*10 < pairs:
+		return unicode.BigEndian, true
+	}
+	return unicode.LittleEndian, false
+}
+
+// decodeUTF16 transcodes UTF-16 content without a byte order mark to UTF-8
+func decodeUTF16(content []byte, order unicode.Endianness) []byte {
+	// Drop a byte left over by truncation
+	content = content[:len(content)-len(content)%2]
+
+	decoded, err := unicode.UTF16(order, unicode.IgnoreBOM).NewDecoder().Bytes(content)
+	if err != nil {
+		return content
+	}
+	return decoded
+}
@@ -0,0 +1,126 @@
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Frontmatter formats, named after the delimiters used by Obsidian, Hugo and Zola
+const (
+	FrontmatterYAML = "yaml" // Between --- lines
+	FrontmatterTOML = "toml" // Between +++ lines
+	FrontmatterJSON = "json" // A JSON object starting on the first line
+)
+
+// frontmatterDelimiters maps the line delimiting each line based frontmatter format to the format
+var frontmatterDelimiters = map[string]string{
+	"---": FrontmatterYAML,
+	"+++": FrontmatterTOML,
+}
+
+// SplitFrontmatter separates YAML, TOML or JSON frontmatter from the body of a note
+// If the content has no complete frontmatter block, the frontmatter is empty and the body is the whole content
+func SplitFrontmatter(content string) (frontmatter, body string) {
+	_, frontmatter, body = splitFrontmatter(content)
+	return frontmatter, body
+}
+
+// splitFrontmatter separates the frontmatter from the body of a note and returns its format
+// The format is empty if the content has no complete frontmatter block.
+func splitFrontmatter(content string) (format, frontmatter, body string) {
+	normalized := strings.ReplaceAll(content, "\r\n", "\n")
+	lines := strings.Split(normalized, "\n")
+
+	// Frontmatter must start on the first line
+	if len(lines) < 2 {
+		return "", "", content
+	}
+
+	if strings.HasPrefix(lines[0], "{") {
+		return splitJSONFrontmatter(normalized, content)
+	}
+
+	format, ok := frontmatterDelimiters[strings.TrimSpace(lines[0])]
+	if !ok {
+		return "", "", content
+	}
+
+	// Find the end of frontmatter, marked by the same delimiter
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == strings.TrimSpace(lines[0]) {
+			return format, strings.Join(lines[1:i], "\n"), strings.Join(lines[i+1:], "\n")
+		}
+	}
+
+	// No end marker found
+	return "", "", content
+}
+
+// splitJSONFrontmatter separates a JSON object at the start of a note from the body that follows it
+// Like Hugo, the body starts on the line after the closing brace.
+func splitJSONFrontmatter(normalized, content string) (format, frontmatter, body string) {
+	decoder := json.NewDecoder(strings.NewReader(normalized))
+	var object map[string]any
+	if err := decoder.Decode(&object); err != nil {
+		return "", "", content
+	}
+
+	end := int(decoder.InputOffset())
+	rest := normalized[end:]
+	newline := strings.Index(rest, "\n")
+	if newline == -1 {
+		newline = len(rest) - 1
+	}
+	if strings.TrimSpace(rest[:newline+1]) != "" {
+		// Something other than the body follows the object on the same line
+		return "", "", content
+	}
+
+	return FrontmatterJSON, normalized[:end], rest[newline+1:]
+}
+
+// CountWords returns the number of words in the body of a note, ignoring frontmatter
+func CountWords(content string) int {
+	_, body := SplitFrontmatter(content)
+	return len(strings.Fields(body))
+}
+
+// ParseFrontmatter parses the YAML, TOML or JSON frontmatter of a note into a map
+// A note without frontmatter returns an empty map. TOML dates without an offset are returned as UTC times,
+// like YAML dates.
+func ParseFrontmatter(content string) (map[string]any, error) {
+	format, frontmatter, _ := splitFrontmatter(content)
+
+	fields := make(map[string]any)
+	if strings.TrimSpace(frontmatter) == "" {
+		return fields, nil
+	}
+
+	var err error
+	switch format {
+	case FrontmatterTOML:
+		err = toml.Unmarshal([]byte(frontmatter), &fields)
+		for name, value := range fields {
+			switch v := value.(type) {
+			case toml.LocalDate:
+				fields[name] = v.AsTime(time.UTC)
+			case toml.LocalDateTime:
+				fields[name] = v.AsTime(time.UTC)
+			}
+		}
+	case FrontmatterJSON:
+		err = json.Unmarshal([]byte(frontmatter), &fields)
+	default:
+		err = yaml.Unmarshal([]byte(frontmatter), &fields)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid frontmatter: %w", err)
+	}
+
+	return fields, nil
+}
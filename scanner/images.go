@@ -0,0 +1,143 @@
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"ratemykb/config"
+)
+
+// wordsPerImage is the number of words of text below which each embedded image is taken to carry more of
+// the note than its text
+const wordsPerImage = 50
+
+// imageExtensions lists the file extensions of images that can be passed to a vision model
+var imageExtensions = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+	".webp": true,
+	".bmp":  true,
+}
+
+// imageEmbedPattern matches an Obsidian embed ![[image.png|300]] or a markdown image ![alt](path/image.png "title"),
+// capturing the referenced file in the first or second group
+var imageEmbedPattern = regexp.MustCompile(`!\[\[([^\]|#]+)[^\]]*\]\]|!\[[^\]]*\]\(<?([^)\s>]+)>?[^)]*\)`)
+
+// EmbeddedImages returns the images embedded in a note as referenced, in order of appearance and without duplicates
+// Images on the web are left out, since only local files can be passed to a model.
+func EmbeddedImages(content string) []string {
+	var images []string
+	seen := make(map[string]bool)
+	for _, match := range imageEmbedPattern.FindAllStringSubmatch(content, -1) {
+		ref := strings.TrimSpace(match[1] + match[2])
+		if strings.Contains(ref, "://") || !imageExtensions[strings.ToLower(filepath.Ext(ref))] || seen[ref] {
+			continue
+		}
+		seen[ref] = true
+		images = append(images, ref)
+	}
+	return images
+}
+
+// IsImageHeavy reports whether a note is mostly embedded images, such as screenshots of a whiteboard,
+// so its sparse text alone would not do it justice
+func IsImageHeavy(content string) bool {
+	images := len(EmbeddedImages(content))
+	if images == 0 {
+		return false
+	}
+	return CountWords(imageEmbedPattern.ReplaceAllString(content, " ")) < images*wordsPerImage
+}
+
+// LinkResolver finds the files linked or embedded in the notes of a vault, such as notes and images, like Obsidian
+// does
+// The files of the vault are indexed the first time a link is not found by its path, and kept for the rest of the
+// run. It is safe for concurrent use.
+type LinkResolver struct {
+	targetFolder string
+	once         sync.Once
+	index        map[string]string // First path found for each key a link may use, see indexKeys
+}
+
+// NewLinkResolver returns a resolver for the links of the notes in the target folder
+func NewLinkResolver(targetFolder string) *LinkResolver {
+	return &LinkResolver{targetFolder: targetFolder}
+}
+
+// Resolve returns the path of a file linked or embedded in a note, looking next to the note, from the root of the
+// vault and finally anywhere in the vault by file name, ignoring case and the extension of notes
+// It returns false if the file cannot be found, the link leads outside the target folder or is a web link.
+func (r *LinkResolver) Resolve(notePath, ref string) (string, bool) {
+	if strings.Contains(ref, "://") {
+		return "", false
+	}
+	ref, _, _ = strings.Cut(strings.ReplaceAll(ref, "%20", " "), "#")
+	ref = filepath.FromSlash(ref)
+	if ref == "" {
+		return "", false
+	}
+
+	nextToNote := filepath.Join(filepath.Dir(notePath), ref)
+	for _, candidate := range []string{nextToNote, filepath.Join(r.targetFolder, ref)} {
+		if !r.contains(candidate) {
+			continue
+		}
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, true
+		}
+	}
+
+	r.once.Do(r.buildIndex)
+	keys := []string{filepath.ToSlash(strings.TrimPrefix(ref, string(filepath.Separator))), filepath.Base(ref)}
+	if rel, err := filepath.Rel(r.targetFolder, nextToNote); err == nil && r.contains(nextToNote) {
+		keys = append([]string{filepath.ToSlash(rel)}, keys...)
+	}
+	for _, key := range keys {
+		if found, ok := r.index[strings.ToLower(key)]; ok {
+			return found, true
+		}
+	}
+	return "", false
+}
+
+// contains reports whether a path is inside the target folder
+func (r *LinkResolver) contains(path string) bool {
+	rel, err := filepath.Rel(r.targetFolder, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// buildIndex records the first path of each file of the vault under the keys a link may use: its path relative to
+// the target folder and its name, with and without the extension, in lower case
+// Hidden folders such as .obsidian, the state and the trash are left out, and folders that cannot be read are
+// skipped.
+func (r *LinkResolver) buildIndex() {
+	r.index = make(map[string]string)
+	filepath.Walk(r.targetFolder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if path != r.targetFolder && (strings.HasPrefix(info.Name(), ".") || info.Name() == config.StateDir || info.Name() == config.TrashDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(r.targetFolder, path)
+		if err != nil {
+			return nil
+		}
+		rel = strings.ToLower(filepath.ToSlash(rel))
+		name := strings.ToLower(info.Name())
+		for _, key := range []string{rel, strings.TrimSuffix(rel, filepath.Ext(rel)), name, strings.TrimSuffix(name, filepath.Ext(name))} {
+			if _, ok := r.index[key]; !ok {
+				r.index[key] = path
+			}
+		}
+		return nil
+	})
+}
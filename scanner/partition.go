@@ -0,0 +1,77 @@
+package scanner
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Partition is one of several shares of the files of a vault, so a vault too large for a single run can be
+// classified by separate runs, such as the jobs of a CI matrix, and aggregated afterwards
+// The zero Partition holds every file.
+type Partition struct {
+	Index int // Number of the partition, from 1 to Count
+	Count int // Number of partitions the files are shared between
+}
+
+// ParsePartition parses a partition written as "3/8", the third of eight partitions
+func ParsePartition(value string) (Partition, error) {
+	index, count, ok := strings.Cut(strings.TrimSpace(value), "/")
+	if !ok {
+		return Partition{}, fmt.Errorf("invalid partition %q, expected a number and a count such as 3/8", value)
+	}
+	var partition Partition
+	var err error
+	if partition.Index, err = strconv.Atoi(strings.TrimSpace(index)); err != nil {
+		return Partition{}, fmt.Errorf("invalid partition %q, expected a number and a count such as 3/8", value)
+	}
+	if partition.Count, err = strconv.Atoi(strings.TrimSpace(count)); err != nil {
+		return Partition{}, fmt.Errorf("invalid partition %q, expected a number and a count such as 3/8", value)
+	}
+	if partition.Count < 1 || partition.Index < 1 || partition.Index > partition.Count {
+		return Partition{}, fmt.Errorf("invalid partition %q, the number must be between 1 and the count", value)
+	}
+	return partition, nil
+}
+
+// String returns the partition as written on the command line
+func (p Partition) String() string {
+	return fmt.Sprintf("%d/%d", p.Index, p.Count)
+}
+
+// Name returns the partition in a form usable in file names, such as "3-of-8"
+func (p Partition) Name() string {
+	return fmt.Sprintf("%d-of-%d", p.Index, p.Count)
+}
+
+// Contains reports whether a file of the target folder belongs to the partition
+// Files are assigned by a hash of their path relative to the target folder, so every run assigns them the same way
+// on any machine, and files added to the vault only join a partition without moving the others.
+func (p Partition) Contains(targetFolder, path string) bool {
+	if p.Count <= 1 {
+		return true
+	}
+	rel, err := filepath.Rel(targetFolder, path)
+	if err != nil {
+		rel = path
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(filepath.ToSlash(rel)))
+	return int(hash.Sum32()%uint32(p.Count)) == p.Index-1
+}
+
+// Filter returns the files of the target folder that belong to the partition
+func (p Partition) Filter(targetFolder string, files []File) []File {
+	if p.Count <= 1 {
+		return files
+	}
+	var filtered []File
+	for _, file := range files {
+		if p.Contains(targetFolder, file.Path) {
+			filtered = append(filtered, file)
+		}
+	}
+	return filtered
+}
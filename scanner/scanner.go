@@ -2,12 +2,18 @@ package scanner
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"ratemykb/config"
 )
@@ -27,18 +33,66 @@ const (
 
 	// StatusExcluded indicates the file is in the exclusion list
 	StatusExcluded FileStatus = "Excluded"
+
+	// StatusNotMarkdown indicates the file has the markdown extension but binary content
+	StatusNotMarkdown FileStatus = "Not-markdown"
+
+	// StatusPlaceholder indicates the file holds nothing but comments, headings and placeholder text such as TBD
+	StatusPlaceholder FileStatus = "Placeholder"
+
+	// StatusUnreadable indicates the file could not be read, such as a file without read permission or a broken symlink
+	StatusUnreadable FileStatus = "Unreadable"
+)
+
+// Errors returned by the scanner
+var (
+	// ErrExcluded indicates a file is listed in the exclusion file
+	ErrExcluded = errors.New("file is excluded")
 )
 
+// sniffLength is the number of bytes inspected to detect binary content
+const sniffLength = 8000
+
 // File represents a markdown file with its path and status
 type File struct {
-	Path   string     // Path to the file
-	Status FileStatus // Status of the file based on pre-checks
+	Path     string     // Path to the file
+	Status   FileStatus // Status of the file based on pre-checks
+	Encoding string     // Detected encoding if the file is not stored as UTF-8
+	Error    string     // Why the file could not be read, for unreadable files
+}
+
+// Reasons recorded for excluded files and directories
+const (
+	ReasonExclusionFile     = "exclusion file"
+	ReasonExcludedDirectory = "excluded directory"
+)
+
+// Exclusion records a file or directory skipped by the last scan and why
+type Exclusion struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+	IsDir  bool   `json:"is_dir,omitempty"`
+}
+
+// syncConflictPattern matches the names sync tools give the copy they keep when a file changed on two devices,
+// such as Syncthing's note.sync-conflict-20240101-120000-ABCDEFG.md and Dropbox's
+// note (Jane's conflicted copy 2024-01-01).md
+var syncConflictPattern = regexp.MustCompile(`(?i)\.sync-conflict-|\(.*conflicted copy.*\)`)
+
+// IsSyncConflict reports whether a file is a copy left behind by a sync conflict
+func IsSyncConflict(path string) bool {
+	return syncConflictPattern.MatchString(filepath.Base(path))
 }
 
 // Scanner handles the scanning of markdown files in a directory
 type Scanner struct {
-	config      *config.Config
-	excludeList map[string]bool // Map of files to exclude
+	config        *config.Config
+	excludeList   map[string]bool // Map of files to exclude
+	exclusions    []Exclusion     // Files and directories excluded by the last scan
+	syncConflicts []string        // Notes left behind by sync conflicts found by the last scan
+
+	root string // Folder whose files are read through fsys, see SetFS
+	fsys fs.FS  // Files of root, nil to read everything from disk
 }
 
 // New creates a new Scanner with the provided configuration
@@ -48,6 +102,13 @@ func New(cfg *config.Config) (*Scanner, error) {
 		excludeList: make(map[string]bool),
 	}
 
+	// Only formats that can be converted to markdown can be classified
+	for _, extension := range cfg.ScanSettings.ExtraExtensions {
+		if !IsConvertible(extension) {
+			return nil, fmt.Errorf("unsupported note extension %q, supported are %s", extension, strings.Join(ConvertibleExtensions(), ", "))
+		}
+	}
+
 	// Parse exclusion file if it exists
 	if cfg.ExclusionFile.Path != "" {
 		if err := scanner.parseExclusionFile(cfg.ExclusionFile.Path); err != nil {
@@ -58,120 +119,393 @@ func New(cfg *config.Config) (*Scanner, error) {
 	return scanner, nil
 }
 
+// SetFS makes the scanner walk a folder and pre-check its files through fsys instead of the disk, so tests and
+// library users can scan notes that are not on disk
+// Only the directory walk of ScanDirectory and ScanFiles and the pre-checks of their files and FileStatus go through
+// fsys. The exclusion file and everything that reads the scanned files afterwards, such as ReadFileText, the
+// analysis passes, classification and the folder rollups, read from disk. Paths are still reported under the folder, and files outside it are
+// read from disk.
+func (s *Scanner) SetFS(root string, fsys fs.FS) {
+	s.root = filepath.Clean(root)
+	s.fsys = fsys
+}
+
+// relPath returns the slash-separated path of a file in fsys, or false if it is read from disk
+func (s *Scanner) relPath(path string) (string, bool) {
+	if s.fsys == nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// walk walks the tree of a folder in lexical order like filepath.WalkDir, through fsys for the folders it holds
+func (s *Scanner) walk(root string, fn fs.WalkDirFunc) error {
+	rel, ok := s.relPath(root)
+	if !ok {
+		return filepath.WalkDir(root, fn)
+	}
+	return fs.WalkDir(s.fsys, rel, func(path string, d fs.DirEntry, err error) error {
+		return fn(filepath.Join(s.root, filepath.FromSlash(path)), d, err)
+	})
+}
+
+// open opens a file for reading, through fsys for the files it holds
+func (s *Scanner) open(path string) (io.ReadCloser, error) {
+	if rel, ok := s.relPath(path); ok {
+		return s.fsys.Open(rel)
+	}
+	return os.Open(path)
+}
+
 // ScanDirectory recursively scans the target directory for markdown files
 // and returns a list of files with their pre-check status
+// The pre-checks run on the configured number of workers, and files are returned in walk order.
 func (s *Scanner) ScanDirectory(targetDir string) ([]File, error) {
 	var files []File
+	s.exclusions = nil
+	s.syncConflicts = nil
 
 	// Walk through the directory tree
-	err := filepath.Walk(targetDir, func(path string, info os.FileInfo, err error) error {
+	err := s.walk(targetDir, func(path string, info fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
 		// Skip directories
 		if info.IsDir() {
+			// Never scan the files ratemykb keeps in the target folder or the Obsidian trash
+			if info.Name() == config.StateDir || info.Name() == config.TrashDir {
+				return filepath.SkipDir
+			}
+
 			// Check if this directory should be excluded
-			for _, excludeDir := range s.config.ScanSettings.ExcludeDirectories {
-				if info.Name() == excludeDir || (strings.HasPrefix(excludeDir, "/") &&
-					strings.HasPrefix(filepath.ToSlash(path), filepath.ToSlash(filepath.Join(targetDir, strings.TrimPrefix(excludeDir, "/"))))) {
-					return filepath.SkipDir
-				}
+			if s.isExcludedDirectory(targetDir, path) {
+				s.exclusions = append(s.exclusions, Exclusion{Path: path, Reason: ReasonExcludedDirectory, IsDir: true})
+				return filepath.SkipDir
 			}
 			return nil
 		}
 
-		// Process only files with the configured extension
-		if filepath.Ext(path) == s.config.ScanSettings.FileExtension {
-			// Normalize path for exclusion check
-			normalizedPath := s.normalizePathForExclusionCheck(path)
+		files = s.addFile(targetDir, path, files)
+		return nil
+	})
 
-			// Skip if file is in exclusion list
-			if s.excludeList[normalizedPath] {
-				files = append(files, File{
-					Path:   path,
-					Status: StatusExcluded,
-				})
-				return nil
-			}
+	if err != nil {
+		return nil, fmt.Errorf("error scanning directory: %w", err)
+	}
 
-			// Perform pre-checks on the file
-			status, err := s.checkFileStatus(path)
-			if err != nil {
-				// Log error but continue processing other files
-				fmt.Printf("Warning: Error checking file %s: %v\n", path, err)
-				return nil
-			}
+	return s.precheck(files), nil
+}
+
+// ScanFiles pre-checks the listed files of the target directory instead of walking it, for runs limited to the
+// files picked by other tools
+// Relative paths are relative to the current directory, like the output of find. Files in the folders a scan of
+// the directory skips, and files that do not exist, such as deleted files listed by git diff, are left out.
+func (s *Scanner) ScanFiles(targetDir string, paths []string) ([]File, error) {
+	var files []File
+	s.exclusions = nil
+	s.syncConflicts = nil
 
-			// Add file with its status to the result
-			files = append(files, File{
-				Path:   path,
-				Status: status,
-			})
+	root, err := filepath.Abs(targetDir)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving target directory: %w", err)
+	}
+	seen := make(map[string]bool)
+	for _, listed := range paths {
+		abs, err := filepath.Abs(listed)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving %s: %w", listed, err)
+		}
+		rel, err := filepath.Rel(root, abs)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("%s is not in the target directory %s", listed, targetDir)
 		}
 
-		return nil
-	})
+		// Report the file under the target directory, as a scan of the directory would
+		path := filepath.Join(targetDir, rel)
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
 
-	if err != nil {
-		return nil, fmt.Errorf("error scanning directory: %w", err)
+		var info fs.FileInfo
+		if rel, ok := s.relPath(path); ok {
+			info, err = fs.Stat(s.fsys, rel)
+		} else {
+			info, err = os.Stat(path)
+		}
+		if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+			continue
+		}
+
+		// Apply the rules of the walk to the folders holding the file
+		skipped := false
+		for dir := filepath.Dir(path); dir != filepath.Clean(targetDir) && dir != "." && !skipped; dir = filepath.Dir(dir) {
+			name := filepath.Base(dir)
+			skipped = name == config.StateDir || name == config.TrashDir || s.isExcludedDirectory(targetDir, dir)
+		}
+		if !skipped {
+			files = s.addFile(targetDir, path, files)
+		}
 	}
 
-	return files, nil
+	return s.precheck(files), nil
 }
 
-// checkFileStatus performs pre-checks on a file and returns its status
-func (s *Scanner) checkFileStatus(filePath string) (FileStatus, error) {
-	content, err := os.ReadFile(filePath)
+// isExcludedDirectory reports whether a directory of the target directory is in the excluded directories
+func (s *Scanner) isExcludedDirectory(targetDir, path string) bool {
+	for _, excludeDir := range s.config.ScanSettings.ExcludeDirectories {
+		if filepath.Base(path) == excludeDir || (strings.HasPrefix(excludeDir, "/") &&
+			strings.HasPrefix(filepath.ToSlash(path), filepath.ToSlash(filepath.Join(targetDir, strings.TrimPrefix(excludeDir, "/"))))) {
+			return true
+		}
+	}
+	return false
+}
+
+// addFile adds a file of the target directory to the files to pre-check, unless it is not a note to classify
+func (s *Scanner) addFile(targetDir, path string, files []File) []File {
+	// Never classify the rollups ratemykb writes into the top-level folders
+	if s.config.Report.FolderRollups && filepath.Base(path) == config.RollupFile && filepath.Dir(filepath.Dir(path)) == filepath.Clean(targetDir) {
+		return files
+	}
+
+	// Process only files with the configured extension or one of the enabled note formats
+	if !s.isNote(path) {
+		return files
+	}
+
+	// Copies left behind by sync conflicts are reported on their own rather than classified
+	if IsSyncConflict(path) {
+		s.syncConflicts = append(s.syncConflicts, path)
+		return files
+	}
+
+	// Normalize path for exclusion check
+	normalizedPath := s.normalizePathForExclusionCheck(path)
+
+	// Skip if file is in exclusion list
+	if s.excludeList[normalizedPath] {
+		s.exclusions = append(s.exclusions, Exclusion{Path: path, Reason: ReasonExclusionFile})
+		return append(files, File{
+			Path:   path,
+			Status: StatusExcluded,
+		})
+	}
+
+	// Pre-check the file later, in parallel with the others
+	return append(files, File{Path: path})
+}
+
+// ReadFileList reads a list of file paths, one per line, skipping blank lines
+func ReadFileList(r io.Reader) ([]string, error) {
+	var paths []string
+	lines := bufio.NewScanner(r)
+	for lines.Scan() {
+		if path := strings.TrimSuffix(lines.Text(), "\r"); strings.TrimSpace(path) != "" {
+			paths = append(paths, path)
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return nil, fmt.Errorf("error reading file list: %w", err)
+	}
+	return paths, nil
+}
+
+// isNote reports whether a file has the configured extension or the extension of an enabled note format
+func (s *Scanner) isNote(path string) bool {
+	if filepath.Ext(path) == s.config.ScanSettings.FileExtension {
+		return true
+	}
+	for _, extension := range s.config.ScanSettings.ExtraExtensions {
+		if strings.EqualFold(filepath.Ext(path), extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// Exclusions returns the files and directories skipped by the last scan, in walk order
+func (s *Scanner) Exclusions() []Exclusion {
+	return s.exclusions
+}
+
+// SyncConflicts returns the notes left behind by sync conflicts that the last scan left out, in walk order
+func (s *Scanner) SyncConflicts() []string {
+	return s.syncConflicts
+}
+
+// precheck sets the status of every file that has none yet, using a pool of workers
+// Files that cannot be read are given the unreadable status, or dropped from the result if no unreadable label is
+// configured.
+func (s *Scanner) precheck(files []File) []File {
+	workers := max(s.config.Concurrency.PrecheckWorkers, 1)
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				// Perform pre-checks on the file
+				status, encoding, err := s.checkFileStatus(files[i].Path)
+				if err != nil {
+					if s.config.ScanSettings.UnreadableLabel != "" {
+						files[i].Status = StatusUnreadable
+						files[i].Error = err.Error()
+						continue
+					}
+					// Log error but continue processing other files
+					fmt.Printf("Warning: Error checking file %s: %v\n", files[i].Path, err)
+					continue
+				}
+				files[i].Status = status
+				files[i].Encoding = encoding
+			}
+		}()
+	}
+
+	for i, file := range files {
+		if file.Status == "" {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Drop the files whose pre-checks failed
+	checked := files[:0]
+	for _, file := range files {
+		if file.Status != "" {
+			checked = append(checked, file)
+		}
+	}
+	return checked
+}
+
+// FileStatus performs the pre-checks on a single file and returns its current status
+// Files listed in the exclusion file are not checked and return ErrExcluded.
+func (s *Scanner) FileStatus(filePath string) (FileStatus, error) {
+	if s.excludeList[s.normalizePathForExclusionCheck(filePath)] {
+		return StatusExcluded, fmt.Errorf("%s: %w", filePath, ErrExcluded)
+	}
+
+	status, _, err := s.checkFileStatus(filePath)
+	return status, err
+}
+
+// checkFileStatus performs pre-checks on a file and returns its status and detected encoding
+// Files that are not stored as UTF-8 are transcoded before the checks.
+func (s *Scanner) checkFileStatus(filePath string) (FileStatus, string, error) {
+	file, err := s.open(filePath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read file: failed to open file: %w", err)
+	}
+	content, encoding, truncated, err := readText(file, filePath, s.config.ScanSettings.MaxReadBytes)
+	file.Close()
 	if err != nil {
-		return "", fmt.Errorf("failed to read file: %w", err)
+		return "", "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	// Never send binary content, such as renamed attachments, to the AI
+	if IsBinary([]byte(content[:min(len(content), sniffLength)])) {
+		return StatusNotMarkdown, "", nil
+	}
+
+	// A file larger than the read limit has more content than was checked
+	if truncated {
+		return StatusNeedsReview, encoding, nil
 	}
 
 	// Check if file is empty
 	trimmedContent := strings.TrimSpace(string(content))
 	if trimmedContent == "" {
-		return StatusEmpty, nil
+		return StatusEmpty, encoding, nil
 	}
 
 	// Check if file contains only frontmatter
 	if s.isFrontmatterOnly(trimmedContent) {
-		return StatusFrontmatterOnly, nil
+		return StatusFrontmatterOnly, encoding, nil
 	}
 
-	return StatusNeedsReview, nil
-}
+	// Check if file is a stub waiting to be written
+	if s.isPlaceholder(trimmedContent) {
+		return StatusPlaceholder, encoding, nil
+	}
 
-// isFrontmatterOnly checks if the content contains only YAML frontmatter
-func (s *Scanner) isFrontmatterOnly(content string) bool {
-	lines := strings.Split(content, "\n")
+	return StatusNeedsReview, encoding, nil
+}
 
-	// Check for YAML frontmatter
-	if len(lines) < 2 || lines[0] != "---" {
+// IsBinary reports whether content looks like binary data rather than text
+// Text never contains NUL bytes; other content is binary if it is neither valid UTF-8 nor recognized as text.
+func IsBinary(content []byte) bool {
+	if bytes.IndexByte(content, 0) != -1 {
+		return true
+	}
+	if utf8.Valid(content) {
 		return false
 	}
 
-	// Find the end of frontmatter
-	endIndex := -1
-	for i := 1; i < len(lines); i++ {
-		if lines[i] == "---" {
-			endIndex = i
-			break
+	// Allow a character cut in half at the end of the sample
+	for i := 1; i < utf8.UTFMax && i < len(content); i++ {
+		if utf8.Valid(content[:len(content)-i]) {
+			return false
 		}
 	}
 
-	// No end marker found
-	if endIndex == -1 {
-		return false
-	}
+	return !strings.HasPrefix(http.DetectContentType(content), "text/")
+}
 
-	// Check if there's any content after the frontmatter
-	for i := endIndex + 1; i < len(lines); i++ {
-		if strings.TrimSpace(lines[i]) != "" {
+// isFrontmatterOnly checks if the content contains only YAML, TOML or JSON frontmatter
+func (s *Scanner) isFrontmatterOnly(content string) bool {
+	format, _, body := splitFrontmatter(content)
+	return format != "" && strings.TrimSpace(body) == ""
+}
+
+// commentPattern matches HTML comments and Obsidian %% comments, which are not shown in the rendered note
+var commentPattern = regexp.MustCompile(`(?s)<!--.*?-->|%%.*?%%`)
+
+// headingPattern matches a markdown heading line
+var headingPattern = regexp.MustCompile(`^#{1,6}(\s|$)`)
+
+// listMarkerPattern matches the list, quote and task markers at the start of a line
+var listMarkerPattern = regexp.MustCompile(`^(?:(?:[-*+>]|\d+[.)])\s+)*(?:\[[ xX]\]\s+)?`)
+
+// isPlaceholder checks if the body of a note holds nothing but comments, headings and placeholder words, with
+// at least a comment or a placeholder word
+// Notes with headings only are left to the AI, since the title alone may be what the note is for.
+func (s *Scanner) isPlaceholder(content string) bool {
+	_, _, body := splitFrontmatter(content)
+	stripped := commentPattern.ReplaceAllString(body, "")
+	placeholder := stripped != body
+	for _, line := range strings.Split(stripped, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || headingPattern.MatchString(line):
+		case s.isPlaceholderWord(line):
+			placeholder = true
+		default:
 			return false
 		}
 	}
+	return placeholder
+}
 
-	return true
+// isPlaceholderWord checks if a line is one of the configured placeholder words, ignoring case, list markers and
+// surrounding punctuation
+func (s *Scanner) isPlaceholderWord(line string) bool {
+	line = strings.Trim(listMarkerPattern.ReplaceAllString(line, ""), " .!?…:;*_~`()[]")
+	for _, word := range s.config.ScanSettings.PlaceholderWords {
+		if strings.EqualFold(line, strings.TrimSpace(word)) {
+			return true
+		}
+	}
+	return false
 }
 
 // parseExclusionFile reads the exclusion file and extracts Obsidian links
@@ -225,18 +559,68 @@ func (s *Scanner) normalizePathForExclusionCheck(path string) string {
 	return filenameWithoutExt
 }
 
-// ReadFileContent reads and returns the content of a file
+// ReadFileContent reads and returns the content of a file, transcoded to UTF-8
 func ReadFileContent(filePath string) (string, error) {
+	content, _, err := ReadFileContentLimit(filePath, 0)
+	return content, err
+}
+
+// ReadFileContentLimit reads at most limit bytes of a file, transcoded to UTF-8, and reports whether the
+// content was truncated
+// A limit of 0 reads the whole file. Truncation never splits a character.
+func ReadFileContentLimit(filePath string, limit int64) (string, bool, error) {
+	content, _, truncated, err := ReadFileText(filePath, limit)
+	return content, truncated, err
+}
+
+// ReadFileText reads at most limit bytes of a file and returns them transcoded to UTF-8 with the detected
+// encoding, which is empty for UTF-8, and reports whether the content was truncated
+// A limit of 0 reads the whole file. A file larger than the limit is not an error: the content read so far is
+// returned with truncated set.
+func ReadFileText(filePath string, limit int64) (string, string, bool, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to open file: %w", err)
+		return "", "", false, fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
-	content, err := io.ReadAll(file)
+	return readText(file, filePath, limit)
+}
+
+// readText reads at most limit bytes of a file like ReadFileText
+func readText(file io.Reader, filePath string, limit int64) (string, string, bool, error) {
+	reader := file
+	if limit > 0 {
+		// Read one byte past the limit to find out whether there is more
+		reader = io.LimitReader(file, limit+1)
+	}
+	raw, err := io.ReadAll(reader)
 	if err != nil {
-		return "", fmt.Errorf("failed to read file content: %w", err)
+		return "", "", false, fmt.Errorf("failed to read file content: %w", err)
+	}
+
+	truncated := limit > 0 && int64(len(raw)) > limit
+	if truncated {
+		raw = raw[:limit]
+	}
+
+	content, encoding := DecodeText(raw)
+	if truncated {
+		// Drop a character cut in half by the limit
+		start := len(content) - 1
+		for start > 0 && len(content)-start < utf8.UTFMax && !utf8.RuneStart(content[start]) {
+			start--
+		}
+		if start >= 0 && !utf8.FullRune(content[start:]) {
+			content = content[:start]
+		}
+	}
+
+	// Notes in other formats are converted to markdown, unless they turn out to be binary
+	text := string(content)
+	if !IsBinary(content[:min(len(content), sniffLength)]) {
+		text = convertNote(filePath, text)
 	}
 
-	return string(content), nil
+	return text, encoding, truncated, nil
 }
@@ -1,9 +1,18 @@
 package scanner
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
+	"unicode/utf16"
 
 	"ratemykb/config"
 )
@@ -59,7 +68,7 @@ func TestEmptyFileCheck(t *testing.T) {
 	}
 
 	// Test empty file
-	status, err := scanner.checkFileStatus(emptyFilePath)
+	status, err := scanner.FileStatus(emptyFilePath)
 	if err != nil {
 		t.Errorf("Failed to check empty file status: %v", err)
 	}
@@ -68,7 +77,7 @@ func TestEmptyFileCheck(t *testing.T) {
 	}
 
 	// Test whitespace file
-	status, err = scanner.checkFileStatus(whitespaceFilePath)
+	status, err = scanner.FileStatus(whitespaceFilePath)
 	if err != nil {
 		t.Errorf("Failed to check whitespace file status: %v", err)
 	}
@@ -77,7 +86,7 @@ func TestEmptyFileCheck(t *testing.T) {
 	}
 
 	// Test content file
-	status, err = scanner.checkFileStatus(contentFilePath)
+	status, err = scanner.FileStatus(contentFilePath)
 	if err != nil {
 		t.Errorf("Failed to check content file status: %v", err)
 	}
@@ -115,6 +124,16 @@ func TestFrontmatterOnlyCheck(t *testing.T) {
 		t.Fatalf("Failed to create invalid-frontmatter file: %v", err)
 	}
 
+	// Create files with only TOML and JSON frontmatter, as used by Hugo and Zola
+	tomlOnlyPath := filepath.Join(tempDir, "toml_only.md")
+	if err := os.WriteFile(tomlOnlyPath, []byte("+++\ntitle = \"Test\"\n+++\n"), 0644); err != nil {
+		t.Fatalf("Failed to create TOML frontmatter file: %v", err)
+	}
+	jsonOnlyPath := filepath.Join(tempDir, "json_only.md")
+	if err := os.WriteFile(jsonOnlyPath, []byte("{\n  \"title\": \"Test\"\n}\n"), 0644); err != nil {
+		t.Fatalf("Failed to create JSON frontmatter file: %v", err)
+	}
+
 	// Create scanner with default config
 	cfg := config.GetDefaultConfig()
 	scanner, err := New(cfg)
@@ -122,8 +141,18 @@ func TestFrontmatterOnlyCheck(t *testing.T) {
 		t.Fatalf("Failed to create scanner: %v", err)
 	}
 
+	for _, path := range []string{tomlOnlyPath, jsonOnlyPath} {
+		status, err := scanner.FileStatus(path)
+		if err != nil {
+			t.Errorf("Failed to check %s status: %v", path, err)
+		}
+		if status != StatusFrontmatterOnly {
+			t.Errorf("Expected %s status to be %s, got %s", filepath.Base(path), StatusFrontmatterOnly, status)
+		}
+	}
+
 	// Test frontmatter-only file
-	status, err := scanner.checkFileStatus(frontmatterOnlyPath)
+	status, err := scanner.FileStatus(frontmatterOnlyPath)
 	if err != nil {
 		t.Errorf("Failed to check frontmatter-only file status: %v", err)
 	}
@@ -132,7 +161,7 @@ func TestFrontmatterOnlyCheck(t *testing.T) {
 	}
 
 	// Test frontmatter-and-content file
-	status, err = scanner.checkFileStatus(frontmatterAndContentPath)
+	status, err = scanner.FileStatus(frontmatterAndContentPath)
 	if err != nil {
 		t.Errorf("Failed to check frontmatter-and-content file status: %v", err)
 	}
@@ -141,7 +170,7 @@ func TestFrontmatterOnlyCheck(t *testing.T) {
 	}
 
 	// Test invalid frontmatter file
-	status, err = scanner.checkFileStatus(invalidFrontmatterPath)
+	status, err = scanner.FileStatus(invalidFrontmatterPath)
 	if err != nil {
 		t.Errorf("Failed to check invalid-frontmatter file status: %v", err)
 	}
@@ -234,6 +263,21 @@ func TestExclusionFileHandling(t *testing.T) {
 	if status, ok := fileStatuses["quality_exclude_links.md"]; !ok || status != StatusNeedsReview {
 		t.Errorf("Expected 'quality_exclude_links.md' to have status %s, got %s", StatusNeedsReview, status)
 	}
+
+	// Checking a single excluded file reports why it was not checked
+	if status, err := scanner.FileStatus(excludedPath); status != StatusExcluded || !errors.Is(err, ErrExcluded) {
+		t.Errorf("Expected FileStatus to return %s and ErrExcluded, got %s and %v", StatusExcluded, status, err)
+	}
+	// Both excluded files are recorded with the reason
+	exclusions := scanner.Exclusions()
+	if len(exclusions) != 2 {
+		t.Fatalf("Expected 2 exclusions, got %v", exclusions)
+	}
+	for _, exclusion := range exclusions {
+		if exclusion.Reason != ReasonExclusionFile || exclusion.IsDir {
+			t.Errorf("Unexpected exclusion %v", exclusion)
+		}
+	}
 }
 
 func TestDirectoryExclusion(t *testing.T) {
@@ -285,6 +329,90 @@ func TestDirectoryExclusion(t *testing.T) {
 	if len(files) > 0 && filepath.Base(files[0].Path) != "included.md" {
 		t.Errorf("Expected to find 'included.md', got '%s'", filepath.Base(files[0].Path))
 	}
+
+	// The skipped directory is recorded with the reason
+	want := []Exclusion{{Path: excludeDir, Reason: ReasonExcludedDirectory, IsDir: true}}
+	if got := scanner.Exclusions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Exclusions() = %v, want %v", got, want)
+	}
+}
+
+func TestParallelPrecheck(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Alternate statuses so a mix-up between files would be noticed
+	var expected []File
+	for i := 0; i < 20; i++ {
+		path := filepath.Join(tempDir, fmt.Sprintf("note%02d.md", i))
+		content, status := "# Content", StatusNeedsReview
+		if i%2 == 0 {
+			content, status = "", StatusEmpty
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+		expected = append(expected, File{Path: path, Status: status})
+	}
+
+	cfg := config.GetDefaultConfig()
+	cfg.Concurrency.PrecheckWorkers = 4
+
+	scanner, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create scanner: %v", err)
+	}
+
+	files, err := scanner.ScanDirectory(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to scan directory: %v", err)
+	}
+
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected files in walk order with their statuses, got %+v", files)
+	}
+}
+
+func TestUnreadableFiles(t *testing.T) {
+	tempDir := t.TempDir()
+
+	readable := filepath.Join(tempDir, "note.md")
+	if err := os.WriteFile(readable, []byte("# Content"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	broken := filepath.Join(tempDir, "broken.md")
+	if err := os.Symlink(filepath.Join(tempDir, "missing.md"), broken); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+
+	cfg := config.GetDefaultConfig()
+	scanner, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create scanner: %v", err)
+	}
+	files, err := scanner.ScanDirectory(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to scan directory: %v", err)
+	}
+	if len(files) != 2 || files[0].Path != broken || files[0].Status != StatusUnreadable || files[0].Error == "" {
+		t.Fatalf("Expected the broken symlink to be unreadable with its error, got %+v", files)
+	}
+	if files[1].Status != StatusNeedsReview {
+		t.Errorf("Expected the readable note to need review, got %+v", files[1])
+	}
+
+	// Without a label unreadable files are skipped as before
+	cfg.ScanSettings.UnreadableLabel = ""
+	scanner, err = New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create scanner: %v", err)
+	}
+	files, err = scanner.ScanDirectory(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to scan directory: %v", err)
+	}
+	if len(files) != 1 || files[0].Path != readable {
+		t.Errorf("Expected only the readable note, got %+v", files)
+	}
 }
 
 func TestReadFileContent(t *testing.T) {
@@ -319,3 +447,649 @@ func TestReadFileContent(t *testing.T) {
 		t.Errorf("Expected error when reading non-existent file, got nil")
 	}
 }
+
+func TestReadFileContentLimit(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "large.md")
+	if err := os.WriteFile(path, []byte("abcdé"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	tests := []struct {
+		limit             int64
+		expectedContent   string
+		expectedTruncated bool
+	}{
+		{0, "abcdé", false},
+		{6, "abcdé", false},
+		{4, "abcd", true},
+		{5, "abcd", true}, // Never split the two bytes of é
+	}
+
+	for _, tt := range tests {
+		content, truncated, err := ReadFileContentLimit(path, tt.limit)
+		if err != nil {
+			t.Fatalf("ReadFileContentLimit() error = %v", err)
+		}
+		if content != tt.expectedContent || truncated != tt.expectedTruncated {
+			t.Errorf("ReadFileContentLimit(%d) = (%q, %v), want (%q, %v)", tt.limit, content, truncated, tt.expectedContent, tt.expectedTruncated)
+		}
+
+		content, _, truncated, err = ReadFileText(path, tt.limit)
+		if err != nil {
+			t.Fatalf("ReadFileText() error = %v", err)
+		}
+		if content != tt.expectedContent || truncated != tt.expectedTruncated {
+			t.Errorf("ReadFileText(%d) = (%q, %v), want (%q, %v)", tt.limit, content, truncated, tt.expectedContent, tt.expectedTruncated)
+		}
+	}
+}
+
+func TestBinaryDetection(t *testing.T) {
+	tempDir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		content  []byte
+		expected FileStatus
+	}{
+		{"png.md", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), StatusNotMarkdown},
+		{"control.md", []byte("\x01\x02\x03\xff\xfe\x80\x81"), StatusNotMarkdown},
+		{"latin1.md", []byte("# Caf\xe9\n\nLegacy encoded notes"), StatusNeedsReview},
+		{"utf8.md", []byte("# Café ☕\n\nSome notes"), StatusNeedsReview},
+	}
+
+	scanner, err := New(config.GetDefaultConfig())
+	if err != nil {
+		t.Fatalf("Failed to create scanner: %v", err)
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(tempDir, tt.name)
+		if err := os.WriteFile(path, tt.content, 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+
+		status, err := scanner.FileStatus(path)
+		if err != nil {
+			t.Fatalf("FileStatus() error = %v", err)
+		}
+		if status != tt.expected {
+			t.Errorf("FileStatus(%s) = %s, want %s", tt.name, status, tt.expected)
+		}
+	}
+}
+
+func TestPlaceholderCheck(t *testing.T) {
+	tempDir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		content  string
+		expected FileStatus
+	}{
+		{"html-comment.md", "<!-- Write about the meeting here -->\n", StatusPlaceholder},
+		{"obsidian-comment.md", "---\ntags: [meeting]\n---\n# Meeting\n\n%%\nfill in after the call\n%%\n", StatusPlaceholder},
+		{"tbd.md", "# Project plan\n\nTBD", StatusPlaceholder},
+		{"tbc-list.md", "# Budget\n\n- tbc...\n- [ ] **TODO**\n", StatusPlaceholder},
+		{"heading-only.md", "# Kubernetes\n", StatusNeedsReview},
+		{"tag-line.md", "#todo\n", StatusNeedsReview},
+		{"content.md", "# Plan\n\nTBD\n\nShip the first version in May.\n", StatusNeedsReview},
+		{"comment-and-content.md", "<!-- draft -->\nThe cache is flushed every hour.\n", StatusNeedsReview},
+	}
+
+	scanner, err := New(config.GetDefaultConfig())
+	if err != nil {
+		t.Fatalf("Failed to create scanner: %v", err)
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(tempDir, tt.name)
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+
+		status, err := scanner.FileStatus(path)
+		if err != nil {
+			t.Fatalf("FileStatus() error = %v", err)
+		}
+		if status != tt.expected {
+			t.Errorf("FileStatus(%s) = %s, want %s", tt.name, status, tt.expected)
+		}
+	}
+}
+
+func TestEncodingDetection(t *testing.T) {
+	tempDir := t.TempDir()
+
+	text := "# Café\r\n\r\nNotes exported from Windows"
+	encodeUTF16 := func(order binary.AppendByteOrder, bom bool) []byte {
+		var data []byte
+		if bom {
+			data = order.AppendUint16(data, 0xFEFF)
+		}
+		for _, unit := range utf16.Encode([]rune(text)) {
+			data = order.AppendUint16(data, unit)
+		}
+		return data
+	}
+
+	tests := []struct {
+		name     string
+		content  []byte
+		encoding string
+	}{
+		{"utf8.md", []byte(text), ""},
+		{"utf8-bom.md", append([]byte("\xef\xbb\xbf"), text...), ""},
+		{"utf16le.md", encodeUTF16(binary.LittleEndian, true), EncodingUTF16LE},
+		{"utf16be.md", encodeUTF16(binary.BigEndian, true), EncodingUTF16BE},
+		{"utf16le-nobom.md", encodeUTF16(binary.LittleEndian, false), EncodingUTF16LE},
+		{"latin1.md", []byte("# Caf\xe9\r\n\r\nNotes exported from Windows"), EncodingWindows1252},
+	}
+
+	cfg := config.GetDefaultConfig()
+	scanner, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create scanner: %v", err)
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(tempDir, tt.name)
+		if err := os.WriteFile(path, tt.content, 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+
+		content, encoding, _, err := ReadFileText(path, 0)
+		if err != nil {
+			t.Fatalf("ReadFileText() error = %v", err)
+		}
+		if content != text {
+			t.Errorf("ReadFileText(%s) content = %q, want %q", tt.name, content, text)
+		}
+		if encoding != tt.encoding {
+			t.Errorf("ReadFileText(%s) encoding = %q, want %q", tt.name, encoding, tt.encoding)
+		}
+	}
+
+	// Transcoded files go through the pre-checks like any other file
+	files, err := scanner.ScanDirectory(tempDir)
+	if err != nil {
+		t.Fatalf("ScanDirectory() error = %v", err)
+	}
+	for _, file := range files {
+		if file.Status != StatusNeedsReview {
+			t.Errorf("%s: status = %s, want %s", filepath.Base(file.Path), file.Status, StatusNeedsReview)
+		}
+	}
+}
+
+func TestSplitFrontmatter(t *testing.T) {
+	tests := []struct {
+		name            string
+		content         string
+		wantFrontmatter string
+		wantBody        string
+	}{
+		{
+			name:            "with frontmatter",
+			content:         "---\ntitle: Test\n---\n\nBody text",
+			wantFrontmatter: "title: Test",
+			wantBody:        "\nBody text",
+		},
+		{
+			name:            "without frontmatter",
+			content:         "# Heading\n\nBody text",
+			wantFrontmatter: "",
+			wantBody:        "# Heading\n\nBody text",
+		},
+		{
+			name:            "unterminated frontmatter",
+			content:         "---\ntitle: Test\nBody text",
+			wantFrontmatter: "",
+			wantBody:        "---\ntitle: Test\nBody text",
+		},
+		{
+			name:            "toml frontmatter",
+			content:         "+++\ntitle = \"Test\"\n+++\nBody text",
+			wantFrontmatter: "title = \"Test\"",
+			wantBody:        "Body text",
+		},
+		{
+			name:            "json frontmatter",
+			content:         "{\n  \"title\": \"Test\"\n}\nBody text",
+			wantFrontmatter: "{\n  \"title\": \"Test\"\n}",
+			wantBody:        "Body text",
+		},
+		{
+			name:            "brace that is not json",
+			content:         "{{< shortcode >}}\nBody text",
+			wantFrontmatter: "",
+			wantBody:        "{{< shortcode >}}\nBody text",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frontmatter, body := SplitFrontmatter(tt.content)
+			if frontmatter != tt.wantFrontmatter {
+				t.Errorf("Expected frontmatter %q, got %q", tt.wantFrontmatter, frontmatter)
+			}
+			if body != tt.wantBody {
+				t.Errorf("Expected body %q, got %q", tt.wantBody, body)
+			}
+		})
+	}
+}
+
+func TestCountWords(t *testing.T) {
+	content := "---\ntitle: Lots of words here\ntags: [a, b]\n---\n\nOne two three\nfour five"
+	if got := CountWords(content); got != 5 {
+		t.Errorf("Expected 5 words, got %d", got)
+	}
+}
+
+func TestParseFrontmatter(t *testing.T) {
+	fields, err := ParseFrontmatter("---\ntitle: Test\ntags: [a, b]\n---\nBody")
+	if err != nil {
+		t.Fatalf("Failed to parse frontmatter: %v", err)
+	}
+	if fields["title"] != "Test" {
+		t.Errorf("Expected title to be 'Test', got %v", fields["title"])
+	}
+
+	fields, err = ParseFrontmatter("No frontmatter here")
+	if err != nil || len(fields) != 0 {
+		t.Errorf("Expected no fields and no error, got %v, %v", fields, err)
+	}
+
+	if _, err := ParseFrontmatter("---\ntitle: [unclosed\n---\nBody"); err == nil {
+		t.Error("Expected an error for invalid frontmatter")
+	}
+
+	fields, err = ParseFrontmatter("+++\ntitle = \"Test\"\ndate = 2023-01-02\n+++\nBody")
+	if err != nil {
+		t.Fatalf("Failed to parse TOML frontmatter: %v", err)
+	}
+	if fields["title"] != "Test" {
+		t.Errorf("Expected title to be 'Test', got %v", fields["title"])
+	}
+	if want := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC); fields["date"] != want {
+		t.Errorf("Expected date to be %v, got %v", want, fields["date"])
+	}
+
+	fields, err = ParseFrontmatter("{\"title\": \"Test\"}\nBody")
+	if err != nil {
+		t.Fatalf("Failed to parse JSON frontmatter: %v", err)
+	}
+	if fields["title"] != "Test" {
+		t.Errorf("Expected title to be 'Test', got %v", fields["title"])
+	}
+}
+
+func TestEmbeddedImages(t *testing.T) {
+	content := "# Whiteboard\n![[board.png|300]]\n![photo](attachments/photo%20one.JPG \"Photo\")\n" +
+		"![[board.png]]\n![[diagram.pdf]]\n![remote](https://example.com/remote.png)\n[[note]]"
+
+	images := EmbeddedImages(content)
+	want := []string{"board.png", "attachments/photo%20one.JPG"}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("EmbeddedImages() = %v, want %v", images, want)
+	}
+
+	if !IsImageHeavy(content) {
+		t.Error("Expected a note with two images and a title to be image heavy")
+	}
+	if IsImageHeavy(content + strings.Repeat(" word", 100)) {
+		t.Error("Expected a note with two images and 100 words not to be image heavy")
+	}
+	if IsImageHeavy("No images here") {
+		t.Error("Expected a note without images not to be image heavy")
+	}
+}
+
+func TestLinkResolver(t *testing.T) {
+	tempDir := t.TempDir()
+	notePath := filepath.Join(tempDir, "notes", "note.md")
+	for _, path := range []string{
+		notePath,
+		filepath.Join(tempDir, "notes", "local.png"),
+		filepath.Join(tempDir, "attachments", "photo one.png"),
+		filepath.Join(filepath.Dir(tempDir), "outside.png"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create folder: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		ref      string
+		expected string
+	}{
+		{"local.png", filepath.Join(tempDir, "notes", "local.png")},
+		{"attachments/photo%20one.png", filepath.Join(tempDir, "attachments", "photo one.png")},
+		{"photo one.png", filepath.Join(tempDir, "attachments", "photo one.png")},
+		{"missing.png", ""},
+		{"../../outside.png", ""},
+		{"../outside.png", ""},
+	}
+
+	resolver := NewLinkResolver(tempDir)
+	for _, tt := range tests {
+		path, ok := resolver.Resolve(notePath, tt.ref)
+		if path != tt.expected || ok != (tt.expected != "") {
+			t.Errorf("Resolve(%q) = %q, %v, want %q", tt.ref, path, ok, tt.expected)
+		}
+	}
+}
+
+func TestHTMLToMarkdown(t *testing.T) {
+	html := `<!DOCTYPE html>
+<html><head><title>Ignored</title><style>p { color: red; }</style></head>
+<body>
+<h1>Meeting   notes</h1>
+<p>Discussed the <strong>roadmap</strong> and <a href="https://example.com/plan">the plan</a>.<br>
+Next &amp; final step.</p>
+<ul><li>First</li><li>Second <em>item</em></li></ul>
+<pre>  indented
+code</pre>
+<img src="board.png" alt="Board">
+<script>alert("ignored")</script>
+</body></html>`
+
+	want := "# Meeting notes\n\n" +
+		"Discussed the **roadmap** and [the plan](https://example.com/plan).\n" +
+		"Next & final step.\n\n" +
+		"- First\n- Second *item*\n\n" +
+		"```\n  indented\ncode\n```\n\n" +
+		"![Board](board.png)"
+	if got := HTMLToMarkdown(html); got != want {
+		t.Errorf("HTMLToMarkdown() = %q, want %q", got, want)
+	}
+
+	if got := HTMLToMarkdown("<html><body>\n  </body></html>"); got != "" {
+		t.Errorf("Expected an empty page to convert to nothing, got %q", got)
+	}
+}
+
+func TestExtraExtensions(t *testing.T) {
+	tempDir := t.TempDir()
+	for name, content := range map[string]string{
+		"note.md":      "# Markdown note",
+		"imported.txt": "Plain text note",
+		"page.html":    "<html><body><p>Web page</p></body></html>",
+		"blank.htm":    "<html><head><title>Blank</title></head><body> </body></html>",
+		"other.csv":    "a,b,c",
+	} {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	cfg := config.GetDefaultConfig()
+	cfg.ScanSettings.ExtraExtensions = []string{".txt", ".HTML", ".htm"}
+	scanner, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create scanner: %v", err)
+	}
+
+	files, err := scanner.ScanDirectory(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to scan directory: %v", err)
+	}
+
+	statuses := make(map[string]FileStatus)
+	for _, file := range files {
+		statuses[filepath.Base(file.Path)] = file.Status
+	}
+	want := map[string]FileStatus{
+		"note.md":      StatusNeedsReview,
+		"imported.txt": StatusNeedsReview,
+		"page.html":    StatusNeedsReview,
+		"blank.htm":    StatusEmpty,
+	}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("Statuses = %v, want %v", statuses, want)
+	}
+
+	content, err := ReadFileContent(filepath.Join(tempDir, "page.html"))
+	if err != nil || content != "Web page" {
+		t.Errorf("ReadFileContent() = %q, %v, want %q", content, err, "Web page")
+	}
+
+	cfg.ScanSettings.ExtraExtensions = []string{".pdf"}
+	if _, err := New(cfg); err == nil {
+		t.Error("Expected an error for an extension that cannot be converted")
+	}
+}
+
+func TestRollupsNotScanned(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, path := range []string{"Projects/_quality.md", "Projects/deep/_quality.md", "_quality.md"} {
+		path = filepath.Join(tempDir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create folder: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("# Some content"), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	scan := func(rollups bool) []string {
+		cfg := config.GetDefaultConfig()
+		cfg.Report.FolderRollups = rollups
+		scanner, err := New(cfg)
+		if err != nil {
+			t.Fatalf("Failed to create scanner: %v", err)
+		}
+		files, err := scanner.ScanDirectory(tempDir)
+		if err != nil {
+			t.Fatalf("Failed to scan directory: %v", err)
+		}
+		var paths []string
+		for _, file := range files {
+			paths = append(paths, filepath.ToSlash(strings.TrimPrefix(file.Path, tempDir+string(filepath.Separator))))
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	// Only the rollups of top-level folders are written by ratemykb, notes of the same name elsewhere are scanned
+	if got, want := scan(true), []string{"Projects/deep/_quality.md", "_quality.md"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Scanned %v, want %v", got, want)
+	}
+
+	// Without rollups, a note of that name is an ordinary note
+	if got, want := scan(false), []string{"Projects/_quality.md", "Projects/deep/_quality.md", "_quality.md"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Scanned %v, want %v", got, want)
+	}
+}
+
+func TestSyncConflicts(t *testing.T) {
+	tempDir := t.TempDir()
+	names := []string{
+		"note.md",
+		"note.sync-conflict-20240101-120000-ABCDEFG.md",
+		"note (Jane's conflicted copy 2024-01-01).md",
+		"conflicted copies of history.md",
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte("# Some content"), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	scanner, err := New(config.GetDefaultConfig())
+	if err != nil {
+		t.Fatalf("Failed to create scanner: %v", err)
+	}
+	files, err := scanner.ScanDirectory(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to scan directory: %v", err)
+	}
+
+	var scanned []string
+	for _, file := range files {
+		scanned = append(scanned, filepath.Base(file.Path))
+	}
+	sort.Strings(scanned)
+	if want := []string{"conflicted copies of history.md", "note.md"}; !reflect.DeepEqual(scanned, want) {
+		t.Errorf("Scanned %v, want %v", scanned, want)
+	}
+
+	var conflicts []string
+	for _, path := range scanner.SyncConflicts() {
+		conflicts = append(conflicts, filepath.Base(path))
+	}
+	sort.Strings(conflicts)
+	if want := []string{names[2], names[1]}; !reflect.DeepEqual(conflicts, want) {
+		t.Errorf("SyncConflicts() = %v, want %v", conflicts, want)
+	}
+}
+
+func TestScanFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"note.md":                   {Data: []byte("# Some content")},
+		"empty.md":                  {Data: []byte("  \n")},
+		"folder/stub.md":            {Data: []byte("---\ntitle: Stub\n---\n")},
+		"folder/image.png":          {Data: []byte{0x89, 'P', 'N', 'G'}},
+		".ratemykb/state.json":      {Data: []byte("{}")},
+		"archive/old/skipped.md":    {Data: []byte("# Old")},
+		"folder/_quality.md":        {Data: []byte("# Quality of folder")},
+		"folder/nested/_quality.md": {Data: []byte("# A note named like a rollup")},
+	}
+	root := filepath.Join(string(filepath.Separator), "vault")
+
+	cfg := config.GetDefaultConfig()
+	cfg.ScanSettings.ExcludeDirectories = []string{"archive"}
+	cfg.Report.FolderRollups = true
+	scanner, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create scanner: %v", err)
+	}
+	scanner.SetFS(root, fsys)
+
+	files, err := scanner.ScanDirectory(root)
+	if err != nil {
+		t.Fatalf("Failed to scan directory: %v", err)
+	}
+
+	got := make(map[string]FileStatus)
+	for _, file := range files {
+		got[file.Path] = file.Status
+	}
+	want := map[string]FileStatus{
+		filepath.Join(root, "note.md"):                         StatusNeedsReview,
+		filepath.Join(root, "empty.md"):                        StatusEmpty,
+		filepath.Join(root, "folder", "stub.md"):               StatusFrontmatterOnly,
+		filepath.Join(root, "folder", "nested", "_quality.md"): StatusNeedsReview,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Scanned %v, want %v", got, want)
+	}
+
+	// Single files are checked through the filesystem too
+	if status, err := scanner.FileStatus(filepath.Join(root, "empty.md")); err != nil || status != StatusEmpty {
+		t.Errorf("FileStatus() = %q, %v, want %q", status, err, StatusEmpty)
+	}
+}
+
+func TestScanFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"note.md":             {Data: []byte("# Some content")},
+		"empty.md":            {Data: []byte("  \n")},
+		"folder/other.md":     {Data: []byte("# Not listed")},
+		"folder/image.png":    {Data: []byte{0x89, 'P', 'N', 'G'}},
+		".ratemykb/notes.md":  {Data: []byte("# State")},
+		"archive/old/note.md": {Data: []byte("# Old")},
+		"folder/_quality.md":  {Data: []byte("# Quality of folder")},
+		"folder/a.sync-conflict-20240101-120000-ABC.md": {Data: []byte("# Copy")},
+	}
+	root := filepath.Join(string(filepath.Separator), "vault")
+
+	cfg := config.GetDefaultConfig()
+	cfg.ScanSettings.ExcludeDirectories = []string{"archive"}
+	cfg.Report.FolderRollups = true
+	scanner, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create scanner: %v", err)
+	}
+	scanner.SetFS(root, fsys)
+
+	list := "/vault/note.md\r\n\n/vault/empty.md\n/vault/note.md\n/vault/deleted.md\n/vault/folder\n/vault/folder/image.png\n" +
+		"/vault/.ratemykb/notes.md\n/vault/archive/old/note.md\n/vault/folder/_quality.md\n/vault/folder/a.sync-conflict-20240101-120000-ABC.md\n"
+	paths, err := ReadFileList(strings.NewReader(filepath.FromSlash(list)))
+	if err != nil {
+		t.Fatalf("ReadFileList() error = %v", err)
+	}
+	if len(paths) != 10 || paths[0] != filepath.Join(root, "note.md") {
+		t.Fatalf("ReadFileList() = %q, want 10 paths without blank lines or carriage returns", paths)
+	}
+
+	files, err := scanner.ScanFiles(root, paths)
+	if err != nil {
+		t.Fatalf("ScanFiles() error = %v", err)
+	}
+	want := []File{
+		{Path: filepath.Join(root, "note.md"), Status: StatusNeedsReview},
+		{Path: filepath.Join(root, "empty.md"), Status: StatusEmpty},
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("ScanFiles() = %+v, want %+v", files, want)
+	}
+	if conflicts := scanner.SyncConflicts(); len(conflicts) != 1 {
+		t.Errorf("Expected the listed sync conflict, got %v", conflicts)
+	}
+
+	// Files outside the target folder are a mistake in the list rather than files to skip
+	if _, err := scanner.ScanFiles(root, []string{filepath.Join(string(filepath.Separator), "elsewhere", "note.md")}); err == nil {
+		t.Errorf("Expected an error for a file outside the target folder")
+	}
+}
+
+func TestPartition(t *testing.T) {
+	for _, value := range []string{"3", "0/8", "9/8", "a/8", "3/b", "3/0"} {
+		if _, err := ParsePartition(value); err == nil {
+			t.Errorf("Expected an error for partition %q", value)
+		}
+	}
+	partition, err := ParsePartition(" 3/8 ")
+	if err != nil {
+		t.Fatalf("Failed to parse partition: %v", err)
+	}
+	if partition != (Partition{Index: 3, Count: 8}) || partition.String() != "3/8" || partition.Name() != "3-of-8" {
+		t.Errorf("Parsed %+v (%s, %s), want 3/8", partition, partition, partition.Name())
+	}
+
+	// Every file belongs to exactly one partition, whichever folder the vault is in
+	root := filepath.Join("vault", "root")
+	var files []File
+	for i := 0; i < 200; i++ {
+		files = append(files, File{Path: filepath.Join(root, fmt.Sprintf("folder%d", i%7), fmt.Sprintf("note%d.md", i))})
+	}
+	total := 0
+	for i := 1; i <= 4; i++ {
+		partition := Partition{Index: i, Count: 4}
+		filtered := partition.Filter(root, files)
+		if len(filtered) == 0 {
+			t.Errorf("Expected files in partition %s", partition)
+		}
+		total += len(filtered)
+		for _, file := range filtered {
+			moved := filepath.Join("elsewhere", strings.TrimPrefix(file.Path, root))
+			if !partition.Contains("elsewhere", moved) {
+				t.Errorf("Expected %s to stay in partition %s after moving the vault", moved, partition)
+			}
+		}
+	}
+	if total != len(files) {
+		t.Errorf("Partitions hold %d files, want %d", total, len(files))
+	}
+
+	// The zero partition holds every file
+	if got := (Partition{}).Filter(root, files); len(got) != len(files) {
+		t.Errorf("Zero partition holds %d files, want %d", len(got), len(files))
+	}
+}
@@ -0,0 +1,160 @@
+package state
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"ratemykb/config"
+	"ratemykb/output"
+	"ratemykb/scanner"
+)
+
+// PartitionDir returns the folder where partition runs keep their state and report, apart from the state of the
+// whole vault
+func PartitionDir(targetFolder string) string {
+	return filepath.Join(targetFolder, config.StateDir, "partitions")
+}
+
+// RebuildPartition creates an empty ProcessingState for one partition of the target folder, like Rebuild
+func RebuildPartition(targetFolder string, partition scanner.Partition) *ProcessingState {
+	ps := Rebuild(targetFolder)
+	ps.Partition = partition
+	ps.StatePath = filepath.Join(PartitionDir(targetFolder), partition.Name()+".json")
+	ps.ReportPath = filepath.Join(PartitionDir(targetFolder), partition.Name()+".md")
+	return ps
+}
+
+// NewPartition creates the ProcessingState of one partition of the target folder and loads its existing state
+func NewPartition(targetFolder string, partition scanner.Partition) (*ProcessingState, error) {
+	return load(RebuildPartition(targetFolder, partition))
+}
+
+// LoadPartitions loads the states written by the partition runs of a target folder, ordered by partition
+// It returns ErrNoState if no partition has been run.
+func LoadPartitions(targetFolder string) ([]*ProcessingState, error) {
+	matches, err := filepath.Glob(filepath.Join(PartitionDir(targetFolder), "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions: %w", err)
+	}
+
+	var partitions []*ProcessingState
+	for _, match := range matches {
+		name := strings.TrimSuffix(filepath.Base(match), ".json")
+		partition, err := scanner.ParsePartition(strings.Replace(name, "-of-", "/", 1))
+		if err != nil {
+			return nil, fmt.Errorf("unexpected file %s in %s: %w", filepath.Base(match), PartitionDir(targetFolder), err)
+		}
+		ps, err := NewPartition(targetFolder, partition)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load partition %s: %w", partition, err)
+		}
+		partitions = append(partitions, ps)
+	}
+	if len(partitions) == 0 {
+		return nil, fmt.Errorf("%w in %s, run a scan with --partition first", ErrNoState, PartitionDir(targetFolder))
+	}
+
+	sort.Slice(partitions, func(i, j int) bool {
+		if partitions[i].Partition.Count != partitions[j].Partition.Count {
+			return partitions[i].Partition.Count < partitions[j].Partition.Count
+		}
+		return partitions[i].Partition.Index < partitions[j].Partition.Index
+	})
+	return partitions, nil
+}
+
+// Aggregate replaces the processed files of the shares of the partitions with the ones of the partitions and updates
+// the report
+// The results of the files of missing partitions are kept, so a partial aggregate never loses them. Partition runs
+// only classify their own files, and only suggest splits and classify attachments for them, so those are combined. The other vault-wide analyses are computed from the whole scan by every partition, so they are taken
+// from the first one. Possible duplicates and topics need the embeddings of the whole vault and are left out until
+// the next regular run. Files that got a different label than in the replaced state without their content changing
+// are flagged as verdict drift.
+func (ps *ProcessingState) Aggregate(partitions []*ProcessingState) error {
+	// Whether a file belongs to the share of one of the partitions, whose results replace the stored ones
+	covered := func(path string) bool {
+		for _, partition := range partitions {
+			if partition.Partition.Contains(ps.TargetFolder, path) {
+				return true
+			}
+		}
+		return false
+	}
+
+	ps.Previous = ps.ProcessedFiles
+	ps.ProcessedFiles = make(map[string]output.ResultFile)
+	for path, file := range ps.Previous {
+		if !covered(path) {
+			ps.ProcessedFiles[path] = file
+		}
+	}
+	for path := range ps.Failures {
+		if covered(path) {
+			delete(ps.Failures, path)
+		}
+	}
+	longNotes, found := ps.LongNotes, ps.Attachments
+	ps.LongNotes = nil
+	ps.Attachments = nil
+	for _, note := range longNotes {
+		if !covered(note.Path) {
+			ps.LongNotes = append(ps.LongNotes, note)
+		}
+	}
+	for _, attachment := range found {
+		if !covered(attachment.Path) {
+			ps.Attachments = append(ps.Attachments, attachment)
+		}
+	}
+	ps.Duplicates = nil
+	ps.Topics = nil
+
+	for _, partition := range partitions {
+		for path, file := range partition.ProcessedFiles {
+			if previous, ok := ps.Previous[path]; ok {
+				ps.trackDrift(previous, file)
+			}
+			ps.ProcessedFiles[path] = file
+		}
+		for path, failure := range partition.Failures {
+			ps.Failures[path] = failure
+		}
+		for path, drift := range partition.Drifts {
+			ps.Drifts[path] = drift
+		}
+		ps.LongNotes = append(ps.LongNotes, partition.LongNotes...)
+		ps.Attachments = append(ps.Attachments, partition.Attachments...)
+	}
+	for path := range ps.Drifts {
+		if _, ok := ps.ProcessedFiles[path]; !ok {
+			delete(ps.Drifts, path)
+		}
+	}
+
+	// Same order as the analyses
+	sort.Slice(ps.LongNotes, func(i, j int) bool {
+		if ps.LongNotes[i].Words != ps.LongNotes[j].Words {
+			return ps.LongNotes[i].Words > ps.LongNotes[j].Words
+		}
+		return ps.LongNotes[i].Path < ps.LongNotes[j].Path
+	})
+	sort.Slice(ps.Attachments, func(i, j int) bool {
+		return ps.Attachments[i].Path < ps.Attachments[j].Path
+	})
+
+	if len(partitions) > 0 {
+		first := partitions[0]
+		ps.AmbiguousTitles = first.AmbiguousTitles
+		ps.SizeOutliers = first.SizeOutliers
+		ps.MetadataIssues = first.MetadataIssues
+		ps.NavIssues = first.NavIssues
+		ps.Exclusions = first.Exclusions
+		ps.SyncConflicts = first.SyncConflicts
+		ps.TaskNotes = first.TaskNotes
+		ps.tasksCounted = true
+	}
+
+	return ps.persist()
+}
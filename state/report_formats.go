@@ -0,0 +1,430 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"ratemykb/analysis"
+	"ratemykb/attachments"
+	"ratemykb/classification"
+	"ratemykb/output"
+	"ratemykb/safewrite"
+	"ratemykb/scanner"
+)
+
+// Supported report formats
+const (
+	FormatMarkdown = "markdown"
+	FormatJSON     = "json"
+	FormatHTML     = "html"
+	FormatRDJSON   = "rdjson" // Diagnostics for reviewdog
+)
+
+// ErrUnsupportedFormat indicates a report format that cannot be rendered
+var ErrUnsupportedFormat = errors.New("unsupported report format")
+
+// ReportFormats lists the supported report formats
+var ReportFormats = []string{FormatMarkdown, FormatJSON, FormatHTML, FormatRDJSON}
+
+// FormatExtension returns the file extension used for a report format
+func FormatExtension(format string) string {
+	switch format {
+	case FormatJSON:
+		return ".json"
+	case FormatHTML:
+		return ".html"
+	case FormatRDJSON:
+		return ".rdjson"
+	default:
+		return ".md"
+	}
+}
+
+// Render generates the report in the requested format purely from the processing state
+func (ps *ProcessingState) Render(format string) (string, error) {
+	switch format {
+	case FormatMarkdown:
+		return ps.renderMarkdown(), nil
+	case FormatJSON:
+		return ps.renderJSON()
+	case FormatHTML:
+		return ps.renderHTML(), nil
+	case FormatRDJSON:
+		return ps.renderRDJSON()
+	default:
+		return "", fmt.Errorf("%w %q (supported: %s)", ErrUnsupportedFormat, format, strings.Join(ReportFormats, ", "))
+	}
+}
+
+// WriteReport renders the report in the requested format and writes it to the given path
+func (ps *ProcessingState) WriteReport(format, path string) error {
+	content, err := ps.Render(format)
+	if err != nil {
+		return err
+	}
+	if err := safewrite.WriteFileAtomic(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write %s report: %w", format, err)
+	}
+	return nil
+}
+
+// jsonReport is the structure of the JSON report
+// Paths are relative to the target folder
+type jsonReport struct {
+	GeneratedOn     string                     `json:"generated_on,omitempty"` // Omitted when timestamps are disabled
+	TargetFolder    string                     `json:"target_folder"`
+	RunID           string                     `json:"run_id,omitempty"`
+	Statistics      map[string]int             `json:"statistics"` // Number of files per classification
+	OpenTasks       int                        `json:"open_tasks"`
+	AverageScore    float64                    `json:"average_score,omitempty"` // Average overall score of the scored files
+	Ratings         *RatingSummary             `json:"ratings,omitempty"`       // Only set when files were rated
+	Files           []jsonFile                 `json:"files"`
+	AmbiguousTitles []analysis.AmbiguousTitle  `json:"ambiguous_titles"`
+	Duplicates      []analysis.DuplicateGroup  `json:"duplicates,omitempty"` // Only set when near duplicates were found
+	Topics          []analysis.TopicCluster    `json:"topics,omitempty"`     // Only set when low quality notes were grouped by topic
+	LongNotes       []analysis.LongNote        `json:"long_notes"`
+	SizeOutliers    []analysis.SizeOutlier     `json:"size_outliers"`
+	TaskNotes       []analysis.TaskNote        `json:"task_notes"`
+	MetadataIssues  []analysis.MetadataIssue   `json:"metadata_issues"`
+	NavIssues       []analysis.NavigationIssue `json:"navigation_issues,omitempty"` // Only set for docs sites with a navigation
+	SyncConflicts   []string                   `json:"sync_conflicts"`
+	Exclusions      []scanner.Exclusion        `json:"exclusions,omitempty"`
+	Attachments     []attachments.Attachment   `json:"attachments,omitempty"`
+	Failures        map[string]Failure         `json:"failures,omitempty"`      // Files that could not be classified, including the skip list
+	Drifts          map[string]Drift           `json:"verdict_drift,omitempty"` // Files whose label changed without their content changing
+}
+
+// jsonFile is a processed file in the JSON report
+type jsonFile struct {
+	output.ResultFile
+	ObsidianURI string `json:"obsidian_uri,omitempty"` // Only set when a vault name is configured
+}
+
+// renderJSON generates the JSON report
+func (ps *ProcessingState) renderJSON() (string, error) {
+	sf := ps.snapshot()
+
+	report := jsonReport{
+		TargetFolder:    ps.TargetFolder,
+		Statistics:      make(map[string]int),
+		OpenTasks:       analysis.TotalOpenTasks(ps.TaskNotes),
+		AverageScore:    ps.AverageScore(),
+		Ratings:         ps.RatingSummary(),
+		AmbiguousTitles: sf.AmbiguousTitles,
+		Duplicates:      sf.Duplicates,
+		Topics:          sf.Topics,
+		LongNotes:       sf.LongNotes,
+		SizeOutliers:    sf.SizeOutliers,
+		TaskNotes:       sf.TaskNotes,
+		MetadataIssues:  sf.MetadataIssues,
+		NavIssues:       sf.NavIssues,
+		SyncConflicts:   sf.SyncConflicts,
+		Exclusions:      sf.Exclusions,
+		Attachments:     sf.Attachments,
+		Failures:        sf.Failures,
+		Drifts:          sf.Drifts,
+	}
+	if ps.Timestamp != TimestampNone {
+		report.GeneratedOn = ps.inLocation(ps.now()).Format(time.RFC3339)
+		report.RunID = ps.RunID
+	}
+	// JSON keeps RFC 3339 dates for other tools to parse, in the configured timezone
+	for i := range report.Attachments {
+		report.Attachments[i].Modified = ps.inLocation(report.Attachments[i].Modified)
+	}
+	for _, file := range sf.Files {
+		report.Statistics[string(file.Classification)]++
+
+		entry := jsonFile{ResultFile: file}
+		if ps.VaultName != "" {
+			entry.ObsidianURI = ps.obsidianURI(strings.TrimSuffix(file.Path, filepath.Ext(file.Path)))
+		}
+		report.Files = append(report.Files, entry)
+	}
+
+	// Keep characters such as & in URIs readable
+	var buf strings.Builder
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return "", fmt.Errorf("failed to encode JSON report: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// rdjsonResult is the structure of a reviewdog diagnostic result
+// See https://github.com/reviewdog/reviewdog/tree/master/proto/rdf
+type rdjsonResult struct {
+	Source      rdjsonSource       `json:"source"`
+	Diagnostics []rdjsonDiagnostic `json:"diagnostics"`
+}
+
+type rdjsonSource struct {
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+type rdjsonDiagnostic struct {
+	Message  string         `json:"message"`
+	Location rdjsonLocation `json:"location"`
+	Severity string         `json:"severity"`
+	Code     rdjsonCode     `json:"code"`
+}
+
+type rdjsonLocation struct {
+	Path  string      `json:"path"`
+	Range rdjsonRange `json:"range"`
+}
+
+type rdjsonRange struct {
+	Start rdjsonPosition `json:"start"`
+}
+
+type rdjsonPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type rdjsonCode struct {
+	Value string `json:"value"`
+}
+
+// renderRDJSON generates the findings in reviewdog's rdjson format, so reviewdog can comment on the flagged notes
+// of a pull request
+// Files with a passing label or that timed out have no finding. Paths are absolute, which reviewdog resolves
+// against its working directory, so the target folder can be anywhere inside the repository.
+func (ps *ProcessingState) renderRDJSON() (string, error) {
+	passing := make(map[string]bool)
+	for _, label := range ps.PassingLabels {
+		passing[strings.ToLower(label)] = true
+	}
+
+	result := rdjsonResult{
+		Source:      rdjsonSource{Name: "ratemykb", URL: "https://github.com/philipf/rate-my-kb"},
+		Diagnostics: []rdjsonDiagnostic{},
+	}
+	add := func(path, severity, code, message string) {
+		if absPath, err := filepath.Abs(path); err == nil {
+			path = absPath
+		}
+		result.Diagnostics = append(result.Diagnostics, rdjsonDiagnostic{
+			Message:  message,
+			Location: rdjsonLocation{Path: path, Range: rdjsonRange{Start: rdjsonPosition{Line: 1, Column: 1}}},
+			Severity: severity,
+			Code:     rdjsonCode{Value: code},
+		})
+	}
+
+	paths := make([]string, 0, len(ps.ProcessedFiles))
+	for path := range ps.ProcessedFiles {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		file := ps.ProcessedFiles[path]
+		label := string(file.Classification)
+		if passing[strings.ToLower(label)] || file.Classification == classification.TimedOut {
+			continue
+		}
+		message := fmt.Sprintf("Note classified as %q", label) + metadataNotes(file.Metadata)
+		if file.Reason != "" {
+			message += ": " + file.Reason
+		}
+		add(path, "WARNING", codeValue(label), message)
+	}
+
+	for _, path := range ps.Drifted() {
+		drift := ps.Drifts[path]
+		add(path, "WARNING", "verdict-drift", fmt.Sprintf("Label changed from %q to %q although the note did not change", drift.From, drift.To))
+	}
+	for _, file := range ps.Disagreements() {
+		add(file.Path, "INFO", "engine-disagreement", "Engines of the ensemble disagreed: "+formatVotes(file.Votes))
+	}
+	for _, issue := range ps.MetadataIssues {
+		add(issue.Path, "WARNING", "metadata-issue", "Metadata issue: "+strings.Join(issue.Problems, "; "))
+	}
+	for _, issue := range ps.NavIssues {
+		if issue.Entry == "" {
+			add(issue.Path, "WARNING", "not-in-navigation", "Page is not in the navigation of the site, so readers cannot find it")
+		} else {
+			add(issue.Path, "WARNING", "missing-page", fmt.Sprintf("Navigation entry %q has no page", issue.Entry))
+		}
+	}
+	for _, path := range ps.SyncConflicts {
+		add(path, "WARNING", "sync-conflict", "Copy left behind by a sync conflict, merge it into the original note and delete it")
+	}
+	for _, note := range ps.LongNotes {
+		add(note.Path, "INFO", "consider-splitting", fmt.Sprintf("Consider splitting this note of %d words", note.Words))
+	}
+	for _, group := range ps.Duplicates {
+		for _, path := range group.Paths {
+			add(path, "INFO", "possible-duplicate", fmt.Sprintf("%d notes are %.0f%% similar, consider merging them", len(group.Paths), group.Similarity*100))
+		}
+	}
+	for _, title := range ps.AmbiguousTitles {
+		for _, path := range title.Paths {
+			add(path, "INFO", "ambiguous-title", fmt.Sprintf("%d notes share the title %q, so links to it are ambiguous", len(title.Paths), title.Title))
+		}
+	}
+	for _, file := range ps.TagSuggestions() {
+		add(file.Path, "INFO", "suggested-tags", "Note has no tags, consider #"+strings.Join(file.SuggestedTags, " #"))
+	}
+	for _, file := range ps.TitleMismatches() {
+		add(file.Path, "WARNING", "title-mismatch", "Note does not match its title: "+file.TitleMismatch)
+	}
+	for _, file := range ps.MissingSources() {
+		add(file.Path, "WARNING", "missing-sources", "Reference note cites no sources")
+	}
+
+	var buf strings.Builder
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		return "", fmt.Errorf("failed to encode rdjson report: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// codeValue turns a label into a diagnostic code, such as low-quality for "Low quality"
+func codeValue(label string) string {
+	return strings.Trim(codePattern.ReplaceAllString(strings.ToLower(label), "-"), "-")
+}
+
+// codePattern matches the characters replaced by dashes in diagnostic codes
+var codePattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Patterns for the inline markdown used in the report
+var (
+	htmlWikiLinkPattern     = regexp.MustCompile(`\[\[([^\]]+)\]\]`)
+	htmlMarkdownLinkPattern = regexp.MustCompile(`\[([^\]]*)\]\(([^)]+)\)`)
+	htmlBoldPattern         = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	htmlCodePattern         = regexp.MustCompile("`([^`]+)`")
+)
+
+// renderHTML generates a standalone HTML page from the markdown report
+// Only the small subset of markdown used by the report is converted
+func (ps *ProcessingState) renderHTML() string {
+	var body strings.Builder
+	inList, inTable := false, false
+
+	closeList := func() {
+		if inList {
+			body.WriteString("</ul>\n")
+			inList = false
+		}
+		if inTable {
+			body.WriteString("</table>\n")
+			inTable = false
+		}
+	}
+
+	for _, line := range strings.Split(ps.renderMarkdown(), "\n") {
+		switch {
+		case strings.HasPrefix(line, "# "):
+			closeList()
+			body.WriteString(fmt.Sprintf("<h1>%s</h1>\n", ps.inlineHTML(strings.TrimPrefix(line, "# "))))
+		case strings.HasPrefix(line, "## "):
+			closeList()
+			body.WriteString(fmt.Sprintf("<h2>%s</h2>\n", ps.inlineHTML(strings.TrimPrefix(line, "## "))))
+		case strings.HasPrefix(line, "### "):
+			closeList()
+			body.WriteString(fmt.Sprintf("<h3>%s</h3>\n", ps.inlineHTML(strings.TrimPrefix(line, "### "))))
+		case strings.HasPrefix(line, "- "):
+			if !inList {
+				body.WriteString("<ul>\n")
+				inList = true
+			}
+			body.WriteString(fmt.Sprintf("<li>%s</li>\n", ps.inlineHTML(strings.TrimPrefix(line, "- "))))
+		case strings.HasPrefix(line, "| --- "):
+			// The separator between the header and the rows of a table
+		case strings.HasPrefix(line, "| "):
+			cell := "td"
+			if !inTable {
+				closeList()
+				body.WriteString("<table>\n")
+				inTable = true
+				cell = "th"
+			}
+			body.WriteString("<tr>")
+			for _, value := range strings.Split(strings.Trim(line, "| "), " | ") {
+				body.WriteString(fmt.Sprintf("<%s>%s</%s>", cell, ps.inlineHTML(value), cell))
+			}
+			body.WriteString("</tr>\n")
+		case strings.HasPrefix(line, "    "):
+			// Indented details belong to the previous list item
+			body.WriteString(fmt.Sprintf("<pre>%s</pre>\n", html.EscapeString(strings.TrimPrefix(line, "    "))))
+		case strings.HasPrefix(line, "<!-- ") || isPageMarkup(line):
+			// Comments such as the generation time and collapsible blocks are kept as they are
+			closeList()
+			body.WriteString(line + "\n")
+		case strings.TrimSpace(line) == "":
+			closeList()
+		default:
+			closeList()
+			body.WriteString(fmt.Sprintf("<p>%s</p>\n", ps.inlineHTML(line)))
+		}
+	}
+	closeList()
+
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Vault Quality Report</title>
+<style>
+body { font-family: sans-serif; max-width: 960px; margin: 2em auto; line-height: 1.5; }
+pre { margin: 0 0 0 2em; }
+th, td { padding: 0.2em 0.8em; text-align: left; }
+</style>
+</head>
+<body>
+%s</body>
+</html>
+`, body.String())
+}
+
+// inlineHTML escapes a line of the markdown report and converts its inline formatting
+// Obsidian links become obsidian:// URIs when a vault name is set, or links to the note file relative to the report
+func (ps *ProcessingState) inlineHTML(line string) string {
+	escaped := html.EscapeString(line)
+	escaped = htmlWikiLinkPattern.ReplaceAllStringFunc(escaped, func(match string) string {
+		name := htmlWikiLinkPattern.FindStringSubmatch(match)[1]
+		if ps.VaultName != "" {
+			return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(ps.obsidianURI(html.UnescapeString(name))), name)
+		}
+
+		segments := strings.Split(html.UnescapeString(name), "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		return fmt.Sprintf(`<a href="%s.md">%s</a>`, html.EscapeString(strings.Join(segments, "/")), name)
+	})
+	escaped = htmlMarkdownLinkPattern.ReplaceAllStringFunc(escaped, func(match string) string {
+		parts := htmlMarkdownLinkPattern.FindStringSubmatch(match)
+		name, target := parts[1], html.UnescapeString(parts[2])
+		if strings.Contains(target, ":") {
+			// Links with a scheme come from the text of the model rather than the report, Obsidian forbids : in names
+			return match
+		}
+		if ps.VaultName != "" {
+			if linkPath, err := url.PathUnescape(target); err == nil {
+				linkPath = strings.TrimSuffix(linkPath, ".md")
+				return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(ps.obsidianURI(linkPath)), name)
+			}
+		}
+		return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(target), name)
+	})
+	escaped = htmlBoldPattern.ReplaceAllString(escaped, "<strong>$1</strong>")
+	escaped = htmlCodePattern.ReplaceAllString(escaped, "<code>$1</code>")
+	return escaped
+}
@@ -3,17 +3,51 @@ package state
 import (
 	"bufio"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"ratemykb/classification"
 	"ratemykb/output"
 	"ratemykb/scanner"
+
+	"golang.org/x/text/unicode/norm"
 )
 
+// headerPrefixes lists the starts of the lines written before the first section of the report
+var headerPrefixes = []string{"# Vault Quality Report", "Generated on: ", "Target folder: ", "Run ID: ", "<!-- "}
+
+// openTasksPattern matches the open tasks statistic, which is kept to show the trend between runs
+var openTasksPattern = regexp.MustCompile(`^- Open tasks: (\d+)`)
+
+// encodingPattern matches the encoding noted after a link to a file that is not stored as UTF-8
+var encodingPattern = regexp.MustCompile(`\(encoding: ([^)]+)\)`)
+
+// confidencePattern matches the share of samples that agreed on the classification of a file
+var confidencePattern = regexp.MustCompile(`\(confidence: (\d+)%\)`)
+
+// ratingPattern matches the rating from 0 to 100 given to a file
+var ratingPattern = regexp.MustCompile(`\(rating: (\d+)\)`)
+
+// metadataPattern matches a frontmatter field noted after a link, such as "(owner: alice)"
+var metadataPattern = regexp.MustCompile(`\(([^():]+): ([^)]*)\)`)
+
+// reportNotes are the notes the report writes itself, which are not frontmatter fields
+var reportNotes = map[string]bool{"confidence": true, "rating": true, "encoding": true}
+
+// summarySeparator separates a link in the report from the summary of the note
+const summarySeparator = ": "
+
+// reasonSeparator separates the notes after a link in the report from the reason given for the classification
+const reasonSeparator = " — "
+
 // loadExistingReport reads the existing report and populates the processed files map
+// Lines and sections that were not written by the report writer, such as hand edits, are left out and recorded in
+// ReportWarnings rather than guessed at.
 func (ps *ProcessingState) loadExistingReport() error {
 	file, err := os.Open(ps.ReportPath)
 	if err != nil {
@@ -23,69 +57,286 @@ func (ps *ProcessingState) loadExistingReport() error {
 
 	// Parse the report to extract processed files
 	fileScanner := bufio.NewScanner(file)
-	currentSection := ""
+	currentSection, currentID, currentLabel := "", sectionUnknown, ""
 	obsidianLinkPattern := regexp.MustCompile(`\[\[([^\]]+)\]\]`)
+	markdownLinkPattern := regexp.MustCompile(`^- \[[^\]]*\]\(([^)]+)\)`)
+	sectionOf := make(map[string]string) // Section each file was loaded from, to spot files listed twice
+	lastPath := ""                       // File of the last entry, which the indented lines after it belong to
+	lineNumber := 0
+
+	warn := func(format string, args ...any) {
+		ps.ReportWarnings = append(ps.ReportWarnings, fmt.Sprintf("report line %d: ", lineNumber)+fmt.Sprintf(format, args...))
+	}
 
 	for fileScanner.Scan() {
 		line := fileScanner.Text()
+		lineNumber++
 
 		// Identify sections
 		if strings.HasPrefix(line, "## ") {
 			currentSection = strings.TrimPrefix(line, "## ")
+			currentID, currentLabel = parseSection(currentSection)
+			if currentID == sectionUnknown {
+				warn("unrecognized section %q, its entries are ignored", currentSection)
+			}
+			continue
+		}
+
+		// Blank lines and the collapsible blocks long sections are split into carry no entries
+		if strings.TrimSpace(line) == "" || isPageMarkup(line) {
+			continue
+		}
+
+		// Only the title and run details come before the first section
+		if currentSection == "" {
+			if !hasAnyPrefix(line, headerPrefixes) {
+				warn("unrecognized line %q before the first section", line)
+			}
+			continue
+		}
+
+		// Remember the previous open task total
+		if currentID == sectionStatistics {
+			if matches := openTasksPattern.FindStringSubmatch(line); len(matches) == 2 {
+				ps.PreviousOpenTasks, _ = strconv.Atoi(matches[1])
+			} else if !strings.HasPrefix(line, "- ") {
+				warn("unrecognized statistic %q", line)
+			}
+			continue
+		}
+
+		// Skip sections that are recomputed from the scan rather than loaded, and sections that are not understood
+		if currentID.isDerived() || currentID == sectionUnknown {
+			continue
+		}
+
+		// Sections without files say so in a sentence
+		if strings.HasPrefix(line, "No ") && strings.HasSuffix(line, " found.") {
+			continue
+		}
+
+		// Low quality notes can be grouped under a heading per topic
+		if currentID == sectionClassification && strings.HasPrefix(line, "### ") {
 			continue
 		}
 
-		// Process file entries in each section
-		if strings.HasPrefix(line, "- [[") && currentSection != "" {
-			matches := obsidianLinkPattern.FindStringSubmatch(line)
-			if len(matches) >= 2 {
-				obsidianLink := matches[1]
-
-				// Convert Obsidian link back to file path
-				filePath := ps.convertObsidianLinkToPath(obsidianLink)
-
-				// Determine classification based on section
-				var classificationStr string
-				var status scanner.FileStatus
-
-				// Handle special known cases
-				switch currentSection {
-				case "Empty Files":
-					classificationStr = "Empty"
-					status = scanner.StatusEmpty
-				case "Files with Frontmatter Only":
-					classificationStr = "Low quality"
-					status = scanner.StatusFrontmatterOnly
-				default:
-					// For all other sections, use the section name as the classification
-					// This handles any LLM-generated classification dynamically
-					if strings.HasSuffix(currentSection, " Files") {
-						// Strip "Files" suffix if present
-						classificationStr = strings.TrimSuffix(currentSection, " Files")
-					} else {
-						classificationStr = currentSection
-					}
-					status = scanner.StatusNeedsReview
-				}
-
-				// Add to processed files
-				ps.ProcessedFiles[filePath] = output.ResultFile{
-					Path:           filePath,
-					Status:         status,
-					Classification: classification.Classification(classificationStr),
-				}
+		// Suggested improvements are indented under the file they are for
+		if suggestion, ok := strings.CutPrefix(line, "    - "); ok && currentID == sectionClassification && lastPath != "" {
+			file := ps.ProcessedFiles[lastPath]
+			file.Suggestions = append(file.Suggestions, suggestion)
+			ps.ProcessedFiles[lastPath] = file
+			continue
+		}
+		lastPath = ""
+
+		// Process file entries in each section, linked in either link style
+		if !strings.HasPrefix(line, "- [") {
+			warn("unrecognized entry %q in section %q, it is ignored", line, currentSection)
+			continue
+		}
+
+		var obsidianLink, filePath, afterLink string
+		if matches := obsidianLinkPattern.FindStringSubmatchIndex(line); strings.HasPrefix(line, "- [[") && matches != nil {
+			// Convert Obsidian link back to file path
+			obsidianLink = line[matches[2]:matches[3]]
+			filePath = ps.convertObsidianLinkToPath(obsidianLink)
+			afterLink = line[matches[1]:]
+		} else if matches := markdownLinkPattern.FindStringSubmatchIndex(line); matches != nil {
+			// Markdown links keep the extension, so they give the path as it is
+			obsidianLink = line[matches[2]:matches[3]]
+			unescaped, err := url.PathUnescape(obsidianLink)
+			if err != nil {
+				warn("malformed link %q in section %q, it is ignored", line, currentSection)
+				continue
 			}
+			filePath = filepath.Join(ps.TargetFolder, filepath.FromSlash(unescaped))
+			afterLink = line[matches[1]:]
+		} else {
+			warn("malformed link %q in section %q, it is ignored", line, currentSection)
+			continue
+		}
+
+		// The reason comes last, so whatever the model wrote cannot be mistaken for a note about the file. The link is
+		// matched first, since file names can hold the separator too.
+		notes, reason, _ := strings.Cut(afterLink, reasonSeparator)
+
+		// Determine classification based on section
+		var label classification.Classification
+		var status scanner.FileStatus
+		switch currentID {
+		case sectionEmpty:
+			label = classification.Empty
+			status = scanner.StatusEmpty
+		case sectionFrontmatterOnly:
+			label = classification.LowQuality
+			status = scanner.StatusFrontmatterOnly
+		default:
+			// Classification sections are named after the label, which can be anything the LLM returns
+			label = classification.Classification(currentLabel)
+			status = scanner.StatusNeedsReview
+			switch label {
+			case classification.NotMarkdown:
+				status = scanner.StatusNotMarkdown
+			case classification.Placeholder:
+				status = scanner.StatusPlaceholder
+			}
+		}
+
+		if previous, ok := sectionOf[filePath]; ok {
+			warn("%s is listed in both %q and %q, keeping %q", obsidianLink, previous, currentSection, currentSection)
 		}
+		sectionOf[filePath] = currentSection
+
+		// Add to processed files
+		ps.ProcessedFiles[filePath] = output.ResultFile{
+			Path:           filePath,
+			Status:         status,
+			Classification: label,
+			Truncated:      strings.HasSuffix(notes, " (truncated)"),
+			Encoding:       parseEncoding(notes),
+			Confidence:     parseConfidence(notes),
+			Reason:         reason,
+			Rating:         parseRating(notes),
+			Metadata:       parseMetadata(notes),
+			Summary:        parseSummary(notes),
+		}
+		lastPath = filePath
 	}
 
 	return fileScanner.Err()
 }
 
+// hasAnyPrefix reports whether s starts with any of the prefixes
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEncoding returns the encoding noted after a link in the report, empty if there is none
+func parseEncoding(line string) string {
+	if matches := encodingPattern.FindStringSubmatch(line); matches != nil {
+		return matches[1]
+	}
+	return ""
+}
+
+// parseSummary returns the summary written after a link in the report, empty if there is none
+// Summaries are escaped so they hold no parentheses, so the summary ends where the first note starts.
+func parseSummary(afterLink string) string {
+	summary, ok := strings.CutPrefix(afterLink, summarySeparator)
+	if !ok {
+		return ""
+	}
+	summary, _, _ = strings.Cut(summary, " (")
+	return summary
+}
+
+// parseMetadata returns the frontmatter fields noted after a link in the report, nil if there are none
+func parseMetadata(line string) map[string]string {
+	var metadata map[string]string
+	for _, matches := range metadataPattern.FindAllStringSubmatch(line, -1) {
+		if reportNotes[matches[1]] {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[matches[1]] = matches[2]
+	}
+	return metadata
+}
+
+// parseConfidence returns the confidence noted after a link in the report, 0 if there is none
+func parseConfidence(line string) float64 {
+	if matches := confidencePattern.FindStringSubmatch(line); matches != nil {
+		if percent, err := strconv.Atoi(matches[1]); err == nil {
+			return float64(percent) / 100
+		}
+	}
+	return 0
+}
+
+// parseRating extracts the rating noted after a link, nil if the file has none
+func parseRating(line string) *int {
+	if matches := ratingPattern.FindStringSubmatch(line); matches != nil {
+		if rating, err := strconv.Atoi(matches[1]); err == nil {
+			return &rating
+		}
+	}
+	return nil
+}
+
 // convertObsidianLinkToPath converts an Obsidian link back to a file path
 func (ps *ProcessingState) convertObsidianLinkToPath(obsidianLink string) string {
 	// Convert forward slashes to path separators
 	pathWithoutExt := strings.ReplaceAll(obsidianLink, "/", string(filepath.Separator))
 
+	// Notes in other formats keep their extension in links
+	if scanner.IsConvertible(pathWithoutExt) {
+		return filepath.Join(ps.TargetFolder, pathWithoutExt)
+	}
+
 	// Add file extension and target folder path
 	return filepath.Join(ps.TargetFolder, pathWithoutExt+".md")
-}
\ No newline at end of file
+}
+
+// MatchFiles matches the processed files to the files found on disk by the scanner
+// Paths rebuilt from report links assume the case of the link and a .md extension, so on case-insensitive
+// filesystems and in vaults with other note extensions they can differ from the scanned path of the same note, which
+// would classify it again under a second entry. Entries without a file on disk are moved to the scanned file with the
+// same path ignoring case, Unicode normalization and extension, or dropped if that file already has an entry. It
+// returns the number of entries moved or dropped.
+func (ps *ProcessingState) MatchFiles(files []scanner.File) (int, error) {
+	onDisk := make(map[string]bool, len(files))
+	byKey := make(map[string]string, len(files))
+	ambiguous := make(map[string]bool)
+	for _, file := range files {
+		onDisk[file.Path] = true
+		key := matchKey(file.Path)
+		if existing, ok := byKey[key]; ok && existing != file.Path {
+			ambiguous[key] = true
+		}
+		byKey[key] = file.Path
+	}
+
+	// Visit the entries in order so the same one wins when several match a file
+	var unmatched []string
+	for path := range ps.ProcessedFiles {
+		if !onDisk[path] {
+			unmatched = append(unmatched, path)
+		}
+	}
+	sort.Strings(unmatched)
+
+	matched := 0
+	for _, path := range unmatched {
+		key := matchKey(path)
+		match, ok := byKey[key]
+		if !ok || ambiguous[key] {
+			continue
+		}
+
+		file := ps.ProcessedFiles[path]
+		delete(ps.ProcessedFiles, path)
+		matched++
+		if _, exists := ps.ProcessedFiles[match]; !exists {
+			file.Path = match
+			ps.ProcessedFiles[match] = file
+		}
+	}
+
+	if matched == 0 {
+		return 0, nil
+	}
+	return matched, ps.persist()
+}
+
+// matchKey returns the form of a path used to match report entries to files on disk
+func matchKey(path string) string {
+	path = strings.TrimSuffix(path, filepath.Ext(path))
+	return strings.ToLower(norm.NFC.String(path))
+}
@@ -2,141 +2,774 @@ package state
 
 import (
 	"fmt"
+	"math"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"time"
 
+	"ratemykb/analysis"
+	"ratemykb/classification"
 	"ratemykb/output"
+	"ratemykb/safewrite"
 	"ratemykb/scanner"
 )
 
+// maxTaskNotes is the number of notes listed in the open tasks section
+const maxTaskNotes = 20
+
 // updateReport regenerates the report with all processed files
 func (ps *ProcessingState) updateReport() error {
-	// Create a temporary file for writing
-	tempFile := ps.ReportPath + ".tmp"
-	file, err := os.Create(tempFile)
+	if err := safewrite.WriteFileAtomic(ps.ReportPath, []byte(ps.renderMarkdown()), 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// updateSections rewrites the header and the given sections of the report and keeps the other sections as they
+// were written, so a changed verdict does not render every file of a large vault again
+// The whole report is regenerated instead when the report on disk does not have the sections it should have, such
+// as when a file gets a label no other file has.
+func (ps *ProcessingState) updateSections(keys map[reportKey]bool) error {
+	data, err := os.ReadFile(ps.ReportPath)
 	if err != nil {
-		return fmt.Errorf("failed to create temp report file: %w", err)
+		return ps.updateReport()
+	}
+
+	files := ps.groupFiles()
+	layout := ps.layout(files)
+	_, written := splitReport(string(data))
+	if len(written) != len(layout) {
+		return ps.updateReport()
+	}
+	for i, key := range layout {
+		heading, _, _ := strings.Cut(strings.TrimPrefix(written[i], "## "), "\n")
+		if id, label := parseSection(heading); id != key.id || label != key.label {
+			return ps.updateReport()
+		}
 	}
 
-	// Generate report content
 	var content strings.Builder
+	content.WriteString(ps.renderHeader())
+	for i, key := range layout {
+		if keys[key] {
+			content.WriteString(ps.renderSection(key, files))
+		} else {
+			content.WriteString(written[i])
+		}
+	}
+	if err := safewrite.WriteFileAtomic(ps.ReportPath, []byte(content.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
 
-	// Add header
-	content.WriteString("# Vault Quality Report\n\n")
-	content.WriteString(fmt.Sprintf("Generated on: %s\n\n", time.Now().Format("2006-01-02 15:04:05")))
-	content.WriteString(fmt.Sprintf("Target folder: `%s`\n\n", ps.TargetFolder))
+// splitReport splits a markdown report into the header before its first section and its sections, each from its
+// heading up to the next one
+func splitReport(markdown string) (string, []string) {
+	var header string
+	var sections []string
+	for _, line := range strings.SplitAfter(markdown, "\n") {
+		switch {
+		case strings.HasPrefix(line, "## "):
+			sections = append(sections, line)
+		case len(sections) > 0:
+			sections[len(sections)-1] += line
+		default:
+			header += line
+		}
+	}
+	return header, sections
+}
+
+// affectedSections returns the sections that change when files are added, changed or removed: the sections
+// listing the files, given as they were before and after the change, and the sections summarizing all files
+func affectedSections(files ...output.ResultFile) map[reportKey]bool {
+	keys := map[reportKey]bool{
+		{id: sectionStatistics}:         true,
+		{id: sectionNeedsReview}:        true,
+		{id: sectionQualityScores}:      true,
+		{id: sectionRatings}:            true,
+		{id: sectionSkippedFiles}:       true,
+		{id: sectionTagSuggestions}:     true,
+		{id: sectionTitleMismatch}:      true,
+		{id: sectionMissingSources}:     true,
+		{id: sectionVerdictDrift}:       true,
+		{id: sectionEngineDisagreement}: true,
+	}
+	for _, file := range files {
+		switch {
+		case file.Status == scanner.StatusEmpty:
+			keys[reportKey{id: sectionEmpty}] = true
+		case file.Status == scanner.StatusFrontmatterOnly:
+			keys[reportKey{id: sectionFrontmatterOnly}] = true
+		case file.Classification != "":
+			keys[reportKey{id: sectionClassification, label: string(file.Classification)}] = true
+		}
+	}
+	return keys
+}
 
-	// Categorize files
-	var emptyFiles, frontmatterOnlyFiles []output.ResultFile
-	classificationMap := make(map[string][]output.ResultFile)
+// reportKey identifies a section of the rendered report, with the label of a classification section
+type reportKey struct {
+	id    sectionID
+	label string
+}
 
+// reportFiles are the processed files grouped the way the report lists them
+type reportFiles struct {
+	empty           []output.ResultFile
+	frontmatterOnly []output.ResultFile
+	byLabel         map[string][]output.ResultFile
+	labels          []string // Labels with files, sorted for consistent output
+}
+
+// groupFiles groups the processed files by the section listing them
+func (ps *ProcessingState) groupFiles() *reportFiles {
+	files := &reportFiles{byLabel: make(map[string][]output.ResultFile)}
 	for _, file := range ps.ProcessedFiles {
 		if file.Status == scanner.StatusEmpty {
-			emptyFiles = append(emptyFiles, file)
+			files.empty = append(files.empty, file)
 		} else if file.Status == scanner.StatusFrontmatterOnly {
-			frontmatterOnlyFiles = append(frontmatterOnlyFiles, file)
+			files.frontmatterOnly = append(files.frontmatterOnly, file)
 		} else if file.Classification != "" {
-			classStr := string(file.Classification)
-			classificationMap[classStr] = append(classificationMap[classStr], file)
+			label := string(file.Classification)
+			files.byLabel[label] = append(files.byLabel[label], file)
 		}
 	}
+	for label := range files.byLabel {
+		files.labels = append(files.labels, label)
+	}
+	sort.Strings(files.labels)
 
-	// Add statistics
-	content.WriteString("## Statistics\n\n")
-	content.WriteString(fmt.Sprintf("- Total files processed: %d\n", len(ps.ProcessedFiles)))
-	content.WriteString(fmt.Sprintf("- Empty files: %d\n", len(emptyFiles)))
-	content.WriteString(fmt.Sprintf("- Files with frontmatter only: %d\n", len(frontmatterOnlyFiles)))
+	// Sort for consistent output
+	sortByPath(files.empty)
+	sortByPath(files.frontmatterOnly)
+	for _, group := range files.byLabel {
+		sortByPath(group)
+	}
+	return files
+}
+
+// metadataNotes returns the frontmatter fields of a file as notes after its link, such as " (owner: alice)", sorted by
+// field
+// Parentheses in values become brackets, so the notes can be read back. The JSON report keeps the values as they are.
+func metadataNotes(metadata map[string]string) string {
+	fields := make([]string, 0, len(metadata))
+	for field := range metadata {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	var notes strings.Builder
+	for _, field := range fields {
+		notes.WriteString(fmt.Sprintf(" (%s: %s)", field, metadataEscaper.Replace(metadata[field])))
+	}
+	return notes.String()
+}
+
+// formatVotes lists the label each engine of an ensemble gave, such as "Low quality (gemma3:1b), Good enough (gpt-4o)"
+func formatVotes(votes []classification.Vote) string {
+	var entries []string
+	for _, vote := range votes {
+		entries = append(entries, fmt.Sprintf("%s (%s)", vote.Classification, vote.Engine))
+	}
+	return strings.Join(entries, ", ")
+}
+
+// summaryEscaper replaces the characters of summaries that would be read back as a note or the reason
+var summaryEscaper = strings.NewReplacer("(", "[", ")", "]", "\n", " ", reasonSeparator, " - ")
 
-	// Add statistics for each classification type
-	for classType, classFiles := range classificationMap {
-		content.WriteString(fmt.Sprintf("- %s files: %d\n", classType, len(classFiles)))
+// metadataEscaper replaces the characters of frontmatter values that would end a note early
+var metadataEscaper = strings.NewReplacer("(", "[", ")", "]", "\n", " ")
+
+// sortByPath sorts files by path
+func sortByPath(files []output.ResultFile) {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+}
+
+// layout returns the sections of the report in the order they are written
+// Sections that depend on the configuration or on optional findings are only present when enabled or found.
+func (ps *ProcessingState) layout(files *reportFiles) []reportKey {
+	keys := []reportKey{{id: sectionStatistics}, {id: sectionEmpty}, {id: sectionFrontmatterOnly}}
+	for _, label := range files.labels {
+		keys = append(keys, reportKey{id: sectionClassification, label: label})
+	}
+	if ps.ReviewThreshold > 0 {
+		keys = append(keys, reportKey{id: sectionNeedsReview})
+	}
+	if len(ps.ScoreDimensions) > 0 {
+		keys = append(keys, reportKey{id: sectionQualityScores})
 	}
-	content.WriteString("\n")
+	if ps.Ratings {
+		keys = append(keys, reportKey{id: sectionRatings})
+	}
+	if len(ps.Drifts) > 0 {
+		keys = append(keys, reportKey{id: sectionVerdictDrift})
+	}
+	if len(ps.Disagreements()) > 0 {
+		keys = append(keys, reportKey{id: sectionEngineDisagreement})
+	}
+	keys = append(keys, reportKey{id: sectionAmbiguousTitles})
+	if len(ps.Duplicates) > 0 {
+		keys = append(keys, reportKey{id: sectionPossibleDuplicates})
+	}
+	keys = append(keys,
+		reportKey{id: sectionConsiderSplitting},
+		reportKey{id: sectionSizeOutliers},
+		reportKey{id: sectionOpenTasks},
+		reportKey{id: sectionMetadataIssues},
+	)
+	if len(ps.NavIssues) > 0 {
+		keys = append(keys, reportKey{id: sectionNavigationIssues})
+	}
+	if len(ps.TagSuggestions()) > 0 {
+		keys = append(keys, reportKey{id: sectionTagSuggestions})
+	}
+	if len(ps.TitleMismatches()) > 0 {
+		keys = append(keys, reportKey{id: sectionTitleMismatch})
+	}
+	if len(ps.MissingSources()) > 0 {
+		keys = append(keys, reportKey{id: sectionMissingSources})
+	}
+	keys = append(keys, reportKey{id: sectionSyncConflicts})
+	if len(ps.SkippedFiles()) > 0 {
+		keys = append(keys, reportKey{id: sectionSkippedFiles})
+	}
+	if len(ps.Attachments) > 0 {
+		keys = append(keys, reportKey{id: sectionAttachments})
+	}
+	if len(ps.Exclusions) > 0 {
+		keys = append(keys, reportKey{id: sectionExcludedFiles})
+	}
+	return keys
+}
 
-	// Add empty files section
-	content.WriteString("## Empty Files\n\n")
-	if len(emptyFiles) == 0 {
-		content.WriteString("No empty files found.\n\n")
-	} else {
-		// Sort for consistent output
-		sort.Slice(emptyFiles, func(i, j int) bool {
-			return emptyFiles[i].Path < emptyFiles[j].Path
-		})
+// renderMarkdown generates the markdown report content from the processing state
+func (ps *ProcessingState) renderMarkdown() string {
+	files := ps.groupFiles()
 
-		for _, file := range emptyFiles {
-			link := formatObsidianLink(ps.TargetFolder, file.Path)
-			content.WriteString(fmt.Sprintf("- %s\n", link))
+	var content strings.Builder
+	content.WriteString(ps.renderHeader())
+	for _, key := range ps.layout(files) {
+		content.WriteString(ps.renderSection(key, files))
+	}
+	return content.String()
+}
+
+// renderHeader generates the title and details of the report before its first section
+func (ps *ProcessingState) renderHeader() string {
+	var content strings.Builder
+	content.WriteString("# Vault Quality Report\n\n")
+	generatedOn := ps.formatTime(ps.now())
+	switch ps.Timestamp {
+	case TimestampNone:
+		content.WriteString(fmt.Sprintf("Target folder: `%s`\n\n", ps.TargetFolder))
+	case TimestampComment:
+		comment := "Generated on: " + generatedOn
+		if ps.RunID != "" {
+			comment += ", run ID: " + ps.RunID
+		}
+		content.WriteString(fmt.Sprintf("<!-- %s -->\n\n", comment))
+		content.WriteString(fmt.Sprintf("Target folder: `%s`\n\n", ps.TargetFolder))
+	default:
+		content.WriteString(fmt.Sprintf("Generated on: %s\n\n", generatedOn))
+		content.WriteString(fmt.Sprintf("Target folder: `%s`\n\n", ps.TargetFolder))
+		if ps.RunID != "" {
+			content.WriteString(fmt.Sprintf("Run ID: `%s`\n\n", ps.RunID))
 		}
-		content.WriteString("\n")
 	}
+	return content.String()
+}
 
-	// Add frontmatter-only files section
-	content.WriteString("## Files with Frontmatter Only\n\n")
-	if len(frontmatterOnlyFiles) == 0 {
-		content.WriteString("No files with frontmatter only found.\n\n")
-	} else {
-		// Sort for consistent output
-		sort.Slice(frontmatterOnlyFiles, func(i, j int) bool {
-			return frontmatterOnlyFiles[i].Path < frontmatterOnlyFiles[j].Path
-		})
+// renderSection generates a section of the report, from its heading to the blank line ending it, split into
+// collapsible blocks when it lists more than the page size
+func (ps *ProcessingState) renderSection(key reportKey, files *reportFiles) string {
+	var content strings.Builder
+	switch key.id {
+	case sectionStatistics:
+		content.WriteString(sectionHeading(sectionStatistics))
+		content.WriteString(fmt.Sprintf("- Total files processed: %d\n", len(ps.ProcessedFiles)))
+		content.WriteString(fmt.Sprintf("- Empty files: %d\n", len(files.empty)))
+		content.WriteString(fmt.Sprintf("- Files with frontmatter only: %d\n", len(files.frontmatterOnly)))
+		content.WriteString(fmt.Sprintf("- Ambiguous titles: %d\n", len(ps.AmbiguousTitles)))
+		if len(ps.Duplicates) > 0 {
+			content.WriteString(fmt.Sprintf("- Possible duplicate groups: %d\n", len(ps.Duplicates)))
+		}
+		content.WriteString(fmt.Sprintf("- Notes to consider splitting: %d\n", len(ps.LongNotes)))
+		content.WriteString(fmt.Sprintf("- Size outliers: %d\n", len(ps.SizeOutliers)))
+		content.WriteString(fmt.Sprintf("- Open tasks: %d%s\n", analysis.TotalOpenTasks(ps.TaskNotes), ps.openTasksTrend()))
+		content.WriteString(fmt.Sprintf("- Notes with metadata issues: %d\n", len(ps.MetadataIssues)))
+		if len(ps.NavIssues) > 0 {
+			content.WriteString(fmt.Sprintf("- Navigation issues: %d\n", len(ps.NavIssues)))
+		}
+		content.WriteString(fmt.Sprintf("- Sync conflicts: %d\n", len(ps.SyncConflicts)))
+		content.WriteString(fmt.Sprintf("- Files needing human review: %d\n", len(ps.NeedsReview())))
+		if len(ps.Drifts) > 0 {
+			content.WriteString(fmt.Sprintf("- Files with verdict drift: %d\n", len(ps.Drifts)))
+		}
+		if len(ps.ScoreDimensions) > 0 {
+			content.WriteString(fmt.Sprintf("- Average quality score: %.1f / 5\n", ps.AverageScore()))
+		}
+		if ps.Ratings {
+			if summary := ps.RatingSummary(); summary != nil {
+				content.WriteString(fmt.Sprintf("- Mean rating: %.1f\n", summary.Mean))
+				content.WriteString(fmt.Sprintf("- Median rating: %g\n", summary.Median))
+				content.WriteString(fmt.Sprintf("- 10th percentile rating: %d\n", summary.P10))
+				content.WriteString(fmt.Sprintf("- 90th percentile rating: %d\n", summary.P90))
+			}
+		}
 
-		for _, file := range frontmatterOnlyFiles {
-			link := formatObsidianLink(ps.TargetFolder, file.Path)
-			content.WriteString(fmt.Sprintf("- %s\n", link))
+		// Add statistics for each classification type
+		for _, label := range files.labels {
+			content.WriteString(fmt.Sprintf("- %s files: %d\n", label, len(files.byLabel[label])))
 		}
 		content.WriteString("\n")
-	}
 
-	// Add sections for each classification type
-	var classTypes []string
-	for classType := range classificationMap {
-		classTypes = append(classTypes, classType)
-	}
-	sort.Strings(classTypes)
+	case sectionEmpty:
+		content.WriteString(sectionHeading(sectionEmpty))
+		if len(files.empty) == 0 {
+			content.WriteString("No empty files found.\n\n")
+		} else {
+			for _, file := range files.empty {
+				link := ps.formatLink(file.Path)
+				content.WriteString(fmt.Sprintf("- %s\n", link))
+			}
+			content.WriteString("\n")
+		}
 
-	for _, classType := range classTypes {
-		classFiles := classificationMap[classType]
-		content.WriteString(fmt.Sprintf("## %s Files\n\n", classType))
+	case sectionFrontmatterOnly:
+		content.WriteString(sectionHeading(sectionFrontmatterOnly))
+		if len(files.frontmatterOnly) == 0 {
+			content.WriteString("No files with frontmatter only found.\n\n")
+		} else {
+			for _, file := range files.frontmatterOnly {
+				link := ps.formatLink(file.Path)
+				content.WriteString(fmt.Sprintf("- %s\n", link))
+			}
+			content.WriteString("\n")
+		}
+
+	case sectionClassification:
+		classFiles := files.byLabel[key.label]
+		content.WriteString(fmt.Sprintf("## %s\n\n", classificationTitle(key.label)))
 		if len(classFiles) == 0 {
-			content.WriteString(fmt.Sprintf("No %s files found.\n\n", strings.ToLower(classType)))
+			content.WriteString(fmt.Sprintf("No %s found.\n\n", strings.ToLower(classificationTitle(key.label))))
+		} else {
+			for _, group := range ps.topicGroups(key.label, classFiles) {
+				if group.title != "" {
+					content.WriteString(fmt.Sprintf("### %s\n\n", group.title))
+				}
+				content.WriteString(ps.classificationEntries(group.files))
+				content.WriteString("\n")
+			}
+		}
+
+	case sectionNeedsReview:
+		// The files classified with a low confidence, only present when a threshold is configured
+		content.WriteString(sectionHeading(sectionNeedsReview))
+		if review := ps.NeedsReview(); len(review) == 0 {
+			content.WriteString("No files needing human review found.\n\n")
 		} else {
-			// Sort for consistent output
-			sort.Slice(classFiles, func(i, j int) bool {
-				return classFiles[i].Path < classFiles[j].Path
-			})
+			content.WriteString(fmt.Sprintf("These files were classified with a confidence below %d%%, least confident first.\n\n", int(math.Round(ps.ReviewThreshold*100))))
+			for _, file := range review {
+				link := ps.formatLink(file.Path)
+				entry := fmt.Sprintf("%s: %s (confidence: %d%%)", link, file.Classification, int(math.Round(file.Confidence*100)))
+				if file.Reason != "" {
+					entry += reasonSeparator + file.Reason
+				}
+				content.WriteString(fmt.Sprintf("- %s\n", entry))
+			}
+			content.WriteString("\n")
+		}
 
-			for _, file := range classFiles {
-				link := formatObsidianLink(ps.TargetFolder, file.Path)
-				content.WriteString(fmt.Sprintf("- %s\n", link))
+	case sectionQualityScores:
+		// The scores of each file on each dimension, only present when scoring is configured
+		content.WriteString(sectionHeading(sectionQualityScores))
+		if scored := ps.ScoredFiles(); len(scored) == 0 {
+			content.WriteString("No scored files found.\n\n")
+		} else {
+			content.WriteString("Scores from 1 to 5, with the overall score weighted by dimension, lowest first.\n\n")
+			content.WriteString("| Note | " + strings.Join(ps.ScoreDimensions, " | ") + " | Overall |\n")
+			content.WriteString(strings.Repeat("| --- ", len(ps.ScoreDimensions)+2) + "|\n")
+			for _, file := range scored {
+				row := []string{ps.formatLink(file.Path)}
+				for _, dimension := range ps.ScoreDimensions {
+					if score, ok := file.Scores[dimension]; ok {
+						row = append(row, fmt.Sprintf("%d", score))
+					} else {
+						row = append(row, "-")
+					}
+				}
+				row = append(row, fmt.Sprintf("%.1f", file.Score))
+				content.WriteString("| " + strings.Join(row, " | ") + " |\n")
+			}
+			content.WriteString("\n")
+		}
+
+	case sectionRatings:
+		// Every rated file by rating so the worst notes come first, only present when rating is on
+		content.WriteString(sectionHeading(sectionRatings))
+		if rated := ps.RatedFiles(); len(rated) == 0 {
+			content.WriteString("No rated files found.\n\n")
+		} else {
+			for _, file := range rated {
+				entry := fmt.Sprintf("%s: %d (%s)", ps.formatLink(file.Path), *file.Rating, file.Classification)
+				if file.Reason != "" {
+					entry += reasonSeparator + file.Reason
+				}
+				content.WriteString(fmt.Sprintf("- %s\n", entry))
+			}
+			content.WriteString("\n")
+		}
+
+	case sectionAmbiguousTitles:
+		content.WriteString(sectionHeading(sectionAmbiguousTitles))
+		if len(ps.AmbiguousTitles) == 0 {
+			content.WriteString("No ambiguous titles found.\n\n")
+		} else {
+			for _, title := range ps.AmbiguousTitles {
+				var links []string
+				for _, path := range title.Paths {
+					links = append(links, ps.formatLink(path))
+				}
+				content.WriteString(fmt.Sprintf("- **%s**: %s\n", title.Title, strings.Join(links, ", ")))
+			}
+			content.WriteString("\n")
+		}
+
+	case sectionPossibleDuplicates:
+		// Only present when near duplicates were found, since finding them needs embeddings
+		content.WriteString(sectionHeading(sectionPossibleDuplicates))
+		for _, group := range ps.Duplicates {
+			var links []string
+			for _, path := range group.Paths {
+				links = append(links, ps.formatLink(path))
+			}
+			content.WriteString(fmt.Sprintf("- %s (%.0f%% similar)\n", strings.Join(links, ", "), group.Similarity*100))
+		}
+		content.WriteString("\n")
+
+	case sectionConsiderSplitting:
+		content.WriteString(sectionHeading(sectionConsiderSplitting))
+		if len(ps.LongNotes) == 0 {
+			content.WriteString("No overly long notes found.\n\n")
+		} else {
+			for _, note := range ps.LongNotes {
+				link := ps.formatLink(note.Path)
+				content.WriteString(fmt.Sprintf("- %s (%d words)\n", link, note.Words))
+
+				// Indent the suggested outline under the note
+				if note.Suggestion != "" {
+					for _, line := range strings.Split(note.Suggestion, "\n") {
+						if strings.TrimSpace(line) != "" {
+							content.WriteString(fmt.Sprintf("    %s\n", line))
+						}
+					}
+				}
 			}
 			content.WriteString("\n")
 		}
+
+	case sectionSizeOutliers:
+		content.WriteString(sectionHeading(sectionSizeOutliers))
+		if len(ps.SizeOutliers) == 0 {
+			content.WriteString("No size outliers found.\n\n")
+		} else {
+			for _, outlier := range ps.SizeOutliers {
+				length := "short"
+				if outlier.Long {
+					length = "long"
+				}
+				link := ps.formatLink(outlier.Path)
+				content.WriteString(fmt.Sprintf("- %s (%d words, unusually %s for this vault)\n", link, outlier.Words, length))
+			}
+			content.WriteString("\n")
+		}
+
+	case sectionOpenTasks:
+		content.WriteString(sectionHeading(sectionOpenTasks))
+		if len(ps.TaskNotes) == 0 {
+			content.WriteString("No open tasks found.\n\n")
+		} else {
+			for i, note := range ps.TaskNotes {
+				if i == maxTaskNotes {
+					content.WriteString(fmt.Sprintf("- ... and %d more notes with open tasks\n", len(ps.TaskNotes)-maxTaskNotes))
+					break
+				}
+				link := ps.formatLink(note.Path)
+				content.WriteString(fmt.Sprintf("- %s: %d open tasks\n", link, note.OpenTasks))
+			}
+			content.WriteString("\n")
+		}
+
+	case sectionMetadataIssues:
+		content.WriteString(sectionHeading(sectionMetadataIssues))
+		if len(ps.MetadataIssues) == 0 {
+			content.WriteString("No metadata issues found.\n\n")
+		} else {
+			for _, issue := range ps.MetadataIssues {
+				link := ps.formatLink(issue.Path)
+				content.WriteString(fmt.Sprintf("- %s: %s\n", link, strings.Join(issue.Problems, "; ")))
+			}
+			content.WriteString("\n")
+		}
+
+	case sectionNavigationIssues:
+		// Only present for docs sites whose navigation leaves out pages or lists missing ones
+		content.WriteString(sectionHeading(sectionNavigationIssues))
+		for _, issue := range ps.NavIssues {
+			if issue.Entry == "" {
+				content.WriteString(fmt.Sprintf("- %s is not in the navigation\n", ps.formatLink(issue.Path)))
+			} else {
+				content.WriteString(fmt.Sprintf("- `%s` in `%s` has no page\n", issue.Entry, ps.relPath(issue.Path)))
+			}
+		}
+		content.WriteString("\n")
+
+	case sectionVerdictDrift:
+		// Only present when a file got a different label for the same content
+		content.WriteString(sectionHeading(sectionVerdictDrift))
+		content.WriteString("These files were classified again without their content changing and got a different label, a sign the model or prompt is unstable on them.\n\n")
+		for _, path := range ps.Drifted() {
+			drift := ps.Drifts[path]
+			entry := fmt.Sprintf("- %s: %s → %s", ps.formatLink(path), drift.From, drift.To)
+			if drift.RunID != "" {
+				entry += fmt.Sprintf(" (run %s)", drift.RunID)
+			}
+			content.WriteString(entry + "\n")
+		}
+		content.WriteString("\n")
+
+	case sectionTagSuggestions:
+		// Only present when tags were suggested for notes without any
+		content.WriteString(sectionHeading(sectionTagSuggestions))
+		content.WriteString("These notes have no tags. Add the suggested ones to their frontmatter to make them easier to find.\n\n")
+		for _, file := range ps.TagSuggestions() {
+			content.WriteString(fmt.Sprintf("- %s: #%s\n", ps.formatLink(file.Path), strings.Join(file.SuggestedTags, " #")))
+		}
+		content.WriteString("\n")
+
+	case sectionEngineDisagreement:
+		// Only present when the engines of an ensemble gave a file different labels
+		content.WriteString(sectionHeading(sectionEngineDisagreement))
+		content.WriteString("The engines of the ensemble gave these files different labels. Check them to see which engine to trust.\n\n")
+		for _, file := range ps.Disagreements() {
+			content.WriteString(fmt.Sprintf("- %s: %s\n", ps.formatLink(file.Path), formatVotes(file.Votes)))
+		}
+		content.WriteString("\n")
+
+	case sectionTitleMismatch:
+		// Only present when the title check found notes about something else than their title
+		content.WriteString(sectionHeading(sectionTitleMismatch))
+		content.WriteString("The body of these notes does not match their title. Rename them or move the content to the right note.\n\n")
+		for _, file := range ps.TitleMismatches() {
+			content.WriteString(fmt.Sprintf("- %s: %s\n", ps.formatLink(file.Path), file.TitleMismatch))
+		}
+		content.WriteString("\n")
+
+	case sectionMissingSources:
+		// Only present when the source check found reference notes without sources
+		content.WriteString(sectionHeading(sectionMissingSources))
+		content.WriteString("These reference notes make claims without citing any source. Add links, citations or a sources section.\n\n")
+		for _, file := range ps.MissingSources() {
+			content.WriteString(fmt.Sprintf("- %s\n", ps.formatLink(file.Path)))
+		}
+		content.WriteString("\n")
+
+	case sectionSyncConflicts:
+		content.WriteString(sectionHeading(sectionSyncConflicts))
+		if len(ps.SyncConflicts) == 0 {
+			content.WriteString("No sync conflicts found.\n\n")
+		} else {
+			content.WriteString("These copies were left behind when a note changed on two devices. They are not classified; merge each into the original note and delete it.\n\n")
+			for _, path := range ps.SyncConflicts {
+				content.WriteString(fmt.Sprintf("- %s\n", ps.formatLink(path)))
+			}
+			content.WriteString("\n")
+		}
+
+	case sectionSkippedFiles:
+		// The skip list, only present when files failed too many runs in a row
+		content.WriteString(sectionHeading(sectionSkippedFiles))
+		content.WriteString("These files failed to be classified too many runs in a row and are skipped until the list is cleared with `ratemykb skip-list --clear`.\n\n")
+		for _, path := range ps.SkippedFiles() {
+			failure := ps.Failures[path]
+			link := ps.formatLink(path)
+			content.WriteString(fmt.Sprintf("- %s (failed %d runs in a row: %s)\n", link, failure.Runs, failure.LastError))
+		}
+		content.WriteString("\n")
+
+	case sectionAttachments:
+		// The attachments appendix, only present when extraction is enabled
+		content.WriteString(sectionHeading(sectionAttachments))
+		for _, attachment := range ps.Attachments {
+			link := ps.formatFileLink(attachment.Path)
+			linkedFrom := fmt.Sprintf("linked from %d notes", len(attachment.LinkedFrom))
+			if len(attachment.LinkedFrom) == 1 {
+				linkedFrom = "linked from " + ps.formatLink(attachment.LinkedFrom[0])
+			}
+			if attachment.Problem != "" {
+				content.WriteString(fmt.Sprintf("- %s: %s (%s)\n", link, attachment.Problem, linkedFrom))
+				continue
+			}
+			content.WriteString(fmt.Sprintf("- %s: %s (%d words, modified %s, %s)\n", link, attachment.Classification, attachment.Words, ps.formatTime(attachment.Modified), linkedFrom))
+		}
+		content.WriteString("\n")
+
+	case sectionExcludedFiles:
+		// The excluded files appendix, only present when listing is enabled
+		content.WriteString(sectionHeading(sectionExcludedFiles))
+		for _, exclusion := range ps.Exclusions {
+			entry := ps.formatLink(exclusion.Path)
+			if exclusion.IsDir {
+				entry = fmt.Sprintf("`%s/`", ps.relPath(exclusion.Path))
+			}
+			content.WriteString(fmt.Sprintf("- %s (%s)\n", entry, exclusion.Reason))
+		}
+		content.WriteString("\n")
 	}
+	return paginate(content.String(), ps.PageSize)
+}
 
-	// Write content to temporary file
-	_, err = file.WriteString(content.String())
-	if err != nil {
-		file.Close()
-		os.Remove(tempFile)
-		return fmt.Errorf("failed to write to temp report: %w", err)
+// classificationEntries lists files in a classification section, each link followed by its summary, notes about the
+// file and the reason for its classification, and the suggested improvements indented under it
+func (ps *ProcessingState) classificationEntries(files []output.ResultFile) string {
+	var content strings.Builder
+	for _, file := range files {
+		link := ps.formatLink(file.Path)
+		if file.Summary != "" {
+			link += summarySeparator + summaryEscaper.Replace(file.Summary)
+		}
+		link += metadataNotes(file.Metadata)
+		if file.Confidence > 0 {
+			link += fmt.Sprintf(" (confidence: %d%%)", int(math.Round(file.Confidence*100)))
+		}
+		if file.Rating != nil {
+			link += fmt.Sprintf(" (rating: %d)", *file.Rating)
+		}
+		if file.Encoding != "" {
+			link += fmt.Sprintf(" (encoding: %s)", file.Encoding)
+		}
+		if file.Truncated {
+			link += " (truncated)"
+		}
+		if file.Reason != "" {
+			link += reasonSeparator + file.Reason
+		}
+		content.WriteString(fmt.Sprintf("- %s\n", link))
+
+		// Indent the suggested improvements under the file
+		for _, suggestion := range file.Suggestions {
+			content.WriteString(fmt.Sprintf("    - %s\n", strings.Join(strings.Fields(suggestion), " ")))
+		}
 	}
+	return content.String()
+}
 
-	// Close the file
-	if err := file.Close(); err != nil {
-		os.Remove(tempFile)
-		return fmt.Errorf("failed to close temp report file: %w", err)
+// topicGroup is files of a classification section listed under a heading of their own
+type topicGroup struct {
+	title string // Empty for files listed without a heading
+	files []output.ResultFile
+}
+
+// topicGroups splits the files of a classification section by topic
+// Only low quality notes are grouped, and only once topics were found. The notes outside every topic, such as the
+// ones classified since, come last under a heading of their own.
+func (ps *ProcessingState) topicGroups(label string, files []output.ResultFile) []topicGroup {
+	if label != string(classification.LowQuality) || len(ps.Topics) == 0 {
+		return []topicGroup{{files: files}}
 	}
 
-	// Atomically replace the existing report
-	if err := os.Rename(tempFile, ps.ReportPath); err != nil {
-		os.Remove(tempFile)
-		return fmt.Errorf("failed to replace report: %w", err)
+	listed := make(map[string]bool)
+	var groups []topicGroup
+	for _, topic := range ps.Topics {
+		inTopic := make(map[string]bool)
+		for _, path := range topic.Paths {
+			inTopic[path] = true
+		}
+		var topicFiles []output.ResultFile
+		for _, file := range files {
+			if inTopic[file.Path] && !listed[file.Path] {
+				topicFiles = append(topicFiles, file)
+				listed[file.Path] = true
+			}
+		}
+		switch len(topicFiles) {
+		case 0:
+		case 1:
+			groups = append(groups, topicGroup{title: fmt.Sprintf("Topic: %s (1 note)", topic.Name), files: topicFiles})
+		default:
+			groups = append(groups, topicGroup{title: fmt.Sprintf("Topic: %s (%d notes)", topic.Name, len(topicFiles)), files: topicFiles})
+		}
 	}
 
-	return nil
+	var others []output.ResultFile
+	for _, file := range files {
+		if !listed[file.Path] {
+			others = append(others, file)
+		}
+	}
+	if len(groups) == 0 {
+		return []topicGroup{{files: others}}
+	}
+	if len(others) > 0 {
+		groups = append(groups, topicGroup{title: "Other notes", files: others})
+	}
+	return groups
+}
+
+// openTasksTrend describes the change in open tasks since the previous report
+func (ps *ProcessingState) openTasksTrend() string {
+	if ps.PreviousOpenTasks < 0 {
+		return ""
+	}
+
+	diff := analysis.TotalOpenTasks(ps.TaskNotes) - ps.PreviousOpenTasks
+	if diff == 0 {
+		return " (unchanged since last run)"
+	}
+	return fmt.Sprintf(" (%+d since last run)", diff)
+}
+
+// formatLink converts the path of a note to a link in the link style of the vault
+func (ps *ProcessingState) formatLink(filePath string) string {
+	if ps.LinkStyle == LinkStyleMarkdown {
+		return formatMarkdownLink(ps.TargetFolder, filePath)
+	}
+	return formatObsidianLink(ps.TargetFolder, filePath)
+}
+
+// formatFileLink converts the path of a file that is not a note, such as an attachment, to a link in the link style
+// of the vault, which keeps its extension either way
+func (ps *ProcessingState) formatFileLink(filePath string) string {
+	if ps.LinkStyle == LinkStyleMarkdown {
+		return formatMarkdownLink(ps.TargetFolder, filePath)
+	}
+	return fmt.Sprintf("[[%s]]", filepath.ToSlash(ps.relPath(filePath)))
+}
+
+// formatMarkdownLink converts a file path to a markdown link [page](folder/page.md), with the path relative to the
+// folder of the note holding the link and escaped as Obsidian does, and the note name as the text
+func formatMarkdownLink(folder, filePath string) string {
+	relPath, err := filepath.Rel(folder, filePath)
+	if err != nil {
+		relPath = filepath.Base(filePath)
+	}
+
+	name := filepath.Base(relPath)
+	if !scanner.IsConvertible(relPath) && strings.EqualFold(filepath.Ext(name), ".md") {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+
+	segments := strings.Split(filepath.ToSlash(relPath), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return fmt.Sprintf("[%s](%s)", name, strings.Join(segments, "/"))
 }
 
 // formatObsidianLink converts a file path to an Obsidian link format [[link-to-page]]
@@ -148,12 +781,59 @@ func formatObsidianLink(targetFolder, filePath string) string {
 		relPath = filepath.Base(filePath)
 	}
 
-	// Remove file extension
-	baseName := strings.TrimSuffix(relPath, filepath.Ext(relPath))
+	// Remove file extension, except for notes in other formats, which Obsidian only links to with their extension
+	baseName := relPath
+	if !scanner.IsConvertible(relPath) {
+		baseName = strings.TrimSuffix(relPath, filepath.Ext(relPath))
+	}
 
 	// Convert path separators to forward slashes for Obsidian format
 	baseName = strings.ReplaceAll(baseName, string(filepath.Separator), "/")
 
 	// Format as Obsidian link [[link-to-page]]
 	return fmt.Sprintf("[[%s]]", baseName)
-}
\ No newline at end of file
+}
+
+// ObsidianURI returns the obsidian:// URI that opens a note in the given vault
+// The link path is the note path as written in an Obsidian link, relative to the vault and without extension.
+func ObsidianURI(vaultName, linkPath string) string {
+	return fmt.Sprintf("obsidian://open?vault=%s&file=%s", uriEscape(vaultName), uriEscape(linkPath))
+}
+
+// obsidianURI returns the obsidian:// URI of a note, given its link path relative to the target folder
+func (ps *ProcessingState) obsidianURI(linkPath string) string {
+	if ps.VaultFolder != "" {
+		linkPath = ps.VaultFolder + "/" + linkPath
+	}
+	return ObsidianURI(ps.VaultName, linkPath)
+}
+
+// uriEscape escapes a URI query value, encoding spaces as %20 as Obsidian expects
+func uriEscape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+// formatTime formats a date and time for the markdown and HTML reports with the configured layout and timezone
+func (ps *ProcessingState) formatTime(t time.Time) string {
+	layout := ps.TimeFormat
+	if layout == "" {
+		layout = DefaultTimeFormat
+	}
+	return ps.inLocation(t).Format(layout)
+}
+
+// now returns the current time of the clock of the state
+func (ps *ProcessingState) now() time.Time {
+	if ps.Now != nil {
+		return ps.Now()
+	}
+	return time.Now()
+}
+
+// inLocation converts a time to the configured timezone of reports
+func (ps *ProcessingState) inLocation(t time.Time) time.Time {
+	if ps.Location == nil {
+		return t
+	}
+	return t.In(ps.Location)
+}
@@ -0,0 +1,166 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"ratemykb/classification"
+	"ratemykb/config"
+	"ratemykb/output"
+	"ratemykb/safewrite"
+	"ratemykb/scanner"
+)
+
+// maxRollupFiles is the number of files listed as needing attention in a folder rollup
+const maxRollupFiles = 10
+
+// rollupMarker starts every rollup, so a note of the same name written by someone else is never overwritten
+const rollupMarker = "<!-- Written by ratemykb, replaced on every run -->"
+
+// isRollup reports whether a file is a rollup written by ratemykb, or does not exist yet
+// Rollups written before the marker was added are recognized by their heading and summary line.
+func isRollup(path string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return strings.HasPrefix(string(content), rollupMarker+"\n") ||
+		(strings.HasPrefix(string(content), "# Quality of ") && strings.Contains(string(content), ", written by ratemykb.\n")), nil
+}
+
+// WriteFolderRollups writes a small rollup of the processed files into each top-level folder of the target folder,
+// so people browsing an area of the vault see its health without opening the report
+// The rollups are written as part of a run, so they are backed up and undone like any other change to the vault.
+// It returns the paths of the rollups written. Files directly in the target folder belong to no rollup, and folders
+// that no longer exist get none. A note of the same name that ratemykb did not write is left alone and reported in
+// the error once the other rollups are written.
+func (ps *ProcessingState) WriteFolderRollups(changes *safewrite.Run) ([]string, error) {
+	folders := make(map[string][]output.ResultFile)
+	for path, file := range ps.ProcessedFiles {
+		relPath, err := filepath.Rel(ps.TargetFolder, path)
+		if err != nil {
+			continue
+		}
+		parts := strings.SplitN(filepath.ToSlash(relPath), "/", 2)
+		if len(parts) < 2 || parts[0] == ".." {
+			continue
+		}
+		folders[parts[0]] = append(folders[parts[0]], file)
+	}
+
+	var names []string
+	for name := range folders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var written, refused []string
+	for _, name := range names {
+		// Folders removed since their files were processed get no rollup
+		if info, err := os.Stat(filepath.Join(ps.TargetFolder, name)); err != nil || !info.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(ps.TargetFolder, name, config.RollupFile)
+		if owned, err := isRollup(path); err != nil {
+			return written, fmt.Errorf("failed to read rollup of %s: %w", name, err)
+		} else if !owned {
+			refused = append(refused, path)
+			continue
+		}
+		if err := changes.WriteFile(path, []byte(ps.renderRollup(name, folders[name]))); err != nil {
+			return written, fmt.Errorf("failed to write rollup of %s: %w", name, err)
+		}
+		written = append(written, path)
+	}
+	if len(refused) > 0 {
+		return written, fmt.Errorf("left %s alone, since ratemykb did not write it", strings.Join(refused, ", "))
+	}
+	return written, nil
+}
+
+// renderRollup generates the rollup of a top-level folder: the number of files per classification and the worst
+// files, linking back to the report
+// Empty files come first, then files with frontmatter only, then any other file without a passing label.
+func (ps *ProcessingState) renderRollup(folder string, files []output.ResultFile) string {
+	passing := make(map[string]bool)
+	for _, label := range ps.PassingLabels {
+		passing[strings.ToLower(label)] = true
+	}
+
+	counts := make(map[string]int)
+	var attention []output.ResultFile
+	for _, file := range files {
+		label := string(file.Classification)
+		counts[label]++
+		if !passing[strings.ToLower(label)] && file.Classification != classification.TimedOut {
+			attention = append(attention, file)
+		}
+	}
+
+	rank := func(file output.ResultFile) int {
+		switch file.Status {
+		case scanner.StatusEmpty:
+			return 0
+		case scanner.StatusFrontmatterOnly:
+			return 1
+		default:
+			return 2
+		}
+	}
+	sort.Slice(attention, func(i, j int) bool {
+		if rank(attention[i]) != rank(attention[j]) {
+			return rank(attention[i]) < rank(attention[j])
+		}
+		return attention[i].Path < attention[j].Path
+	})
+
+	// Markdown links are relative to the rollup, which lives in the folder rather than next to the report
+	link := ps.formatLink
+	if ps.LinkStyle == LinkStyleMarkdown {
+		link = func(path string) string { return formatMarkdownLink(filepath.Join(ps.TargetFolder, folder), path) }
+	}
+	reportLink := link(ps.ReportPath)
+
+	var content strings.Builder
+	content.WriteString(rollupMarker + "\n")
+	content.WriteString(fmt.Sprintf("# Quality of %s\n\n", folder))
+	content.WriteString(fmt.Sprintf("Summary of `%s` from the %s, written by ratemykb.\n\n", folder, reportLink))
+
+	content.WriteString("## Statistics\n\n")
+	content.WriteString(fmt.Sprintf("- Total files processed: %d\n", len(files)))
+	var labels []string
+	for label := range counts {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		content.WriteString(fmt.Sprintf("- %s files: %d\n", label, counts[label]))
+	}
+	content.WriteString("\n")
+
+	content.WriteString("## Files Needing Attention\n\n")
+	if len(attention) == 0 {
+		content.WriteString("No files needing attention found.\n")
+		return content.String()
+	}
+	for _, file := range attention[:min(len(attention), maxRollupFiles)] {
+		entry := fmt.Sprintf("%s (%s)", link(file.Path), file.Classification)
+		if file.Reason != "" {
+			entry += reasonSeparator + file.Reason
+		}
+		content.WriteString(fmt.Sprintf("- %s\n", entry))
+	}
+	if len(attention) > maxRollupFiles {
+		content.WriteString(fmt.Sprintf("\n%d more in the %s.\n", len(attention)-maxRollupFiles, reportLink))
+	}
+	return content.String()
+}
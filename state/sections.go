@@ -0,0 +1,191 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sectionID identifies a section of the markdown report independently of the heading it is written with, so
+// headings can be renamed without breaking the loading of earlier reports
+type sectionID int
+
+// Sections of the markdown report
+const (
+	sectionUnknown sectionID = iota
+	sectionStatistics
+	sectionEmpty
+	sectionFrontmatterOnly
+	sectionClassification // One per label, see classificationTitle
+	sectionNeedsReview
+	sectionQualityScores
+	sectionRatings
+	sectionAmbiguousTitles
+	sectionConsiderSplitting
+	sectionSizeOutliers
+	sectionOpenTasks
+	sectionMetadataIssues
+	sectionSyncConflicts
+	sectionSkippedFiles
+	sectionAttachments
+	sectionExcludedFiles
+	sectionPossibleDuplicates
+	sectionNavigationIssues
+	sectionTagSuggestions
+	sectionVerdictDrift
+	sectionTitleMismatch
+	sectionEngineDisagreement
+	sectionMissingSources
+)
+
+// reportSection describes a section of the markdown report with a fixed heading
+type reportSection struct {
+	title   string   // Heading the section is written with
+	aliases []string // Earlier headings of the section, still recognized when loading a report
+	derived bool     // Describes the vault as a whole rather than processed files, so it is not loaded back
+}
+
+// reportSections lists the sections with a fixed heading
+var reportSections = map[sectionID]reportSection{
+	sectionStatistics:         {title: "Statistics"},
+	sectionEmpty:              {title: "Empty Files"},
+	sectionFrontmatterOnly:    {title: "Files with Frontmatter Only"},
+	sectionNeedsReview:        {title: "Needs Human Review", derived: true},
+	sectionQualityScores:      {title: "Quality Scores", derived: true},
+	sectionRatings:            {title: "Files by Rating", derived: true},
+	sectionAmbiguousTitles:    {title: "Ambiguous Titles", derived: true},
+	sectionPossibleDuplicates: {title: "Possible Duplicates", derived: true},
+	sectionConsiderSplitting:  {title: "Consider Splitting", derived: true},
+	sectionSizeOutliers:       {title: "Size Outliers", derived: true},
+	sectionOpenTasks:          {title: "Open Tasks", derived: true},
+	sectionMetadataIssues:     {title: "Metadata Issues", derived: true},
+	sectionNavigationIssues:   {title: "Navigation Issues", derived: true},
+	sectionTagSuggestions:     {title: "Tag Suggestions", derived: true},
+	sectionVerdictDrift:       {title: "Verdict Drift", derived: true},
+	sectionTitleMismatch:      {title: "Title/Content Mismatch", derived: true},
+	sectionMissingSources:     {title: "Missing Sources", derived: true},
+	sectionEngineDisagreement: {title: "Engine Disagreement", derived: true},
+	sectionSyncConflicts:      {title: "Sync Conflicts", derived: true},
+	sectionSkippedFiles:       {title: "Skipped Files", derived: true},
+	sectionAttachments:        {title: "Attachments", derived: true},
+	sectionExcludedFiles:      {title: "Excluded Files", derived: true},
+}
+
+// classificationSuffix follows the label in the heading of a classification section
+const classificationSuffix = " Files"
+
+// sectionTitle returns the heading of a section with a fixed heading
+func sectionTitle(id sectionID) string {
+	return reportSections[id].title
+}
+
+// sectionHeading returns the markdown line starting a section with a fixed heading
+func sectionHeading(id sectionID) string {
+	return "## " + sectionTitle(id) + "\n\n"
+}
+
+// classificationTitle returns the heading of the section listing the files with a label, such as "Low quality Files"
+// Labels that already end in " files" are used as they are rather than pluralized twice.
+func classificationTitle(label string) string {
+	if strings.HasSuffix(label, " files") {
+		return label
+	}
+	return label + classificationSuffix
+}
+
+// parseSection returns the section a heading starts, with the label for a classification section
+// Fixed headings and their aliases take precedence over labels, and headings written by no version of the report
+// writer are sectionUnknown.
+func parseSection(heading string) (sectionID, string) {
+	for id, section := range reportSections {
+		if heading == section.title {
+			return id, ""
+		}
+		for _, alias := range section.aliases {
+			if heading == alias {
+				return id, ""
+			}
+		}
+	}
+
+	// Reverses classificationTitle, keeping labels that end in " Files" themselves whole
+	if label, ok := strings.CutSuffix(heading, classificationSuffix); ok && label != "" {
+		return sectionClassification, label
+	}
+	if strings.HasSuffix(heading, " files") {
+		return sectionClassification, heading
+	}
+	return sectionUnknown, ""
+}
+
+// isDerived reports whether a section describes the vault as a whole, so its entries are recomputed on every scan
+// rather than loaded back
+func (id sectionID) isDerived() bool {
+	return reportSections[id].derived
+}
+
+// Markup of the collapsible blocks a long section is split into
+const (
+	pageStart    = "<details>"
+	pageEnd      = "</details>"
+	summaryStart = "<summary>"
+)
+
+// paginate splits each run of entries with more than pageSize of them into collapsible blocks of pageSize
+// entries, so very large reports stay usable in Obsidian and on GitHub
+// Entries are the lines starting with "- " and the indented lines following them. Runs are split on their own, so
+// each topic of a section grouped under "### " headings is paged separately, and lines outside a run, such as
+// headings and tables, are left as they are. Lines before the first section are never split.
+func paginate(markdown string, pageSize int) string {
+	if pageSize <= 0 {
+		return markdown
+	}
+
+	lines := strings.Split(markdown, "\n")
+	var result []string
+	inSection := false
+	for i := 0; i < len(lines); {
+		inSection = inSection || strings.HasPrefix(lines[i], "## ")
+		if !inSection || !strings.HasPrefix(lines[i], "- ") {
+			result = append(result, lines[i])
+			i++
+			continue
+		}
+
+		// Collect the run of entries starting here
+		var entries [][]string
+		for ; i < len(lines); i++ {
+			if strings.HasPrefix(lines[i], "- ") {
+				entries = append(entries, []string{lines[i]})
+			} else if strings.HasPrefix(lines[i], "    ") {
+				entries[len(entries)-1] = append(entries[len(entries)-1], lines[i])
+			} else {
+				break
+			}
+		}
+
+		if len(entries) <= pageSize {
+			for _, entry := range entries {
+				result = append(result, entry...)
+			}
+			continue
+		}
+
+		for start := 0; start < len(entries); start += pageSize {
+			stop := min(start+pageSize, len(entries))
+			if start > 0 {
+				result = append(result, "")
+			}
+			result = append(result, pageStart, fmt.Sprintf("%sEntries %d to %d of %d</summary>", summaryStart, start+1, stop, len(entries)), "")
+			for _, entry := range entries[start:stop] {
+				result = append(result, entry...)
+			}
+			result = append(result, "", pageEnd)
+		}
+	}
+	return strings.Join(result, "\n")
+}
+
+// isPageMarkup reports whether a line of the report is markup of a collapsible block written by paginate
+func isPageMarkup(line string) bool {
+	return line == pageStart || line == pageEnd || strings.HasPrefix(line, summaryStart)
+}
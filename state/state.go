@@ -1,39 +1,196 @@
 package state
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"ratemykb/analysis"
+	"ratemykb/attachments"
+	"ratemykb/classification"
+	"ratemykb/config"
 	"ratemykb/output"
+	"ratemykb/scanner"
 )
 
 // ProcessingState manages the state of file processing
 type ProcessingState struct {
-	TargetFolder   string
-	ReportPath     string
-	ProcessedFiles map[string]output.ResultFile
+	TargetFolder    string
+	ReportPath      string
+	StatePath       string
+	ProcessedFiles  map[string]output.ResultFile
+	AmbiguousTitles []analysis.AmbiguousTitle  // Recomputed from the scan on every run
+	Duplicates      []analysis.DuplicateGroup  // Recomputed from the scan on every run, empty unless detection is enabled
+	Topics          []analysis.TopicCluster    // Recomputed on every run, empty unless clustering is enabled
+	LongNotes       []analysis.LongNote        // Recomputed from the scan on every run
+	SizeOutliers    []analysis.SizeOutlier     // Recomputed from the scan on every run
+	TaskNotes       []analysis.TaskNote        // Recomputed from the scan on every run
+	MetadataIssues  []analysis.MetadataIssue   // Recomputed from the scan on every run
+	NavIssues       []analysis.NavigationIssue // Recomputed from the scan on every run, empty without a docs site navigation
+	Exclusions      []scanner.Exclusion        // Recomputed from the scan on every run, empty unless listing is enabled
+	Attachments     []attachments.Attachment   // Recomputed from the scan on every run, empty unless extraction is enabled
+	SyncConflicts   []string                   // Recomputed from the scan on every run
+
+	// Failures records the files that could not be classified in the last runs, including the skipped ones
+	Failures map[string]Failure
+
+	// Drifts records the files whose label changed when they were classified again with the same content, by path
+	Drifts map[string]Drift
+
+	// Previous are the results of the state replaced by a rebuild, used to spot verdict drift
+	Previous map[string]output.ResultFile
+
+	// Partition is the share of the files this state holds, the zero Partition for the whole vault
+	Partition scanner.Partition
+
+	// RunID identifies the run that last updated the report, empty if it is not known
+	RunID string
+
+	// Timestamp is where the generation time and run ID are written in reports: TimestampHeader (the default),
+	// TimestampComment or TimestampNone
+	Timestamp string
+
+	// TimeFormat is the Go layout of the dates and times in the markdown and HTML reports, DefaultTimeFormat if empty
+	TimeFormat string
+
+	// Location is the timezone of the dates and times in reports, local time if nil
+	Location *time.Location
+
+	// Now returns the generation time of reports, time.Now if nil, so reports can be generated for a given time
+	Now func() time.Time
+
+	// PassingLabels are the classifications that are not findings in rdjson reports
+	PassingLabels []string
+
+	// ReviewThreshold is the confidence below which classified files are listed as needing human review, 0 to
+	// list none
+	ReviewThreshold float64
+
+	// ScoreDimensions are the names of the dimensions files are scored on, in the order of the columns of the
+	// Quality Scores table, empty to leave the table out
+	ScoreDimensions []string
+
+	// PageSize is the number of entries per collapsible block when a section of the markdown report has more,
+	// 0 to never split sections
+	PageSize int
+
+	// Ratings lists the files by their rating from 0 to 100 and adds rating statistics to reports
+	Ratings bool
+
+	// LinkStyle is how notes are linked in the markdown report and rollups: LinkStyleWiki (the default) or
+	// LinkStyleMarkdown, to match the links of the vault
+	LinkStyle string
+
+	// VaultName is the Obsidian vault used for obsidian:// URIs in HTML and JSON reports, empty to omit them
+	VaultName string
+	// VaultFolder is the path of the target folder inside the vault, empty if it is the vault root
+	VaultFolder string
+
+	// PreviousOpenTasks is the open task total from the previous run, or -1 if unknown
+	PreviousOpenTasks int
+
+	// ReportWarnings lists the lines of the existing report that could not be understood when the state was loaded
+	// from it, empty when it was loaded from the state file
+	ReportWarnings []string
+
+	tasksCounted bool      // Whether the open tasks were counted during this run
+	stateDirty   bool      // Whether files were added since the state file was last written, see Flush
+	stateSaved   time.Time // When the state file was last written
+}
+
+// stateSaveInterval is the shortest time between two writes of the state file while files are added, so a run
+// over a large vault does not rewrite the whole file for every note
+// A run that is killed before a flush classifies the files added since the last write again.
+const stateSaveInterval = 5 * time.Second
+
+// Drift records a file classified again without its content changing that got a different label
+type Drift struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	RunID string `json:"run_id,omitempty"` // Run in which the label changed
 }
 
+// Failure records the consecutive runs in which a file could not be classified
+type Failure struct {
+	Runs      int    `json:"runs"`
+	LastError string `json:"last_error"`
+	Skipped   bool   `json:"skipped,omitempty"` // Failed too many runs in a row, so it is skipped until the skip list is cleared
+}
+
+// Placements of the generation time and run ID in reports
+// Committing the report to git gives cleaner diffs with a comment, which is easy to ignore, or none at all.
+const (
+	TimestampHeader  = "header"
+	TimestampComment = "comment"
+	TimestampNone    = "none"
+)
+
+// Styles of the links to notes in the markdown report, as set by the "Use [[Wikilinks]]" option of Obsidian
+const (
+	LinkStyleWiki     = "wikilink" // [[folder/note]]
+	LinkStyleMarkdown = "markdown" // [note](folder/note.md)
+)
+
+// DefaultTimeFormat is the layout of the dates and times in reports when none is configured
+const DefaultTimeFormat = "2006-01-02 15:04:05"
+
+// ErrNoState indicates that no file of the target folder has been processed yet
+var ErrNoState = errors.New("no stored state found")
+
 // New creates a new ProcessingState and loads existing state if a report exists
 func New(targetFolder string) (*ProcessingState, error) {
-	ps := &ProcessingState{
-		TargetFolder:   targetFolder,
-		ReportPath:     filepath.Join(targetFolder, "vault-quality-report.md"),
-		ProcessedFiles: make(map[string]output.ResultFile),
-	}
+	return load(Rebuild(targetFolder))
+}
 
-	// Load existing state from report if it exists
-	if _, err := os.Stat(ps.ReportPath); err == nil {
+// load loads the existing state into an empty ProcessingState
+func load(ps *ProcessingState) (*ProcessingState, error) {
+	// Load existing state from the state file, falling back to the report written by older versions
+	if _, err := os.Stat(ps.StatePath); err == nil {
+		if err := ps.loadStateFile(); err != nil {
+			return nil, fmt.Errorf("failed to load existing state: %w", err)
+		}
+	} else if _, err := os.Stat(ps.ReportPath); err == nil {
 		if err := ps.loadExistingReport(); err != nil {
 			return nil, fmt.Errorf("failed to load existing report: %w", err)
 		}
-		fmt.Printf("Found existing report with %d processed files\n", len(ps.ProcessedFiles))
 	}
 
 	return ps, nil
 }
 
+// Rebuild creates an empty ProcessingState without loading the existing state or report, so every file is scanned
+// and classified again
+// The stored state and the report are replaced on the first update.
+func Rebuild(targetFolder string) *ProcessingState {
+	return &ProcessingState{
+		TargetFolder:      targetFolder,
+		ReportPath:        filepath.Join(targetFolder, "vault-quality-report.md"),
+		StatePath:         filepath.Join(targetFolder, config.StateDir, "state.json"),
+		ProcessedFiles:    make(map[string]output.ResultFile),
+		Failures:          make(map[string]Failure),
+		Drifts:            make(map[string]Drift),
+		PreviousOpenTasks: -1,
+	}
+}
+
+// Load loads the stored state of a target folder that has been scanned before
+// It returns ErrNoState if no file has been processed yet.
+func Load(targetFolder string) (*ProcessingState, error) {
+	ps, err := New(targetFolder)
+	if err != nil {
+		return nil, err
+	}
+	if len(ps.ProcessedFiles) == 0 {
+		return nil, fmt.Errorf("%w in %s, run a scan first", ErrNoState, targetFolder)
+	}
+	return ps, nil
+}
+
 // IsFileProcessed checks if a file has already been processed
 func (ps *ProcessingState) IsFileProcessed(filePath string) bool {
 	_, exists := ps.ProcessedFiles[filePath]
@@ -41,15 +198,447 @@ func (ps *ProcessingState) IsFileProcessed(filePath string) bool {
 }
 
 // AddProcessedFile adds a processed file to the state and updates the report
+// Only the report sections listing the file, before and after, and the sections summarizing all files are
+// rendered again, so recording a single verdict stays cheap on large vaults.
 func (ps *ProcessingState) AddProcessedFile(file output.ResultFile) error {
+	changed := []output.ResultFile{file}
+	previous, ok := ps.ProcessedFiles[file.Path]
+	if ok {
+		changed = append(changed, previous)
+	} else {
+		previous, ok = ps.Previous[file.Path]
+	}
+	if ok {
+		ps.trackDrift(previous, file)
+	}
+
 	// Add to processed files map
 	ps.ProcessedFiles[file.Path] = file
 
-	// Update the report
+	// A file that could be classified starts over, timed out files count as failures
+	if file.Classification != classification.TimedOut {
+		delete(ps.Failures, file.Path)
+	}
+
+	ps.stateDirty = true
+	if time.Since(ps.stateSaved) >= stateSaveInterval {
+		if err := ps.saveStateFile(); err != nil {
+			return err
+		}
+	}
+	return ps.updateSections(affectedSections(changed...))
+}
+
+// Flush writes the state file if files were added since it was last written
+// AddProcessedFile writes the state file at most every few seconds, so callers flush once they are done adding files.
+func (ps *ProcessingState) Flush() error {
+	if !ps.stateDirty {
+		return nil
+	}
+	return ps.saveStateFile()
+}
+
+// trackDrift records a file whose label changed although the content the model saw did not, so model or prompt
+// instability shows up rather than silently replacing the earlier verdict
+// A file whose content changed is no longer drifting. Files that timed out and results from before content hashes
+// were kept are left alone.
+func (ps *ProcessingState) trackDrift(previous, file output.ResultFile) {
+	if file.ContentHash == "" || previous.ContentHash == "" || file.Classification == classification.TimedOut || previous.Classification == classification.TimedOut {
+		return
+	}
+	if file.ContentHash != previous.ContentHash {
+		delete(ps.Drifts, file.Path)
+		return
+	}
+	if !strings.EqualFold(string(file.Classification), string(previous.Classification)) {
+		ps.Drifts[file.Path] = Drift{From: string(previous.Classification), To: string(file.Classification), RunID: ps.RunID}
+	}
+}
+
+// Drifted returns the paths of the files whose verdict drifted, sorted
+func (ps *ProcessingState) Drifted() []string {
+	paths := make([]string, 0, len(ps.Drifts))
+	for path := range ps.Drifts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// RecordFailure records a run in which a file could not be classified and updates the report
+// A file that fails skipAfter runs in a row is added to the skip list (0 never skips). It reports whether the
+// file was added to the skip list.
+func (ps *ProcessingState) RecordFailure(filePath, reason string, skipAfter int) (bool, error) {
+	failure := ps.Failures[filePath]
+	failure.Runs++
+	failure.LastError = reason
+	added := !failure.Skipped && skipAfter > 0 && failure.Runs >= skipAfter
+	failure.Skipped = failure.Skipped || added
+	ps.Failures[filePath] = failure
+	return added, ps.persist()
+}
+
+// IsSkipped reports whether a file is on the skip list
+func (ps *ProcessingState) IsSkipped(filePath string) bool {
+	return ps.Failures[filePath].Skipped
+}
+
+// SkippedFiles returns the paths of the files on the skip list, sorted
+func (ps *ProcessingState) SkippedFiles() []string {
+	var paths []string
+	for path, failure := range ps.Failures {
+		if failure.Skipped {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// NeedsReview returns the files classified with a confidence below the review threshold, least confident first
+func (ps *ProcessingState) NeedsReview() []output.ResultFile {
+	var review []output.ResultFile
+	for _, file := range ps.ProcessedFiles {
+		if file.Confidence > 0 && file.Confidence < ps.ReviewThreshold {
+			review = append(review, file)
+		}
+	}
+	sort.Slice(review, func(i, j int) bool {
+		if review[i].Confidence != review[j].Confidence {
+			return review[i].Confidence < review[j].Confidence
+		}
+		return review[i].Path < review[j].Path
+	})
+	return review
+}
+
+// ScoredFiles returns the files with an overall score, lowest scoring first
+func (ps *ProcessingState) ScoredFiles() []output.ResultFile {
+	var scored []output.ResultFile
+	for _, file := range ps.ProcessedFiles {
+		if file.Score > 0 {
+			scored = append(scored, file)
+		}
+	}
+	sort.Slice(scored, func(i, j int) bool {
+		if scored[i].Score != scored[j].Score {
+			return scored[i].Score < scored[j].Score
+		}
+		return scored[i].Path < scored[j].Path
+	})
+	return scored
+}
+
+// AverageScore returns the average overall score of the scored files, 0 if no file was scored
+func (ps *ProcessingState) AverageScore() float64 {
+	scored := ps.ScoredFiles()
+	if len(scored) == 0 {
+		return 0
+	}
+	var total float64
+	for _, file := range scored {
+		total += file.Score
+	}
+	return total / float64(len(scored))
+}
+
+// RatedFiles returns the files with a rating, lowest rated first
+func (ps *ProcessingState) RatedFiles() []output.ResultFile {
+	var rated []output.ResultFile
+	for _, file := range ps.ProcessedFiles {
+		if file.Rating != nil {
+			rated = append(rated, file)
+		}
+	}
+	sort.Slice(rated, func(i, j int) bool {
+		if *rated[i].Rating != *rated[j].Rating {
+			return *rated[i].Rating < *rated[j].Rating
+		}
+		return rated[i].Path < rated[j].Path
+	})
+	return rated
+}
+
+// TagSuggestions returns the processed files with suggested tags, sorted by path
+func (ps *ProcessingState) TagSuggestions() []output.ResultFile {
+	var files []output.ResultFile
+	for _, file := range ps.ProcessedFiles {
+		if len(file.SuggestedTags) > 0 {
+			files = append(files, file)
+		}
+	}
+	sortByPath(files)
+	return files
+}
+
+// Disagreements returns the processed files the engines of the ensemble gave different labels, sorted by path
+func (ps *ProcessingState) Disagreements() []output.ResultFile {
+	var files []output.ResultFile
+	for _, file := range ps.ProcessedFiles {
+		if classification.Disagree(file.Votes) {
+			files = append(files, file)
+		}
+	}
+	sortByPath(files)
+	return files
+}
+
+// TitleMismatches returns the processed files whose body does not match their title, sorted by path
+func (ps *ProcessingState) TitleMismatches() []output.ResultFile {
+	var files []output.ResultFile
+	for _, file := range ps.ProcessedFiles {
+		if file.TitleMismatch != "" {
+			files = append(files, file)
+		}
+	}
+	sortByPath(files)
+	return files
+}
+
+// MissingSources returns the processed reference notes that cite no source, sorted by path
+func (ps *ProcessingState) MissingSources() []output.ResultFile {
+	var files []output.ResultFile
+	for _, file := range ps.ProcessedFiles {
+		if file.MissingSources {
+			files = append(files, file)
+		}
+	}
+	sortByPath(files)
+	return files
+}
+
+// RatingSummary describes the distribution of the ratings of the vault
+type RatingSummary struct {
+	Files  int     `json:"files"`
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	P10    int     `json:"p10"` // 10th percentile, the rating the worst tenth of the files are at or below
+	P90    int     `json:"p90"` // 90th percentile
+}
+
+// RatingSummary summarizes the ratings of the rated files, nil if no file was rated
+func (ps *ProcessingState) RatingSummary() *RatingSummary {
+	rated := ps.RatedFiles()
+	if len(rated) == 0 {
+		return nil
+	}
+
+	ratings := make([]int, len(rated))
+	total := 0
+	for i, file := range rated {
+		ratings[i] = *file.Rating
+		total += ratings[i]
+	}
+	sort.Ints(ratings)
+
+	// Nearest-rank percentiles, so each is the rating of an actual file
+	percentile := func(p float64) int {
+		rank := int(math.Ceil(float64(len(ratings)) * p / 100))
+		return ratings[max(rank, 1)-1]
+	}
+
+	summary := &RatingSummary{
+		Files: len(ratings),
+		Mean:  float64(total) / float64(len(ratings)),
+		P10:   percentile(10),
+		P90:   percentile(90),
+	}
+	middle := len(ratings) / 2
+	if len(ratings)%2 == 0 {
+		summary.Median = float64(ratings[middle-1]+ratings[middle]) / 2
+	} else {
+		summary.Median = float64(ratings[middle])
+	}
+	return summary
+}
+
+// ClearSkipList forgets the failures of the skipped files, so they are classified again on the next run, and
+// updates the report
+// Files that failed fewer runs than the limit keep counting towards it. It returns the number of files removed
+// from the skip list.
+func (ps *ProcessingState) ClearSkipList() (int, error) {
+	skipped := ps.SkippedFiles()
+	for _, path := range skipped {
+		delete(ps.Failures, path)
+	}
+	cleared := len(skipped)
+	return cleared, ps.persist()
+}
+
+// Relabel replaces the classifications of processed files and attachments after the labels changed, and updates
+// the report
+// Old labels are matched case-insensitively. Files mapped to an empty label are forgotten, so the next run
+// classifies them again. It returns the number of files changed for each old label, as written in the mapping.
+func (ps *ProcessingState) Relabel(mapping map[string]string) (map[string]int, error) {
+	counts := ps.relabel(mapping, true)
+	return counts, ps.persist()
+}
+
+// RelabelCounts returns the number of files Relabel would change for each old label, without changing any
+func (ps *ProcessingState) RelabelCounts(mapping map[string]string) map[string]int {
+	return ps.relabel(mapping, false)
+}
+
+// relabel counts the files with an old label of the mapping, replacing their classification if apply is set
+func (ps *ProcessingState) relabel(mapping map[string]string, apply bool) map[string]int {
+	oldLabels := make(map[string]string)
+	for old := range mapping {
+		oldLabels[strings.ToLower(strings.TrimSpace(old))] = old
+	}
+	lookup := func(label classification.Classification) (string, bool) {
+		old, ok := oldLabels[strings.ToLower(strings.TrimSpace(string(label)))]
+		return old, ok
+	}
+
+	counts := make(map[string]int)
+	for path, file := range ps.ProcessedFiles {
+		old, ok := lookup(file.Classification)
+		if !ok {
+			continue
+		}
+		counts[old]++
+		if !apply {
+			continue
+		}
+		if mapping[old] == "" {
+			delete(ps.ProcessedFiles, path)
+			continue
+		}
+		file.Classification = classification.Classification(mapping[old])
+		ps.ProcessedFiles[path] = file
+	}
+
+	for i, attachment := range ps.Attachments {
+		old, ok := lookup(attachment.Classification)
+		if !ok {
+			continue
+		}
+		counts[old]++
+		if apply {
+			// An attachment without a classification is classified again on the next run
+			ps.Attachments[i].Classification = classification.Classification(mapping[old])
+		}
+	}
+
+	if apply {
+		// Drifts follow the new labels, and are dropped once both sides map to the same label
+		rename := func(label string) string {
+			if old, ok := lookup(classification.Classification(label)); ok {
+				return mapping[old]
+			}
+			return label
+		}
+		for path, drift := range ps.Drifts {
+			drift.From, drift.To = rename(drift.From), rename(drift.To)
+			if _, ok := ps.ProcessedFiles[path]; !ok || strings.EqualFold(drift.From, drift.To) {
+				delete(ps.Drifts, path)
+				continue
+			}
+			ps.Drifts[path] = drift
+		}
+	}
+
+	return counts
+}
+
+// RemoveProcessedFile removes a file that no longer exists from the state and updates the report
+// Like AddProcessedFile, only the sections the file affects are rendered again.
+func (ps *ProcessingState) RemoveProcessedFile(filePath string) error {
+	previous, ok := ps.ProcessedFiles[filePath]
+	if !ok {
+		return ps.persist()
+	}
+	delete(ps.ProcessedFiles, filePath)
+	delete(ps.Drifts, filePath)
+	if err := ps.saveStateFile(); err != nil {
+		return err
+	}
+	return ps.updateSections(affectedSections(previous))
+}
+
+// persist saves the state file and regenerates the report
+func (ps *ProcessingState) persist() error {
+	if err := ps.saveStateFile(); err != nil {
+		return err
+	}
 	return ps.updateReport()
 }
 
 // GetProcessedFiles returns the map of processed files
 func (ps *ProcessingState) GetProcessedFiles() map[string]output.ResultFile {
 	return ps.ProcessedFiles
-}
\ No newline at end of file
+}
+
+// SetAmbiguousTitles records the titles shared by several files and updates the report
+func (ps *ProcessingState) SetAmbiguousTitles(titles []analysis.AmbiguousTitle) error {
+	ps.AmbiguousTitles = titles
+	return ps.persist()
+}
+
+// SetDuplicates records the groups of notes with near-duplicate content and updates the report
+func (ps *ProcessingState) SetDuplicates(groups []analysis.DuplicateGroup) error {
+	ps.Duplicates = groups
+	return ps.persist()
+}
+
+// SetTopics records the topics the low quality notes are grouped by and updates the report
+func (ps *ProcessingState) SetTopics(topics []analysis.TopicCluster) error {
+	ps.Topics = topics
+	return ps.persist()
+}
+
+// SetNavigationIssues records the pages left out of the navigation of a docs site and the entries without a page,
+// and updates the report
+func (ps *ProcessingState) SetNavigationIssues(issues []analysis.NavigationIssue) error {
+	ps.NavIssues = issues
+	return ps.persist()
+}
+
+// SetSizeOutliers records the notes of unusual length for the vault and updates the report
+func (ps *ProcessingState) SetSizeOutliers(outliers []analysis.SizeOutlier) error {
+	ps.SizeOutliers = outliers
+	return ps.persist()
+}
+
+// SetLongNotes records the notes that should be considered for splitting and updates the report
+func (ps *ProcessingState) SetLongNotes(notes []analysis.LongNote) error {
+	ps.LongNotes = notes
+	return ps.persist()
+}
+
+// SetTaskNotes records the notes containing open tasks and updates the report
+func (ps *ProcessingState) SetTaskNotes(notes []analysis.TaskNote) error {
+	ps.TaskNotes = notes
+	ps.tasksCounted = true
+	return ps.persist()
+}
+
+// SetExclusions records the files and directories skipped by the scan and updates the report
+func (ps *ProcessingState) SetExclusions(exclusions []scanner.Exclusion) error {
+	ps.Exclusions = exclusions
+	return ps.persist()
+}
+
+// SetAttachments records the attachments linked from notes with the classification of their text and updates the report
+func (ps *ProcessingState) SetAttachments(found []attachments.Attachment) error {
+	ps.Attachments = found
+	return ps.persist()
+}
+
+// SetSyncConflicts records the notes left behind by sync conflicts, forgets any result stored for them by an earlier
+// run, and updates the report
+func (ps *ProcessingState) SetSyncConflicts(paths []string) error {
+	ps.SyncConflicts = paths
+	for _, path := range paths {
+		delete(ps.ProcessedFiles, path)
+		delete(ps.Failures, path)
+	}
+
+	return ps.persist()
+}
+
+// SetMetadataIssues records the notes with inconsistent frontmatter dates and updates the report
+func (ps *ProcessingState) SetMetadataIssues(issues []analysis.MetadataIssue) error {
+	ps.MetadataIssues = issues
+	return ps.persist()
+}
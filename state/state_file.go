@@ -0,0 +1,263 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"ratemykb/analysis"
+	"ratemykb/attachments"
+	"ratemykb/output"
+	"ratemykb/safewrite"
+	"ratemykb/scanner"
+)
+
+// stateFileVersion is incremented whenever the state file format changes incompatibly
+const stateFileVersion = 1
+
+// stateFile is the on-disk representation of the processing state
+// Unlike the report, it keeps every field of the processed files so nothing is lost between runs
+// All paths are stored relative to the target folder so the vault can be moved
+type stateFile struct {
+	Version   int                 `json:"version"`
+	Files     []output.ResultFile `json:"files"`
+	OpenTasks *int                `json:"open_tasks,omitempty"` // Open task total of the last run, used for the trend
+
+	// Results of the vault-wide analyses of the last run
+	AmbiguousTitles []analysis.AmbiguousTitle  `json:"ambiguous_titles,omitempty"`
+	Duplicates      []analysis.DuplicateGroup  `json:"duplicates,omitempty"`
+	Topics          []analysis.TopicCluster    `json:"topics,omitempty"`
+	LongNotes       []analysis.LongNote        `json:"long_notes,omitempty"`
+	SizeOutliers    []analysis.SizeOutlier     `json:"size_outliers,omitempty"`
+	TaskNotes       []analysis.TaskNote        `json:"task_notes,omitempty"`
+	MetadataIssues  []analysis.MetadataIssue   `json:"metadata_issues,omitempty"`
+	NavIssues       []analysis.NavigationIssue `json:"navigation_issues,omitempty"`
+	Exclusions      []scanner.Exclusion        `json:"exclusions,omitempty"`
+	Attachments     []attachments.Attachment   `json:"attachments,omitempty"`
+	SyncConflicts   []string                   `json:"sync_conflicts,omitempty"`
+
+	// Files that could not be classified in the last runs, by path
+	Failures map[string]Failure `json:"failures,omitempty"`
+
+	// Files whose label changed without their content changing, by path
+	Drifts map[string]Drift `json:"verdict_drift,omitempty"`
+}
+
+// loadStateFile reads the state file and populates the processed files map
+func (ps *ProcessingState) loadStateFile() error {
+	data, err := os.ReadFile(ps.StatePath)
+	if err != nil {
+		return fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var sf stateFile
+	if err := json.Unmarshal(data, &sf); err != nil {
+		return fmt.Errorf("failed to parse state file: %w", err)
+	}
+
+	if sf.Version > stateFileVersion {
+		return fmt.Errorf("state file version %d is newer than supported version %d", sf.Version, stateFileVersion)
+	}
+
+	for _, file := range sf.Files {
+		file.Path = ps.absPath(file.Path)
+		ps.ProcessedFiles[file.Path] = file
+	}
+
+	for _, title := range sf.AmbiguousTitles {
+		for i := range title.Paths {
+			title.Paths[i] = ps.absPath(title.Paths[i])
+		}
+		ps.AmbiguousTitles = append(ps.AmbiguousTitles, title)
+	}
+	for _, group := range sf.Duplicates {
+		for i := range group.Paths {
+			group.Paths[i] = ps.absPath(group.Paths[i])
+		}
+		ps.Duplicates = append(ps.Duplicates, group)
+	}
+	for _, topic := range sf.Topics {
+		for i := range topic.Paths {
+			topic.Paths[i] = ps.absPath(topic.Paths[i])
+		}
+		ps.Topics = append(ps.Topics, topic)
+	}
+	for _, note := range sf.LongNotes {
+		note.Path = ps.absPath(note.Path)
+		ps.LongNotes = append(ps.LongNotes, note)
+	}
+	for _, outlier := range sf.SizeOutliers {
+		outlier.Path = ps.absPath(outlier.Path)
+		ps.SizeOutliers = append(ps.SizeOutliers, outlier)
+	}
+	for _, note := range sf.TaskNotes {
+		note.Path = ps.absPath(note.Path)
+		ps.TaskNotes = append(ps.TaskNotes, note)
+	}
+	for _, issue := range sf.MetadataIssues {
+		issue.Path = ps.absPath(issue.Path)
+		ps.MetadataIssues = append(ps.MetadataIssues, issue)
+	}
+	for _, issue := range sf.NavIssues {
+		issue.Path = ps.absPath(issue.Path)
+		ps.NavIssues = append(ps.NavIssues, issue)
+	}
+	for _, exclusion := range sf.Exclusions {
+		exclusion.Path = ps.absPath(exclusion.Path)
+		ps.Exclusions = append(ps.Exclusions, exclusion)
+	}
+	for _, path := range sf.SyncConflicts {
+		ps.SyncConflicts = append(ps.SyncConflicts, ps.absPath(path))
+	}
+	for _, attachment := range sf.Attachments {
+		attachment.Path = ps.absPath(attachment.Path)
+		linkedFrom := make([]string, len(attachment.LinkedFrom))
+		for i, path := range attachment.LinkedFrom {
+			linkedFrom[i] = ps.absPath(path)
+		}
+		attachment.LinkedFrom = linkedFrom
+		ps.Attachments = append(ps.Attachments, attachment)
+	}
+
+	for path, failure := range sf.Failures {
+		ps.Failures[ps.absPath(path)] = failure
+	}
+	for path, drift := range sf.Drifts {
+		ps.Drifts[ps.absPath(path)] = drift
+	}
+
+	if sf.OpenTasks != nil {
+		ps.PreviousOpenTasks = *sf.OpenTasks
+	}
+
+	return nil
+}
+
+// snapshot converts the processing state to its on-disk representation
+func (ps *ProcessingState) snapshot() stateFile {
+	sf := stateFile{Version: stateFileVersion}
+
+	for _, file := range ps.ProcessedFiles {
+		file.Path = ps.relPath(file.Path)
+		sf.Files = append(sf.Files, file)
+	}
+
+	// Sort for consistent output
+	sort.Slice(sf.Files, func(i, j int) bool {
+		return sf.Files[i].Path < sf.Files[j].Path
+	})
+
+	if ps.tasksCounted {
+		openTasks := analysis.TotalOpenTasks(ps.TaskNotes)
+		sf.OpenTasks = &openTasks
+	} else if ps.PreviousOpenTasks >= 0 {
+		sf.OpenTasks = &ps.PreviousOpenTasks
+	}
+
+	for _, title := range ps.AmbiguousTitles {
+		paths := make([]string, len(title.Paths))
+		for i, path := range title.Paths {
+			paths[i] = ps.relPath(path)
+		}
+		sf.AmbiguousTitles = append(sf.AmbiguousTitles, analysis.AmbiguousTitle{Title: title.Title, Paths: paths})
+	}
+	for _, group := range ps.Duplicates {
+		paths := make([]string, len(group.Paths))
+		for i, path := range group.Paths {
+			paths[i] = ps.relPath(path)
+		}
+		sf.Duplicates = append(sf.Duplicates, analysis.DuplicateGroup{Paths: paths, Similarity: group.Similarity})
+	}
+	for _, topic := range ps.Topics {
+		paths := make([]string, len(topic.Paths))
+		for i, path := range topic.Paths {
+			paths[i] = ps.relPath(path)
+		}
+		sf.Topics = append(sf.Topics, analysis.TopicCluster{Name: topic.Name, Paths: paths})
+	}
+	for _, note := range ps.LongNotes {
+		note.Path = ps.relPath(note.Path)
+		sf.LongNotes = append(sf.LongNotes, note)
+	}
+	for _, outlier := range ps.SizeOutliers {
+		outlier.Path = ps.relPath(outlier.Path)
+		sf.SizeOutliers = append(sf.SizeOutliers, outlier)
+	}
+	for _, note := range ps.TaskNotes {
+		note.Path = ps.relPath(note.Path)
+		sf.TaskNotes = append(sf.TaskNotes, note)
+	}
+	for _, issue := range ps.MetadataIssues {
+		issue.Path = ps.relPath(issue.Path)
+		sf.MetadataIssues = append(sf.MetadataIssues, issue)
+	}
+	for _, issue := range ps.NavIssues {
+		issue.Path = ps.relPath(issue.Path)
+		sf.NavIssues = append(sf.NavIssues, issue)
+	}
+	for _, exclusion := range ps.Exclusions {
+		exclusion.Path = ps.relPath(exclusion.Path)
+		sf.Exclusions = append(sf.Exclusions, exclusion)
+	}
+	for _, path := range ps.SyncConflicts {
+		sf.SyncConflicts = append(sf.SyncConflicts, ps.relPath(path))
+	}
+	for _, attachment := range ps.Attachments {
+		attachment.Path = ps.relPath(attachment.Path)
+		linkedFrom := make([]string, len(attachment.LinkedFrom))
+		for i, path := range attachment.LinkedFrom {
+			linkedFrom[i] = ps.relPath(path)
+		}
+		attachment.LinkedFrom = linkedFrom
+		sf.Attachments = append(sf.Attachments, attachment)
+	}
+
+	if len(ps.Failures) > 0 {
+		sf.Failures = make(map[string]Failure)
+		for path, failure := range ps.Failures {
+			sf.Failures[ps.relPath(path)] = failure
+		}
+	}
+	if len(ps.Drifts) > 0 {
+		sf.Drifts = make(map[string]Drift)
+		for path, drift := range ps.Drifts {
+			sf.Drifts[ps.relPath(path)] = drift
+		}
+	}
+
+	return sf
+}
+
+// saveStateFile writes the processed files to the state file
+func (ps *ProcessingState) saveStateFile() error {
+	sf := ps.snapshot()
+
+	if err := os.MkdirAll(filepath.Dir(ps.StatePath), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	// Atomically replace the existing state
+	if err := safewrite.WriteJSON(ps.StatePath, sf); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	ps.stateDirty = false
+	ps.stateSaved = time.Now()
+	return nil
+}
+
+// relPath converts a path to the slash-separated form stored in the state file
+func (ps *ProcessingState) relPath(path string) string {
+	relPath, err := filepath.Rel(ps.TargetFolder, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(relPath)
+}
+
+// absPath converts a path stored in the state file back to a path inside the target folder
+func (ps *ProcessingState) absPath(path string) string {
+	return filepath.Join(ps.TargetFolder, filepath.FromSlash(path))
+}
@@ -1,12 +1,22 @@
 package state
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
+	"ratemykb/analysis"
+	"ratemykb/attachments"
 	"ratemykb/classification"
 	"ratemykb/output"
+	"ratemykb/safewrite"
 	"ratemykb/scanner"
 )
 
@@ -130,7 +140,7 @@ Target folder: ` + "`" + tempDir + "`" + `
 - Total files processed: 3
 - Empty files: 1
 - Files with frontmatter only: 1
-- Good enough files: 1
+- Good enough files: 2
 
 ## Empty Files
 
@@ -142,7 +152,8 @@ Target folder: ` + "`" + tempDir + "`" + `
 
 ## Good enough Files
 
-- [[good-file]]
+- [[good-file]] (confidence: 67%) (encoding: UTF-16LE) — Clear steps, but the example is cut short (truncated)
+- [[Meeting — notes]] (rating: 80) — Clear
 `
 
 	err = os.WriteFile(reportPath, []byte(reportContent), 0644)
@@ -157,8 +168,8 @@ Target folder: ` + "`" + tempDir + "`" + `
 	}
 
 	// Check that the processed files were loaded
-	if len(state.ProcessedFiles) != 3 {
-		t.Errorf("Expected 3 processed files, got %d", len(state.ProcessedFiles))
+	if len(state.ProcessedFiles) != 4 {
+		t.Errorf("Expected 4 processed files, got %d", len(state.ProcessedFiles))
 	}
 
 	// Check specific files
@@ -189,4 +200,1742 @@ Target folder: ` + "`" + tempDir + "`" + `
 	if state.ProcessedFiles[goodFilePath].Classification != classification.Classification("Good enough") {
 		t.Errorf("Expected classification Good enough, got %s", state.ProcessedFiles[goodFilePath].Classification)
 	}
-}
\ No newline at end of file
+
+	if state.ProcessedFiles[goodFilePath].Encoding != "UTF-16LE" {
+		t.Errorf("Expected encoding UTF-16LE, got %q", state.ProcessedFiles[goodFilePath].Encoding)
+	}
+
+	if state.ProcessedFiles[goodFilePath].Confidence != 0.67 {
+		t.Errorf("Expected confidence 0.67, got %v", state.ProcessedFiles[goodFilePath].Confidence)
+	}
+
+	// Notes written by the model in the reason are not read as notes about the file
+	if got := state.ProcessedFiles[goodFilePath]; got.Reason != "Clear steps, but the example is cut short (truncated)" || got.Truncated {
+		t.Errorf("Expected the reason without truncation, got %q (truncated: %v)", got.Reason, got.Truncated)
+	}
+
+	// File names can hold the reason separator
+	meetingFilePath := filepath.Join(tempDir, "Meeting — notes.md")
+	if got := state.ProcessedFiles[meetingFilePath]; got.Reason != "Clear" || got.Rating == nil || *got.Rating != 80 {
+		t.Errorf("Expected the note with the separator in its name to be loaded, got %+v", got)
+	}
+
+	if len(state.ReportWarnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", state.ReportWarnings)
+	}
+}
+
+func TestReportWarnings(t *testing.T) {
+	tempDir := t.TempDir()
+
+	reportContent := `# Vault Quality Report
+
+Generated on: 2023-01-01 12:00:00
+Reviewed by Sam
+
+## Statistics
+
+- Total files processed: 2
+Good overall
+
+## Good enough Files
+
+- [[good-file]]
+- good-file-2.md
+- [[broken
+
+## Drafts
+
+- [[draft]]
+
+## Low quality Files
+
+No low quality files found.
+- [[good-file]]
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "vault-quality-report.md"), []byte(reportContent), 0644); err != nil {
+		t.Fatalf("Failed to create test report: %v", err)
+	}
+
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+
+	expected := []string{
+		`report line 4: unrecognized line "Reviewed by Sam" before the first section`,
+		`report line 9: unrecognized statistic "Good overall"`,
+		`report line 14: unrecognized entry "- good-file-2.md" in section "Good enough Files", it is ignored`,
+		`report line 15: malformed link "- [[broken" in section "Good enough Files", it is ignored`,
+		`report line 17: unrecognized section "Drafts", its entries are ignored`,
+		`report line 24: good-file is listed in both "Good enough Files" and "Low quality Files", keeping "Low quality Files"`,
+	}
+	if !reflect.DeepEqual(state.ReportWarnings, expected) {
+		t.Errorf("Expected warnings:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(state.ReportWarnings, "\n"))
+	}
+	if len(state.ProcessedFiles) != 1 {
+		t.Errorf("Expected 1 processed file, got %v", state.ProcessedFiles)
+	}
+
+	// Rebuilding ignores the report entirely
+	rebuilt := Rebuild(tempDir)
+	if len(rebuilt.ProcessedFiles) != 0 || len(rebuilt.ReportWarnings) != 0 {
+		t.Errorf("Expected an empty state, got %d files and warnings %v", len(rebuilt.ProcessedFiles), rebuilt.ReportWarnings)
+	}
+}
+
+func TestMatchFiles(t *testing.T) {
+	tempDir := t.TempDir()
+
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	for _, name := range []string{"projects/roadmap.md", "Meeting.md", "meeting-notes.md", "Caf\u00e9.md", "gone.md"} {
+		path := filepath.Join(tempDir, name)
+		state.ProcessedFiles[path] = output.ResultFile{Path: path, Status: scanner.StatusNeedsReview, Classification: "Good enough"}
+	}
+
+	// The meeting note was also classified under its real name, café is stored decomposed on disk
+	files := []scanner.File{
+		{Path: filepath.Join(tempDir, "Projects", "Roadmap.md")},
+		{Path: filepath.Join(tempDir, "meeting.txt")},
+		{Path: filepath.Join(tempDir, "meeting-notes.md")},
+		{Path: filepath.Join(tempDir, "Cafe\u0301.md")},
+	}
+	state.ProcessedFiles[filepath.Join(tempDir, "meeting.txt")] = output.ResultFile{Path: filepath.Join(tempDir, "meeting.txt"), Classification: "Low quality"}
+
+	matched, err := state.MatchFiles(files)
+	if err != nil {
+		t.Fatalf("Failed to match files: %v", err)
+	}
+	if matched != 3 {
+		t.Errorf("Expected 3 matched entries, got %d", matched)
+	}
+
+	var paths []string
+	for path := range state.ProcessedFiles {
+		rel, _ := filepath.Rel(tempDir, path)
+		paths = append(paths, filepath.ToSlash(rel))
+	}
+	sort.Strings(paths)
+	expected := []string{"Cafe\u0301.md", "Projects/Roadmap.md", "gone.md", "meeting-notes.md", "meeting.txt"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected entries %q, got %q", expected, paths)
+	}
+	if state.ProcessedFiles[filepath.Join(tempDir, "meeting.txt")].Classification != "Low quality" {
+		t.Error("Expected the existing entry of a matched file to be kept")
+	}
+	if file := state.ProcessedFiles[filepath.Join(tempDir, "Projects", "Roadmap.md")]; file.Path != filepath.Join(tempDir, "Projects", "Roadmap.md") {
+		t.Errorf("Expected the moved entry to take the path on disk, got %s", file.Path)
+	}
+}
+
+func TestAmbiguousTitlesSection(t *testing.T) {
+	// Create a temporary directory
+	tempDir, err := os.MkdirTemp("", "state-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a new state
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+
+	titles := []analysis.AmbiguousTitle{
+		{
+			Title: "ideas",
+			Paths: []string{filepath.Join(tempDir, "a", "ideas.md"), filepath.Join(tempDir, "b", "ideas.md")},
+		},
+	}
+	if err := state.SetAmbiguousTitles(titles); err != nil {
+		t.Fatalf("Failed to set ambiguous titles: %v", err)
+	}
+
+	// Check that the section was written
+	reportContent, err := os.ReadFile(state.ReportPath)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	if !strings.Contains(string(reportContent), "- **ideas**: [[a/ideas]], [[b/ideas]]") {
+		t.Errorf("Expected report to list ambiguous titles, got:\n%s", reportContent)
+	}
+
+	// Reloading the report must not treat the ambiguous titles as processed files
+	reloaded, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to reload state: %v", err)
+	}
+	if len(reloaded.ProcessedFiles) != 0 {
+		t.Errorf("Expected 0 processed files after reload, got %d", len(reloaded.ProcessedFiles))
+	}
+}
+
+func TestPossibleDuplicatesSection(t *testing.T) {
+	tempDir := t.TempDir()
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+
+	groups := []analysis.DuplicateGroup{
+		{Paths: []string{filepath.Join(tempDir, "a", "ideas.md"), filepath.Join(tempDir, "b", "thoughts.md")}, Similarity: 0.97},
+	}
+	if err := state.SetDuplicates(groups); err != nil {
+		t.Fatalf("Failed to set duplicates: %v", err)
+	}
+
+	reportContent, err := os.ReadFile(state.ReportPath)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	for _, want := range []string{"## Possible Duplicates", "- [[a/ideas]], [[b/thoughts]] (97% similar)", "- Possible duplicate groups: 1"} {
+		if !strings.Contains(string(reportContent), want) {
+			t.Errorf("Expected report to contain %q, got:\n%s", want, reportContent)
+		}
+	}
+
+	// The groups survive a reload through the state file, without becoming processed files
+	reloaded, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to reload state: %v", err)
+	}
+	if len(reloaded.ProcessedFiles) != 0 || !reflect.DeepEqual(reloaded.Duplicates, groups) {
+		t.Errorf("Expected the duplicates and no processed files after reload, got %v and %d files", reloaded.Duplicates, len(reloaded.ProcessedFiles))
+	}
+
+	// Without duplicates the section is left out
+	if err := reloaded.SetDuplicates(nil); err != nil {
+		t.Fatalf("Failed to clear duplicates: %v", err)
+	}
+	reportContent, _ = os.ReadFile(reloaded.ReportPath)
+	if strings.Contains(string(reportContent), "Possible Duplicates") {
+		t.Errorf("Expected no duplicates section, got:\n%s", reportContent)
+	}
+}
+
+func TestNavigationIssuesSection(t *testing.T) {
+	tempDir := t.TempDir()
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+
+	issues := []analysis.NavigationIssue{
+		{Path: filepath.Join(tempDir, "docs", "orphan.md")},
+		{Path: filepath.Join(tempDir, "mkdocs.yml"), Entry: "gone.md"},
+	}
+	if err := state.SetNavigationIssues(issues); err != nil {
+		t.Fatalf("Failed to set navigation issues: %v", err)
+	}
+
+	reportContent, err := os.ReadFile(state.ReportPath)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	for _, want := range []string{"## Navigation Issues", "- [[docs/orphan]] is not in the navigation", "- `gone.md` in `mkdocs.yml` has no page"} {
+		if !strings.Contains(string(reportContent), want) {
+			t.Errorf("Expected report to contain %q, got:\n%s", want, reportContent)
+		}
+	}
+
+	reloaded, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to reload state: %v", err)
+	}
+	if len(reloaded.ProcessedFiles) != 0 || !reflect.DeepEqual(reloaded.NavIssues, issues) {
+		t.Errorf("Expected the navigation issues and no processed files after reload, got %v and %d files", reloaded.NavIssues, len(reloaded.ProcessedFiles))
+	}
+}
+
+func TestOpenTasksTrend(t *testing.T) {
+	// Create a temporary directory
+	tempDir, err := os.MkdirTemp("", "state-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// First run records the open task total without a trend
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	notes := []analysis.TaskNote{{Path: filepath.Join(tempDir, "todo.md"), OpenTasks: 5}}
+	if err := state.SetTaskNotes(notes); err != nil {
+		t.Fatalf("Failed to set task notes: %v", err)
+	}
+
+	// Second run loads the previous total and reports the difference
+	state, err = New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to reload state: %v", err)
+	}
+	if state.PreviousOpenTasks != 5 {
+		t.Errorf("Expected previous open tasks to be 5, got %d", state.PreviousOpenTasks)
+	}
+	if len(state.ProcessedFiles) != 0 {
+		t.Errorf("Expected open tasks not to be loaded as processed files, got %d", len(state.ProcessedFiles))
+	}
+
+	notes[0].OpenTasks = 3
+	if err := state.SetTaskNotes(notes); err != nil {
+		t.Fatalf("Failed to set task notes: %v", err)
+	}
+
+	reportContent, err := os.ReadFile(state.ReportPath)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	if !strings.Contains(string(reportContent), "- Open tasks: 3 (-2 since last run)") {
+		t.Errorf("Expected report to contain the open tasks trend, got:\n%s", reportContent)
+	}
+	if !strings.Contains(string(reportContent), "- [[todo]]: 3 open tasks") {
+		t.Errorf("Expected report to list the note with open tasks, got:\n%s", reportContent)
+	}
+}
+
+func TestStateFileSurvivesRestart(t *testing.T) {
+	// Create a temporary directory
+	tempDir, err := os.MkdirTemp("", "state-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a new state
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+
+	// Add a processed file whose details cannot be recovered from the report
+	filePath := filepath.Join(tempDir, "notes", "stub.md")
+	result := output.ResultFile{
+		Path:           filePath,
+		Status:         scanner.StatusFrontmatterOnly,
+		Classification: classification.Classification("Unreadable"),
+		Scores:         map[string]int{"structure": 2},
+		Score:          2,
+	}
+	if err := state.AddProcessedFile(result); err != nil {
+		t.Fatalf("Failed to add processed file: %v", err)
+	}
+
+	if _, err := os.Stat(state.StatePath); err != nil {
+		t.Fatalf("Expected state file %s to exist: %v", state.StatePath, err)
+	}
+
+	// Remove the report so the state can only come from the state file
+	if err := os.Remove(state.ReportPath); err != nil {
+		t.Fatalf("Failed to remove report: %v", err)
+	}
+
+	reloaded, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to reload state: %v", err)
+	}
+
+	got, ok := reloaded.ProcessedFiles[filePath]
+	if !ok {
+		t.Fatalf("Expected file %s to be loaded from the state file", filePath)
+	}
+	if !reflect.DeepEqual(got, result) {
+		t.Errorf("Expected %+v, got %+v", result, got)
+	}
+}
+
+func TestStateFileSaveInterval(t *testing.T) {
+	tempDir := t.TempDir()
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+
+	// The first file is saved at once, the next ones wait for the interval or a flush
+	for _, name := range []string{"a.md", "b.md", "c.md"} {
+		if err := state.AddProcessedFile(output.ResultFile{Path: filepath.Join(tempDir, name), Status: scanner.StatusNeedsReview, Classification: "Good enough"}); err != nil {
+			t.Fatalf("Failed to add processed file: %v", err)
+		}
+	}
+	if err := os.Remove(state.ReportPath); err != nil {
+		t.Fatalf("Failed to remove report: %v", err)
+	}
+	reloaded, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to reload state: %v", err)
+	}
+	if len(reloaded.ProcessedFiles) != 1 {
+		t.Errorf("Expected only the first file in the state file before a flush, got %d", len(reloaded.ProcessedFiles))
+	}
+
+	if err := state.Flush(); err != nil {
+		t.Fatalf("Failed to flush state: %v", err)
+	}
+	reloaded, err = New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to reload state: %v", err)
+	}
+	if len(reloaded.ProcessedFiles) != 3 {
+		t.Errorf("Expected all files in the state file after a flush, got %d", len(reloaded.ProcessedFiles))
+	}
+
+	// Nothing is written when no files were added since the last save
+	if err := os.Remove(state.StatePath); err != nil {
+		t.Fatalf("Failed to remove state file: %v", err)
+	}
+	if err := state.Flush(); err != nil {
+		t.Fatalf("Failed to flush state: %v", err)
+	}
+	if _, err := os.Stat(state.StatePath); !os.IsNotExist(err) {
+		t.Errorf("Expected no state file after flushing a saved state, got %v", err)
+	}
+}
+
+func TestRenderJSON(t *testing.T) {
+	// Create a temporary directory
+	tempDir, err := os.MkdirTemp("", "state-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a new state
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	state.ProcessedFiles[filepath.Join(tempDir, "a", "note.md")] = output.ResultFile{
+		Path:           filepath.Join(tempDir, "a", "note.md"),
+		Status:         scanner.StatusNeedsReview,
+		Classification: classification.Classification("Low quality"),
+	}
+
+	content, err := state.Render(FormatJSON)
+	if err != nil {
+		t.Fatalf("Failed to render JSON: %v", err)
+	}
+
+	var report struct {
+		Statistics map[string]int      `json:"statistics"`
+		Files      []output.ResultFile `json:"files"`
+	}
+	if err := json.Unmarshal([]byte(content), &report); err != nil {
+		t.Fatalf("Failed to parse JSON report: %v", err)
+	}
+	if len(report.Files) != 1 || report.Files[0].Path != "a/note.md" {
+		t.Errorf("Expected one file with relative path a/note.md, got %+v", report.Files)
+	}
+	if report.Statistics["Low quality"] != 1 {
+		t.Errorf("Expected 1 Low quality file in statistics, got %v", report.Statistics)
+	}
+
+	if _, err := state.Render("pdf"); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected ErrUnsupportedFormat, got %v", err)
+	}
+}
+
+func TestDeterministicReport(t *testing.T) {
+	tempDir := t.TempDir()
+
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	state.RunID = "run-1"
+	state.Timestamp = TimestampNone
+	for i, class := range []string{"Low quality", "Empty", "Stub", "Draft"} {
+		path := filepath.Join(tempDir, fmt.Sprintf("note%d.md", i))
+		state.ProcessedFiles[path] = output.ResultFile{
+			Path:           path,
+			Status:         scanner.StatusNeedsReview,
+			Classification: classification.Classification(class),
+		}
+	}
+
+	for _, format := range []string{FormatMarkdown, FormatHTML, FormatJSON} {
+		first, err := state.Render(format)
+		if err != nil {
+			t.Fatalf("Failed to render %s: %v", format, err)
+		}
+		for i := 0; i < 5; i++ {
+			if again, _ := state.Render(format); again != first {
+				t.Fatalf("Expected identical %s reports, got:\n%s\nand:\n%s", format, first, again)
+			}
+		}
+		if strings.Contains(first, "run-1") {
+			t.Errorf("Expected no run ID in the %s report, got:\n%s", format, first)
+		}
+	}
+
+	state.Timestamp = TimestampComment
+	content, err := state.Render(FormatMarkdown)
+	if err != nil {
+		t.Fatalf("Failed to render report: %v", err)
+	}
+	if !strings.Contains(content, "<!-- Generated on: ") || !strings.Contains(content, ", run ID: run-1 -->") {
+		t.Errorf("Expected the generation time in a comment, got:\n%s", content)
+	}
+}
+
+func TestReportTimeFormat(t *testing.T) {
+	tempDir := t.TempDir()
+
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	state.TimeFormat = time.RFC3339
+	state.Location = time.UTC
+	state.Now = func() time.Time { return time.Date(2024, 3, 2, 8, 0, 0, 0, time.FixedZone("CET", 3600)) }
+	state.Attachments = []attachments.Attachment{{
+		Path:           filepath.Join(tempDir, "slides.pdf"),
+		LinkedFrom:     []string{filepath.Join(tempDir, "note.md")},
+		Modified:       time.Date(2024, 3, 1, 10, 30, 0, 0, time.FixedZone("CET", 3600)),
+		Words:          120,
+		Classification: classification.Classification("Reference"),
+	}}
+
+	content, err := state.Render(FormatMarkdown)
+	if err != nil {
+		t.Fatalf("Failed to render report: %v", err)
+	}
+	if !strings.Contains(content, "modified 2024-03-01T09:30:00Z") {
+		t.Errorf("Expected the attachment date in UTC, got:\n%s", content)
+	}
+	if !strings.Contains(content, "Generated on: 2024-03-02T07:00:00Z") {
+		t.Errorf("Expected the generation time in UTC, got:\n%s", content)
+	}
+
+	content, err = state.Render(FormatJSON)
+	if err != nil {
+		t.Fatalf("Failed to render JSON: %v", err)
+	}
+	if !strings.Contains(content, `"modified": "2024-03-01T09:30:00Z"`) {
+		t.Errorf("Expected the attachment date in UTC, got:\n%s", content)
+	}
+	if !strings.Contains(content, `"generated_on": "2024-03-02T07:00:00Z"`) {
+		t.Errorf("Expected the generation time in UTC, got:\n%s", content)
+	}
+}
+
+func TestRenderRDJSON(t *testing.T) {
+	tempDir := t.TempDir()
+
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	state.PassingLabels = []string{"Good enough"}
+	for name, label := range map[string]string{"good.md": "Good enough", "weak.md": "Low quality", "slow.md": "Timed out"} {
+		path := filepath.Join(tempDir, name)
+		state.ProcessedFiles[path] = output.ResultFile{Path: path, Status: scanner.StatusNeedsReview, Classification: classification.Classification(label)}
+	}
+	state.MetadataIssues = []analysis.MetadataIssue{{Path: filepath.Join(tempDir, "good.md"), Problems: []string{"`created` is in the future"}}}
+
+	content, err := state.Render(FormatRDJSON)
+	if err != nil {
+		t.Fatalf("Failed to render rdjson: %v", err)
+	}
+
+	var result struct {
+		Source      struct{ Name string }
+		Diagnostics []struct {
+			Message  string
+			Severity string
+			Location struct {
+				Path  string
+				Range struct{ Start struct{ Line int } }
+			}
+			Code struct{ Value string }
+		}
+	}
+	if err := json.Unmarshal([]byte(content), &result); err != nil {
+		t.Fatalf("Failed to parse rdjson: %v", err)
+	}
+	if result.Source.Name != "ratemykb" || len(result.Diagnostics) != 2 {
+		t.Fatalf("Expected 2 diagnostics from ratemykb, got:\n%s", content)
+	}
+
+	weak := result.Diagnostics[0]
+	if weak.Location.Path != filepath.Join(tempDir, "weak.md") || weak.Code.Value != "low-quality" || weak.Severity != "WARNING" || weak.Location.Range.Start.Line != 1 {
+		t.Errorf("Unexpected diagnostic for the low quality note: %+v", weak)
+	}
+	if issue := result.Diagnostics[1]; issue.Code.Value != "metadata-issue" || !strings.Contains(issue.Message, "in the future") {
+		t.Errorf("Unexpected diagnostic for the metadata issue: %+v", issue)
+	}
+}
+
+func TestObsidianURIs(t *testing.T) {
+	tempDir := t.TempDir()
+
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	state.VaultName = "My Vault"
+	state.ProcessedFiles[filepath.Join(tempDir, "a b", "note.md")] = output.ResultFile{
+		Path:   filepath.Join(tempDir, "a b", "note.md"),
+		Status: scanner.StatusEmpty,
+	}
+
+	expected := "obsidian://open?vault=My%20Vault&file=a%20b%2Fnote"
+	if uri := ObsidianURI("My Vault", "a b/note"); uri != expected {
+		t.Errorf("Expected %s, got %s", expected, uri)
+	}
+
+	content, err := state.Render(FormatJSON)
+	if err != nil {
+		t.Fatalf("Failed to render JSON: %v", err)
+	}
+	if !strings.Contains(content, `"obsidian_uri": "`+expected+`"`) {
+		t.Errorf("Expected JSON report to include the URI, got:\n%s", content)
+	}
+
+	content, err = state.Render(FormatHTML)
+	if err != nil {
+		t.Fatalf("Failed to render HTML: %v", err)
+	}
+	if !strings.Contains(content, `<a href="obsidian://open?vault=My%20Vault&amp;file=a%20b%2Fnote">a b/note</a>`) {
+		t.Errorf("Expected HTML report to link the URI, got:\n%s", content)
+	}
+
+	// Notes of a target folder inside the vault are linked by their path in the vault
+	state.VaultFolder = "Projects"
+	content, err = state.Render(FormatJSON)
+	if err != nil {
+		t.Fatalf("Failed to render JSON: %v", err)
+	}
+	if !strings.Contains(content, "file=Projects%2Fa%20b%2Fnote") {
+		t.Errorf("Expected URI relative to the vault, got:\n%s", content)
+	}
+}
+
+func TestExcludedFilesAppendix(t *testing.T) {
+	tempDir := t.TempDir()
+
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+
+	exclusions := []scanner.Exclusion{
+		{Path: filepath.Join(tempDir, "private", "diary.md"), Reason: scanner.ReasonExclusionFile},
+		{Path: filepath.Join(tempDir, "templates"), Reason: scanner.ReasonExcludedDirectory, IsDir: true},
+	}
+	if err := state.SetExclusions(exclusions); err != nil {
+		t.Fatalf("Failed to set exclusions: %v", err)
+	}
+
+	report, err := os.ReadFile(state.ReportPath)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	expected := "## Excluded Files\n\n- [[private/diary]] (exclusion file)\n- `templates/` (excluded directory)\n"
+	if !strings.Contains(string(report), expected) {
+		t.Errorf("Expected report to contain:\n%s\ngot:\n%s", expected, report)
+	}
+
+	// The appendix is restored from the state file and never loaded as processed files
+	reloaded, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to reload state: %v", err)
+	}
+	if !reflect.DeepEqual(reloaded.Exclusions, exclusions) {
+		t.Errorf("Expected exclusions %v, got %v", exclusions, reloaded.Exclusions)
+	}
+	if len(reloaded.ProcessedFiles) != 0 {
+		t.Errorf("Expected no processed files, got %d", len(reloaded.ProcessedFiles))
+	}
+}
+
+func TestSkipList(t *testing.T) {
+	tempDir := t.TempDir()
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+
+	path := filepath.Join(tempDir, "failing.md")
+	for run := 1; run <= 3; run++ {
+		added, err := state.RecordFailure(path, "unparseable response", 3)
+		if err != nil {
+			t.Fatalf("Failed to record failure: %v", err)
+		}
+		if added != (run == 3) {
+			t.Errorf("Run %d: expected added to be %v", run, run == 3)
+		}
+	}
+	if !state.IsSkipped(path) {
+		t.Fatal("Expected the file to be skipped after 3 failed runs")
+	}
+
+	// The skip list survives a restart and is shown in the report
+	reloaded, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to reload state: %v", err)
+	}
+	if !reloaded.IsSkipped(path) {
+		t.Error("Expected the skip list to be restored from the state file")
+	}
+	report, err := os.ReadFile(reloaded.ReportPath)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	if !strings.Contains(string(report), "- [[failing]] (failed 3 runs in a row: unparseable response)") {
+		t.Errorf("Expected the report to list the skipped file, got:\n%s", report)
+	}
+
+	// A successful classification starts over
+	other := filepath.Join(tempDir, "flaky.md")
+	if _, err := reloaded.RecordFailure(other, "timed out", 3); err != nil {
+		t.Fatalf("Failed to record failure: %v", err)
+	}
+	if err := reloaded.AddProcessedFile(output.ResultFile{Path: other, Classification: "Good enough"}); err != nil {
+		t.Fatalf("Failed to add processed file: %v", err)
+	}
+	if _, ok := reloaded.Failures[other]; ok {
+		t.Error("Expected the failures of a classified file to be forgotten")
+	}
+
+	// Clearing the skip list keeps counting the failures of files that are not on it
+	if _, err := reloaded.RecordFailure(other, "timed out", 3); err != nil {
+		t.Fatalf("Failed to record failure: %v", err)
+	}
+	cleared, err := reloaded.ClearSkipList()
+	if err != nil || cleared != 1 {
+		t.Errorf("ClearSkipList() = %d, %v, want 1", cleared, err)
+	}
+	if reloaded.IsSkipped(path) {
+		t.Error("Expected the skip list to be empty")
+	}
+	if failure := reloaded.Failures[other]; failure.Runs != 1 {
+		t.Errorf("Expected the failure of a file not on the skip list to be kept, got %+v", failure)
+	}
+}
+
+func TestWriteFolderRollups(t *testing.T) {
+	tempDir := t.TempDir()
+
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	state.PassingLabels = []string{"Good enough"}
+	add := func(path, label string, status scanner.FileStatus) {
+		path = filepath.Join(tempDir, filepath.FromSlash(path))
+		state.ProcessedFiles[path] = output.ResultFile{Path: path, Status: status, Classification: classification.Classification(label)}
+	}
+	add("root.md", "Low quality", scanner.StatusNeedsReview)
+	add("Projects/plan.md", "Good enough", scanner.StatusNeedsReview)
+	add("Projects/draft.md", "Low quality", scanner.StatusNeedsReview)
+	add("Projects/old/blank.md", "Empty", scanner.StatusEmpty)
+	add("Projects/slow.md", "Timed out", scanner.StatusNeedsReview)
+	add("Journal/day.md", "Good enough", scanner.StatusNeedsReview)
+	add("Removed/gone.md", "Empty", scanner.StatusEmpty)
+	add("Notes/idea.md", "Low quality", scanner.StatusNeedsReview)
+	for _, folder := range []string{"Projects", "Journal", "Notes"} {
+		if err := os.Mkdir(filepath.Join(tempDir, folder), 0755); err != nil {
+			t.Fatalf("Failed to create folder: %v", err)
+		}
+	}
+
+	// A note of the same name that ratemykb did not write is never overwritten
+	own := filepath.Join(tempDir, "Notes", "_quality.md")
+	if err := os.WriteFile(own, []byte("# Quality standards\n"), 0644); err != nil {
+		t.Fatalf("Failed to write note: %v", err)
+	}
+
+	changes := safewrite.Begin(tempDir, "scan")
+	written, err := state.WriteFolderRollups(changes)
+	if err == nil || !strings.Contains(err.Error(), own) {
+		t.Errorf("WriteFolderRollups() error = %v, want it to name %s", err, own)
+	}
+	if content, _ := os.ReadFile(own); string(content) != "# Quality standards\n" {
+		t.Errorf("Expected the note to be left alone, got:\n%s", content)
+	}
+	want := []string{filepath.Join(tempDir, "Journal", "_quality.md"), filepath.Join(tempDir, "Projects", "_quality.md")}
+	if !reflect.DeepEqual(written, want) {
+		t.Fatalf("WriteFolderRollups() = %v, want %v", written, want)
+	}
+
+	content, err := os.ReadFile(want[1])
+	if err != nil {
+		t.Fatalf("Failed to read rollup: %v", err)
+	}
+	for _, expected := range []string{
+		"<!-- Written by ratemykb, replaced on every run -->\n# Quality of Projects\n",
+		"[[vault-quality-report]]",
+		"- Total files processed: 4\n",
+		"- Low quality files: 1\n",
+		"## Files Needing Attention\n\n- [[Projects/old/blank]] (Empty)\n- [[Projects/draft]] (Low quality)\n",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("Expected rollup to contain %q, got:\n%s", expected, content)
+		}
+	}
+
+	content, err = os.ReadFile(want[0])
+	if err != nil {
+		t.Fatalf("Failed to read rollup: %v", err)
+	}
+	if !strings.Contains(string(content), "No files needing attention found.") {
+		t.Errorf("Expected the journal rollup to need no attention, got:\n%s", content)
+	}
+
+	// The rollups are undone with the run that wrote them
+	if reverted, err := safewrite.Undo(tempDir, changes.ID, false); err != nil || reverted != 2 {
+		t.Errorf("Undo() = %d, %v, want 2", reverted, err)
+	}
+	if _, err := os.Stat(want[0]); !os.IsNotExist(err) {
+		t.Errorf("Expected the rollup to be removed by undo, got %v", err)
+	}
+
+	// Markdown links are relative to the folder of the rollup
+	state.LinkStyle = LinkStyleMarkdown
+	content = []byte(state.renderRollup("Projects", []output.ResultFile{state.ProcessedFiles[filepath.Join(tempDir, "Projects", "old", "blank.md")]}))
+	for _, expected := range []string{"[vault-quality-report](../vault-quality-report.md)", "- [blank](old/blank.md) (Empty)\n"} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("Expected markdown rollup to contain %q, got:\n%s", expected, content)
+		}
+	}
+}
+
+func TestSyncConflictsSection(t *testing.T) {
+	tempDir := t.TempDir()
+
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	conflict := filepath.Join(tempDir, "note.sync-conflict-20240101-120000-ABCDEFG.md")
+	if err := state.AddProcessedFile(output.ResultFile{Path: conflict, Status: scanner.StatusNeedsReview, Classification: "Low quality"}); err != nil {
+		t.Fatalf("Failed to add processed file: %v", err)
+	}
+
+	// Results classified before conflicts were recognized are forgotten
+	if err := state.SetSyncConflicts([]string{conflict}); err != nil {
+		t.Fatalf("SetSyncConflicts() error = %v", err)
+	}
+	if state.IsFileProcessed(conflict) {
+		t.Error("Expected the sync conflict to be removed from the processed files")
+	}
+
+	content, err := os.ReadFile(state.ReportPath)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	for _, expected := range []string{"- Sync conflicts: 1\n", "## Sync Conflicts\n", "- [[note.sync-conflict-20240101-120000-ABCDEFG]]\n"} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("Expected report to contain %q, got:\n%s", expected, content)
+		}
+	}
+
+	// The section is recomputed from the scan, so it survives a restart without being loaded as files
+	reloaded, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to reload state: %v", err)
+	}
+	if !reflect.DeepEqual(reloaded.SyncConflicts, []string{conflict}) || len(reloaded.ProcessedFiles) != 0 {
+		t.Errorf("Expected the sync conflict to be restored without processed files, got %v and %v", reloaded.SyncConflicts, reloaded.ProcessedFiles)
+	}
+}
+
+func TestNeedsHumanReviewSection(t *testing.T) {
+	tempDir := t.TempDir()
+
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	state.ReviewThreshold = 0.5
+	for name, confidence := range map[string]float64{"unsure.md": 0.3, "doubtful.md": 0.45, "sure.md": 0.9, "unknown.md": 0} {
+		path := filepath.Join(tempDir, name)
+		state.ProcessedFiles[path] = output.ResultFile{Path: path, Status: scanner.StatusNeedsReview, Classification: "Low quality", Confidence: confidence}
+	}
+
+	content := state.renderMarkdown()
+	for _, expected := range []string{
+		"- Files needing human review: 2\n",
+		"## Needs Human Review\n\nThese files were classified with a confidence below 50%, least confident first.\n\n" +
+			"- [[unsure]]: Low quality (confidence: 30%)\n- [[doubtful]]: Low quality (confidence: 45%)\n\n",
+	} {
+		if !strings.Contains(content, expected) {
+			t.Errorf("Expected report to contain %q, got:\n%s", expected, content)
+		}
+	}
+
+	// The section lists files found elsewhere in the report, so they are not loaded from it twice
+	if err := os.WriteFile(state.ReportPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write report: %v", err)
+	}
+	reloaded := Rebuild(tempDir)
+	if err := reloaded.loadExistingReport(); err != nil {
+		t.Fatalf("Failed to load report: %v", err)
+	}
+	if len(reloaded.ProcessedFiles) != 4 || len(reloaded.ReportWarnings) != 0 {
+		t.Errorf("Expected 4 files without warnings, got %d files and %v", len(reloaded.ProcessedFiles), reloaded.ReportWarnings)
+	}
+
+	state.ReviewThreshold = 0
+	if strings.Contains(state.renderMarkdown(), "## Needs Human Review") {
+		t.Error("Expected no review section without a threshold")
+	}
+}
+
+func TestQualityScoresSection(t *testing.T) {
+	tempDir := t.TempDir()
+
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	for name, scores := range map[string]map[string]int{
+		"guide.md": {"completeness": 5, "structure": 4},
+		"draft.md": {"completeness": 2},
+		"plain.md": nil,
+	} {
+		path := filepath.Join(tempDir, name)
+		file := output.ResultFile{Path: path, Status: scanner.StatusNeedsReview, Classification: "Good enough", Scores: scores}
+		for _, score := range scores {
+			file.Score += float64(score) / float64(len(scores))
+		}
+		state.ProcessedFiles[path] = file
+	}
+
+	// Without dimensions there is no table
+	if strings.Contains(state.renderMarkdown(), "## Quality Scores") {
+		t.Error("Expected no scores section without dimensions")
+	}
+
+	state.ScoreDimensions = []string{"completeness", "structure"}
+	content := state.renderMarkdown()
+	for _, expected := range []string{
+		"- Average quality score: 3.2 / 5\n",
+		"| Note | completeness | structure | Overall |\n| --- | --- | --- | --- |\n" +
+			"| [[draft]] | 2 | - | 2.0 |\n| [[guide]] | 5 | 4 | 4.5 |\n\n",
+	} {
+		if !strings.Contains(content, expected) {
+			t.Errorf("Expected report to contain %q, got:\n%s", expected, content)
+		}
+	}
+
+	html := state.renderHTML()
+	if !strings.Contains(html, "<tr><th>Note</th><th>completeness</th><th>structure</th><th>Overall</th></tr>") {
+		t.Errorf("Expected the scores as an HTML table, got:\n%s", html)
+	}
+
+	// The table lists files found elsewhere in the report, so they are not loaded from it twice
+	if err := os.WriteFile(state.ReportPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write report: %v", err)
+	}
+	reloaded := Rebuild(tempDir)
+	if err := reloaded.loadExistingReport(); err != nil {
+		t.Fatalf("Failed to load report: %v", err)
+	}
+	if len(reloaded.ProcessedFiles) != 3 || len(reloaded.ReportWarnings) != 0 {
+		t.Errorf("Expected 3 files without warnings, got %d files and %v", len(reloaded.ProcessedFiles), reloaded.ReportWarnings)
+	}
+}
+
+func TestSectionNaming(t *testing.T) {
+	// Every label survives being written as a heading and parsed back
+	for _, label := range []string{"Low quality", "Duplicate files", "Stub Files", "Not markdown"} {
+		title := classificationTitle(label)
+		if id, parsed := parseSection(title); id != sectionClassification || parsed != label {
+			t.Errorf("parseSection(%q) = %v, %q, want the classification section of %q", title, id, parsed, label)
+		}
+	}
+	if title := classificationTitle("Duplicate files"); title != "Duplicate files" {
+		t.Errorf("Expected a label ending in files not to be pluralized again, got %q", title)
+	}
+
+	// Fixed headings and their aliases take precedence over labels
+	for _, tt := range []struct {
+		heading string
+		want    sectionID
+	}{
+		{"Empty Files", sectionEmpty},
+		{"Skipped Files", sectionSkippedFiles},
+		{"Files with Frontmatter Only", sectionFrontmatterOnly},
+		{"Quality Scores", sectionQualityScores},
+		{"My Notes", sectionUnknown},
+	} {
+		if id, _ := parseSection(tt.heading); id != tt.want {
+			t.Errorf("parseSection(%q) = %v, want %v", tt.heading, id, tt.want)
+		}
+	}
+
+	reportSections[sectionSizeOutliers] = reportSection{title: "Unusual Lengths", aliases: []string{"Size Outliers"}, derived: true}
+	defer func() { reportSections[sectionSizeOutliers] = reportSection{title: "Size Outliers", derived: true} }()
+	if id, _ := parseSection("Size Outliers"); id != sectionSizeOutliers {
+		t.Errorf("Expected a renamed section to be recognized by its earlier heading, got %v", id)
+	}
+
+	// Files listed under a label ending in files are loaded with that label
+	tempDir := t.TempDir()
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	path := filepath.Join(tempDir, "copy.md")
+	state.ProcessedFiles[path] = output.ResultFile{Path: path, Status: scanner.StatusNeedsReview, Classification: "Duplicate files"}
+	if err := os.WriteFile(state.ReportPath, []byte(state.renderMarkdown()), 0644); err != nil {
+		t.Fatalf("Failed to write report: %v", err)
+	}
+	reloaded := Rebuild(tempDir)
+	if err := reloaded.loadExistingReport(); err != nil {
+		t.Fatalf("Failed to load report: %v", err)
+	}
+	if got := reloaded.ProcessedFiles[path].Classification; got != "Duplicate files" || len(reloaded.ReportWarnings) != 0 {
+		t.Errorf("Expected Duplicate files without warnings, got %q and %v", got, reloaded.ReportWarnings)
+	}
+}
+
+func TestRatingsSection(t *testing.T) {
+	tempDir := t.TempDir()
+
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	state.Ratings = true
+	for name, rating := range map[string]int{"a.md": 90, "b.md": 15, "c.md": 60, "d.md": 40} {
+		path := filepath.Join(tempDir, name)
+		rating := rating
+		state.ProcessedFiles[path] = output.ResultFile{Path: path, Status: scanner.StatusNeedsReview, Classification: "Good enough", Rating: &rating}
+	}
+	unrated := filepath.Join(tempDir, "e.md")
+	state.ProcessedFiles[unrated] = output.ResultFile{Path: unrated, Status: scanner.StatusNeedsReview, Classification: "Good enough"}
+
+	summary := state.RatingSummary()
+	if *summary != (RatingSummary{Files: 4, Mean: 51.25, Median: 50, P10: 15, P90: 90}) {
+		t.Errorf("RatingSummary() = %+v", summary)
+	}
+
+	content := state.renderMarkdown()
+	for _, expected := range []string{
+		"- Mean rating: 51.2\n- Median rating: 50\n- 10th percentile rating: 15\n- 90th percentile rating: 90\n",
+		"## Files by Rating\n\n- [[b]]: 15 (Good enough)\n- [[d]]: 40 (Good enough)\n- [[c]]: 60 (Good enough)\n- [[a]]: 90 (Good enough)\n\n",
+		"- [[a]] (rating: 90)\n",
+	} {
+		if !strings.Contains(content, expected) {
+			t.Errorf("Expected report to contain %q, got:\n%s", expected, content)
+		}
+	}
+
+	// Ratings are loaded back from the classification sections only
+	if err := os.WriteFile(state.ReportPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write report: %v", err)
+	}
+	reloaded := Rebuild(tempDir)
+	if err := reloaded.loadExistingReport(); err != nil {
+		t.Fatalf("Failed to load report: %v", err)
+	}
+	if len(reloaded.ProcessedFiles) != 5 || len(reloaded.ReportWarnings) != 0 {
+		t.Errorf("Expected 5 files without warnings, got %d files and %v", len(reloaded.ProcessedFiles), reloaded.ReportWarnings)
+	}
+	if rating := reloaded.ProcessedFiles[filepath.Join(tempDir, "b.md")].Rating; rating == nil || *rating != 15 {
+		t.Errorf("Expected the rating of b to be loaded back, got %v", rating)
+	}
+	if rating := reloaded.ProcessedFiles[unrated].Rating; rating != nil {
+		t.Errorf("Expected no rating for e, got %d", *rating)
+	}
+}
+
+func TestPaginatedSections(t *testing.T) {
+	tempDir := t.TempDir()
+
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	state.PageSize = 2
+	for _, name := range []string{"a.md", "b.md", "c.md", "d.md", "e.md"} {
+		path := filepath.Join(tempDir, name)
+		state.ProcessedFiles[path] = output.ResultFile{Path: path, Status: scanner.StatusNeedsReview, Classification: "Low quality"}
+	}
+	empty := filepath.Join(tempDir, "empty.md")
+	state.ProcessedFiles[empty] = output.ResultFile{Path: empty, Status: scanner.StatusEmpty, Classification: "Empty"}
+
+	content := state.renderMarkdown()
+	expected := "## Low quality Files\n\n" +
+		"<details>\n<summary>Entries 1 to 2 of 5</summary>\n\n- [[a]]\n- [[b]]\n\n</details>\n\n" +
+		"<details>\n<summary>Entries 3 to 4 of 5</summary>\n\n- [[c]]\n- [[d]]\n\n</details>\n\n" +
+		"<details>\n<summary>Entries 5 to 5 of 5</summary>\n\n- [[e]]\n\n</details>\n\n## "
+	if !strings.Contains(content, expected) {
+		t.Errorf("Expected report to contain %q, got:\n%s", expected, content)
+	}
+	if !strings.Contains(content, "## Empty Files\n\n- [[empty]]\n\n") {
+		t.Errorf("Expected short sections not to be split, got:\n%s", content)
+	}
+
+	html := state.renderHTML()
+	if !strings.Contains(html, "<details>\n<summary>Entries 1 to 2 of 5</summary>\n<ul>\n") {
+		t.Errorf("Expected collapsible blocks in the HTML report, got:\n%s", html)
+	}
+
+	// Every file is loaded back from the blocks
+	if err := os.WriteFile(state.ReportPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write report: %v", err)
+	}
+	reloaded := Rebuild(tempDir)
+	if err := reloaded.loadExistingReport(); err != nil {
+		t.Fatalf("Failed to load report: %v", err)
+	}
+	if len(reloaded.ProcessedFiles) != 6 || len(reloaded.ReportWarnings) != 0 {
+		t.Errorf("Expected 6 files without warnings, got %d files and %v", len(reloaded.ProcessedFiles), reloaded.ReportWarnings)
+	}
+}
+
+func TestSelectiveSectionUpdates(t *testing.T) {
+	tempDir := t.TempDir()
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	state.Now = func() time.Time { return now }
+	state.ReviewThreshold = 0.5
+
+	add := func(name string, label classification.Classification, confidence float64) {
+		t.Helper()
+		path := filepath.Join(tempDir, name)
+		if err := state.AddProcessedFile(output.ResultFile{Path: path, Status: scanner.StatusNeedsReview, Classification: label, Confidence: confidence}); err != nil {
+			t.Fatalf("AddProcessedFile() error = %v", err)
+		}
+	}
+	report := func() string {
+		t.Helper()
+		data, err := os.ReadFile(state.ReportPath)
+		if err != nil {
+			t.Fatalf("Failed to read report: %v", err)
+		}
+		return string(data)
+	}
+
+	add("a.md", classification.GoodEnough, 0.9)
+	add("b.md", classification.LowQuality, 0.4)
+	if err := state.SetSizeOutliers([]analysis.SizeOutlier{{Path: filepath.Join(tempDir, "a.md"), Words: 5}}); err != nil {
+		t.Fatalf("SetSizeOutliers() error = %v", err)
+	}
+
+	// Sections the change does not affect are kept as written
+	marked := strings.Replace(report(), "unusually short for this vault", "unusually short (kept)", 1)
+	if err := os.WriteFile(state.ReportPath, []byte(marked), 0644); err != nil {
+		t.Fatalf("Failed to write report: %v", err)
+	}
+	now = now.Add(time.Hour)
+	add("b.md", classification.LowQuality, 0.3)
+	content := report()
+	if !strings.Contains(content, "unusually short (kept)") {
+		t.Error("Expected the size outliers section to be kept as written")
+	}
+	if got, want := content, strings.Replace(state.renderMarkdown(), "unusually short for this vault", "unusually short (kept)", 1); got != want {
+		t.Errorf("Expected the affected sections to be rendered again, got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// A label no file had before adds a section, which regenerates the whole report
+	add("c.md", classification.NotMarkdown, 0.9)
+	if got, want := report(), state.renderMarkdown(); got != want {
+		t.Errorf("Expected the whole report to be regenerated, got:\n%s\nwant:\n%s", got, want)
+	}
+	if err := state.RemoveProcessedFile(filepath.Join(tempDir, "c.md")); err != nil {
+		t.Fatalf("RemoveProcessedFile() error = %v", err)
+	}
+	if got, want := report(), state.renderMarkdown(); got != want {
+		t.Errorf("Expected the report to match after removing a file, got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMarkdownLinkStyle(t *testing.T) {
+	tempDir := t.TempDir()
+	state := Rebuild(tempDir)
+	state.LinkStyle = LinkStyleMarkdown
+
+	note := filepath.Join(tempDir, "Projects", "Road map.md")
+	empty := filepath.Join(tempDir, "blank.md")
+	state.ProcessedFiles[note] = output.ResultFile{Path: note, Status: scanner.StatusNeedsReview, Classification: "Good enough", Reason: "Clear"}
+	state.ProcessedFiles[empty] = output.ResultFile{Path: empty, Status: scanner.StatusEmpty, Classification: classification.Empty}
+
+	content := state.renderMarkdown()
+	for _, expected := range []string{
+		"## Empty Files\n\n- [blank](blank.md)\n",
+		"- [Road map](Projects/Road%20map.md) — Clear\n",
+	} {
+		if !strings.Contains(content, expected) {
+			t.Errorf("Expected report to contain %q, got:\n%s", expected, content)
+		}
+	}
+
+	// Markdown links are loaded back like wikilinks
+	if err := os.WriteFile(state.ReportPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write report: %v", err)
+	}
+	reloaded := Rebuild(tempDir)
+	if err := reloaded.loadExistingReport(); err != nil {
+		t.Fatalf("Failed to load report: %v", err)
+	}
+	if len(reloaded.ReportWarnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", reloaded.ReportWarnings)
+	}
+	if got := reloaded.ProcessedFiles[note]; got.Classification != "Good enough" || got.Reason != "Clear" {
+		t.Errorf("Expected the note to be loaded back, got %+v", got)
+	}
+	if got := reloaded.ProcessedFiles[empty]; got.Status != scanner.StatusEmpty {
+		t.Errorf("Expected the empty file to be loaded back, got %+v", got)
+	}
+
+	if html := state.renderHTML(); !strings.Contains(html, `<a href="Projects/Road%20map.md">Road map</a>`) {
+		t.Errorf("Expected the HTML report to link to the note, got:\n%s", html)
+	}
+}
+
+func TestMetadataPassthrough(t *testing.T) {
+	tempDir := t.TempDir()
+
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	path := filepath.Join(tempDir, "a.md")
+	state.ProcessedFiles[path] = output.ResultFile{
+		Path:           path,
+		Status:         scanner.StatusNeedsReview,
+		Classification: "Low quality",
+		Encoding:       "UTF-16LE",
+		Reason:         "Only a heading (no body)",
+		Metadata:       map[string]string{"status": "draft (wip)", "owner": "alice"},
+	}
+
+	content := state.renderMarkdown()
+	expected := "- [[a]] (owner: alice) (status: draft [wip]) (encoding: UTF-16LE) — Only a heading (no body)\n"
+	if !strings.Contains(content, expected) {
+		t.Errorf("Expected report to contain %q, got:\n%s", expected, content)
+	}
+
+	rdjson, err := state.Render("rdjson")
+	if err != nil {
+		t.Fatalf("Failed to render rdjson: %v", err)
+	}
+	if !strings.Contains(rdjson, `"Note classified as \"Low quality\" (owner: alice) (status: draft [wip]): Only a heading (no body)"`) {
+		t.Errorf("Expected the metadata in the rdjson message, got:\n%s", rdjson)
+	}
+	report, err := state.Render("json")
+	if err != nil {
+		t.Fatalf("Failed to render json: %v", err)
+	}
+	if !strings.Contains(report, `"status": "draft (wip)"`) {
+		t.Errorf("Expected the metadata as it is in the JSON report, got:\n%s", report)
+	}
+
+	// Fields are loaded back, while the notes the report writes itself are not mistaken for fields
+	if err := os.WriteFile(state.ReportPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write report: %v", err)
+	}
+	reloaded := Rebuild(tempDir)
+	if err := reloaded.loadExistingReport(); err != nil {
+		t.Fatalf("Failed to load report: %v", err)
+	}
+	file := reloaded.ProcessedFiles[path]
+	if want := map[string]string{"owner": "alice", "status": "draft [wip]"}; !reflect.DeepEqual(file.Metadata, want) {
+		t.Errorf("Expected metadata %v, got %v", want, file.Metadata)
+	}
+	if file.Encoding != "UTF-16LE" || file.Reason != "Only a heading (no body)" {
+		t.Errorf("Expected the encoding and reason to be kept, got %+v", file)
+	}
+}
+
+func TestLowQualityTopics(t *testing.T) {
+	tempDir := t.TempDir()
+
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	for _, name := range []string{"k8s/pods.md", "k8s/ingress.md", "bread.md", "stub.md"} {
+		path := filepath.Join(tempDir, filepath.FromSlash(name))
+		state.ProcessedFiles[path] = output.ResultFile{Path: path, Status: scanner.StatusNeedsReview, Classification: classification.LowQuality}
+	}
+	good := filepath.Join(tempDir, "k8s", "nodes.md")
+	state.ProcessedFiles[good] = output.ResultFile{Path: good, Status: scanner.StatusNeedsReview, Classification: "Good enough"}
+
+	// Without topics, the section stays a single list
+	if content := state.renderMarkdown(); strings.Contains(content, "### ") {
+		t.Errorf("Expected no topic headings without topics, got:\n%s", content)
+	}
+
+	err = state.SetTopics([]analysis.TopicCluster{
+		{Name: "pods", Paths: []string{filepath.Join(tempDir, "k8s", "ingress.md"), filepath.Join(tempDir, "k8s", "nodes.md"), filepath.Join(tempDir, "k8s", "pods.md")}},
+		{Name: "gone", Paths: []string{filepath.Join(tempDir, "gone.md"), filepath.Join(tempDir, "also-gone.md")}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to set topics: %v", err)
+	}
+	content, err := os.ReadFile(state.ReportPath)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	expected := "## Low quality Files\n\n### Topic: pods (2 notes)\n\n- [[k8s/ingress]]\n- [[k8s/pods]]\n\n### Other notes\n\n- [[bread]]\n- [[stub]]\n\n"
+	if !strings.Contains(string(content), expected) {
+		t.Errorf("Expected report to contain %q, got:\n%s", expected, content)
+	}
+
+	// Topic headings are not mistaken for entries when the report is loaded back
+	reloaded := Rebuild(tempDir)
+	if err := reloaded.loadExistingReport(); err != nil {
+		t.Fatalf("Failed to load report: %v", err)
+	}
+	if len(reloaded.ProcessedFiles) != 5 || len(reloaded.ReportWarnings) != 0 {
+		t.Errorf("Expected 5 files without warnings, got %d files and %v", len(reloaded.ProcessedFiles), reloaded.ReportWarnings)
+	}
+
+	loaded, err := Load(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if len(loaded.Topics) != 2 || loaded.Topics[0].Paths[0] != filepath.Join(tempDir, "k8s", "ingress.md") {
+		t.Errorf("Expected the topics to be kept in the state file, got %v", loaded.Topics)
+	}
+
+	// Each topic is paged on its own
+	state.PageSize = 1
+	paged := state.renderMarkdown()
+	for _, expected := range []string{
+		"### Topic: pods (2 notes)\n\n<details>\n<summary>Entries 1 to 1 of 2</summary>\n\n- [[k8s/ingress]]\n\n</details>\n\n" +
+			"<details>\n<summary>Entries 2 to 2 of 2</summary>\n\n- [[k8s/pods]]\n\n</details>\n\n### Other notes",
+		"### Other notes\n\n<details>\n<summary>Entries 1 to 1 of 2</summary>\n\n- [[bread]]\n\n</details>\n\n",
+	} {
+		if !strings.Contains(paged, expected) {
+			t.Errorf("Expected report to contain %q, got:\n%s", expected, paged)
+		}
+	}
+	if err := os.WriteFile(state.ReportPath, []byte(paged), 0644); err != nil {
+		t.Fatalf("Failed to write report: %v", err)
+	}
+	reloaded = Rebuild(tempDir)
+	if err := reloaded.loadExistingReport(); err != nil {
+		t.Fatalf("Failed to load report: %v", err)
+	}
+	if len(reloaded.ProcessedFiles) != 5 || len(reloaded.ReportWarnings) != 0 {
+		t.Errorf("Expected 5 files from the paged topics without warnings, got %d files and %v", len(reloaded.ProcessedFiles), reloaded.ReportWarnings)
+	}
+}
+
+func TestSuggestionsUnderFiles(t *testing.T) {
+	tempDir := t.TempDir()
+
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	stub := filepath.Join(tempDir, "stub.md")
+	state.ProcessedFiles[stub] = output.ResultFile{
+		Path:           stub,
+		Status:         scanner.StatusNeedsReview,
+		Classification: classification.LowQuality,
+		Reason:         "Only a heading.",
+		Suggestions:    []string{"Explain what the service does.", "Add the command\nto restart it."},
+	}
+	other := filepath.Join(tempDir, "other.md")
+	state.ProcessedFiles[other] = output.ResultFile{Path: other, Status: scanner.StatusNeedsReview, Classification: classification.LowQuality}
+
+	content := state.renderMarkdown()
+	expected := "- [[other]]\n- [[stub]] — Only a heading.\n    - Explain what the service does.\n    - Add the command to restart it.\n\n"
+	if !strings.Contains(content, expected) {
+		t.Errorf("Expected report to contain %q, got:\n%s", expected, content)
+	}
+
+	// Suggestions are loaded back onto the file they are indented under
+	if err := os.WriteFile(state.ReportPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write report: %v", err)
+	}
+	reloaded := Rebuild(tempDir)
+	if err := reloaded.loadExistingReport(); err != nil {
+		t.Fatalf("Failed to load report: %v", err)
+	}
+	if len(reloaded.ReportWarnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", reloaded.ReportWarnings)
+	}
+	if want := []string{"Explain what the service does.", "Add the command to restart it."}; !reflect.DeepEqual(reloaded.ProcessedFiles[stub].Suggestions, want) {
+		t.Errorf("Expected suggestions %q, got %q", want, reloaded.ProcessedFiles[stub].Suggestions)
+	}
+	if suggestions := reloaded.ProcessedFiles[other].Suggestions; suggestions != nil {
+		t.Errorf("Expected no suggestions for other, got %q", suggestions)
+	}
+}
+
+func TestDerivedFileSections(t *testing.T) {
+	tests := []struct {
+		name     string
+		heading  string
+		flagged  output.ResultFile // Relative path, the other file is not flagged
+		expected string
+		finding  string
+	}{
+		{
+			name:     "tag suggestions",
+			heading:  "## Tag Suggestions",
+			flagged:  output.ResultFile{Path: "pods.md", SuggestedTags: []string{"kubernetes", "ops"}},
+			expected: "## Tag Suggestions\n\nThese notes have no tags. Add the suggested ones to their frontmatter to make them easier to find.\n\n- [[pods]]: #kubernetes #ops\n\n",
+			finding:  `"Note has no tags, consider #kubernetes #ops"`,
+		},
+		{
+			name:     "missing sources",
+			heading:  "## Missing Sources",
+			flagged:  output.ResultFile{Path: "coffee.md", MissingSources: true},
+			expected: "## Missing Sources\n\nThese reference notes make claims without citing any source. Add links, citations or a sources section.\n\n- [[coffee]]\n\n",
+			finding:  `"Reference note cites no sources"`,
+		},
+		{
+			name:     "title mismatch",
+			heading:  "## Title/Content Mismatch",
+			flagged:  output.ResultFile{Path: "kubernetes.md", TitleMismatch: "A recipe for banana bread"},
+			expected: "## Title/Content Mismatch\n\nThe body of these notes does not match their title. Rename them or move the content to the right note.\n\n- [[kubernetes]]: A recipe for banana bread\n\n",
+			finding:  `"Note does not match its title: A recipe for banana bread"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+
+			state, err := New(tempDir)
+			if err != nil {
+				t.Fatalf("Failed to create state: %v", err)
+			}
+			if err := state.AddProcessedFile(output.ResultFile{Path: filepath.Join(tempDir, "plain.md"), Status: scanner.StatusNeedsReview, Classification: "Good enough"}); err != nil {
+				t.Fatalf("Failed to add file: %v", err)
+			}
+			if content := state.renderMarkdown(); strings.Contains(content, tt.heading) {
+				t.Errorf("Expected no %s section without flagged notes, got:\n%s", tt.heading, content)
+			}
+
+			flagged := tt.flagged
+			flagged.Path = filepath.Join(tempDir, flagged.Path)
+			flagged.Status = scanner.StatusNeedsReview
+			flagged.Classification = "Good enough"
+			if err := state.AddProcessedFile(flagged); err != nil {
+				t.Fatalf("Failed to add file: %v", err)
+			}
+			content, err := os.ReadFile(state.ReportPath)
+			if err != nil {
+				t.Fatalf("Failed to read report: %v", err)
+			}
+			if !strings.Contains(string(content), tt.expected) {
+				t.Errorf("Expected report to contain %q, got:\n%s", tt.expected, content)
+			}
+
+			rdjson, err := state.Render("rdjson")
+			if err != nil {
+				t.Fatalf("Failed to render rdjson: %v", err)
+			}
+			if !strings.Contains(rdjson, tt.finding) {
+				t.Errorf("Expected the finding %s, got:\n%s", tt.finding, rdjson)
+			}
+
+			// The section is derived from the processed files, so loading the report back leaves it out without warnings
+			reloaded := Rebuild(tempDir)
+			if err := reloaded.loadExistingReport(); err != nil {
+				t.Fatalf("Failed to load report: %v", err)
+			}
+			if len(reloaded.ProcessedFiles) != 2 || len(reloaded.ReportWarnings) != 0 {
+				t.Errorf("Expected 2 files without warnings, got %d files and %v", len(reloaded.ProcessedFiles), reloaded.ReportWarnings)
+			}
+		})
+	}
+}
+
+func TestEngineDisagreementSection(t *testing.T) {
+	tempDir := t.TempDir()
+
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	agreed := []classification.Vote{{Engine: "gemma3:1b", Classification: "Good enough"}, {Engine: "gpt-4o", Classification: "good enough"}}
+	if err := state.AddProcessedFile(output.ResultFile{Path: filepath.Join(tempDir, "pods.md"), Status: scanner.StatusNeedsReview, Classification: "Good enough", Votes: agreed}); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	if content := state.renderMarkdown(); strings.Contains(content, "## Engine Disagreement") {
+		t.Errorf("Expected no Engine Disagreement section while the engines agree, got:\n%s", content)
+	}
+
+	disagreed := []classification.Vote{{Engine: "gemma3:1b", Classification: "Low quality"}, {Engine: "gpt-4o", Classification: "Good enough"}}
+	if err := state.AddProcessedFile(output.ResultFile{Path: filepath.Join(tempDir, "nodes.md"), Status: scanner.StatusNeedsReview, Classification: "Low quality", Votes: disagreed}); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	content, err := os.ReadFile(state.ReportPath)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	if !strings.Contains(string(content), "## Engine Disagreement\n\nThe engines of the ensemble gave these files different labels. Check them to see which engine to trust.\n\n- [[nodes]]: Low quality (gemma3:1b), Good enough (gpt-4o)\n\n") {
+		t.Errorf("Expected the disagreement in the report, got:\n%s", content)
+	}
+
+	// The votes are kept in the state file
+	if err := state.Flush(); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	reloaded, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if got := reloaded.GetProcessedFiles()[filepath.Join(tempDir, "nodes.md")].Votes; len(got) != 2 || got[1].Engine != "gpt-4o" {
+		t.Errorf("Expected the votes to be kept, got %+v", got)
+	}
+}
+
+func TestVerdictDrift(t *testing.T) {
+	tempDir := t.TempDir()
+
+	state, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	path := filepath.Join(tempDir, "flaky.md")
+	if err := state.AddProcessedFile(output.ResultFile{Path: path, Status: scanner.StatusNeedsReview, Classification: "Good enough", ContentHash: "abc"}); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	if len(state.Drifts) != 0 {
+		t.Fatalf("Expected no drift for a new file, got %v", state.Drifts)
+	}
+
+	// The same content getting another label drifts
+	state.RunID = "run-2"
+	if err := state.AddProcessedFile(output.ResultFile{Path: path, Status: scanner.StatusNeedsReview, Classification: "Low quality", ContentHash: "abc"}); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	if drift := state.Drifts[path]; drift != (Drift{From: "Good enough", To: "Low quality", RunID: "run-2"}) {
+		t.Errorf("Expected a drift from Good enough to Low quality, got %+v", drift)
+	}
+	content, err := os.ReadFile(state.ReportPath)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	if !strings.Contains(string(content), "- [[flaky]]: Good enough → Low quality (run run-2)\n") {
+		t.Errorf("Expected the drift in the report, got:\n%s", content)
+	}
+	rdjson, err := state.Render("rdjson")
+	if err != nil {
+		t.Fatalf("Failed to render rdjson: %v", err)
+	}
+	if !strings.Contains(rdjson, `"verdict-drift"`) {
+		t.Errorf("Expected a verdict drift finding, got:\n%s", rdjson)
+	}
+
+	// The drift survives a reload of the state file
+	if err := state.Flush(); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	reloaded, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to reload state: %v", err)
+	}
+	if _, ok := reloaded.Drifts[path]; !ok {
+		t.Errorf("Expected the drift to be reloaded, got %v", reloaded.Drifts)
+	}
+
+	// A rebuild compares with the replaced results
+	rebuilt := Rebuild(tempDir)
+	rebuilt.Previous = reloaded.GetProcessedFiles()
+	if err := rebuilt.AddProcessedFile(output.ResultFile{Path: path, Status: scanner.StatusNeedsReview, Classification: "Good enough", ContentHash: "abc"}); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	if drift := rebuilt.Drifts[path]; drift.From != "Low quality" || drift.To != "Good enough" {
+		t.Errorf("Expected a drift from the replaced result, got %+v", drift)
+	}
+
+	// An edited note is no longer drifting
+	if err := rebuilt.AddProcessedFile(output.ResultFile{Path: path, Status: scanner.StatusNeedsReview, Classification: "Low quality", ContentHash: "def"}); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	if len(rebuilt.Drifts) != 0 {
+		t.Errorf("Expected the drift to clear once the content changed, got %v", rebuilt.Drifts)
+	}
+	content, err = os.ReadFile(rebuilt.ReportPath)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	if strings.Contains(string(content), "## Verdict Drift") {
+		t.Errorf("Expected no Verdict Drift section without drift, got:\n%s", content)
+	}
+}
+
+func TestAggregatePartitions(t *testing.T) {
+	tempDir := t.TempDir()
+
+	if _, err := LoadPartitions(tempDir); !errors.Is(err, ErrNoState) {
+		t.Errorf("Expected ErrNoState without partitions, got %v", err)
+	}
+
+	// The stored state of the vault, with a file classified before the partition runs
+	main, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	first, second := filepath.Join(tempDir, "first.md"), filepath.Join(tempDir, "second.md")
+	if err := main.AddProcessedFile(output.ResultFile{Path: first, Status: scanner.StatusNeedsReview, Classification: "Good enough", ContentHash: "abc"}); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+
+	titles := []analysis.AmbiguousTitle{{Title: "same", Paths: []string{first, second}}}
+	for i, file := range []output.ResultFile{
+		{Path: first, Status: scanner.StatusNeedsReview, Classification: "Low quality", ContentHash: "abc"},
+		{Path: second, Status: scanner.StatusEmpty, Classification: "Empty"},
+	} {
+		partition, err := NewPartition(tempDir, scanner.Partition{Index: i + 1, Count: 2})
+		if err != nil {
+			t.Fatalf("Failed to create partition: %v", err)
+		}
+		if err := partition.SetAmbiguousTitles(titles); err != nil {
+			t.Fatalf("Failed to set ambiguous titles: %v", err)
+		}
+		if err := partition.SetLongNotes([]analysis.LongNote{{Path: file.Path, Words: 100 * (i + 1)}}); err != nil {
+			t.Fatalf("Failed to set long notes: %v", err)
+		}
+		if err := partition.AddProcessedFile(file); err != nil {
+			t.Fatalf("Failed to add file: %v", err)
+		}
+		if err := partition.Flush(); err != nil {
+			t.Fatalf("Failed to save partition: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(PartitionDir(tempDir), "2-of-2.md")); err != nil {
+		t.Errorf("Expected a report for the partition: %v", err)
+	}
+
+	partitions, err := LoadPartitions(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to load partitions: %v", err)
+	}
+	if len(partitions) != 2 || partitions[0].Partition.Index != 1 || len(partitions[1].ProcessedFiles) != 1 {
+		t.Fatalf("Expected partitions 1/2 and 2/2 with a file each, got %d", len(partitions))
+	}
+
+	main, err = New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if err := main.Aggregate(partitions); err != nil {
+		t.Fatalf("Failed to aggregate: %v", err)
+	}
+	if len(main.ProcessedFiles) != 2 || main.ProcessedFiles[first].Classification != "Low quality" {
+		t.Errorf("Expected both files with the label of their partition, got %v", main.ProcessedFiles)
+	}
+	if len(main.LongNotes) != 2 || main.LongNotes[0].Path != second {
+		t.Errorf("Expected the long notes of both partitions, longest first, got %v", main.LongNotes)
+	}
+	if !reflect.DeepEqual(main.AmbiguousTitles, titles) {
+		t.Errorf("Expected the ambiguous titles of the first partition, got %v", main.AmbiguousTitles)
+	}
+	if drift := main.Drifts[first]; drift.From != "Good enough" || drift.To != "Low quality" {
+		t.Errorf("Expected a drift against the replaced state, got %+v", drift)
+	}
+
+	reloaded, err := Load(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to reload state: %v", err)
+	}
+	if len(reloaded.ProcessedFiles) != 2 {
+		t.Errorf("Expected the aggregated files to be saved, got %d", len(reloaded.ProcessedFiles))
+	}
+}
+
+func TestAggregatePartial(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Two notes in different shares of the vault, with one failure each
+	var notes [2]string
+	for i := 0; notes[0] == "" || notes[1] == ""; i++ {
+		path := filepath.Join(tempDir, fmt.Sprintf("note%d.md", i))
+		share := 0
+		if !(scanner.Partition{Index: 1, Count: 2}).Contains(tempDir, path) {
+			share = 1
+		}
+		if notes[share] == "" {
+			notes[share] = path
+		}
+	}
+	main, err := New(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	for _, path := range notes {
+		if err := main.AddProcessedFile(output.ResultFile{Path: path, Status: scanner.StatusNeedsReview, Classification: "Good enough"}); err != nil {
+			t.Fatalf("Failed to add file: %v", err)
+		}
+		if _, err := main.RecordFailure(path, "timeout", 3); err != nil {
+			t.Fatalf("Failed to record failure: %v", err)
+		}
+	}
+
+	// Only the first partition ran, relabeling its note
+	partition, err := NewPartition(tempDir, scanner.Partition{Index: 1, Count: 2})
+	if err != nil {
+		t.Fatalf("Failed to create partition: %v", err)
+	}
+	if err := partition.AddProcessedFile(output.ResultFile{Path: notes[0], Status: scanner.StatusNeedsReview, Classification: "Low quality"}); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	if err := main.Aggregate([]*ProcessingState{partition}); err != nil {
+		t.Fatalf("Failed to aggregate: %v", err)
+	}
+
+	if got := main.ProcessedFiles[notes[0]].Classification; got != "Low quality" {
+		t.Errorf("Expected the label of the partition, got %q", got)
+	}
+	if got := main.ProcessedFiles[notes[1]].Classification; got != "Good enough" {
+		t.Errorf("Expected the stored label of the missing partition to be kept, got %q", got)
+	}
+	if _, ok := main.Failures[notes[0]]; ok {
+		t.Errorf("Expected the failure of the partition's note to be replaced")
+	}
+	if _, ok := main.Failures[notes[1]]; !ok {
+		t.Errorf("Expected the failure of the missing partition to be kept")
+	}
+}
+
+func TestSummariesAfterLinks(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for _, style := range []string{LinkStyleWiki, LinkStyleMarkdown} {
+		state := Rebuild(tempDir)
+		state.LinkStyle = style
+		path := filepath.Join(tempDir, "pods.md")
+		state.ProcessedFiles[path] = output.ResultFile{
+			Path:           path,
+			Status:         scanner.StatusNeedsReview,
+			Classification: "Good enough",
+			Confidence:     0.9,
+			Reason:         "Clear and complete",
+			Summary:        "How pods (and services) talk — with examples",
+			Metadata:       map[string]string{"owner": "alice"},
+		}
+
+		content := state.renderMarkdown()
+		expected := state.formatLink(path) + ": How pods [and services] talk - with examples (owner: alice) (confidence: 90%) — Clear and complete\n"
+		if !strings.Contains(content, expected) {
+			t.Errorf("Expected report to contain %q, got:\n%s", expected, content)
+		}
+
+		// The summary is loaded back without the notes after it
+		if err := os.WriteFile(state.ReportPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write report: %v", err)
+		}
+		reloaded := Rebuild(tempDir)
+		if err := reloaded.loadExistingReport(); err != nil {
+			t.Fatalf("Failed to load report: %v", err)
+		}
+		file := reloaded.ProcessedFiles[path]
+		if file.Summary != "How pods [and services] talk - with examples" || file.Confidence != 0.9 || file.Metadata["owner"] != "alice" {
+			t.Errorf("Expected the summary, confidence and metadata to be loaded with %s links, got %+v", style, file)
+		}
+		if len(reloaded.ReportWarnings) != 0 {
+			t.Errorf("Expected no warnings, got %v", reloaded.ReportWarnings)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"ratemykb/config"
+)
+
+// Outage pauses classification once the GenAI engine fails several requests in a row, instead of letting the
+// remaining files fail one after the other, and resumes once a health check passes
+type Outage struct {
+	threshold  int
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	check func() error
+	sleep func(ctx context.Context, d time.Duration) error
+
+	mu       sync.Mutex
+	failures int        // Consecutive failed requests
+	waiting  sync.Mutex // Held by the worker checking the health of the engine, the others wait for it
+}
+
+// NewOutage creates a new Outage with the provided configuration, using check to test whether the engine is back
+func NewOutage(cfg *config.Config, check func() error) *Outage {
+	return &Outage{
+		threshold:  cfg.Throttle.OutageThreshold,
+		backoff:    cfg.Throttle.OutageBackoff,
+		maxBackoff: cfg.Throttle.OutageMaxBackoff,
+		check:      check,
+		sleep:      sleep,
+	}
+}
+
+// Success records a request that reached the engine
+func (o *Outage) Success() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.failures = 0
+}
+
+// Failure records a request that could not reach the engine and reports whether the engine is now considered down
+func (o *Outage) Failure() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.failures++
+	return o.threshold > 0 && o.failures >= o.threshold
+}
+
+// down reports whether the engine is considered down
+func (o *Outage) down() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.threshold > 0 && o.failures >= o.threshold
+}
+
+// Wait blocks while the engine is down, checking its health with exponential backoff between checks, or until
+// ctx is done
+// The notify function is called before each pause with its length and the error of the last check. The error of
+// ctx is returned when it ends the wait.
+func (o *Outage) Wait(ctx context.Context, notify func(delay time.Duration, err error)) error {
+	o.waiting.Lock()
+	defer o.waiting.Unlock()
+
+	// Another worker may have seen the engine recover while this one was waiting
+	delay := o.backoff
+	var err error
+	for o.down() {
+		if notify != nil {
+			notify(delay, err)
+		}
+		if err := o.sleep(ctx, delay); err != nil {
+			return err
+		}
+
+		if err = o.check(); err == nil {
+			o.Success()
+			return nil
+		}
+		delay = min(delay*2, max(o.maxBackoff, o.backoff))
+	}
+	return nil
+}
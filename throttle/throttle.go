@@ -0,0 +1,151 @@
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"ratemykb/config"
+)
+
+// Package throttle pauses classification while the host or GPU is busy, so that
+// background scans don't starve interactive use of the same machine
+
+// LoadFunc returns the current value of a load metric
+type LoadFunc func() (float64, error)
+
+// Throttle checks the host load and GPU utilization against the configured thresholds
+type Throttle struct {
+	maxLoadAverage    float64
+	maxGPUUtilization float64
+	checkInterval     time.Duration
+
+	loadAverage    LoadFunc
+	gpuUtilization LoadFunc
+	sleep          func(ctx context.Context, d time.Duration) error
+}
+
+// New creates a new Throttle with the provided configuration
+func New(cfg *config.Config) *Throttle {
+	return &Throttle{
+		maxLoadAverage:    cfg.Throttle.MaxLoadAverage,
+		maxGPUUtilization: float64(cfg.Throttle.MaxGPUUtilization),
+		checkInterval:     cfg.Throttle.CheckInterval,
+		loadAverage:       readLoadAverage,
+		gpuUtilization:    readGPUUtilization,
+		sleep:             sleep,
+	}
+}
+
+// Enabled reports whether any threshold has been configured
+func (t *Throttle) Enabled() bool {
+	return t.maxLoadAverage > 0 || t.maxGPUUtilization > 0
+}
+
+// Wait blocks until the host load and GPU utilization are below the configured thresholds, or until ctx is done
+// The notify function is called once each time the throttle decides to pause. The error of ctx is returned when
+// it ends the wait.
+func (t *Throttle) Wait(ctx context.Context, notify func(reason string)) error {
+	if !t.Enabled() {
+		return nil
+	}
+
+	for {
+		reason := t.busyReason()
+		if reason == "" {
+			return nil
+		}
+
+		if notify != nil {
+			notify(reason)
+		}
+		if err := t.sleep(ctx, t.checkInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep pauses for d, returning the error of ctx early once it is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// busyReason returns a description of the exceeded threshold, or an empty string if none is exceeded
+// Metrics that cannot be read are ignored so that an unsupported platform never blocks a run
+func (t *Throttle) busyReason() string {
+	if t.maxLoadAverage > 0 {
+		if load, err := t.loadAverage(); err == nil && load > t.maxLoadAverage {
+			return fmt.Sprintf("load average %.2f exceeds %.2f", load, t.maxLoadAverage)
+		}
+	}
+
+	if t.maxGPUUtilization > 0 {
+		if util, err := t.gpuUtilization(); err == nil && util > t.maxGPUUtilization {
+			return fmt.Sprintf("GPU utilization %.0f%% exceeds %.0f%%", util, t.maxGPUUtilization)
+		}
+	}
+
+	return ""
+}
+
+// readLoadAverage reads the 1-minute load average from /proc/loadavg
+func readLoadAverage() (float64, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read load average: %w", err)
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected load average format: %q", string(data))
+	}
+
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// readGPUUtilization queries nvidia-smi for the highest utilization across all GPUs
+func readGPUUtilization() (float64, error) {
+	out, err := exec.Command("nvidia-smi", "--query-gpu=utilization.gpu", "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to query GPU utilization: %w", err)
+	}
+
+	return parseGPUUtilization(string(out))
+}
+
+// parseGPUUtilization parses nvidia-smi output and returns the highest utilization found
+func parseGPUUtilization(out string) (float64, error) {
+	highest := -1.0
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return 0, fmt.Errorf("unexpected GPU utilization value %q: %w", line, err)
+		}
+		if value > highest {
+			highest = value
+		}
+	}
+
+	if highest < 0 {
+		return 0, fmt.Errorf("no GPU utilization reported")
+	}
+
+	return highest, nil
+}
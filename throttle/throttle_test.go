@@ -0,0 +1,186 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"ratemykb/config"
+)
+
+func TestWaitDisabled(t *testing.T) {
+	th := New(config.GetDefaultConfig())
+	if th.Enabled() {
+		t.Fatal("Expected throttle to be disabled by default")
+	}
+
+	// Wait must return immediately without touching the load readers
+	th.loadAverage = func() (float64, error) {
+		t.Fatal("Load average should not be read when throttling is disabled")
+		return 0, nil
+	}
+	th.Wait(context.Background(), nil)
+}
+
+func TestWaitPausesUntilLoadDrops(t *testing.T) {
+	cfg := config.GetDefaultConfig()
+	cfg.Throttle.MaxLoadAverage = 2.0
+	th := New(cfg)
+
+	// Report a high load twice before dropping below the threshold
+	loads := []float64{4.0, 3.0, 1.0}
+	th.loadAverage = func() (float64, error) {
+		load := loads[0]
+		loads = loads[1:]
+		return load, nil
+	}
+
+	sleeps := 0
+	th.sleep = func(context.Context, time.Duration) error { sleeps++; return nil }
+
+	var reasons []string
+	th.Wait(context.Background(), func(reason string) { reasons = append(reasons, reason) })
+
+	if sleeps != 2 {
+		t.Errorf("Expected 2 pauses, got %d", sleeps)
+	}
+	if len(reasons) != 2 {
+		t.Errorf("Expected 2 notifications, got %d", len(reasons))
+	}
+}
+
+func TestWaitCanceled(t *testing.T) {
+	cfg := config.GetDefaultConfig()
+	cfg.Throttle.MaxLoadAverage = 1.0
+	cfg.Throttle.OutageThreshold = 1
+	cfg.Throttle.CheckInterval = time.Hour
+	cfg.Throttle.OutageBackoff = time.Hour
+
+	// The pauses end as soon as the run is interrupted, however long they are
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	th := New(cfg)
+	th.loadAverage = func() (float64, error) { return 4.0, nil }
+	if err := th.Wait(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Throttle.Wait() error = %v, want context.Canceled", err)
+	}
+
+	outage := NewOutage(cfg, func() error { return errors.New("down") })
+	outage.Failure()
+	if err := outage.Wait(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Outage.Wait() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestWaitIgnoresUnreadableMetrics(t *testing.T) {
+	cfg := config.GetDefaultConfig()
+	cfg.Throttle.MaxLoadAverage = 1.0
+	cfg.Throttle.MaxGPUUtilization = 50
+	th := New(cfg)
+
+	th.loadAverage = func() (float64, error) { return 0, errors.New("unsupported") }
+	th.gpuUtilization = func() (float64, error) { return 0, errors.New("no GPU") }
+	th.sleep = func(context.Context, time.Duration) error {
+		t.Fatal("Should not pause when metrics are unavailable")
+		return nil
+	}
+
+	th.Wait(context.Background(), nil)
+}
+
+func TestParseGPUUtilization(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    float64
+		wantErr bool
+	}{
+		{name: "single GPU", output: "35\n", want: 35},
+		{name: "multiple GPUs", output: "10\n87\n42\n", want: 87},
+		{name: "no output", output: "", wantErr: true},
+		{name: "invalid value", output: "[N/A]\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseGPUUtilization(tt.output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseGPUUtilization() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseGPUUtilization() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutageBacksOffUntilHealthy(t *testing.T) {
+	cfg := config.GetDefaultConfig()
+	cfg.Throttle.OutageThreshold = 2
+	cfg.Throttle.OutageBackoff = time.Second
+	cfg.Throttle.OutageMaxBackoff = 3 * time.Second
+
+	// Fail the health check three times before the engine comes back
+	checks := 0
+	outage := NewOutage(cfg, func() error {
+		checks++
+		if checks <= 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	var sleeps []time.Duration
+	outage.sleep = func(_ context.Context, d time.Duration) error { sleeps = append(sleeps, d); return nil }
+
+	// Not waiting before the threshold is reached
+	outage.Wait(context.Background(), nil)
+	if outage.Failure() {
+		t.Fatal("Expected the engine to be up after a single failure")
+	}
+	outage.Wait(context.Background(), nil)
+	if len(sleeps) != 0 {
+		t.Fatalf("Expected no pause before the threshold, got %v", sleeps)
+	}
+
+	// A success resets the count
+	outage.Success()
+	if outage.Failure() {
+		t.Fatal("Expected the count to be reset by a success")
+	}
+	if !outage.Failure() {
+		t.Fatal("Expected the engine to be down after 2 failures in a row")
+	}
+
+	notified := 0
+	outage.Wait(context.Background(), func(time.Duration, error) { notified++ })
+
+	want := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}
+	if !reflect.DeepEqual(sleeps, want) {
+		t.Errorf("Expected pauses %v, got %v", want, sleeps)
+	}
+	if notified != 4 {
+		t.Errorf("Expected 4 notifications, got %d", notified)
+	}
+	if outage.down() {
+		t.Error("Expected the engine to be up after a passing health check")
+	}
+}
+
+func TestOutageDisabled(t *testing.T) {
+	cfg := config.GetDefaultConfig()
+	cfg.Throttle.OutageThreshold = 0
+	outage := NewOutage(cfg, func() error { return errors.New("down") })
+	outage.sleep = func(context.Context, time.Duration) error {
+		t.Fatal("Should not pause when outage detection is disabled")
+		return nil
+	}
+
+	for i := 0; i < 10; i++ {
+		if outage.Failure() {
+			t.Fatal("Expected the engine never to be considered down")
+		}
+	}
+	outage.Wait(context.Background(), nil)
+}
@@ -0,0 +1,54 @@
+package trash
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ratemykb/config"
+	"ratemykb/safewrite"
+)
+
+// Package trash moves files into the Obsidian trash folder as part of a run, so the move can be undone
+
+// Dir is the trash folder Obsidian uses inside a vault
+const Dir = config.TrashDir
+
+// Move moves the given files into the trash folder of the target folder, keeping their relative paths
+// Files that already exist in the trash get a numeric suffix. It returns the files that were moved,
+// even when an error stops the clean-up part way through.
+func Move(run *safewrite.Run, targetFolder string, paths []string) ([]string, error) {
+	var moved []string
+
+	for _, path := range paths {
+		relPath, err := filepath.Rel(targetFolder, path)
+		if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			return moved, fmt.Errorf("file %s is outside the target folder", path)
+		}
+
+		destination := uniquePath(filepath.Join(targetFolder, Dir, relPath))
+		if err := run.Move(path, destination); err != nil {
+			return moved, fmt.Errorf("failed to move %s to trash: %w", path, err)
+		}
+		moved = append(moved, path)
+	}
+
+	return moved, nil
+}
+
+// uniquePath appends a numeric suffix to the file name until the path does not exist
+func uniquePath(path string) string {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return path
+	}
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s %d%s", base, i, ext)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package trash
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ratemykb/safewrite"
+)
+
+func TestMoveAndUndo(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Create files to clean up, one of which already exists in the trash
+	notePath := filepath.Join(tempDir, "notes", "empty.md")
+	otherPath := filepath.Join(tempDir, "stub.md")
+	for _, path := range []string{notePath, otherPath, filepath.Join(tempDir, Dir, "stub.md")} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create folder: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(""), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	run := safewrite.Begin(tempDir, "clean-empty")
+	moved, err := Move(run, tempDir, []string{notePath, otherPath})
+	if err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+
+	if len(moved) != 2 {
+		t.Fatalf("Expected 2 moved files, got %d", len(moved))
+	}
+	if run.Entries[0].Destination != ".trash/notes/empty.md" {
+		t.Errorf("Expected file to keep its relative path in the trash, got %s", run.Entries[0].Destination)
+	}
+	if run.Entries[1].Destination != ".trash/stub 1.md" {
+		t.Errorf("Expected a numeric suffix for a conflicting name, got %s", run.Entries[1].Destination)
+	}
+	if _, err := os.Stat(notePath); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be moved", notePath)
+	}
+
+	restored, err := safewrite.Undo(tempDir, run.ID, false)
+	if err != nil {
+		t.Fatalf("Undo() error = %v", err)
+	}
+	if restored != 2 {
+		t.Errorf("Expected 2 restored files, got %d", restored)
+	}
+	for _, path := range []string{notePath, otherPath} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be restored: %v", path, err)
+		}
+	}
+}
+
+func TestMoveOutsideTargetFolder(t *testing.T) {
+	tempDir := t.TempDir()
+	vault := filepath.Join(tempDir, "vault")
+
+	if _, err := Move(safewrite.Begin(vault, "clean-empty"), vault, []string{filepath.Join(tempDir, "outside.md")}); err == nil {
+		t.Error("Expected an error when moving a file outside the target folder")
+	}
+}
@@ -0,0 +1,148 @@
+package tuning
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Package tuning adjusts the number of concurrent classifications to the throughput the AI engine can sustain
+
+const (
+	// minWindow is the minimum number of completed requests between two adjustments
+	minWindow = 4
+
+	// maxErrorRate is the share of failed requests in a window above which the limit is halved
+	maxErrorRate = 0.2
+
+	// minGain is the relative throughput change considered significant
+	minGain = 0.1
+)
+
+// Tuner limits the number of concurrent requests and adjusts the limit from the measured throughput
+// It climbs while adding a request in flight improves throughput, steps back and settles once throughput drops,
+// and halves the limit when the error rate shows the engine is overloaded.
+type Tuner struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	limit  int // Current number of requests allowed in flight
+	max    int // Upper bound for the limit
+	active int // Requests currently in flight
+
+	// Measurements of the current window
+	windowStart time.Time
+	completed   int
+	failed      int
+	latency     time.Duration
+
+	previousThroughput float64 // Requests per second in the previous window, 0 if unknown
+	probing            bool    // Whether the limit is still being increased
+
+	// OnChange is called with the new limit and the reason whenever the limit changes
+	OnChange func(limit int, reason string)
+
+	now func() time.Time
+}
+
+// New creates a Tuner starting at the initial limit and never exceeding maxLimit
+func New(initial, maxLimit int) *Tuner {
+	maxLimit = max(maxLimit, 1)
+	t := &Tuner{
+		limit:   min(max(initial, 1), maxLimit),
+		max:     maxLimit,
+		probing: true,
+		now:     time.Now,
+	}
+	t.cond = sync.NewCond(&t.mu)
+	t.windowStart = t.now()
+	return t
+}
+
+// Limit returns the current number of requests allowed in flight
+func (t *Tuner) Limit() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.limit
+}
+
+// Acquire blocks until another request may be sent
+func (t *Tuner) Acquire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for t.active >= t.limit {
+		t.cond.Wait()
+	}
+	t.active++
+}
+
+// Release records the outcome of a request started with Acquire and adjusts the limit once a window is complete
+func (t *Tuner) Release(latency time.Duration, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.active--
+	t.completed++
+	t.latency += latency
+	if err != nil {
+		t.failed++
+	}
+
+	// Measure over enough requests to smooth out the latency of individual notes
+	if t.completed >= max(minWindow, 2*t.limit) {
+		t.adjust()
+	}
+
+	t.cond.Broadcast()
+}
+
+// adjust changes the limit based on the measurements of the current window and starts a new window
+func (t *Tuner) adjust() {
+	now := t.now()
+	elapsed := now.Sub(t.windowStart).Seconds()
+	throughput := 0.0
+	if elapsed > 0 {
+		throughput = float64(t.completed) / elapsed
+	}
+	errorRate := float64(t.failed) / float64(t.completed)
+	meanLatency := t.latency / time.Duration(t.completed)
+
+	limit := t.limit
+	var reason string
+	switch {
+	case errorRate > maxErrorRate:
+		// The engine is failing requests, back off quickly and probe again from there
+		limit = max(limit/2, 1)
+		t.probing = true
+		reason = fmt.Sprintf("%.0f%% of requests failed", errorRate*100)
+	case !t.probing:
+		// Settled on the best limit found
+	case t.previousThroughput == 0 || throughput > t.previousThroughput*(1+minGain):
+		// More requests in flight helped (or nothing is known yet), keep climbing
+		limit++
+		reason = fmt.Sprintf("%.2f files/s at %s per file", throughput, meanLatency.Round(time.Millisecond))
+	case throughput < t.previousThroughput*(1-minGain):
+		// The last increase hurt, step back and settle
+		limit--
+		t.probing = false
+		reason = fmt.Sprintf("throughput fell to %.2f files/s at %s per file", throughput, meanLatency.Round(time.Millisecond))
+	default:
+		// No significant gain, settle here
+		t.probing = false
+	}
+	limit = min(max(limit, 1), t.max)
+
+	if limit != t.limit {
+		t.limit = limit
+		if t.OnChange != nil {
+			t.OnChange(limit, reason)
+		}
+	}
+
+	t.previousThroughput = throughput
+	t.windowStart = now
+	t.completed = 0
+	t.failed = 0
+	t.latency = 0
+}
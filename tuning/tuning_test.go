@@ -0,0 +1,96 @@
+package tuning
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock returns a clock that only moves when advanced
+func fakeClock() (func() time.Time, func(time.Duration)) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	return func() time.Time { return now }, func(d time.Duration) { now = now.Add(d) }
+}
+
+// complete simulates n requests finishing over the given duration
+func complete(t *Tuner, advance func(time.Duration), n int, duration time.Duration, failed int) {
+	for i := 0; i < n; i++ {
+		advance(duration / time.Duration(n))
+		t.Acquire()
+		var err error
+		if i < failed {
+			err = errors.New("timeout")
+		}
+		t.Release(time.Second, err)
+	}
+}
+
+func TestTunerClimbsWhileThroughputImproves(t *testing.T) {
+	now, advance := fakeClock()
+	tuner := New(1, 4)
+	tuner.now = now
+	tuner.windowStart = now()
+
+	var changes []int
+	tuner.OnChange = func(limit int, reason string) {
+		changes = append(changes, limit)
+	}
+
+	// 1 file/s, then 2 files/s after the first increase
+	complete(tuner, advance, 4, 4*time.Second, 0)
+	complete(tuner, advance, 4, 2*time.Second, 0)
+	if tuner.Limit() != 3 {
+		t.Fatalf("Expected limit 3 while throughput improves, got %d", tuner.Limit())
+	}
+
+	// Throughput falls with 3 requests in flight, so step back and settle
+	complete(tuner, advance, 6, 6*time.Second, 0)
+	if tuner.Limit() != 2 {
+		t.Fatalf("Expected limit 2 after throughput fell, got %d", tuner.Limit())
+	}
+
+	complete(tuner, advance, 4, time.Second, 0)
+	if tuner.Limit() != 2 {
+		t.Errorf("Expected the limit to stay settled at 2, got %d", tuner.Limit())
+	}
+
+	if len(changes) != 3 {
+		t.Errorf("Expected 3 limit changes, got %v", changes)
+	}
+}
+
+func TestTunerBacksOffOnErrors(t *testing.T) {
+	now, advance := fakeClock()
+	tuner := New(4, 8)
+	tuner.now = now
+	tuner.windowStart = now()
+
+	complete(tuner, advance, 8, 8*time.Second, 3)
+	if tuner.Limit() != 2 {
+		t.Errorf("Expected the limit to halve on errors, got %d", tuner.Limit())
+	}
+}
+
+func TestTunerLimitsConcurrency(t *testing.T) {
+	tuner := New(1, 1)
+	tuner.Acquire()
+
+	acquired := make(chan struct{})
+	go func() {
+		tuner.Acquire()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Expected the second request to wait for the first")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	tuner.Release(time.Second, nil)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the second request to start after the first finished")
+	}
+}